@@ -0,0 +1,84 @@
+// cmd/verifydraw/main.go
+
+// Command verifydraw replays a provably fair draw from its revealed server
+// seed, client seed and nonce, without trusting the server.
+//
+// Usage:
+//
+//	verifydraw -server-seed <seed> -client-seed <seed> -nonce <n> [-hash <hash>] [-outcomes <id:weight[:rare],...>] [-guaranteed]
+//
+// The outcomes of the reward table at the time of the draw are listed, in
+// order, by GET /point/draws/:id/verify. Without -outcomes only the roll is printed.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+
+	"xy.com/mysite/models/prize_models"
+)
+
+func main() {
+	serverSeed := flag.String("server-seed", "", "revealed server seed")
+	clientSeed := flag.String("client-seed", "", "client seed")
+	nonce := flag.Int("nonce", 0, "nonce of the draw")
+	hash := flag.String("hash", "", "server seed hash committed before the draw")
+	outcomesFlag := flag.String("outcomes", "", "outcomes of the reward table in order as id:weight[:rare], comma separated")
+	guaranteed := flag.Bool("guaranteed", false, "the draw was guaranteed by the pity counter")
+	flag.Parse()
+
+	if *serverSeed == "" || *clientSeed == "" {
+		flag.Usage()
+		log.Fatal("server-seed and client-seed are required")
+	}
+
+	if *hash != "" {
+		if prize_models.HashServerSeed(*serverSeed) != strings.ToLower(*hash) {
+			log.Fatal("server seed does not match the committed hash")
+		}
+		fmt.Println("server seed matches the committed hash")
+	}
+
+	roll := prize_models.FairRoll(*serverSeed, *clientSeed, *nonce)
+	fmt.Printf("roll: %v\n", roll)
+
+	if *outcomesFlag == "" {
+		return
+	}
+	outcomes, err := parseOutcomes(*outcomesFlag)
+	if err != nil {
+		log.Fatal(err)
+	}
+	outcome, err := prize_models.ReplayDraw(outcomes, roll, *guaranteed)
+	if err != nil {
+		log.Fatal(err)
+	}
+	fmt.Printf("outcome: %d\n", outcome.ID)
+}
+
+// parseOutcomes parses a list of id:weight[:rare] entries.
+func parseOutcomes(s string) ([]prize_models.RewardOutcome, error) {
+	var outcomes []prize_models.RewardOutcome
+	for _, entry := range strings.Split(s, ",") {
+		fields := strings.Split(strings.TrimSpace(entry), ":")
+		if len(fields) < 2 || len(fields) > 3 || (len(fields) == 3 && fields[2] != "rare") {
+			return nil, fmt.Errorf("invalid outcome %q, expected id:weight[:rare]", entry)
+		}
+		id, err := strconv.ParseUint(fields[0], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid outcome id %q", fields[0])
+		}
+		weight, err := strconv.Atoi(fields[1])
+		if err != nil {
+			return nil, fmt.Errorf("invalid outcome weight %q", fields[1])
+		}
+
+		outcome := prize_models.RewardOutcome{Weight: weight, Rare: len(fields) == 3}
+		outcome.ID = uint(id)
+		outcomes = append(outcomes, outcome)
+	}
+	return outcomes, nil
+}
@@ -8,13 +8,41 @@ import (
 )
 
 type Config struct {
-	DatabaseDriver string       `json:"database_driver"`
-	DatabaseDSN    string       `json:"database_dsn"`
-	Server         ServerConfig `json:"server"`
+	DatabaseDriver string        `json:"database_driver"`
+	DatabaseDSN    string        `json:"database_dsn"`
+	Server         ServerConfig  `json:"server"`
+	Company        CompanyConfig `json:"company"`
+	Points         PointsConfig  `json:"points"`
+	Chat           ChatConfig    `json:"chat"`
 }
 
 type ServerConfig struct {
-	Port string `json:"port"`
+	Port           string   `json:"port"`
+	TrustedProxies []string `json:"trusted_proxies"` // Proxies whose X-Forwarded-For header gives the client IP, none by default
+}
+
+// CompanyConfig holds the seller details printed on invoices and emails.
+type CompanyConfig struct {
+	Name    string `json:"name"`
+	Address string `json:"address"`
+	Email   string `json:"email"`
+}
+
+// PointsConfig holds the points settings.
+type PointsConfig struct {
+	ExpiryDays int `json:"expiry_days"` // Days until credited points expire, 0 to never expire
+}
+
+// ChatConfig holds the chat settings.
+type ChatConfig struct {
+	RedisAddr     string `json:"redis_addr"` // Redis server shared by all instances, empty to run a single instance
+	RedisPassword string `json:"redis_password"`
+	RedisDB       int    `json:"redis_db"`
+
+	MessageRate        float64  `json:"message_rate"` // Messages per second a user may send on average, 0 for the default
+	MessageBurst       int      `json:"message_burst"`
+	BlockedWords       []string `json:"blocked_words"`        // Masked in messages
+	RejectBlockedWords bool     `json:"reject_blocked_words"` // Refuse messages with blocked words instead of masking them
 }
 
 var (
@@ -1,9 +1,14 @@
 package database
 
 import (
+	"os"
+	"strings"
+	"time"
+
 	"gorm.io/driver/sqlite"
 	"gorm.io/gorm"
 	"xy.com/mysite/config"
+	"xy.com/mysite/models/chat_models"
 	"xy.com/mysite/models/prize_models"
 	"xy.com/mysite/models/shop_models"
 	"xy.com/mysite/models/user_models"
@@ -13,13 +18,60 @@ var (
 	DB *gorm.DB
 )
 
+// sqliteOptions let concurrent connections wait for each other rather than fail
+// with "database is locked": readers do not block the writer in WAL mode, write
+// transactions take the write lock when they begin instead of midway, and
+// waiting for a lock gives up after five seconds.
+const sqliteOptions = "_journal_mode=WAL&_busy_timeout=5000&_txlock=immediate"
+
+// tempDBPath is the throwaway database opened for an empty DSN, if any.
+var tempDBPath string
+
+// sqliteDSN adds sqliteOptions to the DSN. An empty DSN is, as in SQLite, a
+// throwaway database, but in a temporary file so every connection of the pool
+// shares it.
+func sqliteDSN(dsn string) (string, error) {
+	if dsn == "" {
+		file, err := os.CreateTemp("", "mysite-*.db")
+		if err != nil {
+			return "", err
+		}
+		file.Close()
+		tempDBPath = file.Name()
+		dsn = tempDBPath
+	}
+	if strings.Contains(dsn, "?") {
+		return dsn + "&" + sqliteOptions, nil
+	}
+	return dsn + "?" + sqliteOptions, nil
+}
+
+// closeTempDB closes and removes the throwaway database, if one is open.
+func closeTempDB() {
+	if tempDBPath == "" {
+		return
+	}
+	if DB != nil {
+		if sqlDB, err := DB.DB(); err == nil {
+			sqlDB.Close()
+		}
+	}
+	for _, suffix := range []string{"", "-wal", "-shm"} {
+		os.Remove(tempDBPath + suffix)
+	}
+	tempDBPath = ""
+}
+
 // InitDB initializes the database connection.
 func InitDB() error {
-	var err error
-	dbConfig := config.Instance.DatabaseDSN
+	closeTempDB()
+	dsn, err := sqliteDSN(config.Instance.DatabaseDSN)
+	if err != nil {
+		return err
+	}
 
 	// Create a new SQLite database connection.
-	DB, err = gorm.Open(sqlite.Open(dbConfig), &gorm.Config{})
+	DB, err = gorm.Open(sqlite.Open(dsn), &gorm.Config{})
 	if err != nil {
 		return err
 	}
@@ -35,20 +87,103 @@ func InitDB() error {
 
 // migrateModels migrates the data models to the database.
 func migrateModels() error {
+	// Prizes from before code pools hand out shared codes, see MigrateCodes.
+	legacyPrizes := DB.Migrator().HasTable(&prize_models.Prize{}) && !DB.Migrator().HasColumn(&prize_models.Prize{}, "SharedCodes")
+
 	err := DB.AutoMigrate(
 		&user_models.User{},
+		&user_models.UserSegment{},
+		&user_models.Referral{},
 		&shop_models.Product{},
 		&shop_models.Order{},
 		&shop_models.OrderItem{},
+		&shop_models.Invoice{},
+		&shop_models.InvoiceSequence{},
+		&shop_models.WishlistItem{},
+		&shop_models.Review{},
 		&prize_models.Prize{},
 		&prize_models.ExchangedPrize{},
 		&prize_models.PointsSystem{},
 		&prize_models.Code{},
+		&prize_models.CodePoolAlert{},
+		&prize_models.CodeBatch{},
 		&prize_models.RedemptionCode{},
+		&prize_models.RedemptionUse{},
+		&prize_models.LedgerEntry{},
+		&prize_models.RewardTable{},
+		&prize_models.RewardOutcome{},
+		&prize_models.DrawRecord{},
+		&prize_models.DrawPity{},
+		&prize_models.DrawSeed{},
+		&prize_models.DrawCampaign{},
+		&prize_models.CampaignDailyDraws{},
+		&prize_models.LeaderboardSnapshot{},
+		&prize_models.LeaderboardSnapshotPeriod{},
+		&prize_models.DailyCheckIn{},
+		&prize_models.StreakReward{},
+		&prize_models.AchievementProgress{},
+		&prize_models.UserAchievement{},
+		&prize_models.ExchangeRate{},
+		&prize_models.CoinExchangeDaily{},
+		&prize_models.PointsBucket{},
+		&chat_models.ChatMessage{},
+		&chat_models.ChatReadReceipt{},
+		&chat_models.ChatSanction{},
+		&chat_models.ChatModerationLog{},
 	)
 	if err != nil {
 		return err
 	}
 
+	err = user_models.MigrateReferralCodes(DB)
+	if err != nil {
+		return err
+	}
+
+	err = prize_models.MigratePrizes(DB)
+	if err != nil {
+		return err
+	}
+
+	err = prize_models.MigrateRedemptionCodes(DB)
+	if err != nil {
+		return err
+	}
+
+	err = prize_models.MigrateCodes(DB, legacyPrizes)
+	if err != nil {
+		return err
+	}
+
+	err = prize_models.MigrateDrawSeeds(DB)
+	if err != nil {
+		return err
+	}
+	err = prize_models.MigrateLeaderboards(DB)
+	if err != nil {
+		return err
+	}
+
+	// Exchanges are unique per user, prize and sequence number since prizes
+	// have per-user limits; drop the older index that allowed one per user.
+	if DB.Migrator().HasIndex(&prize_models.ExchangedPrize{}, "idx_exchanged_user_prize") {
+		err = DB.Migrator().DropIndex(&prize_models.ExchangedPrize{}, "idx_exchanged_user_prize")
+		if err != nil {
+			return err
+		}
+	}
+
+	// Record opening balances for points that predate the ledger.
+	err = prize_models.BackfillOpeningBalances(DB)
+	if err != nil {
+		return err
+	}
+
+	// Put points that predate expiry in a bucket so they can expire too.
+	err = prize_models.BackfillPointsBuckets(DB, time.Now())
+	if err != nil {
+		return err
+	}
+
 	return nil
 }
@@ -0,0 +1,85 @@
+package database_test
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+	"xy.com/mysite/config"
+	"xy.com/mysite/database"
+	"xy.com/mysite/models/prize_models"
+)
+
+// baselineCode is the codes table as it was before code pools.
+type baselineCode struct {
+	gorm.Model
+	Code   string `gorm:"unique"`
+	IsUsed bool
+}
+
+func (baselineCode) TableName() string { return "codes" }
+
+// baselinePrize is the prizes table as it was before code pools.
+type baselinePrize struct {
+	gorm.Model
+	PrizeName string
+	Cost      int
+}
+
+func (baselinePrize) TableName() string { return "prizes" }
+
+func TestMigrateBaselineCodes(t *testing.T) {
+	dsn := filepath.Join(t.TempDir(), "baseline.db")
+	baseline, err := gorm.Open(sqlite.Open(dsn), &gorm.Config{Logger: logger.Default.LogMode(logger.Silent)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.NoError(t, baseline.AutoMigrate(&baselineCode{}, &baselinePrize{}))
+	assert.NoError(t, baseline.Create(&[]baselineCode{{Code: "old-1"}, {Code: "old-2", IsUsed: true}, {Code: "old-3"}}).Error)
+	assert.NoError(t, baseline.Create(&baselinePrize{PrizeName: "Gift card", Cost: 10}).Error)
+	sqlDB, _ := baseline.DB()
+	sqlDB.Close()
+
+	saved := config.Instance.DatabaseDSN
+	config.Instance.DatabaseDSN = dsn
+	defer func() { config.Instance.DatabaseDSN = saved }()
+	assert.NoError(t, database.InitDB())
+	defer func() {
+		if sqlDB, err := database.DB.DB(); err == nil {
+			sqlDB.Close()
+		}
+	}()
+
+	// The old codes join the shared pool, which the old prizes use
+	var nulls int64
+	database.DB.Model(&prize_models.Code{}).Where("prize_id IS NULL OR batch_id IS NULL").Count(&nulls)
+	assert.Equal(t, int64(0), nulls)
+
+	prize, err := prize_models.GetPrizeByName(database.DB, "Gift card")
+	assert.NoError(t, err)
+	assert.True(t, prize.SharedCodes)
+	prize.PerUserLimit = 5
+	assert.NoError(t, prize_models.UpdatePrize(database.DB, prize))
+	assert.NoError(t, prize_models.CreditPoints(database.DB, 1, 100, prize_models.LedgerReasonAdminAdjustment, "test"))
+
+	// New prizes do not, even once the database is migrated again
+	mug := &prize_models.Prize{PrizeName: "Mug", Cost: 10, PerUserLimit: 1}
+	assert.NoError(t, prize_models.CreatePrize(database.DB, mug))
+	if sqlDB, err := database.DB.DB(); err == nil {
+		sqlDB.Close()
+	}
+	assert.NoError(t, database.InitDB())
+	_, err = prize_models.ExchangePrize(database.DB, 1, mug.PrizeName)
+	assert.ErrorIs(t, err, prize_models.ErrNoCodesLeft)
+
+	for _, want := range []string{"old-1", "old-3"} {
+		code, err := prize_models.ExchangePrize(database.DB, 1, prize.PrizeName)
+		assert.NoError(t, err)
+		assert.Equal(t, want, code)
+	}
+	_, err = prize_models.ExchangePrize(database.DB, 1, prize.PrizeName)
+	assert.ErrorIs(t, err, prize_models.ErrNoCodesLeft)
+}
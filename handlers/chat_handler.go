@@ -3,65 +3,339 @@
 package handlers
 
 import (
+	"errors"
+	"fmt"
 	"log"
 	"net/http"
+	"time"
 
+	"github.com/gin-gonic/gin"
 	"github.com/gorilla/websocket"
+	"xy.com/mysite/database"
+	"xy.com/mysite/models"
+	"xy.com/mysite/models/chat_models"
+	"xy.com/mysite/models/user_models"
 )
 
-var clients = make(map[*websocket.Conn]bool)
-var broadcast = make(chan Message)
+// Chat actions sent by clients
+const (
+	ChatActionJoin    = "join"    // Join a room and receive its latest messages
+	ChatActionLeave   = "leave"   // Stop receiving a room's messages
+	ChatActionHistory = "history" // Page back through a conversation's messages
+	ChatActionSend    = "send"    // Post a message to a joined room, or to a user
+	ChatActionTyping  = "typing"  // Tell the conversation the user is typing
+	ChatActionRead    = "read"    // Mark a conversation read up to a message
+)
+
+// Chat event types
+const (
+	ChatEventMessage  = "message"  // A new message
+	ChatEventTyping   = "typing"   // A user is typing
+	ChatEventRead     = "read"     // A user read a conversation up to a message
+	ChatEventPresence = "presence" // A user joined or left a room
+	ChatEventDelete   = "delete"   // A moderator deleted a message
+	ChatEventSystem   = "system"   // The outcome of the client's own request, or a sanction on the user
+)
+
+// Statuses of presence and system events
+const (
+	ChatStatusJoined   = "joined"
+	ChatStatusLeft     = "left"
+	ChatStatusHistory  = "history"
+	ChatStatusError    = "error"
+	ChatStatusMuted    = "muted"
+	ChatStatusUnmuted  = "unmuted"
+	ChatStatusBanned   = "banned"
+	ChatStatusUnbanned = "unbanned"
+)
+
+// DefaultHub serves the chat connections of HandleConnections.
+var DefaultHub = NewHub()
 
 var upGrader = websocket.Upgrader{}
 
+// Message is a request sent by a chat client. Requests are about a room, or,
+// if To is set, about the direct messages with that user.
 type Message struct {
-	Email    string `json:"email"`
-	Username string `json:"username"`
-	Message  string `json:"message"`
+	Action    string `json:"action"`
+	Room      string `json:"room,omitempty"`
+	To        uint   `json:"to,omitempty"`
+	Message   string `json:"message,omitempty"`    // Text to send
+	Before    uint   `json:"before,omitempty"`     // History older than this message ID
+	MessageID uint   `json:"message_id,omitempty"` // Latest message read
 }
 
-// HandleConnections Connect To Server
-func HandleConnections(w http.ResponseWriter, r *http.Request) {
-	ws, err := upGrader.Upgrade(w, r, nil)
+// ChatEvent is the envelope of everything sent to chat clients; Type tells
+// which of the other fields are set. Room is the room name, or the
+// chat_models.DirectRoom of direct messages.
+type ChatEvent struct {
+	Type      string                    `json:"type"`
+	Room      string                    `json:"room,omitempty"`
+	UserID    uint                      `json:"user_id,omitempty"` // Who is typing, read, joined or left
+	Status    string                    `json:"status,omitempty"`  // Presence and system events
+	Message   *chat_models.ChatMessage  `json:"message,omitempty"`
+	MessageID uint                      `json:"message_id,omitempty"` // Read and delete events
+	History   []chat_models.ChatMessage `json:"history,omitempty"`    // Oldest first
+	Sanction  *chat_models.ChatSanction `json:"sanction,omitempty"`   // Mutes and bans; Room is empty if it applies everywhere
+	Error     string                    `json:"error,omitempty"`
+	Code      string                    `json:"code,omitempty"`
+}
+
+// HandleConnections Connect To Server. The user is the one authenticated by
+// AuthMiddleware, whatever the client claims in its messages.
+func HandleConnections(c *gin.Context) {
+	DefaultHub.HandleConnections(c)
+}
+
+// HandleMessages runs DefaultHub.
+func HandleMessages() {
+	DefaultHub.Run()
+}
+
+// HandleConnections upgrades the request to a chat connection served by the hub.
+func (h *Hub) HandleConnections(c *gin.Context) {
+	userID, ok := c.Get("userID")
+	if ok {
+		_, ok = userID.(uint)
+	}
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+		return
+	}
+	user, err := user_models.GetUserByID(database.DB, userID.(uint))
+	if err != nil {
+		c.Error(err)
+		return
+	}
+	if err := chat_models.CheckCanJoin(database.DB, user.ID, ""); err != nil {
+		c.Error(err)
+		return
+	}
+
+	ws, err := upGrader.Upgrade(c.Writer, c.Request, nil)
 	if err != nil {
-		log.Fatal(err)
+		// The upgrader has already replied
+		log.Printf("chat: upgrade failed: %v", err)
+		return
+	}
+
+	cl := &client{
+		hub:      h,
+		conn:     ws,
+		send:     make(chan []byte, h.SendBufferSize),
+		userID:   user.ID,
+		username: user.Username,
+		rooms:    make(map[string]bool),
 	}
-	defer func(ws *websocket.Conn) {
-		err := ws.Close()
+	h.register <- cl
+	go cl.writePump()
+	cl.readPump()
+}
+
+// handle carries out a client's request, replying with the error if it fails.
+func (cl *client) handle(msg Message) {
+	if err := cl.handleAction(msg); err != nil {
+		cl.replyError(msg.Room, err)
+	}
+}
+
+// replyError sends the client the error of one of its requests.
+func (cl *client) replyError(room string, err error) {
+	event := ChatEvent{Type: ChatEventSystem, Status: ChatStatusError, Room: room, Error: err.Error()}
+	var modelErr *models.Error
+	if errors.As(err, &modelErr) {
+		event.Code = modelErr.Code
+	} else {
+		log.Printf("chat: user %d: %v", cl.userID, err)
+		event.Error = "internal server error"
+	}
+	cl.reply(event)
+}
+
+// conversation is a room or the direct messages between two users.
+type conversation struct {
+	room    string
+	userIDs []uint // Direct messages only
+}
+
+// conversation returns the conversation a request is about. Rooms must have been joined.
+func (cl *client) conversation(msg Message) (*conversation, error) {
+	if msg.To == 0 {
+		if err := chat_models.ValidateRoom(msg.Room); err != nil {
+			return nil, err
+		}
+		if !cl.rooms[msg.Room] {
+			return nil, chat_models.ErrNotInRoom
+		}
+		return &conversation{room: msg.Room}, nil
+	}
+
+	if msg.To == cl.userID {
+		return nil, fmt.Errorf("%w: cannot message yourself", chat_models.ErrInvalidRecipient)
+	}
+	if _, err := user_models.GetUserByID(database.DB, msg.To); err != nil {
+		return nil, err
+	}
+	return &conversation{room: chat_models.DirectRoom(cl.userID, msg.To), userIDs: []uint{cl.userID, msg.To}}, nil
+}
+
+// sanctionRoom is the room sanctions are checked in, none for direct messages.
+func (conv *conversation) sanctionRoom() string {
+	if conv.userIDs != nil {
+		return ""
+	}
+	return conv.room
+}
+
+// publish sends an event to everyone in the conversation.
+func (cl *client) publish(conv *conversation, event ChatEvent) error {
+	return cl.hub.publishTo(conv.room, conv.userIDs, event)
+}
+
+// publishTo sends an event to everyone in the room, or to the users of direct messages.
+func (h *Hub) publishTo(room string, userIDs []uint, event ChatEvent) error {
+	event.Room = room
+	if userIDs != nil {
+		return h.publish(routedEvent{UserIDs: userIDs}, event)
+	}
+	return h.publish(routedEvent{Room: room}, event)
+}
+
+func (cl *client) handleAction(msg Message) error {
+	switch msg.Action {
+	case ChatActionJoin:
+		return cl.join(msg.Room)
+
+	case ChatActionLeave:
+		if err := chat_models.ValidateRoom(msg.Room); err != nil {
+			return err
+		}
+		if err := chat_models.DeleteReadReceipt(database.DB, cl.userID, msg.Room); err != nil {
+			return err
+		}
+		cl.leave(msg.Room)
+		cl.reply(ChatEvent{Type: ChatEventSystem, Status: ChatStatusLeft, Room: msg.Room})
+		return nil
+	}
+
+	conv, err := cl.conversation(msg)
+	if err != nil {
+		return err
+	}
+	switch msg.Action {
+	case ChatActionHistory:
+		// Banned users may still be in the room until the hub removes them
+		if err := chat_models.CheckCanJoin(database.DB, cl.userID, conv.sanctionRoom()); err != nil {
+			return err
+		}
+		var history []chat_models.ChatMessage
+		if conv.userIDs != nil {
+			history, err = chat_models.GetDirectHistory(database.DB, cl.userID, msg.To, msg.Before, chat_models.DefaultHistorySize)
+		} else {
+			history, err = chat_models.GetRoomHistory(database.DB, conv.room, msg.Before, chat_models.DefaultHistorySize)
+		}
 		if err != nil {
-			log.Fatal(err)
+			return err
+		}
+		cl.reply(ChatEvent{Type: ChatEventSystem, Status: ChatStatusHistory, Room: conv.room, History: history})
+		return nil
+
+	case ChatActionSend, "": // Clients predating typed events send without an action
+		if err := chat_models.CheckCanPost(database.DB, cl.userID, conv.sanctionRoom()); err != nil {
+			return err
 		}
-	}(ws)
+		if !cl.hub.messages.allow(cl.userID, cl.hub.MessageRate, cl.hub.MessageBurst, time.Now()) {
+			return chat_models.ErrRateLimited
+		}
+		body := msg.Message
+		if cl.hub.Filter != nil {
+			if body, err = cl.hub.Filter.Filter(body); err != nil {
+				return err
+			}
+		}
+		message := &chat_models.ChatMessage{Room: conv.room, UserID: cl.userID, RecipientID: msg.To, Username: cl.username, Body: body}
+		if err := chat_models.CreateChatMessage(database.DB, message); err != nil {
+			return err
+		}
+		return cl.publish(conv, ChatEvent{Type: ChatEventMessage, Message: message})
 
-	clients[ws] = true
+	case ChatActionTyping:
+		if err := chat_models.CheckCanPost(database.DB, cl.userID, conv.sanctionRoom()); err != nil {
+			return err
+		}
+		return cl.publish(conv, ChatEvent{Type: ChatEventTyping, UserID: cl.userID})
 
-	for {
-		var msg Message
-		err := ws.ReadJSON(&msg)
+	case ChatActionRead:
+		receipt, err := chat_models.MarkRead(database.DB, cl.userID, conv.room, msg.MessageID)
 		if err != nil {
-			log.Printf("error: %v", err)
-			delete(clients, ws)
-			break
+			return err
 		}
+		return cl.publish(conv, ChatEvent{Type: ChatEventRead, UserID: cl.userID, MessageID: receipt.LastReadID})
+	}
+	return fmt.Errorf("%w %q", chat_models.ErrInvalidAction, msg.Action)
+}
+
+// join joins a room, sends the client its latest messages, which it has now
+// read, and tells the room.
+func (cl *client) join(room string) error {
+	if err := chat_models.ValidateRoom(room); err != nil {
+		return err
+	}
+	if err := chat_models.CheckCanJoin(database.DB, cl.userID, room); err != nil {
+		return err
+	}
 
-		broadcast <- msg
+	// Subscribe before reading the history so no message falls in between;
+	// one posted meanwhile may arrive both live and in the history.
+	cl.rooms[room] = true
+	cl.hub.subscribe <- subscription{client: cl, room: room, join: true}
+	history, err := chat_models.GetRoomHistory(database.DB, room, 0, chat_models.DefaultHistorySize)
+	if err != nil {
+		return err
+	}
+	var lastID uint
+	if len(history) > 0 {
+		lastID = history[len(history)-1].ID
+	}
+	if _, err := chat_models.MarkRead(database.DB, cl.userID, room, lastID); err != nil {
+		return err
 	}
+	cl.reply(ChatEvent{Type: ChatEventSystem, Status: ChatStatusJoined, Room: room, History: history})
+	return cl.hub.publish(routedEvent{Room: room}, ChatEvent{Type: ChatEventPresence, Status: ChatStatusJoined, Room: room, UserID: cl.userID})
 }
 
-func HandleMessages() {
-	for {
-		msg := <-broadcast
-
-		for client := range clients {
-			err := client.WriteJSON(msg)
-			if err != nil {
-				log.Printf("error: %v", err)
-				err := client.Close()
-				if err != nil {
-					return
-				}
-				delete(clients, client)
-			}
-		}
+// leave leaves a room and tells the room. Clients that disconnect leave every room.
+func (cl *client) leave(room string) {
+	if !cl.rooms[room] {
+		return
+	}
+	delete(cl.rooms, room)
+	cl.hub.subscribe <- subscription{client: cl, room: room}
+	event := ChatEvent{Type: ChatEventPresence, Status: ChatStatusLeft, Room: room, UserID: cl.userID}
+	if err := cl.hub.publish(routedEvent{Room: room}, event); err != nil {
+		log.Printf("chat: user %d: %v", cl.userID, err)
+	}
+}
+
+// GetUnreadCountsHandler handles fetching the user's unread messages per conversation.
+func GetUnreadCountsHandler(c *gin.Context) {
+	userID, ok := c.Get("userID")
+	if ok {
+		_, ok = userID.(uint)
+	}
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+		return
+	}
+
+	counts, err := chat_models.GetUnreadCounts(database.DB, userID.(uint))
+	if err != nil {
+		c.Error(err)
+		return
+	}
+	total := 0
+	for _, count := range counts {
+		total += count.Count
 	}
+	c.JSON(http.StatusOK, gin.H{"conversations": counts, "total": total})
 }
@@ -3,55 +3,380 @@
 package handlers
 
 import (
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
 	"net/http/httptest"
 	"net/url"
+	"strconv"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/gorilla/websocket"
 	"github.com/stretchr/testify/assert"
+	"xy.com/mysite/database"
+	"xy.com/mysite/middleware"
+	"xy.com/mysite/models/chat_models"
+	"xy.com/mysite/models/user_models"
 )
 
-func TestHandleConnectionsAndMessages(t *testing.T) {
+func setupChatServer(t *testing.T, hub *Hub) *httptest.Server {
+	go hub.Run()
 	router := gin.Default()
+	router.Use(middleware.ErrorHandler())
+	// Stands in for AuthMiddleware, the user ID comes from the query
 	router.GET("/ws", func(c *gin.Context) {
-		HandleConnections(c.Writer, c.Request)
-	})
+		if id, err := strconv.Atoi(c.Query("user")); err == nil {
+			c.Set("userID", uint(id))
+		}
+		c.Next()
+	}, hub.HandleConnections)
 
 	server := httptest.NewServer(router)
-	defer server.Close()
-
-	u := url.URL{Scheme: "ws", Host: server.Listener.Addr().String(), Path: "/ws"}
+	t.Cleanup(server.Close)
+	return server
+}
 
-	// Create WebSocket Connect
+func dialChat(t *testing.T, server *httptest.Server, userID uint) *websocket.Conn {
+	u := url.URL{Scheme: "ws", Host: server.Listener.Addr().String(), Path: "/ws", RawQuery: "user=" + strconv.Itoa(int(userID))}
 	c, _, err := websocket.DefaultDialer.Dial(u.String(), nil)
 	if err != nil {
 		t.Fatalf("dial: %v", err)
 	}
-	defer c.Close()
+	t.Cleanup(func() { c.Close() })
+	return c
+}
 
-	// Send A Message
-	message := Message{
-		Email:    "test@example.com",
-		Username: "test_user",
-		Message:  "Hello, world!",
+func nextEvent(t *testing.T, c *websocket.Conn) ChatEvent {
+	var event ChatEvent
+	c.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if err := c.ReadJSON(&event); err != nil {
+		t.Fatalf("read: %v", err)
 	}
+	return event
+}
 
-	err = c.WriteJSON(message)
-	if err != nil {
-		t.Fatalf("write: %v", err)
+// readEvent reads the next event, skipping presence events.
+func readEvent(t *testing.T, c *websocket.Conn) ChatEvent {
+	for {
+		if event := nextEvent(t, c); event.Type != ChatEventPresence {
+			return event
+		}
 	}
+}
 
-	go HandleMessages()
+// waitPresence reads until the next presence event about the user.
+func waitPresence(t *testing.T, c *websocket.Conn, userID uint) ChatEvent {
+	for {
+		if event := nextEvent(t, c); event.Type == ChatEventPresence && event.UserID == userID {
+			return event
+		}
+	}
+}
 
-	// Read Message and Verify
-	var receivedMessage Message
-	err = c.ReadJSON(&receivedMessage)
-	if err != nil {
-		t.Fatalf("read: %v", err)
+func TestHandleConnectionsAndMessages(t *testing.T) {
+	database.InitDB()
+	for _, name := range []string{"alice", "bob", "carol"} {
+		user := &user_models.User{Username: name, Email: name + "@example.com", Password: "password"}
+		assert.NoError(t, user_models.CreateUser(database.DB, user))
+	}
+	server := setupChatServer(t, NewHub())
+
+	// Anonymous sockets are turned away
+	u := url.URL{Scheme: "ws", Host: server.Listener.Addr().String(), Path: "/ws"}
+	_, resp, err := websocket.DefaultDialer.Dial(u.String(), nil)
+	assert.Error(t, err)
+	if assert.NotNil(t, resp) {
+		assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
 	}
 
-	assert.Equal(t, message.Email, receivedMessage.Email)
-	assert.Equal(t, message.Username, receivedMessage.Username)
-	assert.Equal(t, message.Message, receivedMessage.Message)
+	alice := dialChat(t, server, 1)
+	bob := dialChat(t, server, 2)
+
+	// Sending needs the room to be joined
+	assert.NoError(t, alice.WriteJSON(Message{Action: ChatActionSend, Room: "general", Message: "Hello?"}))
+	assert.Equal(t, "not_in_room", readEvent(t, alice).Code)
+	assert.NoError(t, alice.WriteJSON(Message{Action: ChatActionJoin, Room: "Not A Room"}))
+	assert.Equal(t, "invalid_room", readEvent(t, alice).Code)
+
+	for _, c := range []*websocket.Conn{alice, bob} {
+		assert.NoError(t, c.WriteJSON(Message{Action: ChatActionJoin, Room: "general"}))
+		event := readEvent(t, c)
+		assert.Equal(t, ChatStatusJoined, event.Status)
+		assert.Empty(t, event.History)
+	}
+
+	// The sender is the authenticated user, and only the room hears it
+	assert.NoError(t, bob.WriteJSON(Message{Action: ChatActionJoin, Room: "random"}))
+	assert.Equal(t, ChatStatusJoined, readEvent(t, bob).Status)
+	assert.NoError(t, bob.WriteJSON(Message{Action: ChatActionSend, Room: "random", Message: "Only me here"}))
+	assert.Equal(t, "Only me here", readEvent(t, bob).Message.Body)
+
+	assert.NoError(t, alice.WriteJSON(map[string]string{"room": "general", "message": "Hello, world!", "username": "mallory"}))
+	for _, c := range []*websocket.Conn{alice, bob} {
+		event := readEvent(t, c)
+		if assert.NotNil(t, event.Message) {
+			assert.Equal(t, "general", event.Message.Room)
+			assert.Equal(t, "Hello, world!", event.Message.Body)
+			assert.Equal(t, uint(1), event.Message.UserID)
+			assert.Equal(t, "alice", event.Message.Username)
+		}
+	}
+
+	// Messages are persisted, joining later gets the history
+	for i := 0; i < 2; i++ {
+		assert.NoError(t, bob.WriteJSON(Message{Action: ChatActionSend, Room: "general", Message: "Message " + strconv.Itoa(i)}))
+		readEvent(t, alice)
+		readEvent(t, bob)
+	}
+	carol := dialChat(t, server, 3)
+	assert.NoError(t, carol.WriteJSON(Message{Action: ChatActionJoin, Room: "general"}))
+	event := readEvent(t, carol)
+	if assert.Len(t, event.History, 3) {
+		assert.Equal(t, "Hello, world!", event.History[0].Body)
+		assert.Equal(t, "Message 1", event.History[2].Body)
+	}
+	assert.NoError(t, carol.WriteJSON(Message{Action: ChatActionHistory, Room: "general", Before: event.History[1].ID}))
+	event = readEvent(t, carol)
+	if assert.Len(t, event.History, 1) {
+		assert.Equal(t, "Hello, world!", event.History[0].Body)
+	}
+
+	// Clients that left a room no longer hear it
+	assert.NoError(t, bob.WriteJSON(Message{Action: ChatActionLeave, Room: "general"}))
+	assert.Equal(t, ChatStatusLeft, readEvent(t, bob).Status)
+	assert.NoError(t, carol.WriteJSON(Message{Action: ChatActionSend, Room: "general", Message: "Bye bob"}))
+	assert.Equal(t, "Bye bob", readEvent(t, alice).Message.Body)
+	assert.Equal(t, "Bye bob", readEvent(t, carol).Message.Body)
+	assert.NoError(t, bob.WriteJSON(Message{Action: ChatActionSend, Room: "random", Message: "Still here"}))
+	assert.Equal(t, "Still here", readEvent(t, bob).Message.Body)
+}
+
+func TestChatDirectMessages(t *testing.T) {
+	database.InitDB()
+	createChatUsers(t, 3)
+	server := setupChatServer(t, NewHub())
+	alice, bob, carol := dialChat(t, server, 1), dialChat(t, server, 2), dialChat(t, server, 3)
+
+	unread := func(userID uint) (response struct {
+		Conversations []chat_models.UnreadCount `json:"conversations"`
+		Total         int                       `json:"total"`
+	}) {
+		router := gin.Default()
+		router.GET("/chat/unread", func(c *gin.Context) {
+			c.Set("userID", userID)
+			c.Next()
+		}, GetUnreadCountsHandler)
+		req, _ := http.NewRequest("GET", "/chat/unread", nil)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+		return response
+	}
+
+	assert.NoError(t, alice.WriteJSON(Message{Action: ChatActionSend, To: 1, Message: "Note to self"}))
+	assert.Equal(t, "invalid_recipient", readEvent(t, alice).Code)
+
+	// Typing and messages reach both users, and no one else
+	assert.NoError(t, alice.WriteJSON(Message{Action: ChatActionTyping, To: 2}))
+	for _, c := range []*websocket.Conn{alice, bob} {
+		event := readEvent(t, c)
+		assert.Equal(t, ChatEventTyping, event.Type)
+		assert.Equal(t, "dm:1:2", event.Room)
+		assert.Equal(t, uint(1), event.UserID)
+	}
+	assert.NoError(t, alice.WriteJSON(Message{Action: ChatActionSend, To: 2, Message: "Psst"}))
+	var message *chat_models.ChatMessage
+	for _, c := range []*websocket.Conn{alice, bob} {
+		event := readEvent(t, c)
+		assert.Equal(t, ChatEventMessage, event.Type)
+		if assert.NotNil(t, event.Message) {
+			assert.Equal(t, "Psst", event.Message.Body)
+			assert.Equal(t, uint(2), event.Message.RecipientID)
+			message = event.Message
+		}
+	}
+
+	response := unread(2)
+	assert.Equal(t, 1, response.Total)
+	assert.Equal(t, []chat_models.UnreadCount{{Room: "dm:1:2", UserID: 1, Count: 1}}, response.Conversations)
+	assert.Zero(t, unread(1).Total)
+
+	// Reading tells the sender
+	assert.NoError(t, bob.WriteJSON(Message{Action: ChatActionHistory, To: 1}))
+	assert.Len(t, readEvent(t, bob).History, 1)
+	assert.NoError(t, bob.WriteJSON(Message{Action: ChatActionRead, To: 1, MessageID: message.ID}))
+	event := readEvent(t, alice)
+	assert.Equal(t, ChatEventRead, event.Type)
+	assert.Equal(t, uint(2), event.UserID)
+	assert.Equal(t, message.ID, event.MessageID)
+	readEvent(t, bob)
+	assert.Zero(t, unread(2).Total)
+
+	// Room members hear who joins, carol never heard the direct messages
+	assert.NoError(t, alice.WriteJSON(Message{Action: ChatActionJoin, Room: "general"}))
+	assert.Equal(t, ChatStatusJoined, readEvent(t, alice).Status)
+	assert.NoError(t, carol.WriteJSON(Message{Action: ChatActionJoin, Room: "general"}))
+	event = nextEvent(t, carol)
+	assert.Equal(t, ChatEventSystem, event.Type)
+	assert.Equal(t, ChatStatusJoined, event.Status)
+	event = waitPresence(t, alice, 3)
+	assert.Equal(t, ChatStatusJoined, event.Status)
+
+	assert.NoError(t, alice.WriteJSON(Message{Action: ChatActionSend, Room: "general", Message: "Hi carol"}))
+	assert.Equal(t, "Hi carol", readEvent(t, carol).Message.Body)
+	assert.Equal(t, 1, unread(3).Total)
+
+	// Disconnecting leaves every room
+	carol.Close()
+	assert.Equal(t, ChatStatusLeft, waitPresence(t, alice, 3).Status)
+}
+
+func createChatUsers(t *testing.T, n int) {
+	for i := 1; i <= n; i++ {
+		name := "user" + strconv.Itoa(i)
+		user := &user_models.User{Username: name, Email: name + "@example.com", Password: "password"}
+		assert.NoError(t, user_models.CreateUser(database.DB, user))
+	}
+}
+
+func TestChatConcurrentClients(t *testing.T) {
+	database.InitDB()
+	const clientCount, perClient = 8, 5
+	createChatUsers(t, clientCount)
+	server := setupChatServer(t, NewHub())
+
+	conns := make([]*websocket.Conn, clientCount)
+	for i := range conns {
+		conns[i] = dialChat(t, server, uint(i+1))
+		assert.NoError(t, conns[i].WriteJSON(Message{Action: ChatActionJoin, Room: "general"}))
+		assert.Equal(t, ChatStatusJoined, readEvent(t, conns[i]).Status)
+	}
+
+	// Everyone sends at once and everyone hears every message
+	var wg sync.WaitGroup
+	for i, c := range conns {
+		wg.Add(1)
+		go func(i int, c *websocket.Conn) {
+			defer wg.Done()
+			for j := 0; j < perClient; j++ {
+				assert.NoError(t, c.WriteJSON(Message{Action: ChatActionSend, Room: "general", Message: strconv.Itoa(i) + "/" + strconv.Itoa(j)}))
+			}
+		}(i, c)
+	}
+	received := make([]map[uint]bool, clientCount)
+	for i, c := range conns {
+		wg.Add(1)
+		go func(i int, c *websocket.Conn) {
+			defer wg.Done()
+			received[i] = make(map[uint]bool)
+			for len(received[i]) < clientCount*perClient {
+				var event ChatEvent
+				c.SetReadDeadline(time.Now().Add(5 * time.Second))
+				if err := c.ReadJSON(&event); err != nil {
+					t.Errorf("read: %v", err)
+					return
+				}
+				if event.Type == ChatEventMessage {
+					received[i][event.Message.ID] = true
+				}
+			}
+		}(i, c)
+	}
+	wg.Wait()
+	for i := range received {
+		assert.Len(t, received[i], clientCount*perClient)
+	}
+}
+
+func TestChatPingPong(t *testing.T) {
+	database.InitDB()
+	createChatUsers(t, 2)
+	hub := NewHub()
+	hub.PingPeriod = 20 * time.Millisecond
+	hub.PongWait = 100 * time.Millisecond
+	server := setupChatServer(t, hub)
+
+	// Clients that keep reading answer pings and stay connected
+	alive := dialChat(t, server, 1)
+	var pings int32
+	alive.SetPingHandler(func(data string) error {
+		atomic.AddInt32(&pings, 1)
+		return alive.WriteControl(websocket.PongMessage, []byte(data), time.Now().Add(time.Second))
+	})
+	done := make(chan error, 1)
+	go func() {
+		for {
+			alive.SetReadDeadline(time.Now().Add(5 * time.Second))
+			if _, _, err := alive.ReadMessage(); err != nil {
+				done <- err
+				return
+			}
+		}
+	}()
+
+	// Clients that do not are dropped once the pong wait is over
+	silent := dialChat(t, server, 2)
+	time.Sleep(300 * time.Millisecond)
+	silent.SetReadDeadline(time.Now().Add(5 * time.Second))
+	var err error
+	for err == nil {
+		_, _, err = silent.ReadMessage()
+	}
+	var netErr net.Error
+	assert.False(t, errors.As(err, &netErr) && netErr.Timeout(), "silent client was not disconnected")
+
+	assert.GreaterOrEqual(t, atomic.LoadInt32(&pings), int32(5))
+	select {
+	case err := <-done:
+		t.Fatalf("client answering pings was disconnected: %v", err)
+	default:
+	}
+}
+
+func TestHubDropsSlowClients(t *testing.T) {
+	hub := NewHub()
+	hub.SendBufferSize = 2
+	go hub.Run()
+
+	// Clients without pumps; the slow one never drains its queue
+	newClient := func(buffer int) *client {
+		cl := &client{hub: hub, send: make(chan []byte, buffer), rooms: make(map[string]bool)}
+		hub.register <- cl
+		hub.subscribe <- subscription{client: cl, room: "general", join: true}
+		return cl
+	}
+	slow, fast := newClient(hub.SendBufferSize), newClient(10)
+
+	drained := make(chan int)
+	go func() {
+		n := 0
+		for range fast.send {
+			n++
+		}
+		drained <- n
+	}()
+	for i := 0; i < 5; i++ {
+		hub.broadcast <- outgoing{event: ChatEvent{Room: "general"}}
+	}
+
+	// The slow client was dropped once its queue was full, closing it
+	n := 0
+	for range slow.send {
+		n++
+	}
+	assert.Equal(t, 2, n)
+
+	// Events for a dropped client, or from it, are ignored
+	hub.broadcast <- outgoing{to: slow, event: ChatEvent{Room: "general"}}
+	hub.subscribe <- subscription{client: slow, room: "general", join: true}
+	hub.unregister <- slow
+
+	hub.unregister <- fast
+	assert.Equal(t, 5, <-drained)
 }
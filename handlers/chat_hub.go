@@ -0,0 +1,348 @@
+// handlers/chat_hub.go
+
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"xy.com/mysite/models/chat_models"
+)
+
+// Hub defaults
+const (
+	defaultWriteWait      = 10 * time.Second
+	defaultPongWait       = 60 * time.Second
+	defaultSendBufferSize = 64
+	defaultMaxRequestSize = 8192 // Bytes
+	defaultRequestRate    = 10   // Per second
+	defaultRequestBurst   = 30
+	defaultMessageRate    = 1 // Per second
+	defaultMessageBurst   = 5
+)
+
+// Hub keeps track of the connected chat clients and the rooms they joined, and
+// delivers events to them. All of its state is owned by the Run goroutine;
+// connections only talk to it through its channels. Events for rooms and users
+// go through the hub's PubSub, so hubs sharing one reach each other's clients.
+type Hub struct {
+	WriteWait      time.Duration // Time allowed to write an event
+	PongWait       time.Duration // Time allowed to answer a ping
+	PingPeriod     time.Duration // Must be less than PongWait
+	SendBufferSize int           // Events queued per client before it is dropped as too slow
+	MaxRequestSize int64         // Bytes; larger requests close the connection
+	RequestRate    float64       // Requests per second a user may make on average, 0 for no limit
+	RequestBurst   int           // Requests a user may make at once
+	MessageRate    float64       // Messages per second a user may send on average, 0 for no limit
+	MessageBurst   int           // Messages a user may send at once
+
+	// Filter checks messages before they are posted, if set.
+	Filter chat_models.ContentFilter
+
+	requests *rateLimiter
+	messages *rateLimiter
+
+	pubsub     PubSub
+	events     <-chan []byte // Routed events received from pubsub
+	register   chan *client
+	unregister chan *client
+	subscribe  chan subscription
+	broadcast  chan outgoing
+
+	clients map[*client]bool
+	rooms   map[string]map[*client]bool
+	users   map[uint]map[*client]bool // Clients of each user, who may be connected several times
+}
+
+// client is the chat connection of an authenticated user.
+type client struct {
+	hub      *Hub
+	conn     *websocket.Conn
+	send     chan []byte // Closed by the hub once the client is gone
+	userID   uint
+	username string
+	rooms    map[string]bool // Rooms joined, owned by the read pump
+}
+
+// subscription adds a client to a room or removes it.
+type subscription struct {
+	client *client
+	room   string
+	join   bool
+}
+
+// routedEvent is an event published for the clients in a room, or of some users.
+// Once the users' clients have the event, they are removed from LeaveRoom, or
+// disconnected if Disconnect is set.
+type routedEvent struct {
+	Room       string          `json:"room,omitempty"`
+	UserIDs    []uint          `json:"user_ids,omitempty"`
+	Event      json.RawMessage `json:"event"`
+	LeaveRoom  string          `json:"leave_room,omitempty"`
+	Disconnect bool            `json:"disconnect,omitempty"`
+}
+
+// outgoing is an event for one client, or for every client in the event's room.
+type outgoing struct {
+	to    *client
+	event ChatEvent
+}
+
+// NewHub creates a hub with the default timeouts on an in-process PubSub. Run
+// must be started for it to serve connections.
+func NewHub() *Hub {
+	hub, err := NewHubWithPubSub(NewMemoryPubSub())
+	if err != nil {
+		// Subscribing in memory cannot fail
+		panic(err)
+	}
+	return hub
+}
+
+// NewHubWithPubSub creates a hub with the default timeouts whose room events go
+// through pubsub. It subscribes right away, so any subscription error surfaces here.
+func NewHubWithPubSub(pubsub PubSub) (*Hub, error) {
+	events, err := pubsub.Subscribe(context.Background(), chatEventsChannel)
+	if err != nil {
+		return nil, fmt.Errorf("failed to subscribe to chat events: %w", err)
+	}
+	return &Hub{
+		pubsub:         pubsub,
+		events:         events,
+		WriteWait:      defaultWriteWait,
+		PongWait:       defaultPongWait,
+		PingPeriod:     defaultPongWait * 9 / 10,
+		SendBufferSize: defaultSendBufferSize,
+		MaxRequestSize: defaultMaxRequestSize,
+		RequestRate:    defaultRequestRate,
+		RequestBurst:   defaultRequestBurst,
+		MessageRate:    defaultMessageRate,
+		MessageBurst:   defaultMessageBurst,
+		requests:       newRateLimiter(),
+		messages:       newRateLimiter(),
+		register:       make(chan *client),
+		unregister:     make(chan *client),
+		subscribe:      make(chan subscription),
+		broadcast:      make(chan outgoing),
+		clients:        make(map[*client]bool),
+		rooms:          make(map[string]map[*client]bool),
+		users:          make(map[uint]map[*client]bool),
+	}, nil
+}
+
+// Run serves the hub's channels until the program exits.
+func (h *Hub) Run() {
+	for {
+		select {
+		case cl := <-h.register:
+			h.clients[cl] = true
+			if h.users[cl.userID] == nil {
+				h.users[cl.userID] = make(map[*client]bool)
+			}
+			h.users[cl.userID][cl] = true
+
+		case cl := <-h.unregister:
+			h.drop(cl)
+
+		case sub := <-h.subscribe:
+			if !h.clients[sub.client] {
+				continue
+			}
+			if !sub.join {
+				h.leave(sub.client, sub.room)
+				continue
+			}
+			members := h.rooms[sub.room]
+			if members == nil {
+				members = make(map[*client]bool)
+				h.rooms[sub.room] = members
+			}
+			members[sub.client] = true
+
+		case out := <-h.broadcast:
+			data, err := json.Marshal(out.event)
+			if err != nil {
+				log.Printf("chat: failed to encode event: %v", err)
+				continue
+			}
+			if out.to != nil {
+				if h.clients[out.to] {
+					h.deliver(out.to, data)
+				}
+				continue
+			}
+			h.deliverRoom(out.event.Room, data)
+
+		case data, ok := <-h.events:
+			if !ok {
+				log.Printf("chat: pub/sub subscription closed, room events are no longer delivered")
+				h.events = nil
+				continue
+			}
+			var routed routedEvent
+			if err := json.Unmarshal(data, &routed); err != nil {
+				log.Printf("chat: failed to decode event: %v", err)
+				continue
+			}
+			if routed.Room != "" {
+				h.deliverRoom(routed.Room, routed.Event)
+			}
+			for _, userID := range routed.UserIDs {
+				for cl := range h.users[userID] {
+					h.deliver(cl, routed.Event)
+					if routed.Disconnect {
+						h.drop(cl)
+					} else if routed.LeaveRoom != "" {
+						h.leave(cl, routed.LeaveRoom)
+					}
+				}
+			}
+		}
+	}
+}
+
+// publish sends an event to the clients routed to, in a room or of some users,
+// on every hub sharing the PubSub.
+func (h *Hub) publish(routed routedEvent, event ChatEvent) error {
+	encoded, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	routed.Event = encoded
+	data, err := json.Marshal(routed)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), h.WriteWait)
+	defer cancel()
+	if err := h.pubsub.Publish(ctx, chatEventsChannel, data); err != nil {
+		return fmt.Errorf("failed to publish chat event: %w", err)
+	}
+	return nil
+}
+
+// leave removes the client from the room.
+func (h *Hub) leave(cl *client, room string) {
+	if members := h.rooms[room]; members != nil {
+		delete(members, cl)
+		if len(members) == 0 {
+			delete(h.rooms, room)
+		}
+	}
+}
+
+// deliverRoom queues an event for every client in the room.
+func (h *Hub) deliverRoom(room string, data []byte) {
+	for cl := range h.rooms[room] {
+		h.deliver(cl, data)
+	}
+}
+
+// deliver queues an event for the client without waiting. Clients too slow to
+// keep up are dropped rather than holding up everyone else.
+func (h *Hub) deliver(cl *client, data []byte) {
+	select {
+	case cl.send <- data:
+	default:
+		log.Printf("chat: dropping user %d, send queue full", cl.userID)
+		h.drop(cl)
+	}
+}
+
+// drop forgets the client and closes its send queue, which makes its write
+// pump close the connection. Dropping a client twice has no effect.
+func (h *Hub) drop(cl *client) {
+	if !h.clients[cl] {
+		return
+	}
+	delete(h.clients, cl)
+	delete(h.users[cl.userID], cl)
+	if len(h.users[cl.userID]) == 0 {
+		delete(h.users, cl.userID)
+	}
+	for room, members := range h.rooms {
+		delete(members, cl)
+		if len(members) == 0 {
+			delete(h.rooms, room)
+		}
+	}
+	close(cl.send)
+}
+
+// writePump writes the client's queued events to its connection and pings it
+// every PingPeriod. It is the only goroutine writing to the connection.
+func (cl *client) writePump() {
+	ticker := time.NewTicker(cl.hub.PingPeriod)
+	defer func() {
+		ticker.Stop()
+		cl.conn.Close()
+	}()
+
+	for {
+		select {
+		case data, ok := <-cl.send:
+			cl.conn.SetWriteDeadline(time.Now().Add(cl.hub.WriteWait))
+			if !ok {
+				// The hub dropped the client
+				cl.conn.WriteMessage(websocket.CloseMessage, []byte{})
+				return
+			}
+			if err := cl.conn.WriteMessage(websocket.TextMessage, data); err != nil {
+				return
+			}
+
+		case <-ticker.C:
+			cl.conn.SetWriteDeadline(time.Now().Add(cl.hub.WriteWait))
+			if err := cl.conn.WriteMessage(websocket.PingMessage, nil); err != nil {
+				return
+			}
+		}
+	}
+}
+
+// readPump reads the client's requests until the connection fails or the
+// client stops answering pings, then unregisters it.
+func (cl *client) readPump() {
+	defer func() {
+		for room := range cl.rooms {
+			cl.leave(room)
+		}
+		cl.hub.unregister <- cl
+		cl.conn.Close()
+	}()
+
+	cl.conn.SetReadLimit(cl.hub.MaxRequestSize)
+	cl.conn.SetReadDeadline(time.Now().Add(cl.hub.PongWait))
+	cl.conn.SetPongHandler(func(string) error {
+		return cl.conn.SetReadDeadline(time.Now().Add(cl.hub.PongWait))
+	})
+
+	for {
+		_, data, err := cl.conn.ReadMessage()
+		if err != nil {
+			if websocket.IsUnexpectedCloseError(err, websocket.CloseGoingAway, websocket.CloseNormalClosure) {
+				log.Printf("chat: user %d: %v", cl.userID, err)
+			}
+			return
+		}
+		if !cl.hub.requests.allow(cl.userID, cl.hub.RequestRate, cl.hub.RequestBurst, time.Now()) {
+			cl.replyError("", chat_models.ErrRateLimited)
+			continue
+		}
+		var msg Message
+		if err := json.Unmarshal(data, &msg); err != nil {
+			cl.reply(ChatEvent{Type: ChatEventSystem, Status: ChatStatusError, Error: "invalid request", Code: "invalid_request"})
+			continue
+		}
+		cl.handle(msg)
+	}
+}
+
+// reply sends an event to the client alone.
+func (cl *client) reply(event ChatEvent) {
+	cl.hub.broadcast <- outgoing{to: cl, event: event}
+}
@@ -0,0 +1,168 @@
+// handlers/chat_moderation_handler.go
+
+package handlers
+
+import (
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"xy.com/mysite/database"
+	"xy.com/mysite/models/chat_models"
+	"xy.com/mysite/models/user_models"
+)
+
+// notifySanction tells the user's clients about a sanction imposed or lifted.
+// Banned users are removed from the room, or disconnected if banned everywhere.
+func (h *Hub) notifySanction(sanction *chat_models.ChatSanction, lifted bool) error {
+	statuses := map[string][2]string{
+		chat_models.SanctionMute: {ChatStatusMuted, ChatStatusUnmuted},
+		chat_models.SanctionBan:  {ChatStatusBanned, ChatStatusUnbanned},
+	}
+	event := ChatEvent{Type: ChatEventSystem, Status: statuses[sanction.Kind][0], Room: sanction.Room, Sanction: sanction}
+	routed := routedEvent{UserIDs: []uint{sanction.UserID}}
+	if lifted {
+		event.Status = statuses[sanction.Kind][1]
+	} else if sanction.Kind == chat_models.SanctionBan {
+		routed.LeaveRoom = sanction.Room
+		routed.Disconnect = sanction.Room == ""
+	}
+	return h.publish(routed, event)
+}
+
+// moderatorID returns the user acting as moderator, replying unauthorized if
+// the request is not authenticated.
+func moderatorID(c *gin.Context) (uint, bool) {
+	userID, ok := c.Get("userID")
+	if ok {
+		_, ok = userID.(uint)
+	}
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+		return 0, false
+	}
+	return userID.(uint), true
+}
+
+// SanctionChatUserHandler handles muting or banning a user, in a room or everywhere.
+func SanctionChatUserHandler(c *gin.Context) {
+	moderator, ok := moderatorID(c)
+	if !ok {
+		return
+	}
+	userID, err := strconv.Atoi(c.Param("userID"))
+	if err != nil {
+		c.Error(err).SetType(gin.ErrorTypeBind)
+		return
+	}
+
+	var req struct {
+		Room      string     `json:"room"` // Empty for everywhere
+		Reason    string     `json:"reason"`
+		ExpiresAt *time.Time `json:"expires_at"` // Omitted for a permanent sanction
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.Error(err).SetType(gin.ErrorTypeBind)
+		return
+	}
+
+	if _, err := user_models.GetUserByID(database.DB, uint(userID)); err != nil {
+		c.Error(err)
+		return
+	}
+	sanction := &chat_models.ChatSanction{
+		UserID:    uint(userID),
+		Kind:      c.Param("sanction"),
+		Room:      req.Room,
+		Reason:    req.Reason,
+		ExpiresAt: req.ExpiresAt,
+	}
+	if err := chat_models.Sanction(database.DB, moderator, sanction); err != nil {
+		c.Error(err)
+		return
+	}
+	if err := DefaultHub.notifySanction(sanction, false); err != nil {
+		log.Printf("chat: failed to notify user %d of %s: %v", sanction.UserID, sanction.Kind, err)
+	}
+
+	c.JSON(http.StatusCreated, sanction)
+}
+
+// LiftChatSanctionHandler handles unmuting or unbanning a user. The room and
+// reason are given as query parameters.
+func LiftChatSanctionHandler(c *gin.Context) {
+	moderator, ok := moderatorID(c)
+	if !ok {
+		return
+	}
+	userID, err := strconv.Atoi(c.Param("userID"))
+	if err != nil {
+		c.Error(err).SetType(gin.ErrorTypeBind)
+		return
+	}
+
+	sanction := &chat_models.ChatSanction{UserID: uint(userID), Kind: c.Param("sanction"), Room: c.Query("room")}
+	if err := chat_models.LiftSanction(database.DB, moderator, sanction.UserID, sanction.Kind, sanction.Room, c.Query("reason")); err != nil {
+		c.Error(err)
+		return
+	}
+	if err := DefaultHub.notifySanction(sanction, true); err != nil {
+		log.Printf("chat: failed to notify user %d of lifted %s: %v", sanction.UserID, sanction.Kind, err)
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Sanction lifted"})
+}
+
+// DeleteChatMessageHandler handles deleting a message, with an optional reason
+// query parameter, and tells its conversation.
+func DeleteChatMessageHandler(c *gin.Context) {
+	moderator, ok := moderatorID(c)
+	if !ok {
+		return
+	}
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.Error(err).SetType(gin.ErrorTypeBind)
+		return
+	}
+
+	message, err := chat_models.DeleteChatMessage(database.DB, moderator, uint(id), c.Query("reason"))
+	if err != nil {
+		c.Error(err)
+		return
+	}
+	var userIDs []uint
+	if first, second, ok := chat_models.DirectRoomUsers(message.Room); ok {
+		userIDs = []uint{first, second}
+	}
+	if err := DefaultHub.publishTo(message.Room, userIDs, ChatEvent{Type: ChatEventDelete, MessageID: message.ID}); err != nil {
+		log.Printf("chat: failed to announce deleted message %d: %v", message.ID, err)
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Message deleted"})
+}
+
+// GetChatModerationLogHandler handles fetching the latest moderation actions,
+// optionally about the user_id query parameter only.
+func GetChatModerationLogHandler(c *gin.Context) {
+	userID, err := strconv.Atoi(c.DefaultQuery("user_id", "0"))
+	if err != nil {
+		c.Error(err).SetType(gin.ErrorTypeBind)
+		return
+	}
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", "0"))
+	if err != nil {
+		c.Error(err).SetType(gin.ErrorTypeBind)
+		return
+	}
+
+	entries, err := chat_models.GetModerationLog(database.DB, uint(userID), limit)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	c.JSON(http.StatusOK, entries)
+}
@@ -0,0 +1,166 @@
+// handlers/chat_moderation_handler_test.go
+
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/assert"
+	"xy.com/mysite/database"
+	"xy.com/mysite/middleware"
+	"xy.com/mysite/models/chat_models"
+)
+
+func TestRateLimiter(t *testing.T) {
+	limiter := newRateLimiter()
+	now := time.Now()
+
+	assert.True(t, limiter.allow(1, 1, 2, now))
+	assert.True(t, limiter.allow(1, 1, 2, now))
+	assert.False(t, limiter.allow(1, 1, 2, now))
+	assert.True(t, limiter.allow(2, 1, 2, now), "users have their own bucket")
+	assert.True(t, limiter.allow(1, 1, 2, now.Add(time.Second)))
+	assert.False(t, limiter.allow(1, 1, 2, now.Add(time.Second)))
+	assert.True(t, limiter.allow(1, 0, 0, now), "zero rate is unlimited")
+
+	// Refilled buckets are forgotten
+	assert.True(t, limiter.allow(3, 1, 2, now.Add(time.Hour)))
+	assert.Len(t, limiter.buckets, 1)
+}
+
+func TestChatModeration(t *testing.T) {
+	database.InitDB()
+	createChatUsers(t, 3)
+	hub := NewHub()
+	hub.MessageRate, hub.MessageBurst = 0.001, 3
+	hub.MaxRequestSize = 512
+	hub.Filter = chat_models.NewWordFilter([]string{"darn"})
+	server := setupChatServer(t, hub)
+	defaultHub := DefaultHub
+	DefaultHub = hub
+	t.Cleanup(func() { DefaultHub = defaultHub })
+
+	router := gin.Default()
+	router.Use(middleware.ErrorHandler())
+	admin := router.Group("/admin", func(c *gin.Context) {
+		c.Set("userID", uint(3))
+		c.Next()
+	})
+	admin.POST("/chat/users/:userID/:sanction", SanctionChatUserHandler)
+	admin.DELETE("/chat/users/:userID/:sanction", LiftChatSanctionHandler)
+	admin.DELETE("/chat/messages/:id", DeleteChatMessageHandler)
+	admin.GET("/chat/moderation", GetChatModerationLogHandler)
+	request := func(method, path, body string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	alice, bob := dialChat(t, server, 1), dialChat(t, server, 2)
+	for _, c := range []*websocket.Conn{alice, bob} {
+		assert.NoError(t, c.WriteJSON(Message{Action: ChatActionJoin, Room: "general"}))
+		assert.Equal(t, ChatStatusJoined, readEvent(t, c).Status)
+	}
+
+	// Blocked words are masked, and flooding is cut short
+	var messageIDs []uint
+	for i := 0; i < 3; i++ {
+		assert.NoError(t, alice.WriteJSON(Message{Action: ChatActionSend, Room: "general", Message: "darn it"}))
+		readEvent(t, alice)
+		event := readEvent(t, bob)
+		if assert.NotNil(t, event.Message) {
+			assert.Equal(t, "**** it", event.Message.Body)
+			messageIDs = append(messageIDs, event.Message.ID)
+		}
+	}
+	assert.NoError(t, alice.WriteJSON(Message{Action: ChatActionSend, Room: "general", Message: "more"}))
+	assert.Equal(t, "chat_rate_limited", readEvent(t, alice).Code)
+
+	// Muted users are told, and can no longer post
+	w := request("POST", "/admin/chat/users/2/mute", `{"room":"general","reason":"calm down"}`)
+	assert.Equal(t, http.StatusCreated, w.Code)
+	event := readEvent(t, bob)
+	assert.Equal(t, ChatStatusMuted, event.Status)
+	if assert.NotNil(t, event.Sanction) {
+		assert.Equal(t, "calm down", event.Sanction.Reason)
+	}
+	for _, action := range []string{ChatActionSend, ChatActionTyping} {
+		assert.NoError(t, bob.WriteJSON(Message{Action: action, Room: "general", Message: "but"}))
+		assert.Equal(t, "muted", readEvent(t, bob).Code)
+	}
+	assert.Equal(t, http.StatusBadRequest, request("POST", "/admin/chat/users/2/kick", `{}`).Code)
+	assert.Equal(t, http.StatusNotFound, request("POST", "/admin/chat/users/42/mute", `{}`).Code)
+
+	// Deleted messages are taken back from the room
+	w = request("DELETE", "/admin/chat/messages/"+strconv.Itoa(int(messageIDs[0]))+"?reason=spam", "")
+	assert.Equal(t, http.StatusOK, w.Code)
+	for _, c := range []*websocket.Conn{alice, bob} {
+		event := readEvent(t, c)
+		assert.Equal(t, ChatEventDelete, event.Type)
+		assert.Equal(t, "general", event.Room)
+		assert.Equal(t, messageIDs[0], event.MessageID)
+	}
+
+	// Users banned from a room leave it
+	w = request("POST", "/admin/chat/users/2/ban", `{"room":"general"}`)
+	assert.Equal(t, http.StatusCreated, w.Code)
+	assert.Equal(t, ChatStatusBanned, readEvent(t, bob).Status)
+	assert.NoError(t, bob.WriteJSON(Message{Action: ChatActionJoin, Room: "general"}))
+	assert.Equal(t, "banned", readEvent(t, bob).Code)
+	assert.NoError(t, bob.WriteJSON(Message{Action: ChatActionHistory, Room: "general"}))
+	event = readEvent(t, bob)
+	assert.Equal(t, ChatStatusError, event.Status)
+	assert.Nil(t, event.History)
+
+	// Users banned everywhere are disconnected, and cannot reconnect until unbanned
+	w = request("POST", "/admin/chat/users/1/ban", `{"reason":"flooding"}`)
+	assert.Equal(t, http.StatusCreated, w.Code)
+	assert.Equal(t, ChatStatusBanned, readEvent(t, alice).Status)
+	alice.SetReadDeadline(time.Now().Add(5 * time.Second))
+	_, _, err := alice.ReadMessage()
+	assert.True(t, websocket.IsCloseError(err, websocket.CloseNoStatusReceived), "banned user is still connected: %v", err)
+
+	u := url.URL{Scheme: "ws", Host: server.Listener.Addr().String(), Path: "/ws", RawQuery: "user=1"}
+	_, resp, err := websocket.DefaultDialer.Dial(u.String(), nil)
+	assert.Error(t, err)
+	if assert.NotNil(t, resp) {
+		assert.Equal(t, http.StatusForbidden, resp.StatusCode)
+	}
+	assert.Equal(t, http.StatusOK, request("DELETE", "/admin/chat/users/1/ban?reason=appeal", "").Code)
+	assert.Equal(t, http.StatusNotFound, request("DELETE", "/admin/chat/users/1/ban", "").Code)
+	alice = dialChat(t, server, 1)
+
+	// Oversized requests close the connection
+	assert.NoError(t, alice.WriteJSON(Message{Action: ChatActionSend, Room: "general", Message: strings.Repeat("a", 1024)}))
+	alice.SetReadDeadline(time.Now().Add(5 * time.Second))
+	_, _, err = alice.ReadMessage()
+	assert.Error(t, err)
+
+	// Every action is in the audit log
+	w = request("GET", "/admin/chat/moderation", "")
+	assert.Equal(t, http.StatusOK, w.Code)
+	var log []chat_models.ChatModerationLog
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &log))
+	var actions []string
+	for _, entry := range log {
+		actions = append(actions, entry.Action)
+	}
+	assert.Equal(t, []string{"unban", "ban", "ban", "delete_message", "mute"}, actions)
+	w = request("GET", "/admin/chat/moderation?user_id=1", "")
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &log))
+	if assert.Len(t, log, 3) {
+		assert.Equal(t, "appeal", log[0].Reason)
+		assert.Equal(t, uint(3), log[0].ModeratorID)
+	}
+}
@@ -0,0 +1,99 @@
+// handlers/chat_pubsub.go
+
+package handlers
+
+import (
+	"context"
+	"errors"
+	"sync"
+)
+
+// chatEventsChannel is the pub/sub channel room events are published on.
+const chatEventsChannel = "chat:events"
+
+// subscriberBuffer is how many received messages a subscription queues.
+const subscriberBuffer = 256
+
+var ErrPubSubClosed = errors.New("pub/sub is closed")
+
+// PubSub fans published messages out to every subscriber of a channel. Hubs
+// publish room events to it and deliver what they receive from it, so hubs
+// sharing a backend, e.g. in several instances, reach each other's clients.
+type PubSub interface {
+	// Publish sends data to every current subscriber of the channel.
+	Publish(ctx context.Context, channel string, data []byte) error
+	// Subscribe returns the messages published to the channel from now on. The
+	// returned channel is closed once ctx is done or the PubSub is closed.
+	Subscribe(ctx context.Context, channel string) (<-chan []byte, error)
+	// Close releases the backend.
+	Close() error
+}
+
+// MemoryPubSub is a PubSub within a single process.
+type MemoryPubSub struct {
+	mu          sync.Mutex
+	closed      bool
+	subscribers map[string]map[chan []byte]bool
+}
+
+// NewMemoryPubSub creates an in-process PubSub.
+func NewMemoryPubSub() *MemoryPubSub {
+	return &MemoryPubSub{subscribers: make(map[string]map[chan []byte]bool)}
+}
+
+// Publish sends data to the channel's subscribers, waiting for those whose
+// queue is full until ctx is done.
+func (p *MemoryPubSub) Publish(ctx context.Context, channel string, data []byte) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.closed {
+		return ErrPubSubClosed
+	}
+	for ch := range p.subscribers[channel] {
+		select {
+		case ch <- data:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	return nil
+}
+
+// Subscribe subscribes to the channel until ctx is done.
+func (p *MemoryPubSub) Subscribe(ctx context.Context, channel string) (<-chan []byte, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.closed {
+		return nil, ErrPubSubClosed
+	}
+	ch := make(chan []byte, subscriberBuffer)
+	if p.subscribers[channel] == nil {
+		p.subscribers[channel] = make(map[chan []byte]bool)
+	}
+	p.subscribers[channel][ch] = true
+
+	go func() {
+		<-ctx.Done()
+		p.mu.Lock()
+		defer p.mu.Unlock()
+		if p.subscribers[channel][ch] {
+			delete(p.subscribers[channel], ch)
+			close(ch)
+		}
+	}()
+	return ch, nil
+}
+
+// Close closes every subscription.
+func (p *MemoryPubSub) Close() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.closed = true
+	for _, subscribers := range p.subscribers {
+		for ch := range subscribers {
+			close(ch)
+		}
+	}
+	p.subscribers = make(map[string]map[chan []byte]bool)
+	return nil
+}
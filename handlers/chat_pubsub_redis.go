@@ -0,0 +1,63 @@
+// handlers/chat_pubsub_redis.go
+
+package handlers
+
+import (
+	"context"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// RedisPubSub is a PubSub on Redis, shared by every instance using the same server.
+type RedisPubSub struct {
+	client *redis.Client
+}
+
+// NewRedisPubSub creates a PubSub on the Redis server of client.
+func NewRedisPubSub(client *redis.Client) *RedisPubSub {
+	return &RedisPubSub{client: client}
+}
+
+// Publish sends data to the channel's subscribers on every instance.
+func (p *RedisPubSub) Publish(ctx context.Context, channel string, data []byte) error {
+	return p.client.Publish(ctx, channel, data).Err()
+}
+
+// Subscribe subscribes to the channel until ctx is done. It returns once Redis
+// has confirmed the subscription, so nothing published afterwards is missed.
+// Dropped connections are reestablished by the client.
+func (p *RedisPubSub) Subscribe(ctx context.Context, channel string) (<-chan []byte, error) {
+	sub := p.client.Subscribe(ctx, channel)
+	if _, err := sub.Receive(ctx); err != nil {
+		sub.Close()
+		return nil, err
+	}
+
+	messages := sub.Channel()
+	ch := make(chan []byte, subscriberBuffer)
+	go func() {
+		defer close(ch)
+		defer sub.Close()
+		for {
+			select {
+			case msg, ok := <-messages:
+				if !ok {
+					return
+				}
+				select {
+				case ch <- []byte(msg.Payload):
+				case <-ctx.Done():
+					return
+				}
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return ch, nil
+}
+
+// Close closes the Redis client, ending its subscriptions.
+func (p *RedisPubSub) Close() error {
+	return p.client.Close()
+}
@@ -0,0 +1,122 @@
+// handlers/chat_pubsub_test.go
+
+package handlers
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/gorilla/websocket"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+	"xy.com/mysite/database"
+)
+
+func newTestRedisPubSub(t *testing.T, server *miniredis.Miniredis) *RedisPubSub {
+	pubsub := NewRedisPubSub(redis.NewClient(&redis.Options{Addr: server.Addr()}))
+	t.Cleanup(func() { pubsub.Close() })
+	return pubsub
+}
+
+func receive(t *testing.T, ch <-chan []byte) string {
+	select {
+	case data, ok := <-ch:
+		if !ok {
+			t.Fatal("subscription closed")
+		}
+		return string(data)
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for a message")
+	}
+	return ""
+}
+
+func testPubSub(t *testing.T, publisher, subscriber PubSub) {
+	ctx := context.Background()
+	subCtx, cancel := context.WithCancel(ctx)
+	first, err := subscriber.Subscribe(subCtx, "room")
+	assert.NoError(t, err)
+	second, err := publisher.Subscribe(ctx, "room")
+	assert.NoError(t, err)
+	other, err := subscriber.Subscribe(ctx, "other")
+	assert.NoError(t, err)
+
+	// Every subscriber of the channel gets every message, in order
+	assert.NoError(t, publisher.Publish(ctx, "room", []byte("one")))
+	assert.NoError(t, publisher.Publish(ctx, "room", []byte("two")))
+	for _, ch := range []<-chan []byte{first, second} {
+		assert.Equal(t, "one", receive(t, ch))
+		assert.Equal(t, "two", receive(t, ch))
+	}
+	assert.Empty(t, other)
+
+	// Cancelled subscriptions are closed
+	cancel()
+	select {
+	case _, ok := <-first:
+		assert.False(t, ok)
+	case <-time.After(5 * time.Second):
+		t.Fatal("subscription was not closed")
+	}
+	assert.NoError(t, publisher.Publish(ctx, "room", []byte("three")))
+	assert.Equal(t, "three", receive(t, second))
+}
+
+func TestMemoryPubSub(t *testing.T) {
+	pubsub := NewMemoryPubSub()
+	testPubSub(t, pubsub, pubsub)
+
+	ch, err := pubsub.Subscribe(context.Background(), "room")
+	assert.NoError(t, err)
+	assert.NoError(t, pubsub.Close())
+	_, ok := <-ch
+	assert.False(t, ok)
+	assert.ErrorIs(t, pubsub.Publish(context.Background(), "room", nil), ErrPubSubClosed)
+}
+
+func TestRedisPubSub(t *testing.T) {
+	server := miniredis.RunT(t)
+	testPubSub(t, newTestRedisPubSub(t, server), newTestRedisPubSub(t, server))
+
+	// Subscribing fails while Redis is down
+	down := newTestRedisPubSub(t, server)
+	server.Close()
+	_, err := down.Subscribe(context.Background(), "room")
+	assert.Error(t, err)
+}
+
+func TestChatAcrossInstances(t *testing.T) {
+	database.InitDB()
+	createChatUsers(t, 2)
+	redisServer := miniredis.RunT(t)
+
+	// Two instances sharing a Redis server
+	servers := make([]*websocket.Conn, 2)
+	for i := range servers {
+		hub, err := NewHubWithPubSub(newTestRedisPubSub(t, redisServer))
+		if err != nil {
+			t.Fatal(err)
+		}
+		servers[i] = dialChat(t, setupChatServer(t, hub), uint(i+1))
+		assert.NoError(t, servers[i].WriteJSON(Message{Action: ChatActionJoin, Room: "general"}))
+		assert.Equal(t, ChatStatusJoined, readEvent(t, servers[i]).Status)
+	}
+	alice, bob := servers[0], servers[1]
+
+	assert.NoError(t, bob.WriteJSON(Message{Action: ChatActionSend, Room: "general", Message: "Hello from the other side"}))
+	for _, c := range []*websocket.Conn{alice, bob} {
+		event := readEvent(t, c)
+		if assert.NotNil(t, event.Message) {
+			assert.Equal(t, "Hello from the other side", event.Message.Body)
+			assert.Equal(t, "user2", event.Message.Username)
+		}
+	}
+
+	// Replies stay on the instance of their client
+	assert.NoError(t, alice.WriteJSON(Message{Action: ChatActionHistory, Room: "general"}))
+	assert.Len(t, readEvent(t, alice).History, 1)
+	assert.NoError(t, bob.WriteJSON(Message{Action: ChatActionSend, Room: "general", Message: "Still there?"}))
+	assert.Equal(t, "Still there?", readEvent(t, alice).Message.Body)
+}
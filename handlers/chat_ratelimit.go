@@ -0,0 +1,70 @@
+// handlers/chat_ratelimit.go
+
+package handlers
+
+import (
+	"sync"
+	"time"
+)
+
+// rateLimiter is a token bucket per user, shared by all of a user's connections
+// to a hub. Connections to other instances are limited separately.
+type rateLimiter struct {
+	mu        sync.Mutex
+	buckets   map[uint]*tokenBucket
+	lastPrune time.Time
+}
+
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+func newRateLimiter() *rateLimiter {
+	return &rateLimiter{buckets: make(map[uint]*tokenBucket)}
+}
+
+// allow takes a token from the user's bucket, which holds up to burst tokens
+// and refills at rate tokens per second. It reports false if the bucket is
+// empty. A zero rate allows everything.
+func (l *rateLimiter) allow(userID uint, rate float64, burst int, now time.Time) bool {
+	if rate <= 0 {
+		return true
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if now.Sub(l.lastPrune) > time.Minute {
+		l.prune(rate, burst, now)
+	}
+	bucket := l.buckets[userID]
+	if bucket == nil {
+		bucket = &tokenBucket{tokens: float64(burst), last: now}
+		l.buckets[userID] = bucket
+	}
+	bucket.refill(rate, burst, now)
+	if bucket.tokens < 1 {
+		return false
+	}
+	bucket.tokens--
+	return true
+}
+
+func (b *tokenBucket) refill(rate float64, burst int, now time.Time) {
+	b.tokens += now.Sub(b.last).Seconds() * rate
+	if b.tokens > float64(burst) {
+		b.tokens = float64(burst)
+	}
+	b.last = now
+}
+
+// prune forgets the buckets that have refilled, which are as good as new.
+func (l *rateLimiter) prune(rate float64, burst int, now time.Time) {
+	for userID, bucket := range l.buckets {
+		bucket.refill(rate, burst, now)
+		if bucket.tokens >= float64(burst) {
+			delete(l.buckets, userID)
+		}
+	}
+	l.lastPrune = now
+}
@@ -0,0 +1,78 @@
+package prize_handlers
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+	"xy.com/mysite/database"
+	"xy.com/mysite/models/prize_models"
+
+	"github.com/gin-gonic/gin"
+)
+
+// CreateCampaignHandler handles the creation of a draw campaign.
+func CreateCampaignHandler(c *gin.Context) {
+	var campaign prize_models.DrawCampaign
+	if err := c.ShouldBindJSON(&campaign); err != nil {
+		c.Error(err).SetType(gin.ErrorTypeBind)
+		return
+	}
+	campaign.ID = 0
+
+	if err := prize_models.CreateCampaign(database.DB, &campaign); err != nil {
+		c.Error(err)
+		return
+	}
+
+	c.JSON(http.StatusOK, campaign)
+}
+
+// GetCampaignsHandler handles fetching all draw campaigns.
+func GetCampaignsHandler(c *gin.Context) {
+	campaigns, err := prize_models.GetCampaigns(database.DB)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	c.JSON(http.StatusOK, campaigns)
+}
+
+// UpdateCampaignHandler handles updating the settings of a draw campaign.
+func UpdateCampaignHandler(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.Error(err).SetType(gin.ErrorTypeBind)
+		return
+	}
+
+	var campaign prize_models.DrawCampaign
+	if err := c.ShouldBindJSON(&campaign); err != nil {
+		c.Error(err).SetType(gin.ErrorTypeBind)
+		return
+	}
+	campaign.ID = uint(id)
+
+	if err := prize_models.UpdateCampaign(database.DB, &campaign); err != nil {
+		c.Error(err)
+		return
+	}
+
+	c.JSON(http.StatusOK, campaign)
+}
+
+// GetRunningCampaignsHandler handles fetching the campaigns the current user can draw in.
+func GetRunningCampaignsHandler(c *gin.Context) {
+	userID, ok := getUserID(c)
+	if !ok {
+		return
+	}
+
+	campaigns, err := prize_models.GetRunningCampaigns(database.DB, userID, time.Now())
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	c.JSON(http.StatusOK, campaigns)
+}
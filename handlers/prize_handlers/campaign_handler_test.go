@@ -0,0 +1,136 @@
+package prize_handlers_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"xy.com/mysite/database"
+	"xy.com/mysite/handlers/prize_handlers"
+	"xy.com/mysite/middleware"
+	"xy.com/mysite/models/prize_models"
+	"xy.com/mysite/models/user_models"
+)
+
+// createDrawCampaign creates a running campaign for the reward table with a large budget.
+func createDrawCampaign(t *testing.T, tableID uint, dailyLimit int) *prize_models.DrawCampaign {
+	campaign := &prize_models.DrawCampaign{
+		Name:          "campaign-" + strconv.Itoa(int(tableID)),
+		RewardTableID: tableID,
+		StartsAt:      time.Now().Add(-time.Hour),
+		EndsAt:        time.Now().Add(time.Hour),
+		Budget:        1000000,
+		DailyLimit:    dailyLimit,
+		Active:        true,
+	}
+	if err := prize_models.CreateCampaign(database.DB, campaign); err != nil {
+		t.Fatal(err)
+	}
+	return campaign
+}
+
+// fetchDrawSeed fetches the user's draw seed, as users do before their first draw.
+func fetchDrawSeed(t *testing.T, userID uint) {
+	if _, err := prize_models.NewDrawEngine(1).GetActiveDrawSeed(database.DB, userID); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func setupCampaignRouter(userID uint) *gin.Engine {
+	router := gin.Default()
+	router.Use(middleware.ErrorHandler())
+	pointGroup := router.Group("/point", func(c *gin.Context) {
+		c.Set("userID", userID)
+		c.Next()
+	})
+	{
+		pointGroup.GET("/campaigns", prize_handlers.GetRunningCampaignsHandler)
+		pointGroup.POST("/campaigns/:campaignID/draw", prize_handlers.DrawHandler)
+	}
+	adminGroup := router.Group("/admin")
+	{
+		adminGroup.POST("/campaigns", prize_handlers.CreateCampaignHandler)
+		adminGroup.GET("/campaigns", prize_handlers.GetCampaignsHandler)
+		adminGroup.PUT("/campaigns/:id", prize_handlers.UpdateCampaignHandler)
+	}
+	return router
+}
+
+func TestCampaignHandlers(t *testing.T) {
+	database.InitDB()
+	table := createRewardTable(t, 100)
+
+	userID := uint(4001)
+	router := setupCampaignRouter(userID)
+
+	send := func(method, path string, body interface{}) *httptest.ResponseRecorder {
+		data, _ := json.Marshal(body)
+		req, _ := http.NewRequest(method, path, bytes.NewReader(data))
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	campaign := map[string]interface{}{
+		"name":            "spring",
+		"reward_table_id": table.ID,
+		"starts_at":       time.Now().Add(-time.Hour),
+		"ends_at":         time.Now().Add(time.Hour),
+		"budget":          200,
+		"segments":        "vip",
+		"active":          true,
+	}
+	w := send("POST", "/admin/campaigns", campaign)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	var created prize_models.DrawCampaign
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &created))
+	drawPath := "/point/campaigns/" + strconv.Itoa(int(created.ID)) + "/draw"
+
+	w = send("POST", "/admin/campaigns", map[string]interface{}{"name": "broken", "reward_table_id": table.ID})
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	// Only the vip segment may draw
+	w = send("POST", drawPath, nil)
+	assert.Equal(t, http.StatusForbidden, w.Code)
+
+	w = send("GET", "/point/campaigns", nil)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "[]", w.Body.String())
+
+	assert.NoError(t, user_models.AddUserToSegment(database.DB, userID, "vip"))
+	fetchDrawSeed(t, userID)
+
+	// The budget pays for two draws
+	for i := 0; i < 2; i++ {
+		w = send("POST", drawPath, nil)
+		assert.Equal(t, http.StatusOK, w.Code)
+	}
+	w = send("POST", drawPath, nil)
+	assert.Equal(t, http.StatusForbidden, w.Code)
+	assert.Contains(t, w.Body.String(), prize_models.ErrCampaignBudgetExhausted.Error())
+
+	// Topping up the budget reopens the campaign
+	campaign["budget"] = 300
+	w = send("PUT", "/admin/campaigns/"+strconv.Itoa(int(created.ID)), campaign)
+	assert.Equal(t, http.StatusOK, w.Code)
+	w = send("POST", drawPath, nil)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	w = send("PUT", "/admin/campaigns/9999", campaign)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+
+	w = send("GET", "/admin/campaigns", nil)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	var campaigns []prize_models.DrawCampaign
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &campaigns))
+	assert.Equal(t, 1, len(campaigns))
+	assert.Equal(t, 300, campaigns[0].Spent)
+}
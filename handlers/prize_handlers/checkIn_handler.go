@@ -0,0 +1,110 @@
+package prize_handlers
+
+import (
+	"net/http"
+	"time"
+	"xy.com/mysite/database"
+	"xy.com/mysite/models/prize_models"
+
+	"github.com/gin-gonic/gin"
+)
+
+// CheckInHandler handles the current user's daily check-in. The optional
+// "time_zone" (an IANA name such as Asia/Tokyo) decides when their day starts
+// on their first check-in; later ones keep it for a while before switching.
+func CheckInHandler(c *gin.Context) {
+	userID, ok := getUserID(c)
+	if !ok {
+		return
+	}
+
+	var req struct {
+		TimeZone string `json:"time_zone"`
+	}
+	if c.Request.ContentLength > 0 {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.Error(err).SetType(gin.ErrorTypeBind)
+			return
+		}
+	}
+
+	result, err := prize_models.CheckIn(database.DB, userID, req.TimeZone, time.Now())
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	c.JSON(http.StatusOK, result)
+}
+
+// GetCheckInStatusHandler handles fetching the current user's streak in the
+// time zone they check in by, see CheckInHandler.
+func GetCheckInStatusHandler(c *gin.Context) {
+	userID, ok := getUserID(c)
+	if !ok {
+		return
+	}
+
+	status, err := prize_models.GetCheckInStatus(database.DB, userID, c.Query("time_zone"), time.Now())
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	c.JSON(http.StatusOK, status)
+}
+
+// GetAchievementsHandler handles fetching all achievements, the ones the current
+// user has unlocked and their progress towards the others.
+func GetAchievementsHandler(c *gin.Context) {
+	userID, ok := getUserID(c)
+	if !ok {
+		return
+	}
+
+	unlocked, err := prize_models.GetUserAchievements(database.DB, userID)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+	progress, err := prize_models.GetAchievementProgress(database.DB, userID)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"achievements": prize_models.Achievements,
+		"unlocked":     unlocked,
+		"progress":     progress,
+	})
+}
+
+// GetStreakRewardsHandler handles fetching the check-in streak rewards.
+func GetStreakRewardsHandler(c *gin.Context) {
+	rewards, err := prize_models.GetStreakRewards(database.DB)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"rewards": rewards})
+}
+
+// SetStreakRewardsHandler handles replacing the check-in streak rewards.
+func SetStreakRewardsHandler(c *gin.Context) {
+	var req struct {
+		Rewards []prize_models.StreakReward `json:"rewards"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.Error(err).SetType(gin.ErrorTypeBind)
+		return
+	}
+
+	if err := prize_models.SetStreakRewards(database.DB, req.Rewards); err != nil {
+		c.Error(err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"rewards": req.Rewards})
+}
@@ -0,0 +1,89 @@
+package prize_handlers_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"xy.com/mysite/database"
+	"xy.com/mysite/handlers/prize_handlers"
+	"xy.com/mysite/middleware"
+	"xy.com/mysite/models/prize_models"
+)
+
+func setupCheckInRouter(userID uint) *gin.Engine {
+	router := gin.Default()
+	router.Use(middleware.ErrorHandler())
+	pointGroup := router.Group("/point", func(c *gin.Context) {
+		c.Set("userID", userID)
+		c.Next()
+	})
+	{
+		pointGroup.POST("/checkin", prize_handlers.CheckInHandler)
+		pointGroup.GET("/checkin", prize_handlers.GetCheckInStatusHandler)
+		pointGroup.GET("/achievements", prize_handlers.GetAchievementsHandler)
+	}
+	adminGroup := router.Group("/admin")
+	{
+		adminGroup.GET("/streakRewards", prize_handlers.GetStreakRewardsHandler)
+		adminGroup.PUT("/streakRewards", prize_handlers.SetStreakRewardsHandler)
+	}
+	return router
+}
+
+func TestCheckInHandlers(t *testing.T) {
+	database.InitDB()
+	userID := uint(3001)
+	router := setupCheckInRouter(userID)
+
+	serve := func(method, path, body string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest(method, path, strings.NewReader(body))
+		if body != "" {
+			req.Header.Set("Content-Type", "application/json")
+		}
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	w := serve("PUT", "/admin/streakRewards", `{"rewards":[{"streak":0,"points":1}]}`)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	w = serve("PUT", "/admin/streakRewards", `{"rewards":[{"streak":1,"points":15}]}`)
+	assert.Equal(t, http.StatusOK, w.Code)
+	w = serve("GET", "/admin/streakRewards", "")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.JSONEq(t, `{"rewards":[{"streak":1,"points":15}]}`, w.Body.String())
+
+	w = serve("GET", "/point/checkin?time_zone=Europe/Paris", "")
+	assert.Equal(t, http.StatusOK, w.Code)
+	var status prize_models.CheckInStatus
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &status))
+	assert.False(t, status.CheckedInToday)
+	assert.Equal(t, 15, status.NextReward)
+
+	w = serve("POST", "/point/checkin", `{"time_zone":"Nowhere/City"}`)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Contains(t, w.Body.String(), "invalid_time_zone")
+
+	w = serve("POST", "/point/checkin", "")
+	assert.Equal(t, http.StatusOK, w.Code)
+	var result prize_models.CheckInResult
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &result))
+	assert.Equal(t, 1, result.CheckIn.Streak)
+	assert.Equal(t, 15, result.CheckIn.Points)
+
+	w = serve("POST", "/point/checkin", "")
+	assert.Equal(t, http.StatusConflict, w.Code)
+
+	ps, _ := prize_models.GetPointsSystem(database.DB, userID)
+	assert.Equal(t, 15, ps.Points)
+
+	w = serve("GET", "/point/achievements", "")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), `"week_streak"`)
+	assert.Contains(t, w.Body.String(), `"event":"check_in_streak","count":1`)
+}
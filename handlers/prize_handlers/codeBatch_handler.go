@@ -0,0 +1,135 @@
+package prize_handlers
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"xy.com/mysite/database"
+	"xy.com/mysite/models/prize_models"
+
+	"github.com/gin-gonic/gin"
+)
+
+// GenerateCodeBatchHandler handles generating a batch of random codes.
+func GenerateCodeBatchHandler(c *gin.Context) {
+	var req struct {
+		prize_models.CodeSpec
+		Kind    string `json:"kind" binding:"required"`
+		PrizeID uint   `json:"prize_id"`
+		Note    string `json:"note"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.Error(err).SetType(gin.ErrorTypeBind)
+		return
+	}
+
+	batch, err := prize_models.GenerateCodeBatch(database.DB, req.Kind, req.PrizeID, req.CodeSpec, req.Note)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{"message": "Codes generated successfully", "batch": batch})
+}
+
+// ImportCodeBatchHandler handles importing a batch of codes from a CSV file
+// with a "code" column, sent either as the multipart field "file" or as the
+// request body. The kind, prize_id, note and dry_run options are query parameters.
+func ImportCodeBatchHandler(c *gin.Context) {
+	dryRun, _ := strconv.ParseBool(c.Query("dry_run"))
+
+	var prizeID uint64
+	if c.Query("prize_id") != "" {
+		var err error
+		if prizeID, err = strconv.ParseUint(c.Query("prize_id"), 10, 64); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "prize_id must be a positive integer"})
+			return
+		}
+	}
+
+	var body io.Reader = c.Request.Body
+	if strings.HasPrefix(c.ContentType(), "multipart/") {
+		file, err := c.FormFile("file")
+		if err != nil {
+			c.Error(err).SetType(gin.ErrorTypeBind)
+			return
+		}
+		f, err := file.Open()
+		if err != nil {
+			c.Error(err).SetType(gin.ErrorTypeBind)
+			return
+		}
+		defer f.Close()
+		body = f
+	}
+	if body == nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "csv file is required"})
+		return
+	}
+
+	result, err := prize_models.ImportCodeBatch(database.DB, c.Query("kind"), uint(prizeID), body, c.Query("note"), dryRun)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	if len(result.RowErrors) > 0 {
+		c.JSON(http.StatusUnprocessableEntity, result)
+		return
+	}
+
+	c.JSON(http.StatusOK, result)
+}
+
+// GetCodeBatchesHandler handles fetching a page of code batches.
+func GetCodeBatchesHandler(c *gin.Context) {
+	page, pageSize, ok := getPagination(c)
+	if !ok {
+		return
+	}
+
+	batches, total, err := prize_models.GetCodeBatches(database.DB, (page-1)*pageSize, pageSize)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"batches": batches, "page": page, "page_size": pageSize, "total": total})
+}
+
+// ExportCodeBatchHandler handles downloading the codes of a batch as CSV, for printing.
+func ExportCodeBatchHandler(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.Error(err).SetType(gin.ErrorTypeBind)
+		return
+	}
+
+	batch, err := prize_models.GetCodeBatch(database.DB, uint(id))
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	c.Header("Content-Type", "text/csv; charset=utf-8")
+	c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=%q", fmt.Sprintf("codes-batch-%d.csv", batch.ID)))
+	c.Status(http.StatusOK)
+
+	w := csv.NewWriter(c.Writer)
+	_ = w.Write([]string{"code", "used"})
+	err = prize_models.ExportCodeBatch(database.DB, batch, func(code string, used bool) error {
+		return w.Write([]string{code, strconv.FormatBool(used)})
+	})
+	w.Flush()
+	if err == nil {
+		err = w.Error()
+	}
+	if err != nil {
+		// The status has already been sent, so the export can only be cut short
+		log.Printf("code batch export failed: %v", err)
+	}
+}
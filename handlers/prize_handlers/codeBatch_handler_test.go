@@ -0,0 +1,95 @@
+package prize_handlers_test
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"xy.com/mysite/database"
+	"xy.com/mysite/handlers/prize_handlers"
+	"xy.com/mysite/middleware"
+	"xy.com/mysite/models/prize_models"
+)
+
+func setupCodeBatchRouter() *gin.Engine {
+	router := gin.Default()
+	router.Use(middleware.ErrorHandler())
+	adminGroup := router.Group("/admin")
+	{
+		adminGroup.POST("/codeBatches/generate", prize_handlers.GenerateCodeBatchHandler)
+		adminGroup.POST("/codeBatches/import", prize_handlers.ImportCodeBatchHandler)
+		adminGroup.GET("/codeBatches", prize_handlers.GetCodeBatchesHandler)
+		adminGroup.GET("/codeBatches/:id/export", prize_handlers.ExportCodeBatchHandler)
+	}
+	return router
+}
+
+func TestCodeBatchHandlers(t *testing.T) {
+	database.InitDB()
+	router := setupCodeBatchRouter()
+
+	assert.NoError(t, prize_models.AddPrize(database.DB, "Mug", 100))
+	prize, _ := prize_models.GetPrizeByName(database.DB, "Mug")
+
+	body := fmt.Sprintf(`{"kind":"prize","prize_id":%d,"count":20,"length":6,"prefix":"MUG-","check_digit":true,"note":"fair"}`, prize.ID)
+	req, _ := http.NewRequest("POST", "/admin/codeBatches/generate", strings.NewReader(body))
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusCreated, w.Code)
+
+	var generated struct {
+		Batch prize_models.CodeBatch `json:"batch"`
+	}
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &generated))
+	assert.Equal(t, 20, generated.Batch.Count)
+
+	// The export lists every code of the batch for printing
+	req, _ = http.NewRequest("GET", fmt.Sprintf("/admin/codeBatches/%d/export", generated.Batch.ID), nil)
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+	records, err := csv.NewReader(w.Body).ReadAll()
+	assert.NoError(t, err)
+	assert.Equal(t, 21, len(records))
+	assert.Equal(t, []string{"code", "used"}, records[0])
+	assert.True(t, prize_models.ValidLuhnCode(records[1][0], "MUG-", prize_models.DefaultCodeAlphabet))
+
+	// Importing a code from the generated batch is rejected
+	file := "code\nNEW-1\n" + records[1][0] + "\n"
+	req, _ = http.NewRequest("POST", fmt.Sprintf("/admin/codeBatches/import?kind=prize&prize_id=%d", prize.ID), strings.NewReader(file))
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusUnprocessableEntity, w.Code)
+
+	req, _ = http.NewRequest("POST", "/admin/codeBatches/import?kind=redemption", strings.NewReader("code\nNEW-1\nNEW-2\n"))
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	req, _ = http.NewRequest("POST", "/admin/codeBatches/import?kind=voucher", strings.NewReader("code\nNEW-3\n"))
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	req, _ = http.NewRequest("GET", "/admin/codeBatches", nil)
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+	var page struct {
+		Batches []prize_models.CodeBatch `json:"batches"`
+		Total   int64                    `json:"total"`
+	}
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &page))
+	assert.Equal(t, int64(2), page.Total)
+
+	req, _ = http.NewRequest("GET", "/admin/codeBatches/999/export", nil)
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+}
@@ -2,6 +2,7 @@ package prize_handlers
 
 import (
 	"net/http"
+	"strconv"
 	"xy.com/mysite/database"
 	"xy.com/mysite/models/prize_models"
 
@@ -12,16 +13,23 @@ import (
 func AddCodeHandler(c *gin.Context) {
 	// Parse request
 	var req struct {
-		Code string `json:"code"`
+		Code    string `json:"code"`
+		PrizeID uint   `json:"prize_id"` // Optional, adds the code to the prize's pool
 	}
 	if err := c.ShouldBindJSON(&req); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		c.Error(err).SetType(gin.ErrorTypeBind)
 		return
 	}
 
 	// Add code to database
-	if err := prize_models.AddCode(database.DB, req.Code); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	var err error
+	if req.PrizeID != 0 {
+		_, err = prize_models.AddPrizeCodes(database.DB, req.PrizeID, []string{req.Code})
+	} else {
+		err = prize_models.AddCode(database.DB, req.Code)
+	}
+	if err != nil {
+		c.Error(err)
 		return
 	}
 
@@ -33,9 +41,57 @@ func GetCodeHandler(c *gin.Context) {
 	// Retrieve unused code
 	code, err := prize_models.GetCode(database.DB)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		c.Error(err)
 		return
 	}
 
 	c.JSON(http.StatusOK, gin.H{"message": "Code retrieved successfully", "code": code})
 }
+
+// AddPrizeCodesHandler handles adding codes to the pool of a prize.
+func AddPrizeCodesHandler(c *gin.Context) {
+	prizeID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.Error(err).SetType(gin.ErrorTypeBind)
+		return
+	}
+
+	var req struct {
+		Codes []string `json:"codes" binding:"required,min=1,dive,required"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.Error(err).SetType(gin.ErrorTypeBind)
+		return
+	}
+
+	status, err := prize_models.AddPrizeCodes(database.DB, uint(prizeID), req.Codes)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Codes added successfully", "pool": status})
+}
+
+// GetCodePoolsHandler handles fetching the status of every prize's code pool.
+func GetCodePoolsHandler(c *gin.Context) {
+	statuses, err := prize_models.GetCodePoolStatuses(database.DB)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	c.JSON(http.StatusOK, statuses)
+}
+
+// GetCodePoolAlertsHandler handles fetching low code pool alerts.
+// With "open=true" only unresolved alerts are returned.
+func GetCodePoolAlertsHandler(c *gin.Context) {
+	alerts, err := prize_models.GetCodePoolAlerts(database.DB, c.Query("open") == "true")
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	c.JSON(http.StatusOK, alerts)
+}
@@ -1,8 +1,10 @@
 package prize_handlers_test
 
 import (
+	"encoding/json"
 	"net/http"
 	"net/http/httptest"
+	"strconv"
 	"strings"
 	"testing"
 
@@ -10,11 +12,13 @@ import (
 	"github.com/stretchr/testify/assert"
 	"xy.com/mysite/database"
 	"xy.com/mysite/handlers/prize_handlers"
+	"xy.com/mysite/middleware"
 	"xy.com/mysite/models/prize_models"
 )
 
 func setupRouter4() *gin.Engine {
 	router := gin.Default()
+	router.Use(middleware.ErrorHandler())
 	orderGroup := router.Group("/prize_handlers")
 	{
 		orderGroup.POST("/addCode", prize_handlers.AddCodeHandler)
@@ -80,3 +84,61 @@ func TestGetCodeHandler(t *testing.T) {
 	// Clean up
 	database.DB.Delete(&usedCode)
 }
+
+func TestCodePoolHandlers(t *testing.T) {
+	database.InitDB()
+
+	prize := &prize_models.Prize{PrizeName: "Gift card", Cost: 10, PerUserLimit: 1, CodeAlertThreshold: 5}
+	if err := prize_models.CreatePrize(database.DB, prize); err != nil {
+		t.Fatal(err)
+	}
+	prizeID := strconv.Itoa(int(prize.ID))
+
+	router := gin.Default()
+	router.Use(middleware.ErrorHandler())
+	router.POST("/admin/prizes/:id/codes", prize_handlers.AddPrizeCodesHandler)
+	router.GET("/admin/codePools", prize_handlers.GetCodePoolsHandler)
+	router.GET("/admin/codePools/alerts", prize_handlers.GetCodePoolAlertsHandler)
+
+	serve := func(method, path, body string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest(method, path, strings.NewReader(body))
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	// Three codes are below the threshold of five
+	w := serve("POST", "/admin/prizes/"+prizeID+"/codes", `{"codes": ["a", "b", "c"]}`)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	var added struct {
+		Pool prize_models.CodePoolStatus `json:"pool"`
+	}
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &added))
+	assert.Equal(t, int64(3), added.Pool.Remaining)
+	assert.True(t, added.Pool.Low)
+
+	w = serve("POST", "/admin/prizes/"+prizeID+"/codes", `{"codes": []}`)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	w = serve("POST", "/admin/prizes/9999/codes", `{"codes": ["x"]}`)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+	w = serve("POST", "/admin/prizes/"+prizeID+"/codes", `{"codes": ["c", "d"]}`)
+	assert.Equal(t, http.StatusConflict, w.Code)
+	assert.Contains(t, w.Body.String(), "code_exists")
+
+	w = serve("GET", "/admin/codePools/alerts?open=true", "")
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	var alerts []prize_models.CodePoolAlert
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &alerts))
+	assert.Equal(t, 1, len(alerts))
+	assert.Equal(t, prize.ID, alerts[0].PrizeID)
+
+	w = serve("GET", "/admin/codePools", "")
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	var pools []prize_models.CodePoolStatus
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &pools))
+	assert.Equal(t, 1, len(pools))
+	assert.Equal(t, "Gift card", pools[0].PrizeName)
+}
@@ -2,6 +2,7 @@ package prize_handlers
 
 import (
 	"net/http"
+	"strconv"
 	"xy.com/mysite/database"
 	"xy.com/mysite/models/prize_models"
 
@@ -18,28 +19,74 @@ func ExchangePrizeHandler(c *gin.Context) {
 
 	// Parse request
 	var req struct {
-		PrizeName string `json:"prize_name"`
+		PrizeName string                        `json:"prize_name"`
+		Shipping  *prize_models.ShippingDetails `json:"shipping"` // Required for physical prizes
 	}
 	if err := c.ShouldBindJSON(&req); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		c.Error(err).SetType(gin.ErrorTypeBind)
 		return
 	}
 
-	// Fetch user's points system
-	pointsSystem, err := prize_models.GetPointsSystem(database.DB, userID)
+	// Attempt to exchange the prize
+	exchange, err := prize_models.ExchangePrizeWithShipping(database.DB, userID, req.PrizeName, req.Shipping)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		c.Error(err)
 		return
 	}
 
-	// Attempt to exchange the prize
-	code, err := prize_models.ExchangePrize(database.DB, userID, req.PrizeName, pointsSystem)
+	c.JSON(http.StatusOK, gin.H{
+		"message":  "Prize exchanged successfully",
+		"code":     exchange.RedemptionCode,
+		"exchange": exchange,
+	})
+}
+
+// GetExchangeRequestsHandler handles fetching a page of the current user's exchanges and their fulfillment status.
+func GetExchangeRequestsHandler(c *gin.Context) {
+	userID, ok := getUserID(c)
+	if !ok {
+		return
+	}
+
+	page, pageSize, ok := getPagination(c)
+	if !ok {
+		return
+	}
+
+	exchanges, total, err := prize_models.GetUserExchanges(database.DB, userID, (page-1)*pageSize, pageSize)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"exchanges": exchanges,
+		"page":      page,
+		"page_size": pageSize,
+		"total":     total,
+	})
+}
+
+// GetExchangeRequestHandler handles fetching one of the current user's exchanges.
+func GetExchangeRequestHandler(c *gin.Context) {
+	userID, ok := getUserID(c)
+	if !ok {
+		return
+	}
+
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.Error(err).SetType(gin.ErrorTypeBind)
+		return
+	}
+
+	exchange, err := prize_models.GetUserExchange(database.DB, userID, uint(id))
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		c.Error(err)
 		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{"message": "Prize exchanged successfully", "code": code})
+	c.JSON(http.StatusOK, exchange)
 }
 
 // CheckIfUserExchangedPrizeHandler handles a request to check if a user has exchanged a specific prize.
@@ -53,14 +100,14 @@ func CheckIfUserExchangedPrizeHandler(c *gin.Context) {
 
 	hasExchanged, err := prize_models.CheckIfUserExchangedPrize(database.DB, userID, prizeName)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		c.Error(err)
 		return
 	}
 
 	if hasExchanged {
 		code, err := prize_models.GetRedemptionCode(database.DB, userID, prizeName)
 		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			c.Error(err)
 			return
 		}
 		c.JSON(http.StatusOK, gin.H{"hasExchanged": hasExchanged, "code": code})
@@ -75,7 +122,7 @@ func GetPrizeByNameHandler(c *gin.Context) {
 
 	prize, err := prize_models.GetPrizeByName(database.DB, prizeName)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		c.Error(err)
 		return
 	}
 
@@ -94,7 +141,7 @@ func GetRedemptionCodeHandler(c *gin.Context) {
 
 	code, err := prize_models.GetRedemptionCode(database.DB, userID, prizeName)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		c.Error(err)
 		return
 	}
 
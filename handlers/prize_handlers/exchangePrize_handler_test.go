@@ -5,18 +5,24 @@ import (
 	"encoding/json"
 	"net/http"
 	"net/http/httptest"
+	"strconv"
 	"testing"
 
 	"github.com/gin-gonic/gin"
 	"github.com/stretchr/testify/assert"
 	"xy.com/mysite/database"
 	"xy.com/mysite/handlers/prize_handlers"
+	"xy.com/mysite/middleware"
 	"xy.com/mysite/models/prize_models"
 )
 
-func setupRouter1() *gin.Engine {
+func setupRouter1(userID uint) *gin.Engine {
 	router := gin.Default()
-	orderGroup := router.Group("/prize_handlers")
+	router.Use(middleware.ErrorHandler())
+	orderGroup := router.Group("/prize_handlers", func(c *gin.Context) {
+		c.Set("userID", userID)
+		c.Next()
+	})
 	{
 		orderGroup.POST("/exchangePrize", prize_handlers.ExchangePrizeHandler)
 		orderGroup.GET("/checkIfUserExchangedPrize/:userID/:prizeName", prize_handlers.CheckIfUserExchangedPrizeHandler)
@@ -27,9 +33,10 @@ func setupRouter1() *gin.Engine {
 
 func TestExchangePrizeHandler(t *testing.T) {
 	database.InitDB()
+	withoutAchievements(t)
 
 	// Setup data
-	userID := "testuser"
+	userID := uint(1001)
 	prizeName := "Welcome prize 1"
 	cost := 100
 	initialPoints := 200
@@ -46,9 +53,9 @@ func TestExchangePrizeHandler(t *testing.T) {
 		t.Fatal(err)
 	}
 
-	// Add code to the database
+	// Add code to the prize's pool
 	code := "ABC123"
-	if err := prize_models.AddCode(database.DB, code); err != nil {
+	if _, err := prize_models.AddPrizeCodes(database.DB, prize.ID, []string{code}); err != nil {
 		t.Fatal(err)
 	}
 
@@ -67,16 +74,16 @@ func TestExchangePrizeHandler(t *testing.T) {
 
 	w := httptest.NewRecorder()
 
-	router := setupRouter1()
+	router := setupRouter1(userID)
 	router.ServeHTTP(w, req)
 
 	assert.Equal(t, http.StatusOK, w.Code)
 
-	// Check that the points system has not been updated (points remain unchanged)
+	// Check that the prize cost has been debited
 	var updatedPointsSystem prize_models.PointsSystem
 	err = database.DB.Where("user_id = ?", userID).First(&updatedPointsSystem).Error
 	assert.NoError(t, err)
-	assert.Equal(t, initialPoints, updatedPointsSystem.Points)
+	assert.Equal(t, initialPoints-cost, updatedPointsSystem.Points)
 
 	// Check that the prize has been exchanged
 	var exchangedPrize prize_models.ExchangedPrize
@@ -95,7 +102,7 @@ func TestCheckIfUserExchangedPrizeHandler(t *testing.T) {
 	database.InitDB()
 
 	// Setup data
-	userID := "testuser"
+	userID := uint(1001)
 	prizeName := "testprize"
 
 	// Add user and prize
@@ -104,22 +111,24 @@ func TestCheckIfUserExchangedPrizeHandler(t *testing.T) {
 		t.Fatal(err)
 	}
 
-	req, err := http.NewRequest("GET", "/prize_handlers/checkIfUserExchangedPrize/"+userID+"/"+prizeName, nil)
+	req, err := http.NewRequest("GET", "/prize_handlers/checkIfUserExchangedPrize/"+strconv.Itoa(int(userID))+"/"+prizeName, nil)
 	if err != nil {
 		t.Fatal(err)
 	}
 
 	w := httptest.NewRecorder()
 
-	router := setupRouter1()
+	router := setupRouter1(userID)
 	router.ServeHTTP(w, req)
 
 	assert.Equal(t, http.StatusOK, w.Code)
 
-	var result map[string]bool
+	var result struct {
+		HasExchanged bool `json:"hasExchanged"`
+	}
 	err = json.Unmarshal(w.Body.Bytes(), &result)
 	assert.NoError(t, err)
-	assert.True(t, result["hasExchanged"])
+	assert.True(t, result.HasExchanged)
 
 	// Clean up
 	database.DB.Delete(&exchangedPrize)
@@ -146,7 +155,7 @@ func TestGetPrizeByNameHandler(t *testing.T) {
 
 	w := httptest.NewRecorder()
 
-	router := setupRouter1()
+	router := setupRouter1(0)
 	router.ServeHTTP(w, req)
 
 	assert.Equal(t, http.StatusOK, w.Code)
@@ -0,0 +1,66 @@
+package prize_handlers
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+	"xy.com/mysite/database"
+	"xy.com/mysite/models/prize_models"
+
+	"github.com/gin-gonic/gin"
+)
+
+// QuoteCoinExchangeHandler handles previewing an exchange of the number of coins
+// given by the "coins" query parameter, as many as possible if it is omitted.
+func QuoteCoinExchangeHandler(c *gin.Context) {
+	userID, ok := getUserID(c)
+	if !ok {
+		return
+	}
+
+	coins, err := strconv.Atoi(c.DefaultQuery("coins", "0"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "coins must be an integer"})
+		return
+	}
+
+	quote, err := prize_models.QuoteCoinExchange(database.DB, userID, coins, time.Now())
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	c.JSON(http.StatusOK, quote)
+}
+
+// GetExchangeRatesHandler handles fetching the exchange rate history and the rate in effect now.
+func GetExchangeRatesHandler(c *gin.Context) {
+	rates, err := prize_models.GetExchangeRates(database.DB)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+	current, err := prize_models.GetExchangeRate(database.DB, time.Now())
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"rates": rates, "current": current})
+}
+
+// CreateExchangeRateHandler handles scheduling a new exchange rate.
+func CreateExchangeRateHandler(c *gin.Context) {
+	var rate prize_models.ExchangeRate
+	if err := c.ShouldBindJSON(&rate); err != nil {
+		c.Error(err).SetType(gin.ErrorTypeBind)
+		return
+	}
+
+	if err := prize_models.CreateExchangeRate(database.DB, &rate); err != nil {
+		c.Error(err)
+		return
+	}
+
+	c.JSON(http.StatusCreated, rate)
+}
@@ -0,0 +1,96 @@
+package prize_handlers_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"xy.com/mysite/database"
+	"xy.com/mysite/handlers/prize_handlers"
+	"xy.com/mysite/middleware"
+	"xy.com/mysite/models/prize_models"
+)
+
+func setupExchangeRateRouter(userID uint) *gin.Engine {
+	router := gin.Default()
+	router.Use(middleware.ErrorHandler())
+	pointGroup := router.Group("/point", func(c *gin.Context) {
+		c.Set("userID", userID)
+		c.Next()
+	})
+	{
+		pointGroup.POST("/exchange", prize_handlers.ExchangeCoinsHandler)
+		pointGroup.GET("/exchange/quote", prize_handlers.QuoteCoinExchangeHandler)
+	}
+	adminGroup := router.Group("/admin")
+	{
+		adminGroup.GET("/exchangeRates", prize_handlers.GetExchangeRatesHandler)
+		adminGroup.POST("/exchangeRates", prize_handlers.CreateExchangeRateHandler)
+	}
+	return router
+}
+
+func TestExchangeRateHandlers(t *testing.T) {
+	database.InitDB()
+	userID := uint(4001)
+	router := setupExchangeRateRouter(userID)
+	_, err := prize_models.AdjustBalance(database.DB, userID, prize_models.CurrencyCoins, 1000, prize_models.LedgerReasonAdminAdjustment, "")
+	assert.NoError(t, err)
+
+	serve := func(method, path, body string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest(method, path, strings.NewReader(body))
+		if body != "" {
+			req.Header.Set("Content-Type", "application/json")
+		}
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	w := serve("POST", "/admin/exchangeRates", `{"coins_per_point":0}`)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	w = serve("POST", "/admin/exchangeRates", `{"coins_per_point":50,"daily_cap_coins":400,"note":"spring sale"}`)
+	assert.Equal(t, http.StatusCreated, w.Code)
+	// A rate for next week does not apply yet
+	nextWeek := time.Now().AddDate(0, 0, 7).UTC().Format(time.RFC3339)
+	w = serve("POST", "/admin/exchangeRates", `{"coins_per_point":10,"effective_from":"`+nextWeek+`"}`)
+	assert.Equal(t, http.StatusCreated, w.Code)
+
+	w = serve("GET", "/admin/exchangeRates", "")
+	assert.Equal(t, http.StatusOK, w.Code)
+	var rates struct {
+		Rates   []prize_models.ExchangeRate `json:"rates"`
+		Current prize_models.ExchangeRate   `json:"current"`
+	}
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &rates))
+	assert.Len(t, rates.Rates, 2)
+	assert.Equal(t, 50, rates.Current.CoinsPerPoint)
+
+	w = serve("GET", "/point/exchange/quote?coins=150", "")
+	assert.Equal(t, http.StatusOK, w.Code)
+	var quote prize_models.ExchangeQuote
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &quote))
+	assert.Equal(t, 3, quote.Points)
+
+	w = serve("GET", "/point/exchange/quote?coins=many", "")
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	w = serve("GET", "/point/exchange/quote?coins=75", "")
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Contains(t, w.Body.String(), "invalid_exchange_amount")
+
+	w = serve("POST", "/point/exchange", `{"coins":150}`)
+	assert.Equal(t, http.StatusOK, w.Code)
+	w = serve("POST", "/point/exchange", "")
+	assert.Equal(t, http.StatusOK, w.Code)
+	w = serve("POST", "/point/exchange", `{"coins":50}`)
+	assert.Equal(t, http.StatusTooManyRequests, w.Code)
+
+	ps, _ := prize_models.GetPointsSystem(database.DB, userID)
+	assert.Equal(t, 600, ps.Coins)
+	assert.Equal(t, 8, ps.Points)
+}
@@ -0,0 +1,108 @@
+package prize_handlers
+
+import (
+	"net/http"
+	"strconv"
+	"xy.com/mysite/database"
+	"xy.com/mysite/models/prize_models"
+
+	"github.com/gin-gonic/gin"
+)
+
+// revealedSeed includes the server seed, which DrawSeed hides from JSON.
+func revealedSeed(seed *prize_models.DrawSeed) gin.H {
+	return gin.H{
+		"id":               seed.ID,
+		"server_seed":      seed.ServerSeed,
+		"server_seed_hash": seed.ServerSeedHash,
+		"client_seed":      seed.ClientSeed,
+		"nonce":            seed.Nonce,
+		"revealed_at":      seed.RevealedAt,
+	}
+}
+
+// GetDrawSeedHandler handles fetching the current user's seed pair. Only the hash of the server seed is shown.
+// Users must fetch it before their first draw.
+func GetDrawSeedHandler(c *gin.Context) {
+	userID, ok := getUserID(c)
+	if !ok {
+		return
+	}
+
+	seed, err := drawEngine.GetActiveDrawSeed(database.DB, userID)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	c.JSON(http.StatusOK, seed)
+}
+
+// RotateDrawSeedHandler handles revealing the current server seed and starting a new seed pair.
+// The "client_seed" is optional, a random one is picked without it.
+func RotateDrawSeedHandler(c *gin.Context) {
+	userID, ok := getUserID(c)
+	if !ok {
+		return
+	}
+
+	var req struct {
+		ClientSeed string `json:"client_seed" binding:"max=64"`
+	}
+	if c.Request.ContentLength > 0 {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.Error(err).SetType(gin.ErrorTypeBind)
+			return
+		}
+	}
+
+	revealed, next, err := drawEngine.RotateDrawSeed(database.DB, userID, req.ClientSeed)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"revealed": revealedSeed(revealed), "next": next})
+}
+
+// GetRevealedDrawSeedsHandler handles fetching the current user's revealed seed pairs.
+func GetRevealedDrawSeedsHandler(c *gin.Context) {
+	userID, ok := getUserID(c)
+	if !ok {
+		return
+	}
+
+	seeds, err := prize_models.GetRevealedDrawSeeds(database.DB, userID)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	revealed := make([]gin.H, 0, len(seeds))
+	for i := range seeds {
+		revealed = append(revealed, revealedSeed(&seeds[i]))
+	}
+	c.JSON(http.StatusOK, revealed)
+}
+
+// VerifyDrawHandler handles replaying one of the current user's past draws.
+func VerifyDrawHandler(c *gin.Context) {
+	userID, ok := getUserID(c)
+	if !ok {
+		return
+	}
+
+	drawID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.Error(err).SetType(gin.ErrorTypeBind)
+		return
+	}
+
+	verification, err := prize_models.VerifyDraw(database.DB, userID, uint(drawID))
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	c.JSON(http.StatusOK, verification)
+}
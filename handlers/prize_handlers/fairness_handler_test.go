@@ -0,0 +1,102 @@
+package prize_handlers_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"xy.com/mysite/database"
+	"xy.com/mysite/handlers/prize_handlers"
+	"xy.com/mysite/middleware"
+	"xy.com/mysite/models/prize_models"
+)
+
+func setupFairnessRouter(userID uint) *gin.Engine {
+	router := gin.Default()
+	router.Use(middleware.ErrorHandler())
+	pointGroup := router.Group("/point", func(c *gin.Context) {
+		c.Set("userID", userID)
+		c.Next()
+	})
+	{
+		pointGroup.POST("/campaigns/:campaignID/draw", prize_handlers.DrawHandler)
+		pointGroup.GET("/draws/:id/verify", prize_handlers.VerifyDrawHandler)
+		pointGroup.GET("/seeds", prize_handlers.GetDrawSeedHandler)
+		pointGroup.GET("/seeds/revealed", prize_handlers.GetRevealedDrawSeedsHandler)
+		pointGroup.POST("/seeds/rotate", prize_handlers.RotateDrawSeedHandler)
+	}
+	return router
+}
+
+func TestFairnessHandlers(t *testing.T) {
+	database.InitDB()
+	campaign := createDrawCampaign(t, createRewardTable(t, 10).ID, 0)
+
+	userID := uint(3001)
+	router := setupFairnessRouter(userID)
+
+	serve := func(method, path string, body []byte) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest(method, path, bytes.NewReader(body))
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	// Only the hash of the server seed is shown before it is revealed
+	w := serve("GET", "/point/seeds", nil)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.NotContains(t, w.Body.String(), "server_seed\"")
+
+	var committed prize_models.DrawSeed
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &committed))
+	assert.NotEmpty(t, committed.ServerSeedHash)
+
+	w = serve("POST", "/point/campaigns/"+strconv.Itoa(int(campaign.ID))+"/draw", nil)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	var draw struct {
+		Result prize_models.DrawRecord `json:"result"`
+	}
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &draw))
+	assert.Equal(t, committed.ServerSeedHash, draw.Result.ServerSeedHash)
+
+	verifyPath := "/point/draws/" + strconv.Itoa(int(draw.Result.ID)) + "/verify"
+	w = serve("GET", verifyPath, nil)
+	assert.Equal(t, http.StatusConflict, w.Code)
+
+	w = serve("POST", "/point/seeds/rotate", []byte(`{"client_seed": "lucky"}`))
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	var rotated struct {
+		Revealed struct {
+			ServerSeed string `json:"server_seed"`
+		} `json:"revealed"`
+		Next prize_models.DrawSeed `json:"next"`
+	}
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &rotated))
+	assert.Equal(t, committed.ServerSeedHash, prize_models.HashServerSeed(rotated.Revealed.ServerSeed))
+	assert.Equal(t, "lucky", rotated.Next.ClientSeed)
+
+	w = serve("GET", verifyPath, nil)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	var verification prize_models.DrawVerification
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &verification))
+	assert.True(t, verification.Valid)
+
+	// The client seed is optional
+	w = serve("POST", "/point/seeds/rotate", nil)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	w = serve("GET", "/point/seeds/revealed", nil)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), rotated.Revealed.ServerSeed)
+
+	w = serve("GET", "/point/draws/9999/verify", nil)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+}
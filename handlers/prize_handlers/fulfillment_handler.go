@@ -0,0 +1,57 @@
+package prize_handlers
+
+import (
+	"net/http"
+	"strconv"
+	"xy.com/mysite/database"
+	"xy.com/mysite/models/prize_models"
+
+	"github.com/gin-gonic/gin"
+)
+
+// GetFulfillmentQueueHandler handles fetching a page of physical prize exchanges
+// in a fulfillment state, requested ones by default.
+func GetFulfillmentQueueHandler(c *gin.Context) {
+	page, pageSize, ok := getPagination(c)
+	if !ok {
+		return
+	}
+
+	status := c.DefaultQuery("status", prize_models.FulfillmentRequested)
+	exchanges, total, err := prize_models.GetFulfillmentQueue(database.DB, status, (page-1)*pageSize, pageSize)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"exchanges": exchanges,
+		"status":    status,
+		"page":      page,
+		"page_size": pageSize,
+		"total":     total,
+	})
+}
+
+// UpdateFulfillmentHandler handles approving, shipping, delivering or rejecting an exchange.
+func UpdateFulfillmentHandler(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.Error(err).SetType(gin.ErrorTypeBind)
+		return
+	}
+
+	var req prize_models.FulfillmentUpdate
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.Error(err).SetType(gin.ErrorTypeBind)
+		return
+	}
+
+	exchange, err := prize_models.UpdateFulfillment(database.DB, uint(id), req)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	c.JSON(http.StatusOK, exchange)
+}
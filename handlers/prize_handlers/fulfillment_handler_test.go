@@ -0,0 +1,110 @@
+package prize_handlers_test
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"xy.com/mysite/database"
+	"xy.com/mysite/handlers/prize_handlers"
+	"xy.com/mysite/middleware"
+	"xy.com/mysite/models/prize_models"
+)
+
+func setupFulfillmentRouter(userID uint) *gin.Engine {
+	router := gin.Default()
+	router.Use(middleware.ErrorHandler())
+	exchangeGroup := router.Group("/exchange", func(c *gin.Context) {
+		c.Set("userID", userID)
+		c.Next()
+	})
+	{
+		exchangeGroup.POST("/exchanged", prize_handlers.ExchangePrizeHandler)
+		exchangeGroup.GET("/requests", prize_handlers.GetExchangeRequestsHandler)
+		exchangeGroup.GET("/requests/:id", prize_handlers.GetExchangeRequestHandler)
+	}
+	adminGroup := router.Group("/admin")
+	{
+		adminGroup.GET("/fulfillment", prize_handlers.GetFulfillmentQueueHandler)
+		adminGroup.PUT("/fulfillment/:id", prize_handlers.UpdateFulfillmentHandler)
+	}
+	return router
+}
+
+func TestFulfillmentHandlers(t *testing.T) {
+	database.InitDB()
+	userID := uint(1001)
+	router := setupFulfillmentRouter(userID)
+
+	stock := 3
+	prize := &prize_models.Prize{PrizeName: "Hoodie", Cost: 100, Stock: &stock, PerUserLimit: 1, Physical: true}
+	assert.NoError(t, prize_models.CreatePrize(database.DB, prize))
+	assert.NoError(t, prize_models.CreditPoints(database.DB, userID, 100, prize_models.LedgerReasonAdminAdjustment, ""))
+
+	serve := func(method, path, body string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	// Physical prizes need somewhere to ship to
+	w := serve("POST", "/exchange/exchanged", `{"prize_name":"Hoodie"}`)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Contains(t, w.Body.String(), "shipping_required")
+
+	w = serve("POST", "/exchange/exchanged",
+		`{"prize_name":"Hoodie","shipping":{"recipient_name":"Alice","address":"1 Main St","phone":"555-0100"}}`)
+	assert.Equal(t, http.StatusOK, w.Code)
+	var exchanged struct {
+		Exchange prize_models.ExchangedPrize `json:"exchange"`
+	}
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &exchanged))
+	assert.Equal(t, prize_models.FulfillmentRequested, exchanged.Exchange.Status)
+	id := exchanged.Exchange.ID
+
+	// The request shows up in the admin queue
+	w = serve("GET", "/admin/fulfillment", "")
+	assert.Equal(t, http.StatusOK, w.Code)
+	var queue struct {
+		Exchanges []prize_models.ExchangedPrize `json:"exchanges"`
+		Total     int64                         `json:"total"`
+	}
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &queue))
+	assert.Equal(t, int64(1), queue.Total)
+	assert.Equal(t, id, queue.Exchanges[0].ID)
+
+	w = serve("GET", "/admin/fulfillment?status=unknown", "")
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	// Admins move it along
+	w = serve("PUT", fmt.Sprintf("/admin/fulfillment/%d", id), `{"status":"shipped","tracking_number":"1Z999"}`)
+	assert.Equal(t, http.StatusConflict, w.Code)
+	w = serve("PUT", fmt.Sprintf("/admin/fulfillment/%d", id), `{"status":"approved"}`)
+	assert.Equal(t, http.StatusOK, w.Code)
+	w = serve("PUT", fmt.Sprintf("/admin/fulfillment/%d", id), `{"status":"shipped","carrier":"UPS","tracking_number":"1Z999"}`)
+	assert.Equal(t, http.StatusOK, w.Code)
+	w = serve("PUT", "/admin/fulfillment/abc", `{"status":"delivered"}`)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	// The user sees the status and tracking number
+	w = serve("GET", fmt.Sprintf("/exchange/requests/%d", id), "")
+	assert.Equal(t, http.StatusOK, w.Code)
+	var got prize_models.ExchangedPrize
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &got))
+	assert.Equal(t, prize_models.FulfillmentShipped, got.Status)
+	assert.Equal(t, "1Z999", got.TrackingNumber)
+
+	w = serve("GET", "/exchange/requests", "")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), `"total":1`)
+
+	w = serve("GET", fmt.Sprintf("/exchange/requests/%d", id+100), "")
+	assert.Equal(t, http.StatusNotFound, w.Code)
+}
@@ -0,0 +1,102 @@
+package prize_handlers
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+	"xy.com/mysite/database"
+	"xy.com/mysite/models/prize_models"
+
+	"github.com/gin-gonic/gin"
+)
+
+const defaultLeaderboardSize = 10
+
+// getLeaderboardQuery reads the "board" path parameter and the "campaign_id"
+// and "week" (any day of the week, YYYY-MM-DD) query parameters.
+func getLeaderboardQuery(c *gin.Context) (prize_models.LeaderboardQuery, bool) {
+	q := prize_models.LeaderboardQuery{Board: c.Param("board")}
+
+	if campaignID := c.Query("campaign_id"); campaignID != "" {
+		id, err := strconv.Atoi(campaignID)
+		if err != nil || id < 1 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "campaign_id must be a positive integer"})
+			return q, false
+		}
+		q.CampaignID = uint(id)
+	}
+
+	if week := c.Query("week"); week != "" {
+		t, err := time.Parse("2006-01-02", week)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "week must be a date in YYYY-MM-DD format"})
+			return q, false
+		}
+		q.Week = t
+	}
+	return q, true
+}
+
+// GetLeaderboardHandler handles fetching the top of a leaderboard.
+func GetLeaderboardHandler(c *gin.Context) {
+	q, ok := getLeaderboardQuery(c)
+	if !ok {
+		return
+	}
+
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", strconv.Itoa(defaultLeaderboardSize)))
+	if err != nil || limit < 1 || limit > maxPageSize {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "limit must be between 1 and 100"})
+		return
+	}
+
+	board, err := prize_models.GetLeaderboard(database.DB, q, limit)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	c.JSON(http.StatusOK, board)
+}
+
+// GetMyLeaderboardRankHandler handles fetching the current user's rank on a leaderboard.
+func GetMyLeaderboardRankHandler(c *gin.Context) {
+	userID, ok := getUserID(c)
+	if !ok {
+		return
+	}
+
+	q, ok := getLeaderboardQuery(c)
+	if !ok {
+		return
+	}
+
+	entry, err := prize_models.GetLeaderboardRank(database.DB, q, userID)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	c.JSON(http.StatusOK, entry)
+}
+
+// SnapshotLeaderboardHandler handles snapshotting the weekly board of a past week,
+// given by the "week" query parameter. Weeks are also snapshotted automatically.
+func SnapshotLeaderboardHandler(c *gin.Context) {
+	q, ok := getLeaderboardQuery(c)
+	if !ok {
+		return
+	}
+	if q.Week.IsZero() {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "week is required"})
+		return
+	}
+
+	created, err := prize_models.SnapshotWeeklyLeaderboard(database.DB, q.Week)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"period": prize_models.WeekPeriod(q.Week), "entries": created})
+}
@@ -0,0 +1,96 @@
+package prize_handlers_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"xy.com/mysite/database"
+	"xy.com/mysite/handlers/prize_handlers"
+	"xy.com/mysite/middleware"
+	"xy.com/mysite/models/prize_models"
+	"xy.com/mysite/models/user_models"
+)
+
+func setupLeaderboardRouter(userID uint) *gin.Engine {
+	router := gin.Default()
+	router.Use(middleware.ErrorHandler())
+	leaderboardGroup := router.Group("/leaderboards", func(c *gin.Context) {
+		c.Set("userID", userID)
+		c.Next()
+	})
+	{
+		leaderboardGroup.GET("/:board", prize_handlers.GetLeaderboardHandler)
+		leaderboardGroup.GET("/:board/me", prize_handlers.GetMyLeaderboardRankHandler)
+	}
+	router.POST("/admin/leaderboards/snapshot", prize_handlers.SnapshotLeaderboardHandler)
+	return router
+}
+
+func TestLeaderboardHandlers(t *testing.T) {
+	database.InitDB()
+	userID := uint(2001)
+	router := setupLeaderboardRouter(userID)
+
+	database.DB.Create(&prize_models.PointsSystem{UserID: userID, Points: 700})
+	database.DB.Create(&prize_models.PointsSystem{UserID: userID + 1, Points: 900})
+	database.DB.Create(&prize_models.PointsSystem{UserID: userID + 2, Points: 5000})
+	assert.NoError(t, user_models.AddUserToSegment(database.DB, userID+2, user_models.SegmentAdmin))
+
+	week := prize_models.WeekStart(time.Date(2024, 2, 14, 0, 0, 0, 0, time.UTC))
+	record := &prize_models.DrawRecord{UserID: userID, Kind: prize_models.OutcomePoints, Amount: 40}
+	record.CreatedAt = week.Add(time.Hour)
+	assert.NoError(t, database.DB.Create(record).Error)
+
+	serve := func(method, path string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest(method, path, nil)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	w := serve("GET", "/leaderboards/all_time?limit=2")
+	assert.Equal(t, http.StatusOK, w.Code)
+	var board prize_models.Leaderboard
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &board))
+	if assert.Len(t, board.Entries, 2) {
+		assert.Equal(t, userID+1, board.Entries[0].UserID)
+		assert.Equal(t, 2, board.Entries[1].Rank)
+	}
+
+	w = serve("GET", "/leaderboards/all_time/me")
+	assert.Equal(t, http.StatusOK, w.Code)
+	var entry prize_models.LeaderboardEntry
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &entry))
+	assert.Equal(t, 2, entry.Rank)
+
+	w = serve("GET", "/leaderboards/weekly/me?week=2024-02-15")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &entry))
+	assert.Equal(t, 1, entry.Rank)
+	assert.Equal(t, 40, entry.Score)
+
+	w = serve("POST", "/admin/leaderboards/snapshot?week=2024-02-15")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), `"period":"2024-W07"`)
+	w = serve("GET", "/leaderboards/weekly?week=2024-02-12")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), `"snapshot":true`)
+
+	w = serve("POST", "/admin/leaderboards/snapshot?week="+time.Now().Format("2006-01-02"))
+	assert.Equal(t, http.StatusConflict, w.Code)
+
+	for _, path := range []string{
+		"/leaderboards/monthly",
+		"/leaderboards/campaign",
+		"/leaderboards/weekly?week=last",
+		"/leaderboards/all_time?limit=1000",
+	} {
+		w = serve("GET", path)
+		assert.Equal(t, http.StatusBadRequest, w.Code, path)
+	}
+}
@@ -0,0 +1,106 @@
+package prize_handlers
+
+import (
+	"net/http"
+	"strconv"
+	"xy.com/mysite/database"
+	"xy.com/mysite/models/prize_models"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	defaultPageSize = 20
+	maxPageSize     = 100
+)
+
+// getPagination reads the "page" and "page_size" query parameters.
+func getPagination(c *gin.Context) (page int, pageSize int, ok bool) {
+	page, err := strconv.Atoi(c.DefaultQuery("page", "1"))
+	if err != nil || page < 1 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "page must be a positive integer"})
+		return 0, 0, false
+	}
+
+	pageSize, err = strconv.Atoi(c.DefaultQuery("page_size", strconv.Itoa(defaultPageSize)))
+	if err != nil || pageSize < 1 || pageSize > maxPageSize {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "page_size must be between 1 and 100"})
+		return 0, 0, false
+	}
+
+	return page, pageSize, true
+}
+
+// PointHistoryHandler handles fetching the current user's points and coins ledger.
+func PointHistoryHandler(c *gin.Context) {
+	userID, ok := getUserID(c)
+	if !ok {
+		return
+	}
+
+	page, pageSize, ok := getPagination(c)
+	if !ok {
+		return
+	}
+
+	entries, total, err := prize_models.GetLedgerEntries(database.DB, userID, (page-1)*pageSize, pageSize)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"entries":   entries,
+		"page":      page,
+		"page_size": pageSize,
+		"total":     total,
+	})
+}
+
+// AdjustBalanceHandler handles a manual credit or debit of a user's points or coins.
+func AdjustBalanceHandler(c *gin.Context) {
+	var req struct {
+		UserID   uint   `json:"user_id" binding:"required"`
+		Currency string `json:"currency" binding:"required"`
+		Amount   int    `json:"amount" binding:"required"`
+		Reason   string `json:"reason"`
+		Note     string `json:"note"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.Error(err).SetType(gin.ErrorTypeBind)
+		return
+	}
+
+	if req.Reason == "" {
+		req.Reason = prize_models.LedgerReasonAdminAdjustment
+	}
+	if req.Reason != prize_models.LedgerReasonAdminAdjustment && req.Reason != prize_models.LedgerReasonRefund {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "reason must be admin_adjustment or refund"})
+		return
+	}
+
+	pointsSystem, err := prize_models.AdjustBalance(database.DB, req.UserID, req.Currency, req.Amount, req.Reason, req.Note)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Balance adjusted successfully", "point": pointsSystem})
+}
+
+// VerifyBalanceHandler handles checking a user's balances against their ledger.
+func VerifyBalanceHandler(c *gin.Context) {
+	userID, err := strconv.Atoi(c.Param("userID"))
+	if err != nil {
+		c.Error(err).SetType(gin.ErrorTypeBind)
+		return
+	}
+
+	check, err := prize_models.VerifyBalance(database.DB, uint(userID))
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	c.JSON(http.StatusOK, check)
+}
@@ -0,0 +1,103 @@
+package prize_handlers_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"xy.com/mysite/database"
+	"xy.com/mysite/handlers/prize_handlers"
+	"xy.com/mysite/middleware"
+	"xy.com/mysite/models/prize_models"
+)
+
+func setupLedgerRouter(userID uint) *gin.Engine {
+	router := gin.Default()
+	router.Use(middleware.ErrorHandler())
+	pointGroup := router.Group("/point", func(c *gin.Context) {
+		c.Set("userID", userID)
+		c.Next()
+	})
+	{
+		pointGroup.GET("/history", prize_handlers.PointHistoryHandler)
+	}
+	adminGroup := router.Group("/admin")
+	{
+		adminGroup.POST("/points/adjust", prize_handlers.AdjustBalanceHandler)
+		adminGroup.GET("/points/verify/:userID", prize_handlers.VerifyBalanceHandler)
+	}
+	return router
+}
+
+func TestLedgerHandlers(t *testing.T) {
+	database.InitDB()
+	withoutAchievements(t)
+
+	userID := uint(2001)
+	router := setupLedgerRouter(userID)
+
+	createRewardTable(t, 1000)
+	engine := prize_models.NewDrawEngine(1)
+	fetchDrawSeed(t, userID)
+	for i := 0; i < 3; i++ {
+		_, err := engine.Draw(database.DB, userID, 0)
+		assert.NoError(t, err)
+	}
+
+	// Admin adjustment
+	body, _ := json.Marshal(map[string]interface{}{
+		"user_id":  userID,
+		"currency": "points",
+		"amount":   -500,
+		"reason":   "refund",
+		"note":     "duplicate draw",
+	})
+	req, _ := http.NewRequest("POST", "/admin/points/adjust", bytes.NewReader(body))
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	// Debits beyond the balance are refused
+	body, _ = json.Marshal(map[string]interface{}{"user_id": userID, "currency": "points", "amount": -100000})
+	req, _ = http.NewRequest("POST", "/admin/points/adjust", bytes.NewReader(body))
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	// Paginated history, newest first
+	req, _ = http.NewRequest("GET", "/point/history?page=1&page_size=2", nil)
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	var history struct {
+		Entries []prize_models.LedgerEntry `json:"entries"`
+		Total   int64                      `json:"total"`
+	}
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &history))
+	assert.Equal(t, int64(4), history.Total)
+	assert.Equal(t, 2, len(history.Entries))
+	assert.Equal(t, prize_models.LedgerReasonRefund, history.Entries[0].Reason)
+	assert.Equal(t, 2500, history.Entries[0].Balance)
+
+	req, _ = http.NewRequest("GET", "/point/history?page=0", nil)
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	// Balance verification
+	req, _ = http.NewRequest("GET", "/admin/points/verify/"+strconv.Itoa(int(userID)), nil)
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	var check prize_models.BalanceCheck
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &check))
+	assert.True(t, check.Consistent)
+	assert.Equal(t, 2500, check.LedgerPoints)
+}
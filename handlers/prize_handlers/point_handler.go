@@ -2,6 +2,8 @@ package prize_handlers
 
 import (
 	"net/http"
+	"strconv"
+	"time"
 	"xy.com/mysite/database"
 	"xy.com/mysite/models/prize_models"
 
@@ -26,62 +28,96 @@ func getUserID(c *gin.Context) (uint, bool) {
 	return userIDUint, true
 }
 
-// DrawHandler handles the draw operation.
+// drawEngine draws outcomes for DrawHandler.
+var drawEngine = prize_models.NewSecureDrawEngine()
+
+// DrawHandler handles a draw in the campaign given by the "campaignID" path parameter.
+// Behind CheckRedemptionCode the draw consumes the request's redemption code.
 func DrawHandler(c *gin.Context) {
 	userID, ok := getUserID(c)
 	if !ok {
 		return
 	}
 
-	// Fetch user's points system
-	pointsSystem, err := prize_models.GetPointsSystem(database.DB, userID)
+	campaignID, err := strconv.Atoi(c.Param("campaignID"))
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		c.Error(err).SetType(gin.ErrorTypeBind)
 		return
 	}
 
-	// Perform the draw operation
-	if err := pointsSystem.Draw(database.DB); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	// Perform the draw operation, paying with the redemption code if CheckRedemptionCode set one
+	var record *prize_models.DrawRecord
+	if code := c.GetString("redemptionCode"); code != "" {
+		record, err = drawEngine.DrawWithRedemptionCode(database.DB, userID, uint(campaignID), code)
+	} else {
+		record, err = drawEngine.DrawInCampaign(database.DB, userID, uint(campaignID))
+	}
+	if err != nil {
+		c.Error(err)
 		return
 	}
 
-	// Update the points system in the database
-	if err := prize_models.UpdatePointsSystem(database.DB, pointsSystem); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	pointsSystem, err := prize_models.GetPointsSystem(database.DB, userID)
+	if err != nil {
+		c.Error(err)
 		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{"message": "Draw operation successful", "point": pointsSystem})
+	c.JSON(http.StatusOK, gin.H{"message": "Draw operation successful", "result": record, "point": pointsSystem})
 }
 
-// ExchangeCoinsHandler handles the exchange operation.
-func ExchangeCoinsHandler(c *gin.Context) {
+// DrawHistoryHandler handles fetching the current user's past draws.
+func DrawHistoryHandler(c *gin.Context) {
 	userID, ok := getUserID(c)
 	if !ok {
 		return
 	}
 
-	// Fetch user's points system
-	pointsSystem, err := prize_models.GetPointsSystem(database.DB, userID)
+	page, pageSize, ok := getPagination(c)
+	if !ok {
+		return
+	}
+
+	records, total, err := prize_models.GetDrawRecords(database.DB, userID, (page-1)*pageSize, pageSize)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		c.Error(err)
 		return
 	}
 
-	// Perform the exchange operation
-	if err := pointsSystem.ExchangeCoins(database.DB); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	c.JSON(http.StatusOK, gin.H{
+		"draws":     records,
+		"page":      page,
+		"page_size": pageSize,
+		"total":     total,
+	})
+}
+
+// ExchangeCoinsHandler handles the exchange operation. The optional "coins" is
+// how many coins to exchange; without it as many as possible are exchanged.
+func ExchangeCoinsHandler(c *gin.Context) {
+	userID, ok := getUserID(c)
+	if !ok {
 		return
 	}
 
-	// Update the points system in the database
-	if err := prize_models.UpdatePointsSystem(database.DB, pointsSystem); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	var req struct {
+		Coins int `json:"coins"`
+	}
+	if c.Request.ContentLength > 0 {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.Error(err).SetType(gin.ErrorTypeBind)
+			return
+		}
+	}
+
+	// Perform the exchange operation
+	pointsSystem, quote, err := prize_models.ExchangeCoinsAmount(database.DB, userID, req.Coins, time.Now())
+	if err != nil {
+		c.Error(err)
 		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{"message": "Exchange operation successful"})
+	c.JSON(http.StatusOK, gin.H{"message": "Exchange operation successful", "point": pointsSystem, "quote": quote})
 }
 
 // GetPointsSystemHandler handles fetching the points system for a specific user.
@@ -94,7 +130,7 @@ func GetPointsSystemHandler(c *gin.Context) {
 	// Fetch user's points system
 	pointsSystem, err := prize_models.GetPointsSystem(database.DB, userID)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		c.Error(err)
 		return
 	}
 
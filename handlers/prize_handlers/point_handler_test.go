@@ -4,20 +4,43 @@ import (
 	"encoding/json"
 	"net/http"
 	"net/http/httptest"
+	"strconv"
+	"strings"
 	"testing"
 
 	"github.com/gin-gonic/gin"
 	"github.com/stretchr/testify/assert"
 	"xy.com/mysite/database"
 	"xy.com/mysite/handlers/prize_handlers"
+	"xy.com/mysite/middleware"
 	"xy.com/mysite/models/prize_models"
 )
 
+// userIDFromParam sets the userID from the path the way AuthMiddleware sets it from the token.
+func userIDFromParam(c *gin.Context) {
+	userID, err := strconv.Atoi(c.Param("userID"))
+	if err != nil {
+		c.AbortWithStatus(http.StatusBadRequest)
+		return
+	}
+	c.Set("userID", uint(userID))
+	c.Next()
+}
+
+// withoutAchievements disables achievements for the test so their rewards do not
+// skew the balances it checks.
+func withoutAchievements(t *testing.T) {
+	achievements := prize_models.Achievements
+	prize_models.Achievements = nil
+	t.Cleanup(func() { prize_models.Achievements = achievements })
+}
+
 func setupRouter2() *gin.Engine {
 	router := gin.Default()
-	orderGroup := router.Group("/prize_handlers")
+	router.Use(middleware.ErrorHandler())
+	orderGroup := router.Group("/prize_handlers", userIDFromParam)
 	{
-		orderGroup.POST("/draw/:userID", prize_handlers.DrawHandler)
+		orderGroup.POST("/draw/:userID/:campaignID", prize_handlers.DrawHandler)
 		orderGroup.POST("/exchange/:userID", prize_handlers.ExchangeCoinsHandler)
 		orderGroup.GET("/getPointsSystem/:userID", prize_handlers.GetPointsSystemHandler)
 	}
@@ -28,7 +51,7 @@ func TestDrawHandler(t *testing.T) {
 	database.InitDB()
 
 	// Setup data
-	userID := "testuser"
+	userID := uint(1001)
 	points := 30000 // Set points so that user can draw
 
 	// Add user and points system
@@ -37,33 +60,66 @@ func TestDrawHandler(t *testing.T) {
 		t.Fatal(err)
 	}
 
-	req, err := http.NewRequest("POST", "/prize_handlers/draw/"+userID, nil)
+	drawPath := func(campaignID uint) string {
+		return "/prize_handlers/draw/" + strconv.Itoa(int(userID)) + "/" + strconv.Itoa(int(campaignID))
+	}
+
+	// Without a campaign there is nothing to draw
+	req, _ := http.NewRequest("POST", drawPath(1), nil)
+	w := httptest.NewRecorder()
+	setupRouter2().ServeHTTP(w, req)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+
+	campaign := createDrawCampaign(t, createRewardTable(t, 1000).ID, 1)
+
+	// Nor before the user has fetched their seed
+	req, _ = http.NewRequest("POST", drawPath(campaign.ID), nil)
+	w = httptest.NewRecorder()
+	setupRouter2().ServeHTTP(w, req)
+	assert.Equal(t, http.StatusConflict, w.Code)
+	assert.Contains(t, w.Body.String(), "draw_seed_required")
+	fetchDrawSeed(t, userID)
+
+	req, err := http.NewRequest("POST", drawPath(campaign.ID), nil)
 	if err != nil {
 		t.Fatal(err)
 	}
 
-	w := httptest.NewRecorder()
+	w = httptest.NewRecorder()
 
 	router := setupRouter2()
 	router.ServeHTTP(w, req)
 
 	assert.Equal(t, http.StatusOK, w.Code)
 
+	var resp struct {
+		Result prize_models.DrawRecord `json:"result"`
+	}
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+	assert.Equal(t, prize_models.OutcomePoints, resp.Result.Kind)
+	assert.Equal(t, 1000, resp.Result.Amount)
+
 	// Check that the points system has been updated
 	var updatedPointsSystem prize_models.PointsSystem
 	err = database.DB.Where("user_id = ?", userID).First(&updatedPointsSystem).Error
 	assert.NoError(t, err)
 	assert.Greater(t, updatedPointsSystem.Points, points) // Check that points have increased
 
+	// The campaign allows one draw a day
+	req, _ = http.NewRequest("POST", drawPath(campaign.ID), nil)
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusTooManyRequests, w.Code)
+
 	// Clean up
-	database.DB.Delete(&pointsSystem)
+	database.DB.Delete(pointsSystem)
 }
 
 func TestExchangeCoinsHandler(t *testing.T) {
 	database.InitDB()
 
 	// Setup data
-	userID := "testuser"
+	userID := uint(1001)
 	coins := 200 // Set coins so that user can exchange
 
 	// Add user and points system
@@ -72,7 +128,7 @@ func TestExchangeCoinsHandler(t *testing.T) {
 		t.Fatal(err)
 	}
 
-	req, err := http.NewRequest("POST", "/prize_handlers/exchange/"+userID, nil)
+	req, err := http.NewRequest("POST", "/prize_handlers/exchange/"+strconv.Itoa(int(userID)), nil)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -91,14 +147,14 @@ func TestExchangeCoinsHandler(t *testing.T) {
 	assert.Equal(t, coins%100, updatedPointsSystem.Coins) // Check that coins have been converted to points
 
 	// Clean up
-	database.DB.Delete(&pointsSystem)
+	database.DB.Delete(pointsSystem)
 }
 
 func TestGetPointsSystemHandler(t *testing.T) {
 	database.InitDB()
 
 	// Setup data
-	userID := "testuser"
+	userID := uint(1001)
 
 	// Add user and points system
 	pointsSystem := &prize_models.PointsSystem{UserID: userID}
@@ -106,7 +162,7 @@ func TestGetPointsSystemHandler(t *testing.T) {
 		t.Fatal(err)
 	}
 
-	req, err := http.NewRequest("GET", "/prize_handlers/getPointsSystem/"+userID, nil)
+	req, err := http.NewRequest("GET", "/prize_handlers/getPointsSystem/"+strconv.Itoa(int(userID)), nil)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -124,5 +180,36 @@ func TestGetPointsSystemHandler(t *testing.T) {
 	assert.Equal(t, userID, retrievedPointsSystem.UserID)
 
 	// Clean up
-	database.DB.Delete(&pointsSystem)
+	database.DB.Delete(pointsSystem)
+}
+
+func TestDrawHandlerWithRedemptionCode(t *testing.T) {
+	database.InitDB()
+
+	router := gin.Default()
+	router.Use(middleware.ErrorHandler())
+	router.POST("/draw/:userID/:campaignID", userIDFromParam, middleware.CheckRedemptionCode(), prize_handlers.DrawHandler)
+
+	campaign := createDrawCampaign(t, createRewardTable(t, 1000).ID, 1)
+	assert.NoError(t, prize_models.AddRedemptionCode(database.DB, "draw-code"))
+	assert.NoError(t, prize_models.AddRedemptionCode(database.DB, "spare-code"))
+	fetchDrawSeed(t, 1001)
+
+	draw := func(body string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest("POST", "/draw/1001/"+strconv.Itoa(int(campaign.ID)), strings.NewReader(body))
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	assert.Equal(t, http.StatusBadRequest, draw(`{}`).Code)
+	assert.Equal(t, http.StatusNotFound, draw(`{"code":"unknown"}`).Code)
+	assert.Equal(t, http.StatusOK, draw(`{"code":"draw-code"}`).Code)
+	assert.Equal(t, http.StatusConflict, draw(`{"code":"draw-code"}`).Code)
+
+	// The daily limit stops the next draw and the spare code is kept
+	assert.Equal(t, http.StatusTooManyRequests, draw(`{"code":"spare-code"}`).Code)
+	code, _, err := prize_models.GetRedemptionCodeByCode(database.DB, "spare-code")
+	assert.NoError(t, err)
+	assert.False(t, code.Used)
 }
@@ -0,0 +1,75 @@
+package prize_handlers
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+	"xy.com/mysite/database"
+	"xy.com/mysite/models/prize_models"
+
+	"github.com/gin-gonic/gin"
+)
+
+const defaultExpiringDays = 30
+
+// getExpiringWithin reads the "days" query parameter, how far ahead to look for expiring points.
+func getExpiringWithin(c *gin.Context) (time.Duration, bool) {
+	days, err := strconv.Atoi(c.DefaultQuery("days", strconv.Itoa(defaultExpiringDays)))
+	if err != nil || days < 1 || days > 366 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "days must be between 1 and 366"})
+		return 0, false
+	}
+	return time.Duration(days) * 24 * time.Hour, true
+}
+
+// GetExpiringPointsHandler handles fetching the current user's points that expire soon.
+func GetExpiringPointsHandler(c *gin.Context) {
+	userID, ok := getUserID(c)
+	if !ok {
+		return
+	}
+
+	within, ok := getExpiringWithin(c)
+	if !ok {
+		return
+	}
+
+	buckets, err := prize_models.GetExpiringPointsBuckets(database.DB, userID, within, time.Now())
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	points := 0
+	for _, bucket := range buckets {
+		points += bucket.Remaining
+	}
+	c.JSON(http.StatusOK, gin.H{"points": points, "buckets": buckets})
+}
+
+// GetUsersWithExpiringPointsHandler handles fetching a page of the users whose
+// points expire soon, to notify them.
+func GetUsersWithExpiringPointsHandler(c *gin.Context) {
+	within, ok := getExpiringWithin(c)
+	if !ok {
+		return
+	}
+
+	page, pageSize, ok := getPagination(c)
+	if !ok {
+		return
+	}
+
+	users, total, err := prize_models.GetUsersWithExpiringPoints(database.DB, within, time.Now(), (page-1)*pageSize, pageSize)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"users":     users,
+		"page":      page,
+		"page_size": pageSize,
+		"total":     total,
+	})
+}
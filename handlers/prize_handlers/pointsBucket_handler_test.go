@@ -0,0 +1,60 @@
+package prize_handlers_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"xy.com/mysite/database"
+	"xy.com/mysite/handlers/prize_handlers"
+	"xy.com/mysite/middleware"
+	"xy.com/mysite/models/prize_models"
+)
+
+func setupPointsBucketRouter(userID uint) *gin.Engine {
+	router := gin.Default()
+	router.Use(middleware.ErrorHandler())
+	router.GET("/point/expiring", func(c *gin.Context) {
+		c.Set("userID", userID)
+		c.Next()
+	}, prize_handlers.GetExpiringPointsHandler)
+	router.GET("/admin/points/expiring", prize_handlers.GetUsersWithExpiringPointsHandler)
+	return router
+}
+
+func TestExpiringPointsHandlers(t *testing.T) {
+	database.InitDB()
+	userID := uint(5001)
+	router := setupPointsBucketRouter(userID)
+
+	expiry := prize_models.PointsExpiry
+	prize_models.PointsExpiry = 7 * 24 * time.Hour
+	t.Cleanup(func() { prize_models.PointsExpiry = expiry })
+	assert.NoError(t, prize_models.CreditPoints(database.DB, userID, 80, prize_models.LedgerReasonAdminAdjustment, ""))
+
+	serve := func(path string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest("GET", path, nil)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	w := serve("/point/expiring?days=3")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), `"points":0`)
+
+	w = serve("/point/expiring")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), `"points":80`)
+
+	w = serve("/admin/points/expiring?days=10")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), `"total":1`)
+	assert.Contains(t, w.Body.String(), `"user_id":5001`)
+
+	w = serve("/point/expiring?days=0")
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+}
@@ -2,28 +2,132 @@ package prize_handlers
 
 import (
 	"net/http"
+	"strconv"
 	"xy.com/mysite/database"
 	"xy.com/mysite/models/prize_models"
 
 	"github.com/gin-gonic/gin"
 )
 
+// prizeRequest is the body of the add and update prize requests.
+type prizeRequest struct {
+	Slug               string `json:"slug"`
+	PrizeName          string `json:"prize_name"`
+	Description        string `json:"description"`
+	ImageURL           string `json:"image_url"`
+	Cost               int    `json:"cost"`
+	Stock              *int   `json:"stock"`
+	PerUserLimit       int    `json:"per_user_limit"`
+	CodeAlertThreshold int    `json:"code_alert_threshold"`
+	Physical           bool   `json:"physical"`
+	SharedCodes        bool   `json:"shared_codes"`
+}
+
+func (r *prizeRequest) prize() *prize_models.Prize {
+	prize := &prize_models.Prize{
+		Slug:               r.Slug,
+		PrizeName:          r.PrizeName,
+		Description:        r.Description,
+		ImageURL:           r.ImageURL,
+		Cost:               r.Cost,
+		Stock:              r.Stock,
+		PerUserLimit:       r.PerUserLimit,
+		CodeAlertThreshold: r.CodeAlertThreshold,
+		Physical:           r.Physical,
+		SharedCodes:        r.SharedCodes,
+	}
+	if prize.PerUserLimit == 0 {
+		prize.PerUserLimit = 1
+	}
+	return prize
+}
+
 // AddPrizeHandler handles the addition of a new prize_handlers.
 func AddPrizeHandler(c *gin.Context) {
-	var req struct {
-		PrizeName string `json:"prize_name"`
-		Cost      int    `json:"cost"`
-	}
+	var req prizeRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request payload"})
 		return
 	}
 
-	err := prize_models.AddPrize(database.DB, req.PrizeName, req.Cost)
+	prize := req.prize()
+	err := prize_models.CreatePrize(database.DB, prize)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{"message": "Prize added successfully", "prize": prize})
+}
+
+// GetPrizesHandler handles fetching a page of the prize catalog.
+func GetPrizesHandler(c *gin.Context) {
+	page, pageSize, ok := getPagination(c)
+	if !ok {
+		return
+	}
+
+	prizes, total, err := prize_models.GetPrizes(database.DB, (page-1)*pageSize, pageSize)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"prizes":    prizes,
+		"page":      page,
+		"page_size": pageSize,
+		"total":     total,
+	})
+}
+
+// GetPrizeBySlugHandler handles fetching a prize by slug.
+func GetPrizeBySlugHandler(c *gin.Context) {
+	prize, err := prize_models.GetPrizeBySlug(database.DB, c.Param("slug"))
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	c.JSON(http.StatusOK, prize)
+}
+
+// UpdatePrizeHandler handles updating a prize's details, stock and limits.
+func UpdatePrizeHandler(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.Error(err).SetType(gin.ErrorTypeBind)
+		return
+	}
+
+	var req prizeRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.Error(err).SetType(gin.ErrorTypeBind)
+		return
+	}
+
+	prize := req.prize()
+	prize.ID = uint(id)
+	if err := prize_models.UpdatePrize(database.DB, prize); err != nil {
+		c.Error(err)
+		return
+	}
+
+	c.JSON(http.StatusOK, prize)
+}
+
+// RetirePrizeHandler handles withdrawing a prize from the catalog.
+func RetirePrizeHandler(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		c.Error(err).SetType(gin.ErrorTypeBind)
+		return
+	}
+
+	if err := prize_models.RetirePrize(database.DB, uint(id)); err != nil {
+		c.Error(err)
 		return
 	}
 
-	c.JSON(http.StatusCreated, gin.H{"message": "Prize added successfully"})
+	c.JSON(http.StatusOK, gin.H{"message": "Prize retired successfully"})
 }
@@ -7,14 +7,17 @@ import (
 	"github.com/stretchr/testify/assert"
 	"net/http"
 	"net/http/httptest"
+	"strconv"
 	"testing"
 	"xy.com/mysite/database"
 	"xy.com/mysite/handlers/prize_handlers"
+	"xy.com/mysite/middleware"
 	"xy.com/mysite/models/prize_models"
 )
 
 func setupRouter() *gin.Engine {
 	router := gin.Default()
+	router.Use(middleware.ErrorHandler())
 	orderGroup := router.Group("/prize_handlers")
 	{
 		orderGroup.POST("/addPrize", prize_handlers.AddPrizeHandler)
@@ -58,3 +61,96 @@ func TestAddPrizeHandler(t *testing.T) {
 	// Clean up
 	database.DB.Delete(&prize)
 }
+
+func setupPrizeCatalogRouter() *gin.Engine {
+	router := gin.Default()
+	router.Use(middleware.ErrorHandler())
+	router.GET("/prizes", prize_handlers.GetPrizesHandler)
+	router.GET("/prizes/:slug", prize_handlers.GetPrizeBySlugHandler)
+	adminGroup := router.Group("/admin")
+	{
+		adminGroup.POST("/addPrize", prize_handlers.AddPrizeHandler)
+		adminGroup.PUT("/prizes/:id", prize_handlers.UpdatePrizeHandler)
+		adminGroup.DELETE("/prizes/:id", prize_handlers.RetirePrizeHandler)
+	}
+	return router
+}
+
+func TestPrizeCatalogHandlers(t *testing.T) {
+	database.InitDB()
+	router := setupPrizeCatalogRouter()
+
+	send := func(method, path string, body interface{}) *httptest.ResponseRecorder {
+		data, _ := json.Marshal(body)
+		req, _ := http.NewRequest(method, path, bytes.NewReader(data))
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	for _, name := range []string{"Coffee Mug", "Sticker Pack", "T-Shirt"} {
+		w := send("POST", "/admin/addPrize", map[string]interface{}{"prize_name": name, "cost": 100, "stock": 10})
+		assert.Equal(t, http.StatusCreated, w.Code)
+	}
+
+	// Names are unique
+	w := send("POST", "/admin/addPrize", map[string]interface{}{"prize_name": "Coffee Mug", "cost": 100})
+	assert.Equal(t, http.StatusConflict, w.Code)
+	var errResp middleware.ErrorResponse
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &errResp))
+	assert.Equal(t, "prize_exists", errResp.Code)
+
+	w = send("GET", "/prizes?page=2&page_size=2", nil)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	var catalog struct {
+		Prizes []prize_models.Prize `json:"prizes"`
+		Total  int64                `json:"total"`
+	}
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &catalog))
+	assert.Equal(t, int64(3), catalog.Total)
+	assert.Equal(t, 1, len(catalog.Prizes))
+	assert.Equal(t, "t-shirt", catalog.Prizes[0].Slug)
+
+	w = send("GET", "/prizes/coffee-mug", nil)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	var mug prize_models.Prize
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &mug))
+	assert.Equal(t, 10, *mug.Stock)
+
+	w = send("PUT", "/admin/prizes/"+strconv.Itoa(int(mug.ID)), map[string]interface{}{
+		"prize_name":  "Coffee Mug",
+		"description": "Holds coffee",
+		"cost":        150,
+		"stock":       2,
+	})
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &mug))
+	assert.Equal(t, "Holds coffee", mug.Description)
+	assert.Equal(t, 150, mug.Cost)
+	assert.Equal(t, "coffee-mug", mug.Slug)
+
+	w = send("PUT", "/admin/prizes/9999", map[string]interface{}{"prize_name": "Ghost", "cost": 1})
+	assert.Equal(t, http.StatusNotFound, w.Code)
+
+	w = send("DELETE", "/admin/prizes/"+strconv.Itoa(int(mug.ID)), nil)
+	assert.Equal(t, http.StatusOK, w.Code)
+	w = send("DELETE", "/admin/prizes/"+strconv.Itoa(int(mug.ID)), nil)
+	assert.Equal(t, http.StatusConflict, w.Code)
+
+	w = send("GET", "/prizes", nil)
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &catalog))
+	assert.Equal(t, int64(2), catalog.Total)
+
+	w = send("GET", "/prizes/unknown", nil)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &errResp))
+	assert.Equal(t, "prize_not_found", errResp.Code)
+
+	// Malformed requests get the generic code
+	w = send("PUT", "/admin/prizes/abc", nil)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &errResp))
+	assert.Equal(t, "invalid_request", errResp.Code)
+}
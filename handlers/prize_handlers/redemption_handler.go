@@ -3,6 +3,7 @@ package prize_handlers
 import (
 	"github.com/gin-gonic/gin"
 	"net/http"
+	"time"
 	"xy.com/mysite/database"
 	"xy.com/mysite/models/prize_models"
 )
@@ -10,18 +11,54 @@ import (
 func AddRedemptionCodeHandler(c *gin.Context) {
 	// Parse request
 	var req struct {
-		Code string `json:"code"`
+		Code       string     `json:"code"`
+		MaxUses    int        `json:"max_uses"` // Optional, defaults to a single use
+		ExpiresAt  *time.Time `json:"expires_at"`
+		UserID     uint       `json:"user_id"`
+		CampaignID uint       `json:"campaign_id"`
 	}
 	if err := c.ShouldBindJSON(&req); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		c.Error(err).SetType(gin.ErrorTypeBind)
 		return
 	}
+	if req.MaxUses == 0 {
+		req.MaxUses = 1
+	}
 
 	// Add code to database
-	if err := prize_models.AddRedemptionCode(database.DB, req.Code); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	code := &prize_models.RedemptionCode{
+		Code:       req.Code,
+		MaxUses:    req.MaxUses,
+		ExpiresAt:  req.ExpiresAt,
+		UserID:     req.UserID,
+		CampaignID: req.CampaignID,
+	}
+	if err := prize_models.CreateRedemptionCode(database.DB, code); err != nil {
+		c.Error(err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Code added successfully", "code": code})
+}
+
+// GetRedemptionCodeUsesHandler handles fetching a redemption code and its uses.
+func GetRedemptionCodeUsesHandler(c *gin.Context) {
+	code, uses, err := prize_models.GetRedemptionCodeByCode(database.DB, c.Param("code"))
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"code": code, "uses": uses})
+}
+
+// RevokeRedemptionCodeHandler handles revoking a redemption code.
+func RevokeRedemptionCodeHandler(c *gin.Context) {
+	err := prize_models.RevokeRedemptionCode(database.DB, c.Param("code"))
+	if err != nil {
+		c.Error(err)
 		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{"message": "Code added successfully"})
+	c.JSON(http.StatusOK, gin.H{"message": "Code revoked successfully"})
 }
@@ -6,6 +6,7 @@ import (
 	"strings"
 	"testing"
 	"xy.com/mysite/handlers/prize_handlers"
+	"xy.com/mysite/middleware"
 
 	"github.com/gin-gonic/gin"
 	"github.com/stretchr/testify/assert"
@@ -15,6 +16,7 @@ import (
 
 func setupRouter5() *gin.Engine {
 	router := gin.Default()
+	router.Use(middleware.ErrorHandler())
 	prizeGroup := router.Group("/prize_handlers")
 	{
 		prizeGroup.POST("/addRedemptionCode", prize_handlers.AddRedemptionCodeHandler)
@@ -0,0 +1,61 @@
+package prize_handlers
+
+import (
+	"net/http"
+	"strconv"
+	"xy.com/mysite/database"
+	"xy.com/mysite/models/prize_models"
+
+	"github.com/gin-gonic/gin"
+)
+
+// CreateRewardTableHandler handles the creation of a reward table.
+func CreateRewardTableHandler(c *gin.Context) {
+	var table prize_models.RewardTable
+	if err := c.ShouldBindJSON(&table); err != nil {
+		c.Error(err).SetType(gin.ErrorTypeBind)
+		return
+	}
+	table.ID = 0
+
+	if err := prize_models.CreateRewardTable(database.DB, &table); err != nil {
+		c.Error(err)
+		return
+	}
+
+	c.JSON(http.StatusOK, table)
+}
+
+// GetRewardTablesHandler handles fetching all reward tables.
+func GetRewardTablesHandler(c *gin.Context) {
+	tables, err := prize_models.GetRewardTables(database.DB)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	c.JSON(http.StatusOK, tables)
+}
+
+// UpdateRewardTableHandler handles replacing the settings and outcomes of a reward table.
+func UpdateRewardTableHandler(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.Error(err).SetType(gin.ErrorTypeBind)
+		return
+	}
+
+	var table prize_models.RewardTable
+	if err := c.ShouldBindJSON(&table); err != nil {
+		c.Error(err).SetType(gin.ErrorTypeBind)
+		return
+	}
+	table.ID = uint(id)
+
+	if err := prize_models.UpdateRewardTable(database.DB, &table); err != nil {
+		c.Error(err)
+		return
+	}
+
+	c.JSON(http.StatusOK, table)
+}
@@ -0,0 +1,96 @@
+package prize_handlers_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"xy.com/mysite/database"
+	"xy.com/mysite/handlers/prize_handlers"
+	"xy.com/mysite/middleware"
+	"xy.com/mysite/models/prize_models"
+)
+
+// createRewardTable creates an active reward table that always awards the given points.
+func createRewardTable(t *testing.T, points int) *prize_models.RewardTable {
+	table := &prize_models.RewardTable{
+		Name:     "points-" + strconv.Itoa(points),
+		Active:   true,
+		Outcomes: []prize_models.RewardOutcome{{Kind: prize_models.OutcomePoints, Amount: points, Weight: 1}},
+	}
+	if err := prize_models.CreateRewardTable(database.DB, table); err != nil {
+		t.Fatal(err)
+	}
+	return table
+}
+
+func setupRewardTableRouter() *gin.Engine {
+	router := gin.Default()
+	router.Use(middleware.ErrorHandler())
+	adminGroup := router.Group("/admin")
+	{
+		adminGroup.POST("/rewardTables", prize_handlers.CreateRewardTableHandler)
+		adminGroup.GET("/rewardTables", prize_handlers.GetRewardTablesHandler)
+		adminGroup.PUT("/rewardTables/:id", prize_handlers.UpdateRewardTableHandler)
+	}
+	return router
+}
+
+func TestRewardTableHandlers(t *testing.T) {
+	database.InitDB()
+	router := setupRewardTableRouter()
+
+	send := func(method, path string, body interface{}) *httptest.ResponseRecorder {
+		data, _ := json.Marshal(body)
+		req, _ := http.NewRequest(method, path, bytes.NewReader(data))
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	table := map[string]interface{}{
+		"name":           "daily",
+		"active":         true,
+		"pity_threshold": 10,
+		"outcomes": []map[string]interface{}{
+			{"kind": "nothing", "weight": 90},
+			{"kind": "coins", "amount": 50, "weight": 10, "rare": true},
+		},
+	}
+	w := send("POST", "/admin/rewardTables", table)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	var created prize_models.RewardTable
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &created))
+	assert.NotZero(t, created.ID)
+	assert.Equal(t, 2, len(created.Outcomes))
+
+	// Outcomes need a positive weight
+	w = send("POST", "/admin/rewardTables", map[string]interface{}{
+		"name":     "broken",
+		"outcomes": []map[string]interface{}{{"kind": "points", "amount": 10, "weight": 0}},
+	})
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	// Updating replaces the outcomes
+	table["outcomes"] = []map[string]interface{}{{"kind": "points", "amount": 5, "weight": 1, "rare": true}}
+	w = send("PUT", "/admin/rewardTables/"+strconv.Itoa(int(created.ID)), table)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	w = send("PUT", "/admin/rewardTables/9999", table)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+
+	w = send("GET", "/admin/rewardTables", nil)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	var tables []prize_models.RewardTable
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &tables))
+	assert.Equal(t, 1, len(tables))
+	assert.Equal(t, 1, len(tables[0].Outcomes))
+	assert.Equal(t, prize_models.OutcomePoints, tables[0].Outcomes[0].Kind)
+}
@@ -0,0 +1,234 @@
+package shop_handlers
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+	"xy.com/mysite/models/shop_models"
+
+	"github.com/gin-gonic/gin"
+	"xy.com/mysite/database"
+)
+
+// flushEvery is the number of exported records written between flushes.
+const flushEvery = 100
+
+// exportWriter writes one record at a time in either CSV or NDJSON format.
+type exportWriter struct {
+	w       gin.ResponseWriter
+	csv     *csv.Writer
+	json    *json.Encoder
+	written int
+}
+
+// newExportWriter validates the format and starts the response. Nothing is
+// written if an error is returned.
+func newExportWriter(c *gin.Context, format string, name string, header []string) (*exportWriter, error) {
+	if format != "" && format != "csv" && format != "ndjson" {
+		return nil, fmt.Errorf("unsupported format %q, expected csv or ndjson", format)
+	}
+
+	ew := &exportWriter{w: c.Writer}
+	filename := fmt.Sprintf("%s-%s", name, time.Now().Format("20060102-150405"))
+
+	switch format {
+	case "", "csv":
+		c.Header("Content-Type", "text/csv; charset=utf-8")
+		c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=%q", filename+".csv"))
+		c.Status(http.StatusOK)
+		ew.csv = csv.NewWriter(c.Writer)
+		_ = ew.csv.Write(header)
+	case "ndjson":
+		c.Header("Content-Type", "application/x-ndjson")
+		c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=%q", filename+".ndjson"))
+		c.Status(http.StatusOK)
+		ew.json = json.NewEncoder(c.Writer)
+	}
+
+	return ew, nil
+}
+
+// write emits one record, using row for CSV and value for NDJSON.
+func (ew *exportWriter) write(row []string, value interface{}) error {
+	var err error
+	if ew.csv != nil {
+		err = ew.csv.Write(row)
+	} else {
+		err = ew.json.Encode(value)
+	}
+	if err != nil {
+		return err
+	}
+
+	ew.written++
+	if ew.written%flushEvery == 0 {
+		return ew.flush()
+	}
+	return nil
+}
+
+func (ew *exportWriter) flush() error {
+	if ew.csv != nil {
+		ew.csv.Flush()
+		if err := ew.csv.Error(); err != nil {
+			return err
+		}
+	}
+	ew.w.Flush()
+	return nil
+}
+
+// parseExportFilter reads the optional "from" and "to" query parameters.
+// Both accept RFC 3339 timestamps or YYYY-MM-DD dates; a date in "to" includes that whole day.
+func parseExportFilter(c *gin.Context) (shop_models.ExportFilter, error) {
+	var filter shop_models.ExportFilter
+	var err error
+
+	if raw := c.Query("from"); raw != "" {
+		if filter.From, err = parseExportTime(raw, false); err != nil {
+			return filter, fmt.Errorf("invalid from: %w", err)
+		}
+	}
+	if raw := c.Query("to"); raw != "" {
+		if filter.To, err = parseExportTime(raw, true); err != nil {
+			return filter, fmt.Errorf("invalid to: %w", err)
+		}
+	}
+
+	return filter, nil
+}
+
+func parseExportTime(raw string, endOfDay bool) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, raw); err == nil {
+		return t, nil
+	}
+	t, err := time.Parse("2006-01-02", raw)
+	if err != nil {
+		return time.Time{}, err
+	}
+	if endOfDay {
+		t = t.AddDate(0, 0, 1)
+	}
+	return t, nil
+}
+
+// ExportProductsHandler streams products as CSV or NDJSON.
+func ExportProductsHandler(c *gin.Context) {
+	filter, err := parseExportFilter(c)
+	if err != nil {
+		c.Error(err).SetType(gin.ErrorTypeBind)
+		return
+	}
+
+	header := []string{"id", "name", "description", "price", "image_url", "created_at", "updated_at"}
+	ew, err := newExportWriter(c, c.Query("format"), "products", header)
+	if err != nil {
+		c.Error(err).SetType(gin.ErrorTypeBind)
+		return
+	}
+
+	err = shop_models.ExportProducts(database.DB, filter, func(product *shop_models.Product) error {
+		return ew.write([]string{
+			strconv.FormatUint(uint64(product.ID), 10),
+			product.Name,
+			product.Description,
+			strconv.FormatFloat(product.Price, 'f', -1, 64),
+			product.ImageURL,
+			product.CreatedAt.Format(time.RFC3339),
+			product.UpdatedAt.Format(time.RFC3339),
+		}, product)
+	})
+	finishExport(ew, "product", err)
+}
+
+// ExportOrdersHandler streams orders as CSV or NDJSON.
+func ExportOrdersHandler(c *gin.Context) {
+	filter, err := parseExportFilter(c)
+	if err != nil {
+		c.Error(err).SetType(gin.ErrorTypeBind)
+		return
+	}
+
+	header := []string{"id", "user_id", "total_cost", "item_count", "shipping_address", "billing_address", "created_at"}
+	ew, err := newExportWriter(c, c.Query("format"), "orders", header)
+	if err != nil {
+		c.Error(err).SetType(gin.ErrorTypeBind)
+		return
+	}
+
+	err = shop_models.ExportOrders(database.DB, filter, func(order *shop_models.Order) error {
+		return ew.write([]string{
+			strconv.FormatUint(uint64(order.ID), 10),
+			strconv.FormatUint(uint64(order.UserID), 10),
+			strconv.FormatFloat(order.TotalCost, 'f', -1, 64),
+			strconv.Itoa(len(order.OrderItems)),
+			order.ShippingAddress,
+			order.BillingAddress,
+			order.CreatedAt.Format(time.RFC3339),
+		}, order)
+	})
+	finishExport(ew, "order", err)
+}
+
+// finishExport flushes the remaining output. The status line has already been
+// sent, so an export that failed ends with an error record in NDJSON, and
+// aborts the connection in CSV, which has no room for one, so that it does not
+// look complete.
+func finishExport(ew *exportWriter, kind string, err error) {
+	if err != nil {
+		log.Printf("%s export failed after %d records: %v", kind, ew.written, err)
+		if ew.csv != nil {
+			_ = ew.flush()
+			panic(http.ErrAbortHandler)
+		}
+		message := fmt.Sprintf("%s export failed after %d records", kind, ew.written)
+		if err := ew.json.Encode(gin.H{"error": message, "code": "export_failed"}); err != nil {
+			log.Printf("%s export: failed to write error: %v", kind, err)
+		}
+	}
+	if err := ew.flush(); err != nil {
+		log.Printf("%s export: failed to flush: %v", kind, err)
+	}
+}
+
+// ImportProductsHandler handles a bulk product import from CSV.
+// The CSV is read from the "file" form field or, if absent, the raw request body.
+// Pass dry_run=true to validate and preview the import without writing anything.
+func ImportProductsHandler(c *gin.Context) {
+	dryRun, _ := strconv.ParseBool(c.Query("dry_run"))
+
+	var body io.Reader = c.Request.Body
+	if strings.HasPrefix(c.ContentType(), "multipart/") {
+		file, err := c.FormFile("file")
+		if err != nil {
+			c.Error(err).SetType(gin.ErrorTypeBind)
+			return
+		}
+		f, err := file.Open()
+		if err != nil {
+			c.Error(err).SetType(gin.ErrorTypeBind)
+			return
+		}
+		defer f.Close()
+		body = f
+	}
+
+	result, err := shop_models.ImportProducts(database.DB, body, dryRun)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	if len(result.RowErrors) > 0 {
+		c.JSON(http.StatusUnprocessableEntity, result)
+		return
+	}
+
+	c.JSON(http.StatusOK, result)
+}
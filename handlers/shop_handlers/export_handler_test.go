@@ -0,0 +1,251 @@
+package shop_handlers_test
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"xy.com/mysite/database"
+	"xy.com/mysite/handlers/shop_handlers"
+	"xy.com/mysite/middleware"
+	"xy.com/mysite/models/shop_models"
+)
+
+func setupExportRouter() *gin.Engine {
+	router := gin.New()
+	router.Use(gin.Logger(), middleware.Recovery(), middleware.ErrorHandler())
+	adminGroup := router.Group("/admin")
+	{
+		adminGroup.GET("/products/export", shop_handlers.ExportProductsHandler)
+		adminGroup.POST("/products/import", shop_handlers.ImportProductsHandler)
+		adminGroup.GET("/orders/export", shop_handlers.ExportOrdersHandler)
+	}
+	return router
+}
+
+func TestExportProductsHandler(t *testing.T) {
+	setupTestData()
+
+	product1 := &shop_models.Product{Name: "export1", Price: 1.5}
+	product2 := &shop_models.Product{Name: "export2", Price: 2.5}
+	database.DB.Create(product1)
+	database.DB.Create(product2)
+
+	router := setupExportRouter()
+
+	// CSV
+	req, _ := http.NewRequest("GET", "/admin/products/export", nil)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Header().Get("Content-Type"), "text/csv")
+	records, err := csv.NewReader(w.Body).ReadAll()
+	assert.NoError(t, err)
+	assert.Equal(t, "id", records[0][0])
+	names := map[string]bool{}
+	for _, record := range records[1:] {
+		names[record[1]] = true
+	}
+	assert.True(t, names["export1"])
+	assert.True(t, names["export2"])
+
+	// NDJSON
+	req, _ = http.NewRequest("GET", "/admin/products/export?format=ndjson", nil)
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	scanner := bufio.NewScanner(w.Body)
+	found := false
+	for scanner.Scan() {
+		var product shop_models.Product
+		assert.NoError(t, json.Unmarshal(scanner.Bytes(), &product))
+		if product.ID == product1.ID {
+			found = true
+		}
+	}
+	assert.True(t, found)
+
+	// A date range in the future matches nothing
+	req, _ = http.NewRequest("GET", "/admin/products/export?from=2999-01-01", nil)
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	records, _ = csv.NewReader(w.Body).ReadAll()
+	assert.Equal(t, 1, len(records))
+
+	// Bad parameters are rejected before anything is streamed
+	req, _ = http.NewRequest("GET", "/admin/products/export?format=xml", nil)
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	req, _ = http.NewRequest("GET", "/admin/products/export?to=yesterday", nil)
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	database.DB.Delete(product1)
+	database.DB.Delete(product2)
+}
+
+func TestExportOrdersHandler(t *testing.T) {
+	setupTestData()
+
+	testOrder := shop_models.Order{UserID: 7, TotalCost: 42.0, ShippingAddress: "Somewhere"}
+	database.DB.Create(&testOrder)
+
+	req, _ := http.NewRequest("GET", "/admin/orders/export", nil)
+	w := httptest.NewRecorder()
+	router := setupExportRouter()
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	records, err := csv.NewReader(w.Body).ReadAll()
+	assert.NoError(t, err)
+
+	found := false
+	for _, record := range records[1:] {
+		if record[0] == strconv.Itoa(int(testOrder.ID)) {
+			found = true
+			assert.Equal(t, "7", record[1])
+			assert.Equal(t, "42", record[2])
+			assert.Equal(t, "Somewhere", record[4])
+		}
+	}
+	assert.True(t, found)
+
+	database.DB.Delete(&testOrder)
+}
+
+func TestExportFailingMidway(t *testing.T) {
+	setupTestData()
+
+	testOrder := shop_models.Order{UserID: 7, TotalCost: 42.0}
+	database.DB.Create(&testOrder)
+	defer database.DB.Delete(&testOrder)
+
+	// Loading the items fails once the export has started
+	assert.NoError(t, database.DB.Exec("ALTER TABLE order_items RENAME TO order_items_gone").Error)
+	defer database.DB.Exec("ALTER TABLE order_items_gone RENAME TO order_items")
+	router := setupExportRouter()
+
+	// NDJSON ends with an error record
+	req, _ := http.NewRequest("GET", "/admin/orders/export?format=ndjson", nil)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+	lines := strings.Split(strings.TrimSpace(w.Body.String()), "\n")
+	assert.Contains(t, lines[len(lines)-1], `"code":"export_failed"`)
+
+	// CSV has no room for one, the download is cut short instead
+	server := httptest.NewServer(router)
+	defer server.Close()
+	resp, err := http.Get(server.URL + "/admin/orders/export")
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer resp.Body.Close()
+	_, err = io.ReadAll(resp.Body)
+	assert.Error(t, err)
+}
+
+func TestImportProductsHandler(t *testing.T) {
+	setupTestData()
+
+	existing := &shop_models.Product{Name: "before import", Price: 1.0}
+	database.DB.Create(existing)
+
+	router := setupExportRouter()
+	csvBody := "id,name,description,price,image_url\n" +
+		strconv.Itoa(int(existing.ID)) + ",after import,updated,3.5,\n" +
+		",imported product,new,4.25,http://example.com/a.png\n"
+
+	// Dry run reports the changes without writing them
+	req, _ := http.NewRequest("POST", "/admin/products/import?dry_run=true", strings.NewReader(csvBody))
+	req.Header.Set("Content-Type", "text/csv")
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	var result shop_models.ImportResult
+	json.Unmarshal(w.Body.Bytes(), &result)
+	assert.True(t, result.DryRun)
+	assert.Equal(t, 1, result.Created)
+	assert.Equal(t, 1, result.Updated)
+
+	var unchanged shop_models.Product
+	database.DB.First(&unchanged, existing.ID)
+	assert.Equal(t, "before import", unchanged.Name)
+	var count int64
+	database.DB.Model(&shop_models.Product{}).Where("name = ?", "imported product").Count(&count)
+	assert.Equal(t, int64(0), count)
+
+	// A real import writes everything
+	req, _ = http.NewRequest("POST", "/admin/products/import", strings.NewReader(csvBody))
+	req.Header.Set("Content-Type", "text/csv")
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	var updated shop_models.Product
+	database.DB.First(&updated, existing.ID)
+	assert.Equal(t, "after import", updated.Name)
+	assert.Equal(t, 3.5, updated.Price)
+	var imported shop_models.Product
+	assert.NoError(t, database.DB.Where("name = ?", "imported product").First(&imported).Error)
+
+	// Any invalid row rejects the whole file and reports every bad row
+	badBody := "name,price\nvalid,1\n,2\nbad price,abc\n"
+	req, _ = http.NewRequest("POST", "/admin/products/import", strings.NewReader(badBody))
+	req.Header.Set("Content-Type", "text/csv")
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusUnprocessableEntity, w.Code)
+	result = shop_models.ImportResult{}
+	json.Unmarshal(w.Body.Bytes(), &result)
+	assert.Equal(t, 2, len(result.RowErrors))
+	assert.Equal(t, 3, result.RowErrors[0].Row)
+	assert.Equal(t, 4, result.RowErrors[1].Row)
+	database.DB.Model(&shop_models.Product{}).Where("name = ?", "valid").Count(&count)
+	assert.Equal(t, int64(0), count)
+
+	// A missing required column is a bad request
+	req, _ = http.NewRequest("POST", "/admin/products/import", strings.NewReader("name\nfoo\n"))
+	req.Header.Set("Content-Type", "text/csv")
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	// Rows cannot bring back a deleted product
+	database.DB.Delete(&updated)
+	deletedBody := "id,name,price\n" +
+		strconv.Itoa(int(imported.ID)) + ",still here,2\n" +
+		strconv.Itoa(int(updated.ID)) + ",undeleted,2\n"
+	req, _ = http.NewRequest("POST", "/admin/products/import", strings.NewReader(deletedBody))
+	req.Header.Set("Content-Type", "text/csv")
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusUnprocessableEntity, w.Code)
+	result = shop_models.ImportResult{}
+	json.Unmarshal(w.Body.Bytes(), &result)
+	assert.Equal(t, 0, result.Updated)
+	if assert.Equal(t, 1, len(result.RowErrors)) {
+		assert.Equal(t, 3, result.RowErrors[0].Row)
+	}
+	assert.Error(t, database.DB.First(&shop_models.Product{}, updated.ID).Error)
+	var kept shop_models.Product
+	database.DB.First(&kept, imported.ID)
+	assert.Equal(t, "imported product", kept.Name)
+
+	database.DB.Delete(&imported)
+}
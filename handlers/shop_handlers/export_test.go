@@ -0,0 +1,4 @@
+package shop_handlers
+
+// SendMail lets tests replace the mailer of order confirmations.
+var SendMail = &sendMail
@@ -0,0 +1,120 @@
+package shop_handlers
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"xy.com/mysite/config"
+	"xy.com/mysite/models"
+	"xy.com/mysite/models/shop_models"
+	"xy.com/mysite/models/user_models"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+	"xy.com/mysite/database"
+)
+
+// sendMail is the function used to deliver order confirmation emails.
+var sendMail = models.SendEmailWithAttachments
+
+// GetOrderInvoiceHandler handles rendering the PDF invoice for an order. Only
+// the customer who placed the order and admins can get it; anyone else is told
+// the order does not exist.
+func GetOrderInvoiceHandler(c *gin.Context) {
+	userID, ok := c.Get("userID")
+	if ok {
+		_, ok = userID.(uint)
+	}
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+		return
+	}
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.Error(err).SetType(gin.ErrorTypeBind)
+		return
+	}
+
+	order, err := shop_models.GetOrderByID(database.DB, uint(id))
+	if err != nil {
+		c.Error(err)
+		return
+	}
+	if order.UserID != userID.(uint) {
+		admin, err := user_models.IsAdmin(database.DB, userID.(uint))
+		if err != nil {
+			c.Error(err)
+			return
+		}
+		if !admin {
+			c.Error(shop_models.ErrOrderNotFound)
+			return
+		}
+	}
+
+	invoice, pdf, err := renderInvoice(database.DB, order)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	c.Header("Content-Disposition", fmt.Sprintf("inline; filename=%q", invoice.Number+".pdf"))
+	c.Data(http.StatusOK, "application/pdf", pdf)
+}
+
+// renderInvoice issues (or reuses) the invoice for an order and renders it as a PDF.
+func renderInvoice(db *gorm.DB, order *shop_models.Order) (*shop_models.Invoice, []byte, error) {
+	invoice, err := shop_models.GetOrCreateInvoice(db, order.ID)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	issuer := shop_models.InvoiceIssuer{
+		Name:    config.Instance.Company.Name,
+		Address: config.Instance.Company.Address,
+		Email:   config.Instance.Company.Email,
+	}
+
+	var buf bytes.Buffer
+	if err := shop_models.RenderInvoicePDF(&buf, issuer, invoice, order); err != nil {
+		return nil, nil, err
+	}
+
+	return invoice, buf.Bytes(), nil
+}
+
+// sendOrderConfirmation emails the order confirmation with the invoice attached.
+func sendOrderConfirmation(db *gorm.DB, orderID uint) {
+	order, err := shop_models.GetOrderByID(db, orderID)
+	if err != nil {
+		log.Printf("order confirmation: failed to load order %d: %v", orderID, err)
+		return
+	}
+
+	user, err := user_models.GetUserByID(db, order.UserID)
+	if err != nil {
+		log.Printf("order confirmation: failed to load user %d: %v", order.UserID, err)
+		return
+	}
+
+	invoice, pdf, err := renderInvoice(db, order)
+	if err != nil {
+		log.Printf("order confirmation: failed to render invoice for order %d: %v", orderID, err)
+		return
+	}
+
+	subject := fmt.Sprintf("Order confirmation #%d", order.ID)
+	body := fmt.Sprintf("Hi %s,\n\nThank you for your order #%d. Your invoice %s is attached.\n\nTotal: %.2f\n",
+		user.Username, order.ID, invoice.Number, order.TotalCost)
+	attachment := models.Attachment{
+		Filename:    invoice.Number + ".pdf",
+		ContentType: "application/pdf",
+		Data:        pdf,
+	}
+
+	if err := sendMail(user.Email, subject, body, attachment); err != nil {
+		log.Printf("order confirmation: failed to send email for order %d: %v", orderID, err)
+	}
+}
@@ -0,0 +1,114 @@
+package shop_handlers_test
+
+import (
+	"bytes"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"xy.com/mysite/database"
+	"xy.com/mysite/handlers/shop_handlers"
+	"xy.com/mysite/middleware"
+	"xy.com/mysite/models/shop_models"
+	"xy.com/mysite/models/user_models"
+)
+
+func setupInvoiceRouter() *gin.Engine {
+	router := gin.Default()
+	router.Use(middleware.ErrorHandler())
+	// Stands in for AuthMiddleware, the user ID comes from the query
+	orderGroup := router.Group("/orders", func(c *gin.Context) {
+		if id, err := strconv.Atoi(c.Query("user")); err == nil {
+			c.Set("userID", uint(id))
+		}
+		c.Next()
+	})
+	{
+		orderGroup.GET("/:id/invoice.pdf", shop_handlers.GetOrderInvoiceHandler)
+	}
+	return router
+}
+
+func TestGetOrderInvoiceHandler(t *testing.T) {
+	setupTestData()
+
+	testProduct := shop_models.Product{Name: "Invoice Product", Price: 12.5}
+	database.DB.Create(&testProduct)
+
+	testOrder := shop_models.Order{
+		UserID:          1,
+		TotalCost:       25.0,
+		ShippingAddress: "1 Test Street\nTest City",
+		BillingAddress:  "1 Test Street\nTest City",
+		OrderItems: []shop_models.OrderItem{
+			{ProductID: testProduct.ID, Quantity: 2, Price: 12.5},
+		},
+	}
+	database.DB.Create(&testOrder)
+	otherOrder := shop_models.Order{UserID: 1, TotalCost: 10.0}
+	database.DB.Create(&otherOrder)
+
+	router := setupInvoiceRouter()
+
+	req, _ := http.NewRequest("GET", "/orders/"+strconv.Itoa(int(testOrder.ID))+"/invoice.pdf?user=1", nil)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "application/pdf", w.Header().Get("Content-Type"))
+	assert.True(t, bytes.HasPrefix(w.Body.Bytes(), []byte("%PDF")))
+
+	// Requesting the invoice again reuses the same invoice number
+	var first shop_models.Invoice
+	database.DB.Where("order_id = ?", testOrder.ID).First(&first)
+	req, _ = http.NewRequest("GET", "/orders/"+strconv.Itoa(int(testOrder.ID))+"/invoice.pdf?user=1", nil)
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	var count int64
+	database.DB.Model(&shop_models.Invoice{}).Where("order_id = ?", testOrder.ID).Count(&count)
+	assert.Equal(t, int64(1), count)
+
+	// A different order gets the next invoice number
+	req, _ = http.NewRequest("GET", "/orders/"+strconv.Itoa(int(otherOrder.ID))+"/invoice.pdf?user=1", nil)
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	var second shop_models.Invoice
+	database.DB.Where("order_id = ?", otherOrder.ID).First(&second)
+	assert.NotEqual(t, first.Number, second.Number)
+	assert.Greater(t, second.Number, first.Number)
+
+	// Missing orders return 404
+	req, _ = http.NewRequest("GET", "/orders/999999/invoice.pdf?user=1", nil)
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+
+	// Other customers cannot tell the order exists, admins can get it
+	invoiceURL := "/orders/" + strconv.Itoa(int(testOrder.ID)) + "/invoice.pdf"
+	req, _ = http.NewRequest("GET", invoiceURL+"?user=2", nil)
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+	assert.NoError(t, user_models.AddUserToSegment(database.DB, 3, user_models.SegmentAdmin))
+	req, _ = http.NewRequest("GET", invoiceURL+"?user=3", nil)
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+	req, _ = http.NewRequest("GET", invoiceURL, nil)
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+
+	// Clean up
+	database.DB.Delete(&first)
+	database.DB.Delete(&second)
+	database.DB.Delete(&testOrder)
+	database.DB.Delete(&otherOrder)
+	database.DB.Delete(&testProduct)
+}
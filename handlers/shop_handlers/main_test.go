@@ -0,0 +1,53 @@
+package shop_handlers_test
+
+import (
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"xy.com/mysite/handlers/shop_handlers"
+	"xy.com/mysite/models"
+)
+
+// sentMail is an email the handlers would have sent.
+type sentMail struct {
+	To          string
+	Subject     string
+	Body        string
+	Attachments []models.Attachment
+}
+
+var (
+	sentMailsMu sync.Mutex
+	sentMails   []sentMail
+)
+
+// TestMain keeps the handlers from sending real emails, recording them instead.
+func TestMain(m *testing.M) {
+	*shop_handlers.SendMail = func(to string, subject string, body string, attachments ...models.Attachment) error {
+		sentMailsMu.Lock()
+		defer sentMailsMu.Unlock()
+		sentMails = append(sentMails, sentMail{To: to, Subject: subject, Body: body, Attachments: attachments})
+		return nil
+	}
+	os.Exit(m.Run())
+}
+
+// waitForMail waits for the email with the subject, which is sent in the background.
+func waitForMail(t *testing.T, subject string) sentMail {
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		sentMailsMu.Lock()
+		for _, mail := range sentMails {
+			if mail.Subject == subject {
+				sentMailsMu.Unlock()
+				return mail
+			}
+		}
+		sentMailsMu.Unlock()
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("no email with subject %q was sent", subject)
+	return sentMail{}
+}
@@ -3,25 +3,48 @@ package shop_handlers
 import (
 	"net/http"
 	"strconv"
+	"xy.com/mysite/models/prize_models"
 	"xy.com/mysite/models/shop_models"
+	"xy.com/mysite/models/user_models"
 
 	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
 	"xy.com/mysite/database"
 )
 
-// CreateOrderHandler handles the creation of a new order.
+// CreateOrderHandler handles the creation of a new order for the authenticated user.
 func CreateOrderHandler(c *gin.Context) {
+	userID, ok := c.Get("userID")
+	if ok {
+		_, ok = userID.(uint)
+	}
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+		return
+	}
+
 	var order shop_models.Order
 	if err := c.ShouldBindJSON(&order); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		c.Error(err).SetType(gin.ErrorTypeBind)
 		return
 	}
-
-	if err := shop_models.CreateOrder(database.DB, &order); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	order.UserID = userID.(uint)
+
+	err := database.DB.Transaction(func(tx *gorm.DB) error {
+		if err := shop_models.CreateOrder(tx, &order); err != nil {
+			return err
+		}
+		_, err := prize_models.RecordEvent(tx, order.UserID, prize_models.EventOrderPlaced)
+		return err
+	})
+	if err != nil {
+		c.Error(err)
 		return
 	}
 
+	// Send the confirmation email with the invoice in the background
+	go sendOrderConfirmation(database.DB, order.ID)
+
 	c.JSON(http.StatusCreated, order)
 }
 
@@ -40,13 +63,13 @@ func GetAllOrdersHandler(c *gin.Context) {
 func GetOrderByIDHandler(c *gin.Context) {
 	id, err := strconv.Atoi(c.Param("id"))
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		c.Error(err).SetType(gin.ErrorTypeBind)
 		return
 	}
 
 	order, err := shop_models.GetOrderByID(database.DB, uint(id))
 	if err != nil {
-		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		c.Error(err)
 		return
 	}
 
@@ -57,52 +80,123 @@ func GetOrderByIDHandler(c *gin.Context) {
 func GetOrdersByUserIDHandler(c *gin.Context) {
 	userID, err := strconv.Atoi(c.Param("userID"))
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		c.Error(err).SetType(gin.ErrorTypeBind)
 		return
 	}
 
 	orders, err := shop_models.GetOrdersByUserID(database.DB, uint(userID))
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		c.Error(err)
 		return
 	}
 
 	c.JSON(http.StatusOK, orders)
 }
 
-// UpdateOrderHandler handles updating an order.
+// UpdateOrderHandler handles updating an order. Only the customer who placed
+// the order and admins can update it; anyone else is told the order does not exist.
 func UpdateOrderHandler(c *gin.Context) {
+	userID, ok := c.Get("userID")
+	if ok {
+		_, ok = userID.(uint)
+	}
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+		return
+	}
 	id, err := strconv.Atoi(c.Param("id"))
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		c.Error(err).SetType(gin.ErrorTypeBind)
 		return
 	}
 
 	var order shop_models.Order
 	if err := c.ShouldBindJSON(&order); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		c.Error(err).SetType(gin.ErrorTypeBind)
+		return
+	}
+
+	existing, err := shop_models.GetOrderByID(database.DB, uint(id))
+	if err != nil {
+		c.Error(err)
 		return
 	}
+	if existing.UserID != userID.(uint) {
+		admin, err := user_models.IsAdmin(database.DB, userID.(uint))
+		if err != nil {
+			c.Error(err)
+			return
+		}
+		if !admin {
+			c.Error(shop_models.ErrOrderNotFound)
+			return
+		}
+	}
 
-	order.ID = uint(id)
+	order.ID = existing.ID
+	order.UserID = existing.UserID
 	if err := shop_models.UpdateOrder(database.DB, &order); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		c.Error(err)
 		return
 	}
 
 	c.JSON(http.StatusOK, order)
 }
 
+// UpdateOrderStatusHandler handles changing the status of an order.
+func UpdateOrderStatusHandler(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.Error(err).SetType(gin.ErrorTypeBind)
+		return
+	}
+
+	var req struct {
+		Status string `json:"status" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.Error(err).SetType(gin.ErrorTypeBind)
+		return
+	}
+
+	if !shop_models.ValidOrderStatus(req.Status) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid order status"})
+		return
+	}
+
+	err = database.DB.Transaction(func(tx *gorm.DB) error {
+		order, err := shop_models.GetOrderByID(tx, uint(id))
+		if err != nil {
+			return err
+		}
+		if err := shop_models.UpdateOrderStatus(tx, order.ID, req.Status); err != nil {
+			return err
+		}
+		// Paying for an order unlocks the first order achievement and
+		// qualifies the customer's referral, if they have one
+		if !shop_models.IsPaidOrderStatus(order.Status) && shop_models.IsPaidOrderStatus(req.Status) {
+			_, err = prize_models.RecordEvent(tx, order.UserID, prize_models.EventOrderPaid)
+		}
+		return err
+	})
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Order status updated"})
+}
+
 // DeleteOrderHandler handles deleting an order.
 func DeleteOrderHandler(c *gin.Context) {
 	id, err := strconv.Atoi(c.Param("id"))
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		c.Error(err).SetType(gin.ErrorTypeBind)
 		return
 	}
 
 	if err := shop_models.DeleteOrder(database.DB, uint(id)); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		c.Error(err)
 		return
 	}
 
@@ -113,13 +207,13 @@ func DeleteOrderHandler(c *gin.Context) {
 func GetOrderItemsByOrderIDHandler(c *gin.Context) {
 	orderID, err := strconv.Atoi(c.Param("orderID"))
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		c.Error(err).SetType(gin.ErrorTypeBind)
 		return
 	}
 
 	orderItems, err := shop_models.GetOrderItemsByOrderID(database.DB, uint(orderID))
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		c.Error(err)
 		return
 	}
 
@@ -8,10 +8,14 @@ import (
 	"net/http"
 	"net/http/httptest"
 	"strconv"
+	"strings"
 	"testing"
 	"xy.com/mysite/database"
 	"xy.com/mysite/handlers/shop_handlers"
+	"xy.com/mysite/middleware"
+	"xy.com/mysite/models/prize_models"
 	"xy.com/mysite/models/shop_models"
+	"xy.com/mysite/models/user_models"
 )
 
 func setupTestData() {
@@ -20,7 +24,14 @@ func setupTestData() {
 
 func setupRouter() *gin.Engine {
 	router := gin.Default()
-	orderGroup := router.Group("/orders")
+	router.Use(middleware.ErrorHandler())
+	// Stands in for AuthMiddleware, the user ID comes from the query
+	orderGroup := router.Group("/orders", func(c *gin.Context) {
+		if id, err := strconv.Atoi(c.Query("user")); err == nil {
+			c.Set("userID", uint(id))
+		}
+		c.Next()
+	})
 	{
 		orderGroup.POST("/", shop_handlers.CreateOrderHandler)
 		orderGroup.GET("/getall", shop_handlers.GetAllOrdersHandler)
@@ -30,33 +41,49 @@ func setupRouter() *gin.Engine {
 		orderGroup.DELETE("/:id", shop_handlers.DeleteOrderHandler)
 		orderGroup.GET("/items/:orderID", shop_handlers.GetOrderItemsByOrderIDHandler)
 	}
+	router.PUT("/admin/orders/:id/status", shop_handlers.UpdateOrderStatusHandler)
 
 	return router
 }
 
 func TestCreateOrderHandler(t *testing.T) {
 	setupTestData()
+	customer := &user_models.User{Username: "customer", Email: "customer@example.com", Password: "password"}
+	assert.NoError(t, user_models.CreateUser(database.DB, customer))
 
+	// The order is the authenticated user's whatever the body says
 	newOrder := shop_models.Order{
-		UserID:    1,
+		UserID:    customer.ID + 1,
 		TotalCost: 100.0,
 	}
 
 	orderJson, _ := json.Marshal(newOrder)
-	body := bytes.NewReader(orderJson)
+	router := setupRouter()
 
-	req, _ := http.NewRequest("POST", "/orders/", body)
+	req, _ := http.NewRequest("POST", "/orders/", bytes.NewReader(orderJson))
 	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
 
-	router := setupRouter()
+	req, _ = http.NewRequest("POST", "/orders/?user="+strconv.Itoa(int(customer.ID)), bytes.NewReader(orderJson))
+	w = httptest.NewRecorder()
 	router.ServeHTTP(w, req)
 
 	assert.Equal(t, http.StatusCreated, w.Code)
 	var createdOrder shop_models.Order
 	json.Unmarshal(w.Body.Bytes(), &createdOrder)
-	assert.Equal(t, newOrder.UserID, createdOrder.UserID)
+	assert.Equal(t, customer.ID, createdOrder.UserID)
 	assert.Equal(t, newOrder.TotalCost, createdOrder.TotalCost)
 
+	// The customer is emailed the invoice
+	mail := waitForMail(t, "Order confirmation #"+strconv.Itoa(int(createdOrder.ID)))
+	assert.Equal(t, "customer@example.com", mail.To)
+	if assert.Len(t, mail.Attachments, 1) {
+		assert.Equal(t, "application/pdf", mail.Attachments[0].ContentType)
+		assert.True(t, bytes.HasPrefix(mail.Attachments[0].Data, []byte("%PDF")))
+		assert.Contains(t, mail.Body, strings.TrimSuffix(mail.Attachments[0].Filename, ".pdf"))
+	}
+
 	// Clean up
 	database.DB.Delete(&createdOrder)
 }
@@ -168,7 +195,7 @@ func TestUpdateOrderHandler(t *testing.T) {
 	orderJson, _ := json.Marshal(updatedOrder)
 	body := bytes.NewReader(orderJson)
 
-	req, _ := http.NewRequest("PUT", "/orders/"+strconv.Itoa(int(testOrder.ID)), body)
+	req, _ := http.NewRequest("PUT", "/orders/"+strconv.Itoa(int(testOrder.ID))+"?user=1", body)
 	w := httptest.NewRecorder()
 
 	router := setupRouter()
@@ -179,6 +206,23 @@ func TestUpdateOrderHandler(t *testing.T) {
 	json.Unmarshal(w.Body.Bytes(), &fetchedOrder)
 	assert.Equal(t, updatedOrder.TotalCost, fetchedOrder.TotalCost)
 
+	// Other users can neither update the order nor take it over
+	stolen, _ := json.Marshal(shop_models.Order{UserID: 2, TotalCost: 300.0})
+	req, _ = http.NewRequest("PUT", "/orders/"+strconv.Itoa(int(testOrder.ID))+"?user=2", bytes.NewReader(stolen))
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+
+	// Nor can the customer give it away
+	req, _ = http.NewRequest("PUT", "/orders/"+strconv.Itoa(int(testOrder.ID))+"?user=1", bytes.NewReader(stolen))
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+	order, err := shop_models.GetOrderByID(database.DB, testOrder.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, uint(1), order.UserID)
+	assert.Equal(t, 300.0, order.TotalCost)
+
 	// Clean up
 	database.DB.Delete(&fetchedOrder)
 }
@@ -236,3 +280,42 @@ func TestGetOrderItemsByOrderIDHandler(t *testing.T) {
 	database.DB.Delete(&testOrderItem1)
 	database.DB.Delete(&testOrderItem2)
 }
+
+func TestPayingForOrderQualifiesReferral(t *testing.T) {
+	setupTestData()
+	referrer := &user_models.User{Username: "referrer", Email: "referrer@example.com", Password: "password"}
+	assert.NoError(t, user_models.SignUp(database.DB, referrer, user_models.SignupReferral{IP: "10.0.0.1"}))
+	referee := &user_models.User{Username: "referee", Email: "referee@example.com", Password: "password"}
+	assert.NoError(t, user_models.SignUp(database.DB, referee, user_models.SignupReferral{Code: referrer.ReferralCode, IP: "10.0.0.2"}))
+	points := func(userID uint) int {
+		ps, err := prize_models.GetPointsSystem(database.DB, userID)
+		assert.NoError(t, err)
+		return ps.Points
+	}
+	router := setupRouter()
+	send := func(method, path, body string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	// Placing an order is not enough
+	w := send("POST", "/orders/?user="+strconv.Itoa(int(referee.ID)), `{"total_cost":10}`)
+	assert.Equal(t, http.StatusCreated, w.Code)
+	var order shop_models.Order
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &order))
+	waitForMail(t, "Order confirmation #"+strconv.Itoa(int(order.ID)))
+	assert.Equal(t, 0, points(referrer.ID))
+	assert.Equal(t, 0, points(referee.ID))
+
+	// Paying for it rewards both parties, once
+	statusPath := "/admin/orders/" + strconv.Itoa(int(order.ID)) + "/status"
+	for _, status := range []string{shop_models.OrderStatusPaid, shop_models.OrderStatusShipped, shop_models.OrderStatusDelivered} {
+		assert.Equal(t, http.StatusOK, send("PUT", statusPath, `{"status":"`+status+`"}`).Code)
+	}
+	assert.Equal(t, prize_models.ReferrerReward, points(referrer.ID))
+	assert.Equal(t, prize_models.RefereeReward+50, points(referee.ID)) // And the first order achievement
+	assert.Equal(t, http.StatusNotFound, send("PUT", "/admin/orders/999/status", `{"status":"paid"}`).Code)
+}
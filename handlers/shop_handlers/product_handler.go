@@ -13,12 +13,12 @@ import (
 func CreateProductHandler(c *gin.Context) {
 	var product shop_models.Product
 	if err := c.ShouldBindJSON(&product); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		c.Error(err).SetType(gin.ErrorTypeBind)
 		return
 	}
 
 	if err := shop_models.CreateProduct(database.DB, &product); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		c.Error(err)
 		return
 	}
 
@@ -29,24 +29,24 @@ func CreateProductHandler(c *gin.Context) {
 func GetProductHandlerByID(c *gin.Context) {
 	id, err := strconv.Atoi(c.Param("id"))
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		c.Error(err).SetType(gin.ErrorTypeBind)
 		return
 	}
 
 	product, err := shop_models.GetProductByID(database.DB, uint(id))
 	if err != nil {
-		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		c.Error(err)
 		return
 	}
 
 	c.JSON(http.StatusOK, product)
 }
 
-// GetAllProductsHandler handles fetching all products.
+// GetAllProductsHandler handles fetching all products with their average rating and review count.
 func GetAllProductsHandler(c *gin.Context) {
-	products, err := shop_models.GetAllProducts(database.DB)
+	products, err := shop_models.GetAllProductsWithRatings(database.DB)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		c.Error(err)
 		return
 	}
 
@@ -57,19 +57,19 @@ func GetAllProductsHandler(c *gin.Context) {
 func UpdateProductHandler(c *gin.Context) {
 	id, err := strconv.Atoi(c.Param("id"))
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		c.Error(err).SetType(gin.ErrorTypeBind)
 		return
 	}
 
 	var product shop_models.Product
 	if err := c.ShouldBindJSON(&product); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		c.Error(err).SetType(gin.ErrorTypeBind)
 		return
 	}
 
 	product.ID = uint(id)
 	if err := shop_models.UpdateProduct(database.DB, &product); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		c.Error(err)
 		return
 	}
 
@@ -80,12 +80,12 @@ func UpdateProductHandler(c *gin.Context) {
 func DeleteProductHandler(c *gin.Context) {
 	id, err := strconv.Atoi(c.Param("id"))
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		c.Error(err).SetType(gin.ErrorTypeBind)
 		return
 	}
 
 	if err := shop_models.DeleteProduct(database.DB, uint(id)); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		c.Error(err)
 		return
 	}
 
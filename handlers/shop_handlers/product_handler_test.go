@@ -11,11 +11,13 @@ import (
 	"testing"
 	"xy.com/mysite/database"
 	"xy.com/mysite/handlers/shop_handlers"
+	"xy.com/mysite/middleware"
 	"xy.com/mysite/models/shop_models"
 )
 
 func setupProductRouter() *gin.Engine {
 	router := gin.Default()
+	router.Use(middleware.ErrorHandler())
 	productGroup := router.Group("/products")
 	{
 		productGroup.POST("/", shop_handlers.CreateProductHandler)
@@ -0,0 +1,100 @@
+package shop_handlers
+
+import (
+	"net/http"
+	"strconv"
+	"xy.com/mysite/models/shop_models"
+
+	"github.com/gin-gonic/gin"
+	"xy.com/mysite/database"
+)
+
+// CreateReviewHandler handles a user reviewing a product.
+func CreateReviewHandler(c *gin.Context) {
+	userID, ok := getUserID(c)
+	if !ok {
+		return
+	}
+
+	productID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.Error(err).SetType(gin.ErrorTypeBind)
+		return
+	}
+
+	var req struct {
+		Rating int    `json:"rating"`
+		Text   string `json:"text"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.Error(err).SetType(gin.ErrorTypeBind)
+		return
+	}
+
+	review := shop_models.Review{
+		ProductID: uint(productID),
+		UserID:    userID,
+		Rating:    req.Rating,
+		Text:      req.Text,
+	}
+	if err := shop_models.CreateReview(database.DB, &review); err != nil {
+		c.Error(err)
+		return
+	}
+
+	c.JSON(http.StatusCreated, review)
+}
+
+// GetProductReviewsHandler handles fetching the approved reviews of a product.
+func GetProductReviewsHandler(c *gin.Context) {
+	productID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.Error(err).SetType(gin.ErrorTypeBind)
+		return
+	}
+
+	reviews, err := shop_models.GetApprovedReviewsByProductID(database.DB, uint(productID))
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	c.JSON(http.StatusOK, reviews)
+}
+
+// GetReviewsForModerationHandler handles listing reviews by moderation state (pending by default).
+func GetReviewsForModerationHandler(c *gin.Context) {
+	status := c.DefaultQuery("status", shop_models.ReviewStatusPending)
+
+	reviews, err := shop_models.GetReviewsByStatus(database.DB, status)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	c.JSON(http.StatusOK, reviews)
+}
+
+// ModerateReviewHandler handles approving or rejecting a review.
+func ModerateReviewHandler(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.Error(err).SetType(gin.ErrorTypeBind)
+		return
+	}
+
+	var req struct {
+		Status string `json:"status" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.Error(err).SetType(gin.ErrorTypeBind)
+		return
+	}
+
+	if err := shop_models.ModerateReview(database.DB, uint(id), req.Status); err != nil {
+		c.Error(err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Review updated"})
+}
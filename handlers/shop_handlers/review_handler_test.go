@@ -0,0 +1,146 @@
+package shop_handlers_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"xy.com/mysite/database"
+	"xy.com/mysite/handlers/shop_handlers"
+	"xy.com/mysite/middleware"
+	"xy.com/mysite/models/shop_models"
+)
+
+func setupReviewRouter(userID uint) *gin.Engine {
+	router := gin.Default()
+	router.Use(middleware.ErrorHandler())
+	productGroup := router.Group("/products", withUser(userID))
+	{
+		productGroup.GET("/all", shop_handlers.GetAllProductsHandler)
+		productGroup.GET("/:id/reviews", shop_handlers.GetProductReviewsHandler)
+		productGroup.POST("/:id/reviews", shop_handlers.CreateReviewHandler)
+	}
+	adminGroup := router.Group("/admin")
+	{
+		adminGroup.PUT("/orders/:id/status", shop_handlers.UpdateOrderStatusHandler)
+		adminGroup.GET("/reviews", shop_handlers.GetReviewsForModerationHandler)
+		adminGroup.PUT("/reviews/:id", shop_handlers.ModerateReviewHandler)
+	}
+	return router
+}
+
+func postReview(router *gin.Engine, productID uint, rating int) *httptest.ResponseRecorder {
+	body, _ := json.Marshal(map[string]interface{}{"rating": rating, "text": "nice"})
+	req, _ := http.NewRequest("POST", "/products/"+strconv.Itoa(int(productID))+"/reviews", bytes.NewReader(body))
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	return w
+}
+
+func putJSON(router *gin.Engine, path string, payload interface{}) *httptest.ResponseRecorder {
+	body, _ := json.Marshal(payload)
+	req, _ := http.NewRequest("PUT", path, bytes.NewReader(body))
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	return w
+}
+
+func TestReviewHandlers(t *testing.T) {
+	setupTestData()
+
+	userID := uint(77)
+	product := &shop_models.Product{Name: "reviewed", Price: 5.0}
+	database.DB.Create(product)
+	order := &shop_models.Order{
+		UserID:     userID,
+		TotalCost:  5.0,
+		OrderItems: []shop_models.OrderItem{{ProductID: product.ID, Quantity: 1, Price: 5.0}},
+	}
+	shop_models.CreateOrder(database.DB, order)
+
+	router := setupReviewRouter(userID)
+
+	// Not delivered yet
+	w := postReview(router, product.ID, 5)
+	assert.Equal(t, http.StatusForbidden, w.Code)
+
+	w = putJSON(router, "/admin/orders/"+strconv.Itoa(int(order.ID))+"/status", gin.H{"status": "delivered"})
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	// Out of range rating
+	w = postReview(router, product.ID, 6)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	w = postReview(router, product.ID, 4)
+	assert.Equal(t, http.StatusCreated, w.Code)
+	var review shop_models.Review
+	json.Unmarshal(w.Body.Bytes(), &review)
+	assert.Equal(t, shop_models.ReviewStatusPending, review.Status)
+
+	// Only one review per product
+	w = postReview(router, product.ID, 3)
+	assert.Equal(t, http.StatusConflict, w.Code)
+
+	// Other users without a delivered order cannot review
+	w = postReview(setupReviewRouter(userID+1), product.ID, 1)
+	assert.Equal(t, http.StatusForbidden, w.Code)
+
+	// Pending reviews are not public
+	req, _ := http.NewRequest("GET", "/products/"+strconv.Itoa(int(product.ID))+"/reviews", nil)
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	var reviews []shop_models.Review
+	json.Unmarshal(w.Body.Bytes(), &reviews)
+	assert.Equal(t, 0, len(reviews))
+
+	// Moderation queue
+	req, _ = http.NewRequest("GET", "/admin/reviews", nil)
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	reviews = nil
+	json.Unmarshal(w.Body.Bytes(), &reviews)
+	found := false
+	for _, r := range reviews {
+		if r.ID == review.ID {
+			found = true
+		}
+	}
+	assert.True(t, found)
+
+	w = putJSON(router, "/admin/reviews/"+strconv.Itoa(int(review.ID)), gin.H{"status": "bogus"})
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	w = putJSON(router, "/admin/reviews/"+strconv.Itoa(int(review.ID)), gin.H{"status": "approved"})
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	req, _ = http.NewRequest("GET", "/products/"+strconv.Itoa(int(product.ID))+"/reviews", nil)
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	reviews = nil
+	json.Unmarshal(w.Body.Bytes(), &reviews)
+	assert.Equal(t, 1, len(reviews))
+
+	// The listing exposes the rating summary
+	req, _ = http.NewRequest("GET", "/products/all", nil)
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	var listing []shop_models.ProductWithRating
+	json.Unmarshal(w.Body.Bytes(), &listing)
+	found = false
+	for _, p := range listing {
+		if p.ID == product.ID {
+			found = true
+			assert.Equal(t, 4.0, p.AverageRating)
+			assert.Equal(t, int64(1), p.ReviewCount)
+		}
+	}
+	assert.True(t, found)
+
+	// Clean up
+	database.DB.Unscoped().Delete(&review)
+	database.DB.Delete(order)
+	database.DB.Delete(product)
+}
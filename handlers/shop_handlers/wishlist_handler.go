@@ -0,0 +1,86 @@
+package shop_handlers
+
+import (
+	"net/http"
+	"strconv"
+	"xy.com/mysite/models/shop_models"
+
+	"github.com/gin-gonic/gin"
+	"xy.com/mysite/database"
+)
+
+func getUserID(c *gin.Context) (uint, bool) {
+	// Get the userID from the Gin context
+	userID, exists := c.Get("userID")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+		return 0, false
+	}
+
+	// Ensure the userID is of type uint before returning it
+	userIDUint, ok := userID.(uint)
+	if !ok {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "userID is not of type uint"})
+		return 0, false
+	}
+
+	return userIDUint, true
+}
+
+// GetWishlistHandler handles fetching the current user's wishlist.
+func GetWishlistHandler(c *gin.Context) {
+	userID, ok := getUserID(c)
+	if !ok {
+		return
+	}
+
+	items, err := shop_models.GetWishlistByUserID(database.DB, userID)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	c.JSON(http.StatusOK, items)
+}
+
+// AddToWishlistHandler handles saving a product to the current user's wishlist.
+func AddToWishlistHandler(c *gin.Context) {
+	userID, ok := getUserID(c)
+	if !ok {
+		return
+	}
+
+	productID, err := strconv.Atoi(c.Param("productID"))
+	if err != nil {
+		c.Error(err).SetType(gin.ErrorTypeBind)
+		return
+	}
+
+	if err := shop_models.AddToWishlist(database.DB, userID, uint(productID)); err != nil {
+		c.Error(err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Product added to wishlist"})
+}
+
+// RemoveFromWishlistHandler handles removing a product from the current user's wishlist.
+func RemoveFromWishlistHandler(c *gin.Context) {
+	userID, ok := getUserID(c)
+	if !ok {
+		return
+	}
+
+	productID, err := strconv.Atoi(c.Param("productID"))
+	if err != nil {
+		c.Error(err).SetType(gin.ErrorTypeBind)
+		return
+	}
+
+	if err := shop_models.RemoveFromWishlist(database.DB, userID, uint(productID)); err != nil {
+		c.Error(err)
+		return
+	}
+
+	c.Status(http.StatusOK)
+}
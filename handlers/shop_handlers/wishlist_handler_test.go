@@ -0,0 +1,92 @@
+package shop_handlers_test
+
+import (
+	"encoding/json"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"xy.com/mysite/database"
+	"xy.com/mysite/handlers/shop_handlers"
+	"xy.com/mysite/middleware"
+	"xy.com/mysite/models/shop_models"
+)
+
+// withUser sets the authenticated user ID the way AuthMiddleware does.
+func withUser(userID uint) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Set("userID", userID)
+		c.Next()
+	}
+}
+
+func setupWishlistRouter(userID uint) *gin.Engine {
+	router := gin.Default()
+	router.Use(middleware.ErrorHandler())
+	wishlistGroup := router.Group("/wishlist", withUser(userID))
+	{
+		wishlistGroup.GET("/", shop_handlers.GetWishlistHandler)
+		wishlistGroup.POST("/:productID", shop_handlers.AddToWishlistHandler)
+		wishlistGroup.DELETE("/:productID", shop_handlers.RemoveFromWishlistHandler)
+	}
+	return router
+}
+
+func TestWishlistHandlers(t *testing.T) {
+	setupTestData()
+
+	product := &shop_models.Product{Name: "wished", Price: 9.0}
+	database.DB.Create(product)
+	productPath := "/wishlist/" + strconv.Itoa(int(product.ID))
+
+	router := setupWishlistRouter(42)
+
+	// Adding twice keeps a single entry
+	for i := 0; i < 2; i++ {
+		req, _ := http.NewRequest("POST", productPath, nil)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		assert.Equal(t, http.StatusOK, w.Code)
+	}
+
+	req, _ := http.NewRequest("GET", "/wishlist/", nil)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	var items []shop_models.WishlistItem
+	json.Unmarshal(w.Body.Bytes(), &items)
+	assert.Equal(t, 1, len(items))
+	assert.Equal(t, "wished", items[0].Product.Name)
+
+	// Other users have their own wishlist
+	req, _ = http.NewRequest("GET", "/wishlist/", nil)
+	w = httptest.NewRecorder()
+	setupWishlistRouter(43).ServeHTTP(w, req)
+	items = nil
+	json.Unmarshal(w.Body.Bytes(), &items)
+	assert.Equal(t, 0, len(items))
+
+	// Unknown products cannot be added
+	req, _ = http.NewRequest("POST", "/wishlist/999999", nil)
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+
+	// Removing and adding again works
+	req, _ = http.NewRequest("DELETE", productPath, nil)
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	req, _ = http.NewRequest("POST", productPath, nil)
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	// Clean up
+	shop_models.RemoveFromWishlist(database.DB, 42, product.ID)
+	database.DB.Delete(product)
+}
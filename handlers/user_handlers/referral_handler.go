@@ -0,0 +1,34 @@
+package user_handlers
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"xy.com/mysite/database"
+	"xy.com/mysite/models/user_models"
+)
+
+// GetReferralsHandler handles fetching the user's referral code and the users they referred.
+func GetReferralsHandler(c *gin.Context) {
+	userID, ok := c.Get("userID")
+	if ok {
+		_, ok = userID.(uint)
+	}
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	user, err := user_models.GetUserByID(database.DB, userID.(uint))
+	if err != nil {
+		c.Error(err)
+		return
+	}
+	referrals, err := user_models.GetReferralsByReferrer(database.DB, user.ID)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"referral_code": user.ReferralCode, "referrals": referrals})
+}
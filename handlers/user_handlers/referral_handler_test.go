@@ -0,0 +1,71 @@
+package user_handlers_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"xy.com/mysite/database"
+	"xy.com/mysite/handlers/user_handlers"
+	"xy.com/mysite/middleware"
+	"xy.com/mysite/models/user_models"
+)
+
+func TestReferralHandlers(t *testing.T) {
+	database.InitDB()
+	router := gin.Default()
+	router.Use(middleware.ErrorHandler())
+	router.POST("/auth/signup", user_handlers.CreateUserHandler)
+	router.GET("/referrals", func(c *gin.Context) {
+		c.Set("userID", uint(1))
+		c.Next()
+	}, user_handlers.GetReferralsHandler)
+
+	signUp := func(body string, ip string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest("POST", "/auth/signup", strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		req.RemoteAddr = ip + ":1234"
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	w := signUp(`{"username":"referrer","email":"referrer@example.com","password":"password"}`, "10.0.0.1")
+	assert.Equal(t, http.StatusCreated, w.Code)
+	var referrer user_models.User
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &referrer))
+	assert.Equal(t, uint(1), referrer.ID)
+	assert.Len(t, referrer.ReferralCode, 8)
+
+	w = signUp(`{"username":"typo","email":"typo@example.com","password":"password","referral":"NOPE"}`, "10.0.0.2")
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Contains(t, w.Body.String(), "invalid_referral_code")
+
+	w = signUp(`{"username":"friend","email":"friend@example.com","password":"password","referral":"`+strings.ToLower(referrer.ReferralCode)+`","device_id":"d-2"}`, "10.0.0.2")
+	assert.Equal(t, http.StatusCreated, w.Code)
+	assert.NotContains(t, w.Body.String(), "d-2")
+
+	// Signing up from the referrer's IP still works, the referral is just not rewarded
+	w = signUp(`{"username":"sock","email":"sock@example.com","password":"password","referral":"`+referrer.ReferralCode+`"}`, "10.0.0.1")
+	assert.Equal(t, http.StatusCreated, w.Code)
+
+	req, _ := http.NewRequest("GET", "/referrals", nil)
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+	var response struct {
+		ReferralCode string                 `json:"referral_code"`
+		Referrals    []user_models.Referral `json:"referrals"`
+	}
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+	assert.Equal(t, referrer.ReferralCode, response.ReferralCode)
+	if assert.Len(t, response.Referrals, 2) {
+		assert.Equal(t, user_models.ReferralRejected, response.Referrals[0].Status)
+		assert.Equal(t, user_models.ReferralRejectSameIP, response.Referrals[0].RejectReason)
+		assert.Equal(t, user_models.ReferralPending, response.Referrals[1].Status)
+	}
+}
@@ -0,0 +1,59 @@
+package user_handlers
+
+import (
+	"net/http"
+	"strconv"
+	"xy.com/mysite/database"
+	"xy.com/mysite/models/user_models"
+
+	"github.com/gin-gonic/gin"
+)
+
+// AddUserToSegmentHandler handles adding a user to a segment.
+func AddUserToSegmentHandler(c *gin.Context) {
+	userID, err := strconv.Atoi(c.Param("userID"))
+	if err != nil {
+		c.Error(err).SetType(gin.ErrorTypeBind)
+		return
+	}
+
+	if err := user_models.AddUserToSegment(database.DB, uint(userID), c.Param("segment")); err != nil {
+		c.Error(err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "User added to segment"})
+}
+
+// RemoveUserFromSegmentHandler handles removing a user from a segment.
+func RemoveUserFromSegmentHandler(c *gin.Context) {
+	userID, err := strconv.Atoi(c.Param("userID"))
+	if err != nil {
+		c.Error(err).SetType(gin.ErrorTypeBind)
+		return
+	}
+
+	if err := user_models.RemoveUserFromSegment(database.DB, uint(userID), c.Param("segment")); err != nil {
+		c.Error(err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "User removed from segment"})
+}
+
+// GetUserSegmentsHandler handles fetching the segments a user is in.
+func GetUserSegmentsHandler(c *gin.Context) {
+	userID, err := strconv.Atoi(c.Param("userID"))
+	if err != nil {
+		c.Error(err).SetType(gin.ErrorTypeBind)
+		return
+	}
+
+	segments, err := user_models.GetUserSegments(database.DB, uint(userID))
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"segments": segments})
+}
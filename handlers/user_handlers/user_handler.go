@@ -17,16 +17,24 @@ type LoginInput struct {
 	Password string `json:"password" binding:"required"`
 }
 
-// CreateUserHandler handles the creation of a new user.
+// CreateUserHandler handles the creation of a new user, optionally referred by
+// another user's referral code. The device ID is whatever the client sends, so
+// the same device check only stops clients that do not bother to change it.
 func CreateUserHandler(c *gin.Context) {
-	var user user_models.User
-	if err := c.ShouldBindJSON(&user); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	var request struct {
+		user_models.User
+		Referral string `json:"referral"`
+		DeviceID string `json:"device_id"`
+	}
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.Error(err).SetType(gin.ErrorTypeBind)
 		return
 	}
 
-	if err := user_models.CreateUser(database.DB, &user); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	user := request.User
+	referral := user_models.SignupReferral{Code: request.Referral, IP: c.ClientIP(), DeviceID: request.DeviceID}
+	if err := user_models.SignUp(database.DB, &user, referral); err != nil {
+		c.Error(err)
 		return
 	}
 	c.JSON(http.StatusCreated, user)
@@ -36,13 +44,13 @@ func CreateUserHandler(c *gin.Context) {
 func GetUserHandler(c *gin.Context) {
 	id, err := strconv.Atoi(c.Param("id"))
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		c.Error(err).SetType(gin.ErrorTypeBind)
 		return
 	}
 
 	user, err := user_models.GetUserByID(database.DB, uint(id))
 	if err != nil {
-		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		c.Error(err)
 		return
 	}
 
@@ -59,7 +67,7 @@ func GetUserByEmailHandler(c *gin.Context) {
 
 	user, err := user_models.GetUserByEmail(database.DB, email)
 	if err != nil {
-		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		c.Error(err)
 		return
 	}
 
@@ -70,19 +78,19 @@ func GetUserByEmailHandler(c *gin.Context) {
 func UpdateUserHandler(c *gin.Context) {
 	id, err := strconv.Atoi(c.Param("id"))
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		c.Error(err).SetType(gin.ErrorTypeBind)
 		return
 	}
 
 	var user user_models.User
 	if err := c.ShouldBindJSON(&user); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		c.Error(err).SetType(gin.ErrorTypeBind)
 		return
 	}
 
 	user.ID = uint(id)
 	if err := user_models.UpdateUser(database.DB, &user); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		c.Error(err)
 		return
 	}
 
@@ -93,12 +101,12 @@ func UpdateUserHandler(c *gin.Context) {
 func DeleteUserHandler(c *gin.Context) {
 	id, err := strconv.Atoi(c.Param("id"))
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		c.Error(err).SetType(gin.ErrorTypeBind)
 		return
 	}
 
 	if err := user_models.DeleteUser(database.DB, uint(id)); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		c.Error(err)
 		return
 	}
 
@@ -109,12 +117,12 @@ func AuthenticateUserHandlers(c *gin.Context) {
 	var loginInput LoginInput
 	// Bind the request body to the loginInput struct
 	if err := c.ShouldBindJSON(&loginInput); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		c.Error(err).SetType(gin.ErrorTypeBind)
 		return
 	}
 	user, err := user_models.AuthenticateUser(database.DB, loginInput.Email, loginInput.Password)
 	if err != nil {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+		c.Error(err)
 		return
 	}
 
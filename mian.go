@@ -5,10 +5,15 @@ package main
 import (
 	"fmt"
 	"log"
+	"time"
 
+	"github.com/redis/go-redis/v9"
 	"xy.com/mysite/config"
 	"xy.com/mysite/database"
 	"xy.com/mysite/handlers"
+	"xy.com/mysite/models"
+	"xy.com/mysite/models/chat_models"
+	"xy.com/mysite/models/prize_models"
 	"xy.com/mysite/routes"
 )
 
@@ -16,14 +21,69 @@ func main() {
 	// Load configuration
 	config.LoadConfig()
 
+	// Points credited from now on expire after the configured period
+	prize_models.PointsExpiry = time.Duration(config.Instance.Points.ExpiryDays) * 24 * time.Hour
+
 	// Initialize the database connection
 	err := database.InitDB()
 	if err != nil {
 		log.Fatalf("Failed to initialize database: %v", err)
 	}
 
+	// Email low code pool alerts to the company address
+	if to := config.Instance.Company.Email; to != "" {
+		notifyLog := prize_models.NotifyCodePoolAlert
+		prize_models.NotifyCodePoolAlert = func(alert *prize_models.CodePoolAlert, prize *prize_models.Prize) {
+			notifyLog(alert, prize)
+			subject := fmt.Sprintf("Code pool for %s is running low", prize.PrizeName)
+			body := fmt.Sprintf("Only %d unused codes are left for prize %q (alert threshold %d).", alert.Remaining, prize.PrizeName, alert.Threshold)
+			go func() {
+				if err := models.SendEmailWithAttachments(to, subject, body); err != nil {
+					log.Printf("Failed to send code pool alert: %v", err)
+				}
+			}()
+		}
+	}
+
+	// Keep past weekly leaderboards fixed
+	go prize_models.RunLeaderboardSnapshots(database.DB, time.Hour, nil)
+
+	// Expire points past their expiry
+	go prize_models.RunPointsExpiry(database.DB, time.Hour, nil)
+
 	// Set up the Gin router
 	router := routes.SetupRouter()
+	if proxies := config.Instance.Server.TrustedProxies; len(proxies) > 0 {
+		if err := router.SetTrustedProxies(proxies); err != nil {
+			log.Fatalf("Invalid trusted proxies: %v", err)
+		}
+	}
+
+	// Share chat rooms with the other instances through Redis
+	if addr := config.Instance.Chat.RedisAddr; addr != "" {
+		client := redis.NewClient(&redis.Options{
+			Addr:     addr,
+			Password: config.Instance.Chat.RedisPassword,
+			DB:       config.Instance.Chat.RedisDB,
+		})
+		handlers.DefaultHub, err = handlers.NewHubWithPubSub(handlers.NewRedisPubSub(client))
+		if err != nil {
+			log.Fatalf("Failed to connect chat to Redis: %v", err)
+		}
+	}
+
+	// Moderate chat messages
+	if rate := config.Instance.Chat.MessageRate; rate > 0 {
+		handlers.DefaultHub.MessageRate = rate
+	}
+	if burst := config.Instance.Chat.MessageBurst; burst > 0 {
+		handlers.DefaultHub.MessageBurst = burst
+	}
+	if words := config.Instance.Chat.BlockedWords; len(words) > 0 {
+		filter := chat_models.NewWordFilter(words)
+		filter.Reject = config.Instance.Chat.RejectBlockedWords
+		handlers.DefaultHub.Filter = filter
+	}
 
 	// Start the HandleMessages goroutine for chat functionality
 	go handlers.HandleMessages() // new add
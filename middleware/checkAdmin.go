@@ -1 +1,41 @@
 package middleware
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"xy.com/mysite/database"
+	"xy.com/mysite/models"
+	"xy.com/mysite/models/user_models"
+)
+
+var ErrAdminOnly = models.NewError(models.KindForbidden, "admin_only", "admins only")
+
+// CheckAdmin only lets admins, the users in the admin segment, through. It must
+// run after AuthMiddleware.
+func CheckAdmin() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID, ok := c.Get("userID")
+		if ok {
+			_, ok = userID.(uint)
+		}
+		if !ok {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+			return
+		}
+
+		admin, err := user_models.IsAdmin(database.DB, userID.(uint))
+		if err != nil {
+			c.Error(err)
+			c.Abort()
+			return
+		}
+		if !admin {
+			c.Error(ErrAdminOnly)
+			c.Abort()
+			return
+		}
+
+		c.Next()
+	}
+}
@@ -0,0 +1,60 @@
+package middleware
+
+import (
+	"errors"
+	"log"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"xy.com/mysite/models"
+)
+
+// statusByKind maps the kinds of model errors to HTTP statuses.
+var statusByKind = map[models.ErrorKind]int{
+	models.KindNotFound:          http.StatusNotFound,
+	models.KindConflict:          http.StatusConflict,
+	models.KindInvalid:           http.StatusBadRequest,
+	models.KindForbidden:         http.StatusForbidden,
+	models.KindUnauthorized:      http.StatusUnauthorized,
+	models.KindInsufficientFunds: http.StatusBadRequest,
+	models.KindRateLimited:       http.StatusTooManyRequests,
+}
+
+// ErrorResponse is the body of every error response written by ErrorHandler.
+type ErrorResponse struct {
+	Error string `json:"error"`
+	Code  string `json:"code"`
+}
+
+// ErrorHandler writes the response for the last error a handler attached with
+// c.Error, unless the handler has already written one. Model errors are mapped
+// by their kind and keep their code; binding errors are bad requests; anything
+// else is logged and reported as an internal error without its details.
+func ErrorHandler() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Next()
+
+		if len(c.Errors) == 0 || c.Writer.Written() {
+			return
+		}
+		status, body := errorResponse(c.Errors.Last())
+		c.AbortWithStatusJSON(status, body)
+	}
+}
+
+// errorResponse maps an error to its status and response body.
+func errorResponse(err *gin.Error) (int, ErrorResponse) {
+	var modelErr *models.Error
+	if errors.As(err.Err, &modelErr) {
+		if status, ok := statusByKind[modelErr.Kind]; ok {
+			return status, ErrorResponse{Error: err.Error(), Code: modelErr.Code}
+		}
+	}
+
+	if err.IsType(gin.ErrorTypeBind) {
+		return http.StatusBadRequest, ErrorResponse{Error: err.Error(), Code: "invalid_request"}
+	}
+
+	log.Printf("internal error: %v", err.Err)
+	return http.StatusInternalServerError, ErrorResponse{Error: "Internal server error", Code: "internal"}
+}
@@ -6,12 +6,18 @@ import (
 
 	"github.com/dgrijalva/jwt-go"
 	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
 )
 
 // AuthMiddleware checks if the request has a valid JWT token in the Authorization header.
+// Browsers cannot set headers on WebSocket handshakes, so those may pass the token
+// in the token query parameter instead.
 func AuthMiddleware() gin.HandlerFunc {
 	return func(c *gin.Context) {
 		authHeader := c.GetHeader("Authorization")
+		if authHeader == "" && websocket.IsWebSocketUpgrade(c.Request) && c.Query("token") != "" {
+			authHeader = "Bearer " + c.Query("token")
+		}
 		if authHeader == "" {
 			c.JSON(http.StatusUnauthorized, gin.H{"error": "Authorization header is missing"})
 			c.Abort()
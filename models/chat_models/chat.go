@@ -0,0 +1,114 @@
+package chat_models
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"gorm.io/gorm"
+	"xy.com/mysite/models"
+)
+
+const (
+	MaxMessageLength   = 2000 // Characters
+	DefaultHistorySize = 50
+	MaxHistorySize     = 100
+)
+
+var (
+	ErrInvalidRoom      = models.NewError(models.KindInvalid, "invalid_room", "invalid room name")
+	ErrInvalidMessage   = models.NewError(models.KindInvalid, "invalid_message", "invalid message")
+	ErrNotInRoom        = models.NewError(models.KindForbidden, "not_in_room", "join the room first")
+	ErrInvalidAction    = models.NewError(models.KindInvalid, "invalid_action", "invalid chat action")
+	ErrInvalidRecipient = models.NewError(models.KindInvalid, "invalid_recipient", "invalid recipient")
+	ErrMessageNotFound  = models.NewError(models.KindNotFound, "message_not_found", "message not found")
+	ErrRateLimited      = models.NewError(models.KindRateLimited, "chat_rate_limited", "slow down")
+)
+
+// Room names are short lowercase slugs, e.g. "general" or "prize-talk".
+var roomPattern = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]{0,63}$`)
+
+// ChatMessage is a message posted to a chat room, or sent directly to another
+// user. The sender is always the authenticated user, never what the client claims.
+type ChatMessage struct {
+	ID          uint      `json:"id" gorm:"primaryKey"`
+	CreatedAt   time.Time `json:"created_at"`
+	Room        string    `json:"room" gorm:"size:64;not null;index"` // Room name, or DirectRoom of a direct message; paged by ID
+	UserID      uint      `json:"user_id" gorm:"not null;index"`
+	RecipientID uint      `json:"recipient_id,omitempty" gorm:"index"` // Direct messages only
+	Username    string    `json:"username" gorm:"size:255"`
+	Body        string    `json:"body" gorm:"type:text;not null"`
+
+	DeletedAt gorm.DeletedAt `json:"-" gorm:"index"` // Set when a moderator deletes the message
+}
+
+// ValidateRoom checks a room name.
+func ValidateRoom(room string) error {
+	if !roomPattern.MatchString(room) {
+		return fmt.Errorf("%w %q", ErrInvalidRoom, room)
+	}
+	return nil
+}
+
+// CreateChatMessage validates and stores a message. Direct messages, those with
+// a recipient, are stored in the DirectRoom of the sender and the recipient.
+func CreateChatMessage(db *gorm.DB, message *ChatMessage) error {
+	if message.RecipientID != 0 {
+		if message.RecipientID == message.UserID {
+			return fmt.Errorf("%w: cannot message yourself", ErrInvalidRecipient)
+		}
+		message.Room = DirectRoom(message.UserID, message.RecipientID)
+	} else if err := ValidateRoom(message.Room); err != nil {
+		return err
+	}
+	message.Body = strings.TrimSpace(message.Body)
+	if message.Body == "" {
+		return fmt.Errorf("%w: message is empty", ErrInvalidMessage)
+	}
+	if utf8.RuneCountInString(message.Body) > MaxMessageLength {
+		return fmt.Errorf("%w: message is longer than %d characters", ErrInvalidMessage, MaxMessageLength)
+	}
+
+	message.ID = 0
+	if err := db.Create(message).Error; err != nil {
+		return fmt.Errorf("failed to save chat message: %w", err)
+	}
+	return nil
+}
+
+// GetRoomHistory retrieves up to limit of the latest messages of a room, oldest
+// first. Pages go back in time: pass the ID of the oldest message received as
+// before to get the page preceding it, or zero for the latest messages. Unlike
+// offsets, IDs stay put while new messages keep arriving.
+func GetRoomHistory(db *gorm.DB, room string, before uint, limit int) ([]ChatMessage, error) {
+	if err := ValidateRoom(room); err != nil {
+		return nil, err
+	}
+	return getHistory(db, room, before, limit)
+}
+
+// GetDirectHistory retrieves the direct messages between two users like GetRoomHistory.
+func GetDirectHistory(db *gorm.DB, userID, otherID uint, before uint, limit int) ([]ChatMessage, error) {
+	return getHistory(db, DirectRoom(userID, otherID), before, limit)
+}
+
+func getHistory(db *gorm.DB, room string, before uint, limit int) ([]ChatMessage, error) {
+	if limit < 1 || limit > MaxHistorySize {
+		limit = DefaultHistorySize
+	}
+
+	query := db.Where("room = ?", room)
+	if before > 0 {
+		query = query.Where("id < ?", before)
+	}
+	var messages []ChatMessage
+	if err := query.Order("id desc").Limit(limit).Find(&messages).Error; err != nil {
+		return nil, err
+	}
+	for i, j := 0, len(messages)-1; i < j; i, j = i+1, j-1 {
+		messages[i], messages[j] = messages[j], messages[i]
+	}
+	return messages, nil
+}
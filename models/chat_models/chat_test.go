@@ -0,0 +1,67 @@
+package chat_models_test
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+	"xy.com/mysite/models/chat_models"
+)
+
+func setupChatDB(t *testing.T) *gorm.DB {
+	db, err := gorm.Open(sqlite.Open(filepath.Join(t.TempDir(), "chat.db")), &gorm.Config{
+		Logger: logger.Default.LogMode(logger.Silent),
+	})
+	if err != nil {
+		t.Fatalf("failed to connect to test database: %v", err)
+	}
+	if err := db.AutoMigrate(&chat_models.ChatMessage{}, &chat_models.ChatReadReceipt{}, &chat_models.ChatSanction{}, &chat_models.ChatModerationLog{}); err != nil {
+		t.Fatal(err)
+	}
+	return db
+}
+
+func TestChatMessages(t *testing.T) {
+	db := setupChatDB(t)
+
+	for _, room := range []string{"", "General", "no spaces", "-dash", strings.Repeat("a", 65)} {
+		err := chat_models.CreateChatMessage(db, &chat_models.ChatMessage{Room: room, UserID: 1, Body: "hi"})
+		assert.ErrorIs(t, err, chat_models.ErrInvalidRoom, room)
+	}
+	for _, body := range []string{"  ", strings.Repeat("字", chat_models.MaxMessageLength+1)} {
+		err := chat_models.CreateChatMessage(db, &chat_models.ChatMessage{Room: "general", UserID: 1, Body: body})
+		assert.ErrorIs(t, err, chat_models.ErrInvalidMessage)
+	}
+
+	for i := 1; i <= 5; i++ {
+		message := &chat_models.ChatMessage{Room: "general", UserID: 1, Body: fmt.Sprintf(" message %d ", i)}
+		assert.NoError(t, chat_models.CreateChatMessage(db, message))
+		assert.Equal(t, fmt.Sprintf("message %d", i), message.Body)
+	}
+	assert.NoError(t, chat_models.CreateChatMessage(db, &chat_models.ChatMessage{Room: "random", UserID: 1, Body: "elsewhere"}))
+
+	// Pages go back in time, each oldest first
+	page, err := chat_models.GetRoomHistory(db, "general", 0, 2)
+	assert.NoError(t, err)
+	if assert.Len(t, page, 2) {
+		assert.Equal(t, "message 4", page[0].Body)
+		assert.Equal(t, "message 5", page[1].Body)
+	}
+	page, err = chat_models.GetRoomHistory(db, "general", page[0].ID, 2)
+	assert.NoError(t, err)
+	if assert.Len(t, page, 2) {
+		assert.Equal(t, "message 2", page[0].Body)
+	}
+	page, err = chat_models.GetRoomHistory(db, "general", page[0].ID, 2)
+	assert.NoError(t, err)
+	assert.Len(t, page, 1)
+
+	page, err = chat_models.GetRoomHistory(db, "general", 0, 0)
+	assert.NoError(t, err)
+	assert.Len(t, page, 5)
+}
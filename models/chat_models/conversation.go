@@ -0,0 +1,134 @@
+package chat_models
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// directRoomPrefix starts the rooms of direct messages. Room names cannot contain
+// a colon, so no room can be joined to read other users' direct messages.
+const directRoomPrefix = "dm:"
+
+// ChatReadReceipt is how far a user has read a conversation: a room they joined
+// or their direct messages with another user.
+type ChatReadReceipt struct {
+	UserID     uint      `json:"user_id" gorm:"primaryKey"`
+	Room       string    `json:"room" gorm:"primaryKey;size:64"` // Room name or DirectRoom
+	LastReadID uint      `json:"last_read_id"`                   // Latest message read, and every one before it
+	UpdatedAt  time.Time `json:"updated_at"`
+}
+
+// UnreadCount is the number of messages of a conversation the user has not read.
+type UnreadCount struct {
+	Room   string `json:"room"`
+	UserID uint   `json:"user_id,omitempty"` // The other user of direct messages
+	Count  int    `json:"count"`
+}
+
+// DirectRoom returns the room of the direct messages between two users, the
+// same whichever of them is given first.
+func DirectRoom(userID, otherID uint) string {
+	if userID > otherID {
+		userID, otherID = otherID, userID
+	}
+	return fmt.Sprintf("%s%d:%d", directRoomPrefix, userID, otherID)
+}
+
+// DirectRoomUsers returns the users of a DirectRoom, false for other rooms.
+func DirectRoomUsers(room string) (uint, uint, bool) {
+	ids := strings.Split(strings.TrimPrefix(room, directRoomPrefix), ":")
+	if !strings.HasPrefix(room, directRoomPrefix) || len(ids) != 2 {
+		return 0, 0, false
+	}
+	first, err1 := strconv.ParseUint(ids[0], 10, 64)
+	second, err2 := strconv.ParseUint(ids[1], 10, 64)
+	if err1 != nil || err2 != nil {
+		return 0, 0, false
+	}
+	return uint(first), uint(second), true
+}
+
+// MarkRead records that the user has read a conversation up to the message.
+// Receipts never move back. A zero message ID starts tracking the conversation
+// without marking anything read, e.g. when joining an empty room.
+func MarkRead(db *gorm.DB, userID uint, room string, messageID uint) (*ChatReadReceipt, error) {
+	if messageID > 0 {
+		var count int64
+		// Deleted messages were still read
+		if err := db.Unscoped().Model(&ChatMessage{}).Where("id = ? AND room = ?", messageID, room).Count(&count).Error; err != nil {
+			return nil, err
+		}
+		if count == 0 {
+			return nil, fmt.Errorf("%w: %d", ErrMessageNotFound, messageID)
+		}
+	}
+
+	receipt := ChatReadReceipt{UserID: userID, Room: room}
+	err := db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&receipt).Error; err != nil {
+			return fmt.Errorf("failed to create read receipt: %w", err)
+		}
+		err := tx.Model(&ChatReadReceipt{}).Where("user_id = ? AND room = ? AND last_read_id < ?", userID, room, messageID).
+			Updates(map[string]interface{}{"last_read_id": messageID, "updated_at": time.Now()}).Error
+		if err != nil {
+			return fmt.Errorf("failed to update read receipt: %w", err)
+		}
+		return tx.Where("user_id = ? AND room = ?", userID, room).First(&receipt).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &receipt, nil
+}
+
+// DeleteReadReceipt stops tracking what the user read of a conversation, e.g.
+// a room they left.
+func DeleteReadReceipt(db *gorm.DB, userID uint, room string) error {
+	return db.Where("user_id = ? AND room = ?", userID, room).Delete(&ChatReadReceipt{}).Error
+}
+
+// GetReadReceipt retrieves how far the user has read a conversation, nil if they never have.
+func GetReadReceipt(db *gorm.DB, userID uint, room string) (*ChatReadReceipt, error) {
+	var receipt ChatReadReceipt
+	err := db.Where("user_id = ? AND room = ?", userID, room).First(&receipt).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &receipt, nil
+}
+
+// GetUnreadCounts retrieves the conversations with messages from others the user
+// has not read: rooms they have joined and direct messages sent to them.
+func GetUnreadCounts(db *gorm.DB, userID uint) ([]UnreadCount, error) {
+	var counts []UnreadCount
+	err := db.Model(&ChatMessage{}).
+		Select("chat_messages.room AS room, COUNT(*) AS count").
+		Joins("LEFT JOIN chat_read_receipts r ON r.user_id = ? AND r.room = chat_messages.room", userID).
+		Where("chat_messages.user_id <> ?", userID).
+		Where("chat_messages.recipient_id = ? OR r.user_id IS NOT NULL", userID).
+		Where("chat_messages.id > COALESCE(r.last_read_id, 0)").
+		Group("chat_messages.room").Order("chat_messages.room").
+		Scan(&counts).Error
+	if err != nil {
+		return nil, err
+	}
+
+	for i := range counts {
+		if first, second, ok := DirectRoomUsers(counts[i].Room); ok {
+			counts[i].UserID = first
+			if first == userID {
+				counts[i].UserID = second
+			}
+		}
+	}
+	return counts, nil
+}
@@ -0,0 +1,76 @@
+package chat_models_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"xy.com/mysite/models/chat_models"
+)
+
+func TestDirectRoom(t *testing.T) {
+	assert.Equal(t, "dm:2:7", chat_models.DirectRoom(7, 2))
+	assert.Equal(t, chat_models.DirectRoom(2, 7), chat_models.DirectRoom(7, 2))
+	assert.Error(t, chat_models.ValidateRoom(chat_models.DirectRoom(2, 7)))
+
+	first, second, ok := chat_models.DirectRoomUsers("dm:2:7")
+	assert.True(t, ok)
+	assert.Equal(t, []uint{2, 7}, []uint{first, second})
+	for _, room := range []string{"general", "dm:2", "dm:a:b", "xdm:2:7"} {
+		_, _, ok := chat_models.DirectRoomUsers(room)
+		assert.False(t, ok, room)
+	}
+}
+
+func TestDirectMessagesAndReadReceipts(t *testing.T) {
+	db := setupChatDB(t)
+
+	err := chat_models.CreateChatMessage(db, &chat_models.ChatMessage{UserID: 1, RecipientID: 1, Body: "me"})
+	assert.ErrorIs(t, err, chat_models.ErrInvalidRecipient)
+
+	// Users 1 and 2 talk directly, user 3 reads "general"
+	var direct []chat_models.ChatMessage
+	for i, from := range []uint{1, 2, 1} {
+		message := &chat_models.ChatMessage{UserID: from, RecipientID: 3 - from, Body: "dm"}
+		assert.NoError(t, chat_models.CreateChatMessage(db, message), i)
+		assert.Equal(t, chat_models.DirectRoom(1, 2), message.Room)
+		direct = append(direct, *message)
+	}
+	_, err = chat_models.MarkRead(db, 3, "general", 0)
+	assert.NoError(t, err)
+	general := &chat_models.ChatMessage{Room: "general", UserID: 1, Body: "hi"}
+	assert.NoError(t, chat_models.CreateChatMessage(db, general))
+
+	history, err := chat_models.GetDirectHistory(db, 2, 1, 0, 0)
+	assert.NoError(t, err)
+	assert.Len(t, history, 3)
+
+	counts, err := chat_models.GetUnreadCounts(db, 2)
+	assert.NoError(t, err)
+	assert.Equal(t, []chat_models.UnreadCount{{Room: "dm:1:2", UserID: 1, Count: 2}}, counts)
+	counts, err = chat_models.GetUnreadCounts(db, 3)
+	assert.NoError(t, err)
+	assert.Equal(t, []chat_models.UnreadCount{{Room: "general", Count: 1}}, counts)
+
+	// Receipts only move forward, and only to messages of the conversation
+	receipt, err := chat_models.MarkRead(db, 2, direct[0].Room, direct[2].ID)
+	assert.NoError(t, err)
+	assert.Equal(t, direct[2].ID, receipt.LastReadID)
+	receipt, err = chat_models.MarkRead(db, 2, direct[0].Room, direct[0].ID)
+	assert.NoError(t, err)
+	assert.Equal(t, direct[2].ID, receipt.LastReadID)
+	_, err = chat_models.MarkRead(db, 2, direct[0].Room, general.ID)
+	assert.ErrorIs(t, err, chat_models.ErrMessageNotFound)
+
+	counts, err = chat_models.GetUnreadCounts(db, 2)
+	assert.NoError(t, err)
+	assert.Empty(t, counts)
+
+	// Left rooms no longer count
+	assert.NoError(t, chat_models.DeleteReadReceipt(db, 3, "general"))
+	receipt, err = chat_models.GetReadReceipt(db, 3, "general")
+	assert.NoError(t, err)
+	assert.Nil(t, receipt)
+	counts, err = chat_models.GetUnreadCounts(db, 3)
+	assert.NoError(t, err)
+	assert.Empty(t, counts)
+}
@@ -0,0 +1,63 @@
+package chat_models
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+
+	"xy.com/mysite/models"
+)
+
+var ErrMessageRejected = models.NewError(models.KindInvalid, "message_rejected", "message not allowed")
+
+// ContentFilter checks the body of messages before they are posted.
+type ContentFilter interface {
+	// Filter returns the body to post, which may differ from the one given, or
+	// an error wrapping ErrMessageRejected if the message may not be posted.
+	Filter(body string) (string, error)
+}
+
+// WordFilter masks blocked words with asterisks, or rejects messages containing
+// them if Reject is set. Words are matched regardless of case, and only as whole
+// words if they are made of ASCII letters and digits, so "ass" leaves "class" be.
+type WordFilter struct {
+	Reject  bool
+	pattern *regexp.Regexp
+}
+
+var asciiWordPattern = regexp.MustCompile(`^[A-Za-z0-9]+$`)
+
+// NewWordFilter creates a filter blocking the words.
+func NewWordFilter(words []string) *WordFilter {
+	var alternatives []string
+	for _, word := range words {
+		word = strings.TrimSpace(word)
+		if word == "" {
+			continue
+		}
+		if asciiWordPattern.MatchString(word) {
+			alternatives = append(alternatives, `\b`+word+`\b`)
+		} else {
+			alternatives = append(alternatives, regexp.QuoteMeta(word))
+		}
+	}
+	filter := &WordFilter{}
+	if len(alternatives) > 0 {
+		filter.pattern = regexp.MustCompile(`(?i)` + strings.Join(alternatives, "|"))
+	}
+	return filter
+}
+
+// Filter masks or rejects the blocked words of body.
+func (f *WordFilter) Filter(body string) (string, error) {
+	if f.pattern == nil || !f.pattern.MatchString(body) {
+		return body, nil
+	}
+	if f.Reject {
+		return "", fmt.Errorf("%w: contains blocked words", ErrMessageRejected)
+	}
+	return f.pattern.ReplaceAllStringFunc(body, func(word string) string {
+		return strings.Repeat("*", utf8.RuneCountInString(word))
+	}), nil
+}
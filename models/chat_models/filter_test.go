@@ -0,0 +1,28 @@
+package chat_models_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"xy.com/mysite/models/chat_models"
+)
+
+func TestWordFilter(t *testing.T) {
+	filter := chat_models.NewWordFilter([]string{"darn", " ", "笨蛋"})
+
+	body, err := filter.Filter("Darn it, darnit, DARN! 你是笨蛋")
+	assert.NoError(t, err)
+	assert.Equal(t, "**** it, darnit, ****! 你是**", body)
+	body, err = filter.Filter("all good")
+	assert.NoError(t, err)
+	assert.Equal(t, "all good", body)
+
+	filter.Reject = true
+	_, err = filter.Filter("darn")
+	assert.ErrorIs(t, err, chat_models.ErrMessageRejected)
+
+	// No words, no filtering
+	body, err = chat_models.NewWordFilter(nil).Filter("darn")
+	assert.NoError(t, err)
+	assert.Equal(t, "darn", body)
+}
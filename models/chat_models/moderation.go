@@ -0,0 +1,243 @@
+package chat_models
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"gorm.io/gorm"
+	"xy.com/mysite/models"
+)
+
+// Sanctions moderators put on users
+const (
+	SanctionMute = "mute" // Cannot post, but can still read
+	SanctionBan  = "ban"  // Cannot join, and is disconnected if banned everywhere
+)
+
+// Actions recorded in the moderation log
+const (
+	ModerationMute          = "mute"
+	ModerationUnmute        = "unmute"
+	ModerationBan           = "ban"
+	ModerationUnban         = "unban"
+	ModerationDeleteMessage = "delete_message"
+)
+
+var (
+	ErrMuted            = models.NewError(models.KindForbidden, "muted", "you are muted")
+	ErrBanned           = models.NewError(models.KindForbidden, "banned", "you are banned")
+	ErrInvalidSanction  = models.NewError(models.KindInvalid, "invalid_sanction", "invalid sanction")
+	ErrSanctionNotFound = models.NewError(models.KindNotFound, "sanction_not_found", "sanction not found")
+)
+
+const (
+	DefaultModerationLogSize = 50
+	MaxModerationLogSize     = 500
+)
+
+// ChatSanction mutes or bans a user in a room, or everywhere including direct
+// messages if Room is empty. A user has at most one sanction of each kind per room.
+type ChatSanction struct {
+	ID          uint       `json:"id" gorm:"primaryKey"`
+	CreatedAt   time.Time  `json:"created_at"`
+	UserID      uint       `json:"user_id" gorm:"not null;index"`
+	Kind        string     `json:"kind" gorm:"size:16;not null"`
+	Room        string     `json:"room" gorm:"size:64"`
+	ModeratorID uint       `json:"moderator_id"`
+	Reason      string     `json:"reason" gorm:"size:255"`
+	ExpiresAt   *time.Time `json:"expires_at"` // Nil for a permanent sanction
+}
+
+// ChatModerationLog is the audit trail of moderator actions.
+type ChatModerationLog struct {
+	ID          uint       `json:"id" gorm:"primaryKey"`
+	CreatedAt   time.Time  `json:"created_at"`
+	ModeratorID uint       `json:"moderator_id" gorm:"index"`
+	Action      string     `json:"action" gorm:"size:32;not null"`
+	UserID      uint       `json:"user_id" gorm:"index"` // The user acted upon
+	Room        string     `json:"room" gorm:"size:64"`
+	MessageID   uint       `json:"message_id,omitempty"`
+	Reason      string     `json:"reason" gorm:"size:255"`
+	ExpiresAt   *time.Time `json:"expires_at,omitempty"`
+}
+
+// moderationActions maps sanction kinds to the actions imposing and lifting them.
+var moderationActions = map[string][2]string{
+	SanctionMute: {ModerationMute, ModerationUnmute},
+	SanctionBan:  {ModerationBan, ModerationUnban},
+}
+
+func validateSanction(kind string, room string) error {
+	if _, ok := moderationActions[kind]; !ok {
+		return fmt.Errorf("%w: unknown kind %q", ErrInvalidSanction, kind)
+	}
+	if room != "" {
+		return ValidateRoom(room)
+	}
+	return nil
+}
+
+// Sanction mutes or bans a user on behalf of a moderator, replacing any
+// sanction of the same kind in the same room, and logs it.
+func Sanction(db *gorm.DB, moderatorID uint, sanction *ChatSanction) error {
+	if err := validateSanction(sanction.Kind, sanction.Room); err != nil {
+		return err
+	}
+	if sanction.UserID == 0 {
+		return fmt.Errorf("%w: user is required", ErrInvalidSanction)
+	}
+	if sanction.ExpiresAt != nil && !sanction.ExpiresAt.After(time.Now()) {
+		return fmt.Errorf("%w: already expired", ErrInvalidSanction)
+	}
+
+	sanction.ID = 0
+	sanction.ModeratorID = moderatorID
+	return db.Transaction(func(tx *gorm.DB) error {
+		err := tx.Where("user_id = ? AND kind = ? AND room = ?", sanction.UserID, sanction.Kind, sanction.Room).Delete(&ChatSanction{}).Error
+		if err != nil {
+			return err
+		}
+		if err := tx.Create(sanction).Error; err != nil {
+			return fmt.Errorf("failed to save sanction: %w", err)
+		}
+		return logModeration(tx, &ChatModerationLog{
+			ModeratorID: moderatorID,
+			Action:      moderationActions[sanction.Kind][0],
+			UserID:      sanction.UserID,
+			Room:        sanction.Room,
+			Reason:      sanction.Reason,
+			ExpiresAt:   sanction.ExpiresAt,
+		})
+	})
+}
+
+// LiftSanction lifts a user's sanction of the kind in the room on behalf of a
+// moderator, and logs it.
+func LiftSanction(db *gorm.DB, moderatorID uint, userID uint, kind string, room string, reason string) error {
+	if err := validateSanction(kind, room); err != nil {
+		return err
+	}
+	return db.Transaction(func(tx *gorm.DB) error {
+		result := tx.Where("user_id = ? AND kind = ? AND room = ?", userID, kind, room).Delete(&ChatSanction{})
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return ErrSanctionNotFound
+		}
+		return logModeration(tx, &ChatModerationLog{
+			ModeratorID: moderatorID,
+			Action:      moderationActions[kind][1],
+			UserID:      userID,
+			Room:        room,
+			Reason:      reason,
+		})
+	})
+}
+
+// getActiveSanction retrieves the user's unexpired sanction of one of the kinds
+// in the room or everywhere, bans first, nil if there is none.
+func getActiveSanction(db *gorm.DB, userID uint, room string, kinds ...string) (*ChatSanction, error) {
+	var sanction ChatSanction
+	err := db.Where("user_id = ? AND kind IN ? AND room IN ?", userID, kinds, []string{"", room}).
+		Where("expires_at IS NULL OR expires_at > ?", time.Now()).
+		Order("kind = 'ban' DESC").First(&sanction).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &sanction, nil
+}
+
+func sanctionError(sanction *ChatSanction) error {
+	err := ErrMuted
+	if sanction.Kind == SanctionBan {
+		err = ErrBanned
+	}
+	if sanction.ExpiresAt != nil {
+		return fmt.Errorf("%w until %s", err, sanction.ExpiresAt.Format(time.RFC3339))
+	}
+	return err
+}
+
+// CheckCanJoin checks that the user is not banned from the room, or from chat
+// altogether if room is empty.
+func CheckCanJoin(db *gorm.DB, userID uint, room string) error {
+	sanction, err := getActiveSanction(db, userID, room, SanctionBan)
+	if err != nil {
+		return err
+	}
+	if sanction != nil {
+		return sanctionError(sanction)
+	}
+	return nil
+}
+
+// CheckCanPost checks that the user is neither muted nor banned in the room, or
+// everywhere if room is empty, e.g. for direct messages.
+func CheckCanPost(db *gorm.DB, userID uint, room string) error {
+	sanction, err := getActiveSanction(db, userID, room, SanctionBan, SanctionMute)
+	if err != nil {
+		return err
+	}
+	if sanction != nil {
+		return sanctionError(sanction)
+	}
+	return nil
+}
+
+// DeleteChatMessage deletes a message on behalf of a moderator, and logs it.
+// The message is returned so that its conversation can be told.
+func DeleteChatMessage(db *gorm.DB, moderatorID uint, messageID uint, reason string) (*ChatMessage, error) {
+	var message ChatMessage
+	err := db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.First(&message, messageID).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return fmt.Errorf("%w: %d", ErrMessageNotFound, messageID)
+			}
+			return err
+		}
+		if err := tx.Delete(&message).Error; err != nil {
+			return fmt.Errorf("failed to delete chat message: %w", err)
+		}
+		return logModeration(tx, &ChatModerationLog{
+			ModeratorID: moderatorID,
+			Action:      ModerationDeleteMessage,
+			UserID:      message.UserID,
+			Room:        message.Room,
+			MessageID:   message.ID,
+			Reason:      reason,
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &message, nil
+}
+
+func logModeration(tx *gorm.DB, entry *ChatModerationLog) error {
+	if err := tx.Create(entry).Error; err != nil {
+		return fmt.Errorf("failed to log moderation: %w", err)
+	}
+	return nil
+}
+
+// GetModerationLog retrieves up to limit of the latest moderation actions,
+// newest first, about the user or, if userID is zero, anyone.
+func GetModerationLog(db *gorm.DB, userID uint, limit int) ([]ChatModerationLog, error) {
+	if limit < 1 || limit > MaxModerationLogSize {
+		limit = DefaultModerationLogSize
+	}
+	query := db.Order("id desc").Limit(limit)
+	if userID != 0 {
+		query = query.Where("user_id = ?", userID)
+	}
+	var entries []ChatModerationLog
+	if err := query.Find(&entries).Error; err != nil {
+		return nil, err
+	}
+	return entries, nil
+}
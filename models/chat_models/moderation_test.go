@@ -0,0 +1,105 @@
+package chat_models_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"xy.com/mysite/models"
+	"xy.com/mysite/models/chat_models"
+)
+
+func TestSanctions(t *testing.T) {
+	db := setupChatDB(t)
+	const moderator = 99
+	past, future := time.Now().Add(-time.Hour), time.Now().Add(time.Hour)
+
+	for _, sanction := range []chat_models.ChatSanction{
+		{UserID: 1, Kind: "kick"},
+		{UserID: 1, Kind: chat_models.SanctionMute, Room: "Not A Room"},
+		{UserID: 1, Kind: chat_models.SanctionMute, ExpiresAt: &past},
+		{Kind: chat_models.SanctionMute},
+	} {
+		err := chat_models.Sanction(db, moderator, &sanction)
+		assert.ErrorIs(t, err, models.ErrInvalid)
+	}
+
+	// Muted in one room: can still post elsewhere and join
+	assert.NoError(t, chat_models.Sanction(db, moderator, &chat_models.ChatSanction{UserID: 1, Kind: chat_models.SanctionMute, Room: "general", ExpiresAt: &future}))
+	assert.ErrorIs(t, chat_models.CheckCanPost(db, 1, "general"), chat_models.ErrMuted)
+	assert.NoError(t, chat_models.CheckCanPost(db, 1, "random"))
+	assert.NoError(t, chat_models.CheckCanPost(db, 1, ""))
+	assert.NoError(t, chat_models.CheckCanJoin(db, 1, "general"))
+
+	// Banned everywhere: bans win over mutes
+	assert.NoError(t, chat_models.Sanction(db, moderator, &chat_models.ChatSanction{UserID: 1, Kind: chat_models.SanctionBan, Reason: "spam"}))
+	assert.ErrorIs(t, chat_models.CheckCanPost(db, 1, "general"), chat_models.ErrBanned)
+	assert.ErrorIs(t, chat_models.CheckCanJoin(db, 1, "random"), chat_models.ErrBanned)
+	assert.ErrorIs(t, chat_models.CheckCanJoin(db, 1, ""), chat_models.ErrBanned)
+	assert.NoError(t, chat_models.CheckCanJoin(db, 2, ""))
+
+	assert.NoError(t, chat_models.LiftSanction(db, moderator, 1, chat_models.SanctionBan, "", "appealed"))
+	assert.ErrorIs(t, chat_models.LiftSanction(db, moderator, 1, chat_models.SanctionBan, "", ""), chat_models.ErrSanctionNotFound)
+	assert.NoError(t, chat_models.CheckCanJoin(db, 1, ""))
+
+	// Sanctioning again replaces the sanction, expired ones no longer apply
+	soon := time.Now().Add(50 * time.Millisecond)
+	assert.NoError(t, chat_models.Sanction(db, moderator, &chat_models.ChatSanction{UserID: 1, Kind: chat_models.SanctionMute, Room: "general", ExpiresAt: &soon}))
+	time.Sleep(100 * time.Millisecond)
+	assert.NoError(t, chat_models.CheckCanPost(db, 1, "general"))
+	var count int64
+	db.Model(&chat_models.ChatSanction{}).Count(&count)
+	assert.Equal(t, int64(1), count)
+
+	log, err := chat_models.GetModerationLog(db, 1, 0)
+	assert.NoError(t, err)
+	var actions []string
+	for _, entry := range log {
+		actions = append(actions, entry.Action)
+		assert.Equal(t, uint(moderator), entry.ModeratorID)
+	}
+	assert.Equal(t, []string{"mute", "unban", "ban", "mute"}, actions)
+	assert.Equal(t, "appealed", log[1].Reason)
+	log, err = chat_models.GetModerationLog(db, 2, 0)
+	assert.NoError(t, err)
+	assert.Empty(t, log)
+}
+
+func TestDeleteChatMessage(t *testing.T) {
+	db := setupChatDB(t)
+
+	_, err := chat_models.MarkRead(db, 2, "general", 0)
+	assert.NoError(t, err)
+	var messages []*chat_models.ChatMessage
+	for _, body := range []string{"hello", "spam"} {
+		message := &chat_models.ChatMessage{Room: "general", UserID: 1, Body: body}
+		assert.NoError(t, chat_models.CreateChatMessage(db, message))
+		messages = append(messages, message)
+	}
+
+	deleted, err := chat_models.DeleteChatMessage(db, 99, messages[1].ID, "spam")
+	assert.NoError(t, err)
+	assert.Equal(t, "general", deleted.Room)
+	_, err = chat_models.DeleteChatMessage(db, 99, messages[1].ID, "spam")
+	assert.ErrorIs(t, err, chat_models.ErrMessageNotFound)
+
+	// Deleted messages are gone from history and unread counts, but can still be read up to
+	history, err := chat_models.GetRoomHistory(db, "general", 0, 0)
+	assert.NoError(t, err)
+	if assert.Len(t, history, 1) {
+		assert.Equal(t, "hello", history[0].Body)
+	}
+	counts, err := chat_models.GetUnreadCounts(db, 2)
+	assert.NoError(t, err)
+	assert.Equal(t, []chat_models.UnreadCount{{Room: "general", Count: 1}}, counts)
+	_, err = chat_models.MarkRead(db, 2, "general", messages[1].ID)
+	assert.NoError(t, err)
+
+	log, err := chat_models.GetModerationLog(db, 0, 0)
+	assert.NoError(t, err)
+	if assert.Len(t, log, 1) {
+		assert.Equal(t, chat_models.ModerationDeleteMessage, log[0].Action)
+		assert.Equal(t, messages[1].ID, log[0].MessageID)
+		assert.Equal(t, uint(1), log[0].UserID)
+	}
+}
@@ -0,0 +1,52 @@
+package models
+
+// ErrorKind classifies model errors, so that callers can handle a whole class
+// of errors, such as anything that was not found, without knowing every model.
+type ErrorKind int
+
+const (
+	KindInternal ErrorKind = iota
+	KindNotFound
+	KindConflict
+	KindInvalid
+	KindForbidden
+	KindUnauthorized
+	KindInsufficientFunds
+	KindRateLimited
+)
+
+// Error is a model error with a machine-readable code. Models declare their
+// errors as sentinels with NewError and wrap them with fmt.Errorf("%w") to add
+// detail; errors.Is matches both the sentinel and the generic error of its kind.
+type Error struct {
+	Kind    ErrorKind
+	Code    string
+	Message string
+}
+
+func (e *Error) Error() string {
+	return e.Message
+}
+
+// Is reports whether target is the generic error of e's kind, for example
+// errors.Is(ErrPrizeNotFound, models.ErrNotFound).
+func (e *Error) Is(target error) bool {
+	t, ok := target.(*Error)
+	return ok && t.Code == "" && t.Kind == e.Kind
+}
+
+// NewError creates a model error.
+func NewError(kind ErrorKind, code string, message string) *Error {
+	return &Error{Kind: kind, Code: code, Message: message}
+}
+
+// Generic errors of each kind, for use with errors.Is.
+var (
+	ErrNotFound          = &Error{Kind: KindNotFound, Message: "not found"}
+	ErrConflict          = &Error{Kind: KindConflict, Message: "conflict"}
+	ErrInvalid           = &Error{Kind: KindInvalid, Message: "invalid input"}
+	ErrForbidden         = &Error{Kind: KindForbidden, Message: "forbidden"}
+	ErrUnauthorized      = &Error{Kind: KindUnauthorized, Message: "unauthorized"}
+	ErrInsufficientFunds = &Error{Kind: KindInsufficientFunds, Message: "insufficient funds"}
+	ErrRateLimited       = &Error{Kind: KindRateLimited, Message: "rate limited"}
+)
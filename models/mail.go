@@ -1,8 +1,14 @@
 package models
 
 import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"io"
 	"log"
+	"mime/multipart"
 	"net/smtp"
+	"net/textproto"
 )
 
 // mail config
@@ -19,6 +25,13 @@ type Email struct {
 	Body    string `json:"body"`
 }
 
+// Attachment is a file attached to an outgoing email.
+type Attachment struct {
+	Filename    string
+	ContentType string
+	Data        []byte
+}
+
 func sendEmail(to string, subject string, body string) {
 	from := smtpUser
 	pass := smtpPass
@@ -38,3 +51,78 @@ func sendEmail(to string, subject string, body string) {
 
 	log.Print("sent, visit http://foobar.com/baz")
 }
+
+// SendEmailWithAttachments sends a plain text email with the given files attached.
+func SendEmailWithAttachments(to string, subject string, body string, attachments ...Attachment) error {
+	from := smtpUser
+	msg, err := buildMultipartMessage(from, to, subject, body, attachments)
+	if err != nil {
+		return err
+	}
+
+	err = smtp.SendMail(smtpServer+":"+smtpPort,
+		smtp.PlainAuth("", from, smtpPass, smtpServer),
+		from, []string{to}, msg)
+	if err != nil {
+		return fmt.Errorf("smtp error: %w", err)
+	}
+
+	return nil
+}
+
+// buildMultipartMessage encodes the email as a multipart/mixed MIME message.
+func buildMultipartMessage(from, to, subject, body string, attachments []Attachment) ([]byte, error) {
+	var buf bytes.Buffer
+	writer := multipart.NewWriter(&buf)
+
+	header := "From: " + from + "\r\n" +
+		"To: " + to + "\r\n" +
+		"Subject: " + subject + "\r\n" +
+		"MIME-Version: 1.0\r\n" +
+		"Content-Type: multipart/mixed; boundary=" + writer.Boundary() + "\r\n\r\n"
+	msg := bytes.NewBufferString(header)
+
+	part, err := writer.CreatePart(textproto.MIMEHeader{
+		"Content-Type": {"text/plain; charset=utf-8"},
+	})
+	if err != nil {
+		return nil, err
+	}
+	if _, err := part.Write([]byte(body)); err != nil {
+		return nil, err
+	}
+
+	for _, attachment := range attachments {
+		part, err := writer.CreatePart(textproto.MIMEHeader{
+			"Content-Type":              {attachment.ContentType},
+			"Content-Transfer-Encoding": {"base64"},
+			"Content-Disposition":       {fmt.Sprintf("attachment; filename=%q", attachment.Filename)},
+		})
+		if err != nil {
+			return nil, err
+		}
+		if err := writeBase64Lines(part, attachment.Data); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := writer.Close(); err != nil {
+		return nil, err
+	}
+
+	msg.Write(buf.Bytes())
+	return msg.Bytes(), nil
+}
+
+// writeBase64Lines writes data as base64 wrapped at 76 characters per line.
+func writeBase64Lines(w io.Writer, data []byte) error {
+	encoded := base64.StdEncoding.EncodeToString(data)
+	for len(encoded) > 76 {
+		if _, err := w.Write([]byte(encoded[:76] + "\r\n")); err != nil {
+			return err
+		}
+		encoded = encoded[76:]
+	}
+	_, err := w.Write([]byte(encoded + "\r\n"))
+	return err
+}
@@ -0,0 +1,126 @@
+package prize_models
+
+import (
+	"fmt"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Events that count towards achievements
+const (
+	EventDraw          = "draw"
+	EventPrizeExchange = "prize_exchange"
+	EventOrderPlaced   = "order_placed"
+	EventOrderPaid     = "order_paid"      // Counts orders as they are first paid for
+	EventCheckInStreak = "check_in_streak" // Counts the current streak rather than every check-in
+)
+
+// Achievement is a badge a user unlocks once an event has happened Threshold
+// times, optionally with a points reward.
+type Achievement struct {
+	Key         string `json:"key"`
+	Name        string `json:"name"`
+	Description string `json:"description"`
+	Event       string `json:"event"`
+	Threshold   int    `json:"threshold"`
+	Points      int    `json:"points"`
+}
+
+// Achievements lists the achievements users can unlock. Keys must never be
+// reused, unlocked achievements are stored by key.
+var Achievements = []Achievement{
+	{Key: "first_draw", Name: "Beginner's luck", Description: "Make your first draw", Event: EventDraw, Threshold: 1, Points: 10},
+	{Key: "tenth_draw", Name: "Regular", Description: "Make ten draws", Event: EventDraw, Threshold: 10, Points: 50},
+	{Key: "first_exchange", Name: "Collector", Description: "Exchange your first prize", Event: EventPrizeExchange, Threshold: 1, Points: 20},
+	{Key: "first_order", Name: "Customer", Description: "Pay for your first order", Event: EventOrderPaid, Threshold: 1, Points: 50},
+	{Key: "week_streak", Name: "Dedicated", Description: "Check in seven days in a row", Event: EventCheckInStreak, Threshold: 7, Points: 100},
+	{Key: "month_streak", Name: "Devoted", Description: "Check in thirty days in a row", Event: EventCheckInStreak, Threshold: 30, Points: 500},
+}
+
+// AchievementProgress counts how often an event has happened for a user.
+type AchievementProgress struct {
+	UserID uint   `json:"user_id" gorm:"primaryKey"`
+	Event  string `json:"event" gorm:"primaryKey;size:32"`
+	Count  int    `json:"count"`
+}
+
+// UserAchievement is an achievement unlocked by a user.
+type UserAchievement struct {
+	UserID         uint      `json:"user_id" gorm:"primaryKey"`
+	AchievementKey string    `json:"achievement_key" gorm:"primaryKey;size:64"`
+	Points         int       `json:"points"` // Points awarded when it was unlocked
+	CreatedAt      time.Time `json:"created_at"`
+}
+
+// RecordEvent counts an event for the user and unlocks the achievements it
+// completes, crediting their points. The referral qualifying event also rewards
+// the user's referral. Call it in the transaction of the event
+// so the event and its rewards are committed together.
+func RecordEvent(db *gorm.DB, userID uint, event string) ([]UserAchievement, error) {
+	progress := AchievementProgress{UserID: userID, Event: event, Count: 1}
+	err := db.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "user_id"}, {Name: "event"}},
+		DoUpdates: clause.Assignments(map[string]interface{}{"count": gorm.Expr("count + 1")}),
+	}).Create(&progress).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to record event: %w", err)
+	}
+	if err := db.Where("user_id = ? AND event = ?", userID, event).First(&progress).Error; err != nil {
+		return nil, err
+	}
+	if event == ReferralQualifyingEvent {
+		if err := qualifyReferral(db, userID, time.Now()); err != nil {
+			return nil, err
+		}
+	}
+	return unlockAchievements(db, userID, event, progress.Count)
+}
+
+// unlockAchievements unlocks the user's achievements for the event whose threshold
+// count has reached. Achievements are only unlocked and rewarded once.
+func unlockAchievements(db *gorm.DB, userID uint, event string, count int) ([]UserAchievement, error) {
+	var unlocked []UserAchievement
+	for _, achievement := range Achievements {
+		if achievement.Event != event || count < achievement.Threshold {
+			continue
+		}
+
+		userAchievement := UserAchievement{UserID: userID, AchievementKey: achievement.Key, Points: achievement.Points}
+		result := db.Clauses(clause.OnConflict{DoNothing: true}).Create(&userAchievement)
+		if result.Error != nil {
+			return nil, fmt.Errorf("failed to unlock achievement: %w", result.Error)
+		}
+		if result.RowsAffected == 0 {
+			continue
+		}
+
+		if achievement.Points > 0 {
+			err := CreditPoints(db, userID, achievement.Points, LedgerReasonAchievement, "achievement:"+achievement.Key)
+			if err != nil {
+				return nil, err
+			}
+		}
+		unlocked = append(unlocked, userAchievement)
+	}
+	return unlocked, nil
+}
+
+// GetUserAchievements retrieves the achievements the user has unlocked, oldest first.
+func GetUserAchievements(db *gorm.DB, userID uint) ([]UserAchievement, error) {
+	var achievements []UserAchievement
+	if err := db.Where("user_id = ?", userID).Order("created_at, achievement_key").Find(&achievements).Error; err != nil {
+		return nil, err
+	}
+	return achievements, nil
+}
+
+// GetAchievementProgress retrieves the user's event counts.
+func GetAchievementProgress(db *gorm.DB, userID uint) ([]AchievementProgress, error) {
+	var progress []AchievementProgress
+	if err := db.Where("user_id = ?", userID).Order("event").Find(&progress).Error; err != nil {
+		return nil, err
+	}
+	return progress, nil
+}
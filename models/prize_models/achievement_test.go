@@ -0,0 +1,96 @@
+package prize_models_test
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"xy.com/mysite/models/prize_models"
+)
+
+func TestAchievements(t *testing.T) {
+	db := setupPointsDB(t)
+	prize_models.Achievements = []prize_models.Achievement{
+		{Key: "first_draw", Event: prize_models.EventDraw, Threshold: 1, Points: 5},
+		{Key: "third_draw", Event: prize_models.EventDraw, Threshold: 3, Points: 50},
+		{Key: "first_exchange", Event: prize_models.EventPrizeExchange, Threshold: 1},
+		{Key: "two_day_streak", Event: prize_models.EventCheckInStreak, Threshold: 2, Points: 100},
+	}
+	userID := uint(1)
+	createPointsTable(t, db)
+	engine := prize_models.NewDrawEngine(1)
+	fetchDrawSeeds(t, db, engine, userID)
+
+	// Draws unlock their achievements in the draw's transaction
+	record, err := engine.Draw(db, userID, 0)
+	assert.NoError(t, err)
+	if assert.Len(t, record.Achievements, 1) {
+		assert.Equal(t, "first_draw", record.Achievements[0].AchievementKey)
+	}
+	record, err = engine.Draw(db, userID, 0)
+	assert.NoError(t, err)
+	assert.Empty(t, record.Achievements)
+	record, err = engine.Draw(db, userID, 0)
+	assert.NoError(t, err)
+	assert.Len(t, record.Achievements, 1)
+
+	ps, _ := prize_models.GetPointsSystem(db, userID)
+	assert.Equal(t, 3000+5+50, ps.Points)
+
+	// Exchanges too
+	assert.NoError(t, prize_models.AddPrize(db, "sticker", 100))
+	addPrizeCodes(t, db, "sticker", "code-1")
+	exchange, err := prize_models.ExchangePrizeWithShipping(db, userID, "sticker", nil)
+	assert.NoError(t, err)
+	assert.Len(t, exchange.Achievements, 1)
+
+	// Streak achievements count the current streak
+	day := time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)
+	result, err := prize_models.CheckIn(db, userID, "", day)
+	assert.NoError(t, err)
+	assert.Empty(t, result.Achievements)
+	result, err = prize_models.CheckIn(db, userID, "", day.AddDate(0, 0, 1))
+	assert.NoError(t, err)
+	assert.Len(t, result.Achievements, 1)
+
+	unlocked, err := prize_models.GetUserAchievements(db, userID)
+	assert.NoError(t, err)
+	assert.Len(t, unlocked, 4)
+
+	progress, err := prize_models.GetAchievementProgress(db, userID)
+	assert.NoError(t, err)
+	counts := map[string]int{}
+	for _, p := range progress {
+		counts[p.Event] = p.Count
+	}
+	assert.Equal(t, map[string]int{prize_models.EventDraw: 3, prize_models.EventPrizeExchange: 1, prize_models.EventCheckInStreak: 2}, counts)
+
+	check, err := prize_models.VerifyBalance(db, userID)
+	assert.NoError(t, err)
+	assert.True(t, check.Consistent)
+}
+
+func TestAchievementsUnlockOnceUnderConcurrency(t *testing.T) {
+	db := setupPointsDB(t)
+	prize_models.Achievements = []prize_models.Achievement{
+		{Key: "first_order", Event: prize_models.EventOrderPlaced, Threshold: 1, Points: 50},
+	}
+	userID := uint(1)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := prize_models.RecordEvent(db, userID, prize_models.EventOrderPlaced)
+			assert.NoError(t, err)
+		}()
+	}
+	wg.Wait()
+
+	ps, _ := prize_models.GetPointsSystem(db, userID)
+	assert.Equal(t, 50, ps.Points)
+	progress, _ := prize_models.GetAchievementProgress(db, userID)
+	assert.Equal(t, 10, progress[0].Count)
+}
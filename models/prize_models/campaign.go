@@ -0,0 +1,291 @@
+package prize_models
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"xy.com/mysite/models"
+	"xy.com/mysite/models/user_models"
+)
+
+var (
+	ErrCampaignNotFound        = models.NewError(models.KindNotFound, "campaign_not_found", "campaign not found")
+	ErrCampaignNotRunning      = models.NewError(models.KindForbidden, "campaign_not_running", "campaign is not running")
+	ErrCampaignBudgetExhausted = models.NewError(models.KindForbidden, "campaign_budget_exhausted", "campaign budget is exhausted")
+	ErrDailyDrawLimitReached   = models.NewError(models.KindRateLimited, "daily_draw_limit_reached", "daily draw limit reached")
+	ErrNotEligible             = models.NewError(models.KindForbidden, "not_eligible", "user is not eligible for this campaign")
+	ErrInvalidCampaign         = models.NewError(models.KindInvalid, "invalid_campaign", "invalid campaign")
+)
+
+// DrawCampaign limits draws from a reward table to a time window, a total
+// reward budget and a number of draws per user per day.
+//
+// The budget is counted in points: points outcomes cost their amount, coins
+// outcomes their amount converted to points (rounded up) and prize outcomes
+// the cost of the prize. Once the budget cannot pay for a reward the campaign
+// closes itself.
+type DrawCampaign struct {
+	gorm.Model
+	Name          string     `json:"name" gorm:"uniqueIndex;size:128;not null"`
+	RewardTableID uint       `json:"reward_table_id" gorm:"index"`
+	StartsAt      time.Time  `json:"starts_at"`
+	EndsAt        time.Time  `json:"ends_at"`
+	Budget        int        `json:"budget"`
+	Spent         int        `json:"spent"`
+	DailyLimit    int        `json:"daily_limit"`               // Draws per user per day, 0 for no limit
+	Segments      string     `json:"segments" gorm:"size:512"`  // Comma separated user segments, empty for everyone
+	Active        bool       `json:"active"`                    // Admin switch
+	ExhaustedAt   *time.Time `json:"exhausted_at" gorm:"index"` // Set when the budget ran out
+}
+
+// CampaignDailyDraws counts a user's draws in a campaign on one day.
+type CampaignDailyDraws struct {
+	CampaignID uint   `gorm:"primaryKey"`
+	UserID     uint   `gorm:"primaryKey"`
+	Day        string `gorm:"primaryKey;size:10"` // YYYY-MM-DD in server time
+	Draws      int
+}
+
+// SegmentList returns the campaign's segments.
+func (c *DrawCampaign) SegmentList() []string {
+	var segments []string
+	for _, segment := range strings.Split(c.Segments, ",") {
+		if segment = strings.TrimSpace(segment); segment != "" {
+			segments = append(segments, segment)
+		}
+	}
+	return segments
+}
+
+// IsRunning reports whether the campaign accepts draws at the given time.
+func (c *DrawCampaign) IsRunning(now time.Time) bool {
+	return c.Active && c.ExhaustedAt == nil && !now.Before(c.StartsAt) && now.Before(c.EndsAt)
+}
+
+// Validate checks the campaign settings.
+func (c *DrawCampaign) Validate(db *gorm.DB) error {
+	if c.Name == "" {
+		return fmt.Errorf("%w: name is required", ErrInvalidCampaign)
+	}
+	if !c.EndsAt.After(c.StartsAt) {
+		return fmt.Errorf("%w: end time must be after start time", ErrInvalidCampaign)
+	}
+	if c.Budget <= 0 {
+		return fmt.Errorf("%w: budget must be positive", ErrInvalidCampaign)
+	}
+	if c.DailyLimit < 0 {
+		return fmt.Errorf("%w: daily limit must not be negative", ErrInvalidCampaign)
+	}
+	c.Segments = strings.Join(c.SegmentList(), ",")
+
+	var table RewardTable
+	if err := db.First(&table, c.RewardTableID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return fmt.Errorf("%w: reward table %d does not exist", ErrInvalidCampaign, c.RewardTableID)
+		}
+		return err
+	}
+	return nil
+}
+
+// CreateCampaign validates and saves a new campaign.
+func CreateCampaign(db *gorm.DB, campaign *DrawCampaign) error {
+	campaign.Spent = 0
+	campaign.ExhaustedAt = nil
+	if err := campaign.Validate(db); err != nil {
+		return err
+	}
+	if err := db.Create(campaign).Error; err != nil {
+		return fmt.Errorf("failed to create campaign: %w", err)
+	}
+	return nil
+}
+
+// UpdateCampaign updates a campaign's settings. The amount spent is kept; raising
+// the budget of an exhausted campaign reopens it.
+func UpdateCampaign(db *gorm.DB, campaign *DrawCampaign) error {
+	if err := campaign.Validate(db); err != nil {
+		return err
+	}
+
+	return db.Transaction(func(tx *gorm.DB) error {
+		existing, err := GetCampaign(tx, campaign.ID)
+		if err != nil {
+			return err
+		}
+
+		updates := map[string]interface{}{
+			"name":            campaign.Name,
+			"reward_table_id": campaign.RewardTableID,
+			"starts_at":       campaign.StartsAt,
+			"ends_at":         campaign.EndsAt,
+			"budget":          campaign.Budget,
+			"daily_limit":     campaign.DailyLimit,
+			"segments":        campaign.Segments,
+			"active":          campaign.Active,
+		}
+		if campaign.Budget > existing.Budget {
+			updates["exhausted_at"] = nil
+		}
+		if err := tx.Model(existing).Updates(updates).Error; err != nil {
+			return fmt.Errorf("failed to update campaign: %w", err)
+		}
+
+		*campaign = *existing
+		return nil
+	})
+}
+
+// GetCampaign retrieves a campaign by ID.
+func GetCampaign(db *gorm.DB, id uint) (*DrawCampaign, error) {
+	var campaign DrawCampaign
+	if err := db.First(&campaign, id).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrCampaignNotFound
+		}
+		return nil, err
+	}
+	return &campaign, nil
+}
+
+// GetCampaigns retrieves all campaigns.
+func GetCampaigns(db *gorm.DB) ([]DrawCampaign, error) {
+	var campaigns []DrawCampaign
+	if err := db.Order("id").Find(&campaigns).Error; err != nil {
+		return nil, err
+	}
+	return campaigns, nil
+}
+
+// GetRunningCampaigns retrieves the campaigns the user can currently draw in.
+func GetRunningCampaigns(db *gorm.DB, userID uint, now time.Time) ([]DrawCampaign, error) {
+	var campaigns []DrawCampaign
+	err := db.Where("active = ? AND exhausted_at IS NULL AND starts_at <= ? AND ends_at > ?", true, now, now).
+		Order("ends_at").
+		Find(&campaigns).Error
+	if err != nil {
+		return nil, err
+	}
+
+	eligible := campaigns[:0]
+	for _, campaign := range campaigns {
+		ok, err := isEligible(db, &campaign, userID)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			eligible = append(eligible, campaign)
+		}
+	}
+	return eligible, nil
+}
+
+// isEligible reports whether the user is in one of the campaign's segments.
+func isEligible(db *gorm.DB, campaign *DrawCampaign, userID uint) (bool, error) {
+	segments := campaign.SegmentList()
+	if len(segments) == 0 {
+		return true, nil
+	}
+	return user_models.IsUserInAnySegment(db, userID, segments)
+}
+
+// countDailyDraw counts a draw against the user's daily limit in the campaign.
+func countDailyDraw(tx *gorm.DB, campaign *DrawCampaign, userID uint, now time.Time) error {
+	if campaign.DailyLimit == 0 {
+		return nil
+	}
+
+	counter := CampaignDailyDraws{CampaignID: campaign.ID, UserID: userID, Day: now.Format("2006-01-02")}
+	if err := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&counter).Error; err != nil {
+		return fmt.Errorf("failed to create daily draw counter: %w", err)
+	}
+
+	result := tx.Model(&CampaignDailyDraws{}).
+		Where("campaign_id = ? AND user_id = ? AND day = ? AND draws < ?", counter.CampaignID, userID, counter.Day, campaign.DailyLimit).
+		Update("draws", gorm.Expr("draws + 1"))
+	if result.Error != nil {
+		return fmt.Errorf("failed to update daily draw counter: %w", result.Error)
+	}
+	if result.RowsAffected == 0 {
+		return ErrDailyDrawLimitReached
+	}
+	return nil
+}
+
+// rewardCost returns what an outcome costs a campaign's budget. Coins cost
+// what they can be exchanged for at the time, rounded up.
+func rewardCost(tx *gorm.DB, outcome *RewardOutcome, now time.Time) (int, error) {
+	switch outcome.Kind {
+	case OutcomePoints:
+		return outcome.Amount, nil
+	case OutcomeCoins:
+		rate, err := GetExchangeRate(tx, now)
+		if err != nil {
+			return 0, err
+		}
+		return (outcome.Amount + rate.CoinsPerPoint - 1) / rate.CoinsPerPoint, nil
+	case OutcomePrize:
+		prize, err := GetPrizeByName(tx, outcome.PrizeName)
+		if err != nil {
+			return 0, err
+		}
+		return prize.Cost, nil
+	}
+	return 0, nil
+}
+
+// spendBudget atomically charges the cost of an outcome to the campaign. It
+// reports false, leaving the budget untouched, if the remaining budget cannot
+// pay for it. The campaign is closed once what is left cannot pay for any of
+// the table's outcomes that cost something.
+func spendBudget(tx *gorm.DB, campaign *DrawCampaign, table *RewardTable, outcome *RewardOutcome, now time.Time) (bool, error) {
+	cost, err := rewardCost(tx, outcome, now)
+	if err != nil {
+		return false, err
+	}
+
+	result := tx.Model(&DrawCampaign{}).
+		Where("id = ? AND exhausted_at IS NULL AND spent + ? <= budget", campaign.ID, cost).
+		Update("spent", gorm.Expr("spent + ?", cost))
+	if result.Error != nil {
+		return false, fmt.Errorf("failed to update campaign budget: %w", result.Error)
+	}
+
+	current, err := GetCampaign(tx, campaign.ID)
+	if err != nil {
+		return false, err
+	}
+	if current.ExhaustedAt != nil {
+		return false, ErrCampaignBudgetExhausted
+	}
+	cheapest, err := cheapestCost(tx, table, now)
+	if err != nil {
+		return false, err
+	}
+	if cheapest > 0 && current.Budget-current.Spent < cheapest {
+		if err := tx.Model(current).Update("exhausted_at", now).Error; err != nil {
+			return false, fmt.Errorf("failed to close campaign: %w", err)
+		}
+	}
+	return result.RowsAffected > 0, nil
+}
+
+// cheapestCost returns the lowest cost of the table's outcomes that cost
+// something, or zero if none does.
+func cheapestCost(tx *gorm.DB, table *RewardTable, now time.Time) (int, error) {
+	cheapest := 0
+	for i := range table.Outcomes {
+		cost, err := rewardCost(tx, &table.Outcomes[i], now)
+		if err != nil {
+			return 0, err
+		}
+		if cost > 0 && (cheapest == 0 || cost < cheapest) {
+			cheapest = cost
+		}
+	}
+	return cheapest, nil
+}
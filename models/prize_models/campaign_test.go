@@ -0,0 +1,214 @@
+package prize_models_test
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"gorm.io/gorm"
+	"xy.com/mysite/models/prize_models"
+	"xy.com/mysite/models/user_models"
+)
+
+func createCampaign(t *testing.T, db *gorm.DB, campaign *prize_models.DrawCampaign) *prize_models.DrawCampaign {
+	if campaign.StartsAt.IsZero() {
+		campaign.StartsAt = time.Now().Add(-time.Hour)
+	}
+	if campaign.EndsAt.IsZero() {
+		campaign.EndsAt = time.Now().Add(time.Hour)
+	}
+	campaign.Active = true
+	if err := prize_models.CreateCampaign(db, campaign); err != nil {
+		t.Fatal(err)
+	}
+	return campaign
+}
+
+func TestCampaignRules(t *testing.T) {
+	db := setupPointsDB(t)
+	engine := prize_models.NewDrawEngine(1)
+	table := createPointsTable(t, db)
+	fetchDrawSeeds(t, db, engine, 1, 2)
+
+	_, err := engine.DrawInCampaign(db, 1, 99)
+	assert.ErrorIs(t, err, prize_models.ErrCampaignNotFound)
+
+	// Outside the schedule
+	future := createCampaign(t, db, &prize_models.DrawCampaign{
+		Name:          "future",
+		RewardTableID: table.ID,
+		StartsAt:      time.Now().Add(time.Hour),
+		EndsAt:        time.Now().Add(2 * time.Hour),
+		Budget:        10000,
+	})
+	_, err = engine.DrawInCampaign(db, 1, future.ID)
+	assert.ErrorIs(t, err, prize_models.ErrCampaignNotRunning)
+
+	// Daily limit
+	limited := createCampaign(t, db, &prize_models.DrawCampaign{
+		Name:          "limited",
+		RewardTableID: table.ID,
+		Budget:        10000,
+		DailyLimit:    2,
+	})
+	for i := 0; i < 2; i++ {
+		record, err := engine.DrawInCampaign(db, 1, limited.ID)
+		assert.NoError(t, err)
+		assert.Equal(t, limited.ID, record.CampaignID)
+	}
+	_, err = engine.DrawInCampaign(db, 1, limited.ID)
+	assert.ErrorIs(t, err, prize_models.ErrDailyDrawLimitReached)
+	_, err = engine.DrawInCampaign(db, 2, limited.ID)
+	assert.NoError(t, err)
+
+	// Segments
+	vip := createCampaign(t, db, &prize_models.DrawCampaign{
+		Name:          "vip",
+		RewardTableID: table.ID,
+		Budget:        10000,
+		Segments:      "vip, gold",
+	})
+	assert.Equal(t, "vip,gold", vip.Segments)
+	_, err = engine.DrawInCampaign(db, 1, vip.ID)
+	assert.ErrorIs(t, err, prize_models.ErrNotEligible)
+	assert.NoError(t, user_models.AddUserToSegment(db, 1, "gold"))
+	_, err = engine.DrawInCampaign(db, 1, vip.ID)
+	assert.NoError(t, err)
+
+	running, err := prize_models.GetRunningCampaigns(db, 3, time.Now())
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(running))
+	assert.Equal(t, limited.ID, running[0].ID)
+
+	// Invalid settings
+	err = prize_models.CreateCampaign(db, &prize_models.DrawCampaign{Name: "broken", RewardTableID: table.ID, Budget: 100})
+	assert.ErrorIs(t, err, prize_models.ErrInvalidCampaign)
+}
+
+func TestCampaignBudget(t *testing.T) {
+	db := setupPointsDB(t)
+	engine := prize_models.NewDrawEngine(1)
+	table := createPointsTable(t, db)
+	fetchDrawSeeds(t, db, engine, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10)
+
+	// Room for exactly three draws of 1000 points
+	campaign := createCampaign(t, db, &prize_models.DrawCampaign{
+		Name:          "budget",
+		RewardTableID: table.ID,
+		Budget:        3500,
+	})
+
+	var (
+		wg        sync.WaitGroup
+		mu        sync.Mutex
+		succeeded int
+	)
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func(userID uint) {
+			defer wg.Done()
+			_, err := engine.DrawInCampaign(db, userID, campaign.ID)
+			mu.Lock()
+			defer mu.Unlock()
+			if err != nil {
+				assert.ErrorIs(t, err, prize_models.ErrCampaignBudgetExhausted)
+				return
+			}
+			succeeded++
+		}(uint(i + 1))
+	}
+	wg.Wait()
+
+	assert.Equal(t, 3, succeeded)
+
+	campaign, err := prize_models.GetCampaign(db, campaign.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, 3000, campaign.Spent)
+	assert.NotNil(t, campaign.ExhaustedAt)
+
+	var awarded int64
+	db.Model(&prize_models.PointsSystem{}).Select("COALESCE(SUM(points), 0)").Scan(&awarded)
+	assert.Equal(t, int64(3000), awarded)
+
+	// Raising the budget reopens the campaign
+	campaign.Budget = 4000
+	assert.NoError(t, prize_models.UpdateCampaign(db, campaign))
+	assert.Nil(t, campaign.ExhaustedAt)
+	_, err = engine.DrawInCampaign(db, 1, campaign.ID)
+	assert.NoError(t, err)
+
+	// Spending the whole budget closes the campaign straight away
+	campaign, _ = prize_models.GetCampaign(db, campaign.ID)
+	assert.NotNil(t, campaign.ExhaustedAt)
+}
+
+func TestCampaignBudgetOverBudgetOutcomes(t *testing.T) {
+	db := setupPointsDB(t)
+	engine := prize_models.NewDrawEngine(1)
+	table := &prize_models.RewardTable{
+		Name:   "mixed",
+		Active: true,
+		Outcomes: []prize_models.RewardOutcome{
+			{Kind: prize_models.OutcomePoints, Amount: 1000, Weight: 3},
+			{Kind: prize_models.OutcomePoints, Amount: 100, Weight: 1},
+			{Kind: prize_models.OutcomeNothing, Weight: 1},
+		},
+	}
+	assert.NoError(t, prize_models.CreateRewardTable(db, table))
+	campaign := createCampaign(t, db, &prize_models.DrawCampaign{
+		Name:          "mixed",
+		RewardTableID: table.ID,
+		Budget:        1550,
+		DailyLimit:    100,
+	})
+
+	assert.NoError(t, prize_models.CreateRedemptionCode(db, &prize_models.RedemptionCode{Code: "tokens", MaxUses: 100}))
+	fetchDrawSeeds(t, db, engine, 1)
+
+	// Outcomes the budget cannot pay for award nothing, but leave the campaign
+	// running while the cheaper outcome is still affordable
+	draws, overBudget, awarded := 0, 0, 0
+	for i := 0; i < 100; i++ {
+		record, err := engine.DrawWithRedemptionCode(db, 1, campaign.ID, "tokens")
+		if err != nil {
+			assert.ErrorIs(t, err, prize_models.ErrCampaignBudgetExhausted)
+			break
+		}
+		draws++
+		awarded += record.Amount
+		current, err := prize_models.GetCampaign(db, campaign.ID)
+		assert.NoError(t, err)
+		assert.Equal(t, awarded, current.Spent)
+		assert.Equal(t, current.Budget-current.Spent < 100, current.ExhaustedAt != nil)
+
+		if record.OverBudget {
+			overBudget++
+			assert.Equal(t, prize_models.OutcomeNothing, record.Kind)
+			assert.Equal(t, 0, record.Amount)
+
+			// The rolled outcome is kept, so the draw still verifies
+			_, _, err := engine.RotateDrawSeed(db, 1, "")
+			assert.NoError(t, err)
+			verification, err := prize_models.VerifyDraw(db, 1, record.ID)
+			assert.NoError(t, err)
+			assert.True(t, verification.Valid)
+			assert.Equal(t, table.Outcomes[0].ID, verification.OutcomeID)
+		}
+	}
+	assert.Greater(t, overBudget, 0)
+	assert.Less(t, 1550-awarded, 100)
+
+	// Over budget draws are draws all the same, they take a code use and a daily draw
+	code, uses, err := prize_models.GetRedemptionCodeByCode(db, "tokens")
+	assert.NoError(t, err)
+	assert.Equal(t, draws, code.Uses)
+	assert.Equal(t, draws, len(uses))
+	var daily prize_models.CampaignDailyDraws
+	assert.NoError(t, db.Where("campaign_id = ? AND user_id = ?", campaign.ID, 1).First(&daily).Error)
+	assert.Equal(t, draws, daily.Draws)
+
+	points, err := prize_models.GetPointsSystem(db, 1)
+	assert.NoError(t, err)
+	assert.Equal(t, awarded, points.Points)
+}
@@ -0,0 +1,283 @@
+package prize_models
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"xy.com/mysite/models"
+)
+
+const dayFormat = "2006-01-02"
+
+var (
+	ErrAlreadyCheckedIn    = models.NewError(models.KindConflict, "already_checked_in", "already checked in today")
+	ErrInvalidTimeZone     = models.NewError(models.KindInvalid, "invalid_time_zone", "invalid time zone")
+	ErrInvalidStreakReward = models.NewError(models.KindInvalid, "invalid_streak_reward", "invalid streak reward")
+)
+
+// TimeZoneChangeDays is how many days users check in by a time zone before they
+// can switch to another, so that hopping between zones cannot squeeze in extra days.
+var TimeZoneChangeDays = 30
+
+// DefaultStreakRewards are used until an admin configures streak rewards.
+var DefaultStreakRewards = []StreakReward{
+	{Streak: 1, Points: 10},
+	{Streak: 3, Points: 20},
+	{Streak: 7, Points: 50},
+}
+
+// DailyCheckIn records a user's check-in on a day of their time zone.
+type DailyCheckIn struct {
+	ID        uint      `json:"id" gorm:"primaryKey"`
+	CreatedAt time.Time `json:"created_at"`
+	UserID    uint      `json:"user_id" gorm:"not null;uniqueIndex:idx_check_in_user_day"`
+	Day       string    `json:"day" gorm:"size:10;not null;uniqueIndex:idx_check_in_user_day"` // YYYY-MM-DD in TimeZone
+	TimeZone  string    `json:"time_zone" gorm:"size:64"`
+	Streak    int       `json:"streak"` // Consecutive days checked in, including this one
+	Points    int       `json:"points"` // Streak reward credited for this check-in
+}
+
+// StreakReward is the points a check-in earns once the streak reaches Streak
+// days, until a longer streak's reward applies.
+type StreakReward struct {
+	Streak int `json:"streak" gorm:"primaryKey;autoIncrement:false"`
+	Points int `json:"points"`
+}
+
+// CheckInResult is a check-in and the achievements it unlocked.
+type CheckInResult struct {
+	CheckIn      *DailyCheckIn     `json:"check_in"`
+	Achievements []UserAchievement `json:"achievements"`
+}
+
+// CheckInStatus is the user's streak as seen on a given day.
+type CheckInStatus struct {
+	Day            string `json:"day"`
+	TimeZone       string `json:"time_zone"`
+	CheckedInToday bool   `json:"checked_in_today"`
+	Streak         int    `json:"streak"`      // Zero once a day has been missed
+	NextReward     int    `json:"next_reward"` // Points the next check-in earns
+}
+
+// loadTimeZone returns the named IANA time zone, UTC if name is empty.
+func loadTimeZone(name string) (*time.Location, error) {
+	if name == "" {
+		return time.UTC, nil
+	}
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		return nil, fmt.Errorf("%w %q", ErrInvalidTimeZone, name)
+	}
+	return loc, nil
+}
+
+// previousDay returns the day before day, both formatted as YYYY-MM-DD.
+func previousDay(day string) string {
+	t, _ := time.Parse(dayFormat, day)
+	return t.AddDate(0, 0, -1).Format(dayFormat)
+}
+
+// GetStreakRewards retrieves the streak rewards ordered by streak, or the defaults if none are configured.
+func GetStreakRewards(db *gorm.DB) ([]StreakReward, error) {
+	var rewards []StreakReward
+	if err := db.Order("streak").Find(&rewards).Error; err != nil {
+		return nil, err
+	}
+	if len(rewards) == 0 {
+		rewards = append(rewards, DefaultStreakRewards...)
+	}
+	return rewards, nil
+}
+
+// SetStreakRewards replaces the streak rewards.
+func SetStreakRewards(db *gorm.DB, rewards []StreakReward) error {
+	seen := make(map[int]bool)
+	for _, reward := range rewards {
+		if reward.Streak < 1 || reward.Points < 0 {
+			return fmt.Errorf("%w: streaks must be at least 1 and points not negative", ErrInvalidStreakReward)
+		}
+		if seen[reward.Streak] {
+			return fmt.Errorf("%w: duplicate streak %d", ErrInvalidStreakReward, reward.Streak)
+		}
+		seen[reward.Streak] = true
+	}
+
+	return db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("1 = 1").Delete(&StreakReward{}).Error; err != nil {
+			return err
+		}
+		if len(rewards) == 0 {
+			return nil
+		}
+		return tx.Create(&rewards).Error
+	})
+}
+
+// streakRewardFor returns the points for a check-in on the given streak day.
+func streakRewardFor(rewards []StreakReward, streak int) int {
+	sort.Slice(rewards, func(i, j int) bool { return rewards[i].Streak < rewards[j].Streak })
+	points := 0
+	for _, reward := range rewards {
+		if reward.Streak > streak {
+			break
+		}
+		points = reward.Points
+	}
+	return points
+}
+
+// lastCheckIn retrieves the user's latest check-in, nil if there is none.
+func lastCheckIn(db *gorm.DB, userID uint) (*DailyCheckIn, error) {
+	var checkIn DailyCheckIn
+	err := db.Where("user_id = ?", userID).Order("day desc").First(&checkIn).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &checkIn, nil
+}
+
+// checkInTimeZone returns the time zone the user checks in by given their last
+// check-in: the zone of that check-in, unless they have checked in by it for
+// TimeZoneChangeDays and ask for another. Users who have not checked in yet
+// get the zone they ask for, UTC if they do not.
+func checkInTimeZone(db *gorm.DB, userID uint, requested string, last *DailyCheckIn, now time.Time) (*time.Location, error) {
+	loc, err := loadTimeZone(requested)
+	if err != nil || last == nil {
+		return loc, err
+	}
+	current, err := loadTimeZone(last.TimeZone)
+	if err != nil || requested == "" || loc.String() == current.String() {
+		return current, err
+	}
+
+	// Find the first day checked in by the current zone since the last switch
+	var switched string
+	err = db.Model(&DailyCheckIn{}).Where("user_id = ? AND time_zone <> ?", userID, last.TimeZone).
+		Select("COALESCE(MAX(day), '')").Scan(&switched).Error
+	if err != nil {
+		return nil, err
+	}
+	var since string
+	err = db.Model(&DailyCheckIn{}).Where("user_id = ? AND time_zone = ? AND day > ?", userID, last.TimeZone, switched).
+		Select("MIN(day)").Scan(&since).Error
+	if err != nil {
+		return nil, err
+	}
+	if since > now.In(current).AddDate(0, 0, -TimeZoneChangeDays).Format(dayFormat) {
+		return current, nil
+	}
+	return loc, nil
+}
+
+// CheckIn checks the user in for the day it is at now in their time zone, extending
+// their streak if they checked in the day before, and credits the streak reward.
+// Users can check in once a day; a day earlier than their last check-in, as seen
+// from another time zone, counts as already checked in. The time zone is kept
+// from one check-in to the next, see checkInTimeZone.
+func CheckIn(db *gorm.DB, userID uint, timeZone string, now time.Time) (*CheckInResult, error) {
+	if _, err := loadTimeZone(timeZone); err != nil {
+		return nil, err
+	}
+
+	result := &CheckInResult{}
+	err := db.Transaction(func(tx *gorm.DB) error {
+		last, err := lastCheckIn(tx, userID)
+		if err != nil {
+			return err
+		}
+		loc, err := checkInTimeZone(tx, userID, timeZone, last, now)
+		if err != nil {
+			return err
+		}
+		day := now.In(loc).Format(dayFormat)
+		if last != nil && last.Day >= day {
+			return ErrAlreadyCheckedIn
+		}
+
+		streak := 1
+		if last != nil && last.Day == previousDay(day) {
+			streak = last.Streak + 1
+		}
+		rewards, err := GetStreakRewards(tx)
+		if err != nil {
+			return err
+		}
+
+		checkIn := &DailyCheckIn{
+			UserID:   userID,
+			Day:      day,
+			TimeZone: loc.String(),
+			Streak:   streak,
+			Points:   streakRewardFor(rewards, streak),
+		}
+		created := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(checkIn)
+		if created.Error != nil {
+			return fmt.Errorf("failed to check in: %w", created.Error)
+		}
+		if created.RowsAffected == 0 {
+			return ErrAlreadyCheckedIn
+		}
+		result.CheckIn = checkIn
+
+		if checkIn.Points > 0 {
+			err := CreditPoints(tx, userID, checkIn.Points, LedgerReasonCheckIn, fmt.Sprintf("check_in:%d", checkIn.ID))
+			if err != nil {
+				return err
+			}
+		}
+
+		// The streak event tracks the current streak, not the number of check-ins
+		progress := AchievementProgress{UserID: userID, Event: EventCheckInStreak, Count: streak}
+		err = tx.Clauses(clause.OnConflict{
+			Columns:   []clause.Column{{Name: "user_id"}, {Name: "event"}},
+			DoUpdates: clause.AssignmentColumns([]string{"count"}),
+		}).Create(&progress).Error
+		if err != nil {
+			return fmt.Errorf("failed to record streak: %w", err)
+		}
+		result.Achievements, err = unlockAchievements(tx, userID, EventCheckInStreak, streak)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// GetCheckInStatus retrieves the user's streak for the day it is at now in the
+// time zone they would check in by.
+func GetCheckInStatus(db *gorm.DB, userID uint, timeZone string, now time.Time) (*CheckInStatus, error) {
+	last, err := lastCheckIn(db, userID)
+	if err != nil {
+		return nil, err
+	}
+	loc, err := checkInTimeZone(db, userID, timeZone, last, now)
+	if err != nil {
+		return nil, err
+	}
+	status := &CheckInStatus{Day: now.In(loc).Format(dayFormat), TimeZone: loc.String()}
+
+	if last != nil {
+		switch {
+		case last.Day >= status.Day:
+			status.CheckedInToday = true
+			status.Streak = last.Streak
+		case last.Day == previousDay(status.Day):
+			status.Streak = last.Streak
+		}
+	}
+
+	rewards, err := GetStreakRewards(db)
+	if err != nil {
+		return nil, err
+	}
+	status.NextReward = streakRewardFor(rewards, status.Streak+1)
+	return status, nil
+}
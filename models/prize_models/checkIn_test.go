@@ -0,0 +1,139 @@
+package prize_models_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"xy.com/mysite/models/prize_models"
+)
+
+func TestCheckInStreak(t *testing.T) {
+	db := setupPointsDB(t)
+	userID := uint(1)
+	day := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+
+	result, err := prize_models.CheckIn(db, userID, "", day)
+	assert.NoError(t, err)
+	assert.Equal(t, "2024-03-01", result.CheckIn.Day)
+	assert.Equal(t, 1, result.CheckIn.Streak)
+	assert.Equal(t, 10, result.CheckIn.Points)
+
+	_, err = prize_models.CheckIn(db, userID, "", day.Add(time.Hour))
+	assert.ErrorIs(t, err, prize_models.ErrAlreadyCheckedIn)
+
+	// Default rewards: 10 points, 20 from the third day, 50 from the seventh
+	points := []int{10, 10, 20, 20, 20, 20, 50, 50}
+	for i := 1; i < len(points); i++ {
+		result, err = prize_models.CheckIn(db, userID, "", day.AddDate(0, 0, i))
+		assert.NoError(t, err)
+		assert.Equal(t, i+1, result.CheckIn.Streak)
+		assert.Equal(t, points[i], result.CheckIn.Points)
+	}
+
+	// Missing a day starts over
+	result, err = prize_models.CheckIn(db, userID, "", day.AddDate(0, 0, 10))
+	assert.NoError(t, err)
+	assert.Equal(t, 1, result.CheckIn.Streak)
+
+	ps, _ := prize_models.GetPointsSystem(db, userID)
+	assert.Equal(t, 210, ps.Points)
+	check, err := prize_models.VerifyBalance(db, userID)
+	assert.NoError(t, err)
+	assert.True(t, check.Consistent)
+}
+
+func TestCheckInTimeZones(t *testing.T) {
+	db := setupPointsDB(t)
+	userID := uint(1)
+
+	_, err := prize_models.CheckIn(db, userID, "Mars/Olympus", time.Now())
+	assert.ErrorIs(t, err, prize_models.ErrInvalidTimeZone)
+
+	// 23:30 UTC on March 1st is already March 2nd in Tokyo
+	now := time.Date(2024, 3, 1, 23, 30, 0, 0, time.UTC)
+	result, err := prize_models.CheckIn(db, userID, "Asia/Tokyo", now)
+	assert.NoError(t, err)
+	assert.Equal(t, "2024-03-02", result.CheckIn.Day)
+
+	// Switching to a zone where it is still March 1st does not allow a second check-in
+	_, err = prize_models.CheckIn(db, userID, "", now.Add(time.Minute))
+	assert.ErrorIs(t, err, prize_models.ErrAlreadyCheckedIn)
+
+	status, err := prize_models.GetCheckInStatus(db, userID, "Asia/Tokyo", now.Add(12*time.Hour))
+	assert.NoError(t, err)
+	assert.Equal(t, "2024-03-02", status.Day)
+	assert.True(t, status.CheckedInToday)
+	assert.Equal(t, 1, status.Streak)
+
+	// The next Tokyo day continues the streak
+	status, err = prize_models.GetCheckInStatus(db, userID, "Asia/Tokyo", now.Add(24*time.Hour))
+	assert.NoError(t, err)
+	assert.False(t, status.CheckedInToday)
+	assert.Equal(t, 1, status.Streak)
+	assert.Equal(t, 10, status.NextReward)
+	result, err = prize_models.CheckIn(db, userID, "Asia/Tokyo", now.Add(24*time.Hour))
+	assert.NoError(t, err)
+	assert.Equal(t, 2, result.CheckIn.Streak)
+
+	status, err = prize_models.GetCheckInStatus(db, userID, "Asia/Tokyo", now.Add(72*time.Hour))
+	assert.NoError(t, err)
+	assert.Equal(t, 0, status.Streak)
+}
+
+func TestCheckInKeepsTimeZone(t *testing.T) {
+	db := setupPointsDB(t)
+	userID := uint(1)
+
+	// 10:00 UTC on March 1st is still February 29th at UTC-12
+	now := time.Date(2024, 3, 1, 10, 0, 0, 0, time.UTC)
+	result, err := prize_models.CheckIn(db, userID, "Etc/GMT+12", now)
+	assert.NoError(t, err)
+	assert.Equal(t, "2024-02-29", result.CheckIn.Day)
+
+	// Hopping to UTC+14, where it is already March 2nd, is ignored
+	_, err = prize_models.CheckIn(db, userID, "Pacific/Kiritimati", now.Add(time.Minute))
+	assert.ErrorIs(t, err, prize_models.ErrAlreadyCheckedIn)
+	status, err := prize_models.GetCheckInStatus(db, userID, "Pacific/Kiritimati", now.Add(time.Minute))
+	assert.NoError(t, err)
+	assert.Equal(t, "Etc/GMT+12", status.TimeZone)
+	assert.True(t, status.CheckedInToday)
+
+	// Checking in without a time zone keeps the user's
+	result, err = prize_models.CheckIn(db, userID, "", now.Add(24*time.Hour))
+	assert.NoError(t, err)
+	assert.Equal(t, "Etc/GMT+12", result.CheckIn.TimeZone)
+	assert.Equal(t, 2, result.CheckIn.Streak)
+
+	// The time zone can change once it has been kept long enough
+	later := now.Add(time.Duration(prize_models.TimeZoneChangeDays) * 24 * time.Hour)
+	result, err = prize_models.CheckIn(db, userID, "Asia/Tokyo", later)
+	assert.NoError(t, err)
+	assert.Equal(t, "Asia/Tokyo", result.CheckIn.TimeZone)
+
+	// And is then kept again
+	result, err = prize_models.CheckIn(db, userID, "Etc/GMT+12", later.Add(24*time.Hour))
+	assert.NoError(t, err)
+	assert.Equal(t, "Asia/Tokyo", result.CheckIn.TimeZone)
+}
+
+func TestStreakRewards(t *testing.T) {
+	db := setupPointsDB(t)
+
+	rewards, err := prize_models.GetStreakRewards(db)
+	assert.NoError(t, err)
+	assert.Equal(t, prize_models.DefaultStreakRewards, rewards)
+
+	err = prize_models.SetStreakRewards(db, []prize_models.StreakReward{{Streak: 0, Points: 5}})
+	assert.ErrorIs(t, err, prize_models.ErrInvalidStreakReward)
+	err = prize_models.SetStreakRewards(db, []prize_models.StreakReward{{Streak: 2, Points: 5}, {Streak: 2, Points: 6}})
+	assert.ErrorIs(t, err, prize_models.ErrInvalidStreakReward)
+
+	assert.NoError(t, prize_models.SetStreakRewards(db, []prize_models.StreakReward{{Streak: 2, Points: 30}, {Streak: 1, Points: 5}}))
+	day := time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)
+	for i, want := range []int{5, 30, 30} {
+		result, err := prize_models.CheckIn(db, 1, "", day.AddDate(0, 0, i))
+		assert.NoError(t, err)
+		assert.Equal(t, want, result.CheckIn.Points)
+	}
+}
@@ -0,0 +1,488 @@
+package prize_models
+
+import (
+	"crypto/rand"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"strings"
+	"unicode/utf8"
+
+	"gorm.io/gorm"
+	"xy.com/mysite/models"
+)
+
+// Code batch kinds: prize codes handed out on exchange, or redemption codes that grant draws
+const (
+	CodeKindPrize      = "prize"
+	CodeKindRedemption = "redemption"
+)
+
+// Code batch sources
+const (
+	CodeSourceGenerated = "generated"
+	CodeSourceImported  = "imported"
+)
+
+const (
+	// DefaultCodeAlphabet leaves out characters that are easily confused, such as 0/O and 1/I.
+	DefaultCodeAlphabet = "23456789ABCDEFGHJKLMNPQRSTUVWXYZ"
+	DefaultCodeLength   = 12
+	MaxCodeBatchSize    = 10000
+	MaxCodeLength       = 64 // Including the prefix and check character
+
+	// codeInsertBatch is the number of codes inserted per statement.
+	codeInsertBatch = 500
+	// maxGenerateAttempts bounds the retries when generated codes collide with existing ones.
+	maxGenerateAttempts = 10
+)
+
+var (
+	ErrInvalidCodeSpec   = models.NewError(models.KindInvalid, "invalid_code_spec", "invalid code spec")
+	ErrInvalidCodeKind   = models.NewError(models.KindInvalid, "invalid_code_kind", "invalid code kind")
+	ErrCodeBatchNotFound = models.NewError(models.KindNotFound, "code_batch_not_found", "code batch not found")
+
+	// errCodesNotImported is returned from the import transaction to roll it back.
+	errCodesNotImported = errors.New("codes not imported")
+)
+
+// CodeBatch records a set of codes generated or imported together, so every
+// code can be traced back to how and when it was created.
+type CodeBatch struct {
+	gorm.Model
+	Kind       string `json:"kind" gorm:"size:16;not null"`
+	PrizeID    uint   `json:"prize_id,omitempty" gorm:"index"` // Pool of prize codes, see AddPrizeCodes
+	Source     string `json:"source" gorm:"size:16;not null"`
+	Count      int    `json:"count"`
+	Alphabet   string `json:"alphabet,omitempty"`
+	Length     int    `json:"length,omitempty"`
+	Prefix     string `json:"prefix,omitempty"`
+	CheckDigit bool   `json:"check_digit"`
+	Note       string `json:"note"`
+}
+
+// CodeSpec describes the codes to generate. A code is the prefix followed by
+// Length random characters from the alphabet and, optionally, a Luhn mod N
+// check character over the random part.
+type CodeSpec struct {
+	Count      int    `json:"count"`
+	Length     int    `json:"length"`
+	Alphabet   string `json:"alphabet"`
+	Prefix     string `json:"prefix"`
+	CheckDigit bool   `json:"check_digit"`
+}
+
+// CodeImportRowError describes why a row of an imported code file was rejected.
+// Row is the 1-based line number in the file, counting the header.
+type CodeImportRowError struct {
+	Row    int      `json:"row"`
+	Errors []string `json:"errors"`
+}
+
+// CodeImportResult summarises a code import.
+type CodeImportResult struct {
+	DryRun    bool                 `json:"dry_run"`
+	Rows      int                  `json:"rows"`
+	Imported  int                  `json:"imported"`
+	BatchID   uint                 `json:"batch_id,omitempty"`
+	RowErrors []CodeImportRowError `json:"row_errors,omitempty"`
+}
+
+// normalize fills in defaults and validates the spec.
+func (s *CodeSpec) normalize() error {
+	if s.Alphabet == "" {
+		s.Alphabet = DefaultCodeAlphabet
+	}
+	if s.Length == 0 {
+		s.Length = DefaultCodeLength
+	}
+
+	if s.Count < 1 || s.Count > MaxCodeBatchSize {
+		return fmt.Errorf("%w: count must be between 1 and %d", ErrInvalidCodeSpec, MaxCodeBatchSize)
+	}
+	if s.Length < 4 || s.Length > 64 {
+		return fmt.Errorf("%w: length must be between 4 and 64", ErrInvalidCodeSpec)
+	}
+	if utf8.RuneCountInString(s.Prefix) > 16 {
+		return fmt.Errorf("%w: prefix must be at most 16 characters", ErrInvalidCodeSpec)
+	}
+	total := utf8.RuneCountInString(s.Prefix) + s.Length
+	if s.CheckDigit {
+		total++
+	}
+	if total > MaxCodeLength {
+		return fmt.Errorf("%w: codes would be %d characters, at most %d fit", ErrInvalidCodeSpec, total, MaxCodeLength)
+	}
+
+	alphabet := []rune(s.Alphabet)
+	if len(alphabet) < 2 || len(alphabet) > 64 {
+		return fmt.Errorf("%w: alphabet must have between 2 and 64 characters", ErrInvalidCodeSpec)
+	}
+	seen := make(map[rune]bool)
+	for _, r := range alphabet {
+		if seen[r] {
+			return fmt.Errorf("%w: alphabet repeats %q", ErrInvalidCodeSpec, r)
+		}
+		seen[r] = true
+	}
+	return nil
+}
+
+// generate returns a cryptographically random code.
+func (s *CodeSpec) generate() (string, error) {
+	alphabet := []rune(s.Alphabet)
+	max := big.NewInt(int64(len(alphabet)))
+
+	body := make([]rune, s.Length)
+	for i := range body {
+		n, err := rand.Int(rand.Reader, max)
+		if err != nil {
+			return "", fmt.Errorf("failed to generate code: %w", err)
+		}
+		body[i] = alphabet[n.Int64()]
+	}
+
+	code := string(body)
+	if s.CheckDigit {
+		check, err := LuhnCheckCharacter(code, s.Alphabet)
+		if err != nil {
+			return "", err
+		}
+		code += string(check)
+	}
+	return s.Prefix + code, nil
+}
+
+// LuhnCheckCharacter computes the Luhn mod N check character of input over the alphabet.
+func LuhnCheckCharacter(input, alphabet string) (rune, error) {
+	chars := []rune(alphabet)
+	sum, err := luhnSum([]rune(input), chars, 2)
+	if err != nil {
+		return 0, err
+	}
+	n := len(chars)
+	return chars[(n-sum%n)%n], nil
+}
+
+// ValidLuhnCode reports whether the last character of code is the correct Luhn mod N
+// check character for the rest of it. The prefix is not part of the checksum.
+func ValidLuhnCode(code, prefix, alphabet string) bool {
+	if !strings.HasPrefix(code, prefix) {
+		return false
+	}
+	body := []rune(strings.TrimPrefix(code, prefix))
+	if len(body) < 2 {
+		return false
+	}
+	sum, err := luhnSum(body, []rune(alphabet), 1)
+	return err == nil && sum%len([]rune(alphabet)) == 0
+}
+
+// luhnSum adds up the Luhn mod N addends of input from right to left, starting with the given factor.
+func luhnSum(input []rune, alphabet []rune, factor int) (int, error) {
+	n := len(alphabet)
+	index := make(map[rune]int, n)
+	for i, r := range alphabet {
+		index[r] = i
+	}
+
+	sum := 0
+	for i := len(input) - 1; i >= 0; i-- {
+		value, ok := index[input[i]]
+		if !ok {
+			return 0, fmt.Errorf("%w: %q is not in the alphabet", ErrInvalidCodeSpec, input[i])
+		}
+		addend := factor * value
+		sum += addend/n + addend%n
+		factor = 3 - factor // alternate between 2 and 1
+	}
+	return sum, nil
+}
+
+// validateCodeTarget checks the kind and, for prize codes, the prize.
+func validateCodeTarget(db *gorm.DB, kind string, prizeID uint) error {
+	switch kind {
+	case CodeKindPrize:
+		_, err := GetPrizeByID(db, prizeID)
+		return err
+	case CodeKindRedemption:
+		if prizeID != 0 {
+			return fmt.Errorf("%w: redemption codes do not belong to a prize", ErrInvalidCodeKind)
+		}
+		return nil
+	}
+	return fmt.Errorf("%w: %q, expected %s or %s", ErrInvalidCodeKind, kind, CodeKindPrize, CodeKindRedemption)
+}
+
+// existingCodes returns which of the codes are already stored for the kind.
+func existingCodes(db *gorm.DB, kind string, codes []string) (map[string]bool, error) {
+	var model interface{} = &Code{}
+	if kind == CodeKindRedemption {
+		model = &RedemptionCode{}
+	}
+
+	existing := make(map[string]bool)
+	for start := 0; start < len(codes); start += codeInsertBatch {
+		end := start + codeInsertBatch
+		if end > len(codes) {
+			end = len(codes)
+		}
+		var found []string
+		if err := db.Unscoped().Model(model).Where("code IN ?", codes[start:end]).Pluck("code", &found).Error; err != nil {
+			return nil, err
+		}
+		for _, code := range found {
+			existing[code] = true
+		}
+	}
+	return existing, nil
+}
+
+// insertCodes stores the codes of a batch.
+func insertCodes(tx *gorm.DB, batch *CodeBatch, codes []string) error {
+	var err error
+	if batch.Kind == CodeKindRedemption {
+		rows := make([]RedemptionCode, len(codes))
+		for i, code := range codes {
+			rows[i] = RedemptionCode{Code: code, BatchID: batch.ID}
+		}
+		err = tx.CreateInBatches(rows, codeInsertBatch).Error
+	} else {
+		rows := make([]Code, len(codes))
+		for i, code := range codes {
+			rows[i] = Code{Code: code, PrizeID: batch.PrizeID, BatchID: batch.ID}
+		}
+		err = tx.CreateInBatches(rows, codeInsertBatch).Error
+	}
+	if err != nil {
+		return fmt.Errorf("failed to add codes: %w", err)
+	}
+	return nil
+}
+
+// GenerateCodeBatch generates spec.Count new unique codes of the kind and stores them as one batch.
+func GenerateCodeBatch(db *gorm.DB, kind string, prizeID uint, spec CodeSpec, note string) (*CodeBatch, error) {
+	if err := spec.normalize(); err != nil {
+		return nil, err
+	}
+	if err := validateCodeTarget(db, kind, prizeID); err != nil {
+		return nil, err
+	}
+
+	batch := &CodeBatch{
+		Kind:       kind,
+		PrizeID:    prizeID,
+		Source:     CodeSourceGenerated,
+		Count:      spec.Count,
+		Alphabet:   spec.Alphabet,
+		Length:     spec.Length,
+		Prefix:     spec.Prefix,
+		CheckDigit: spec.CheckDigit,
+		Note:       note,
+	}
+	err := db.Transaction(func(tx *gorm.DB) error {
+		codes := make([]string, 0, spec.Count)
+		unique := make(map[string]bool, spec.Count)
+		for attempt := 0; len(codes) < spec.Count; attempt++ {
+			if attempt == maxGenerateAttempts {
+				return fmt.Errorf("%w: too many collisions, use a longer code or a larger alphabet", ErrInvalidCodeSpec)
+			}
+
+			// Codes repeated within the batch are dropped and replaced on the next attempt
+			var candidates []string
+			for i := len(codes); i < spec.Count; i++ {
+				code, err := spec.generate()
+				if err != nil {
+					return err
+				}
+				if !unique[code] {
+					unique[code] = true
+					candidates = append(candidates, code)
+				}
+			}
+
+			existing, err := existingCodes(tx, kind, candidates)
+			if err != nil {
+				return err
+			}
+			for _, code := range candidates {
+				if !existing[code] {
+					codes = append(codes, code)
+				}
+			}
+		}
+
+		if err := tx.Create(batch).Error; err != nil {
+			return fmt.Errorf("failed to create code batch: %w", err)
+		}
+		return insertCodes(tx, batch, codes)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if kind == CodeKindPrize {
+		if _, err := CheckCodePool(db, prizeID); err != nil {
+			return nil, err
+		}
+	}
+	return batch, nil
+}
+
+// ImportCodeBatch reads codes of the kind from a CSV with a "code" column and
+// stores them as one batch. If any code is empty, repeated in the file or
+// already exists, nothing is imported and the per-row errors are returned in
+// the result. In dry-run mode nothing is written.
+func ImportCodeBatch(db *gorm.DB, kind string, prizeID uint, r io.Reader, note string, dryRun bool) (*CodeImportResult, error) {
+	if err := validateCodeTarget(db, kind, prizeID); err != nil {
+		return nil, err
+	}
+
+	reader := csv.NewReader(r)
+	reader.TrimLeadingSpace = true
+	reader.FieldsPerRecord = -1
+
+	header, err := reader.Read()
+	if err != nil {
+		return nil, fmt.Errorf("%w: failed to read csv header: %v", ErrInvalidCodeSpec, err)
+	}
+	column := -1
+	for i, name := range header {
+		if strings.ToLower(strings.TrimSpace(name)) == "code" {
+			column = i
+		}
+	}
+	if column < 0 {
+		return nil, fmt.Errorf("%w: csv header is missing required column \"code\"", ErrInvalidCodeSpec)
+	}
+
+	result := &CodeImportResult{DryRun: dryRun}
+	var codes []string
+	rows := make(map[string]int)
+	for {
+		record, err := reader.Read()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		result.Rows++
+		var parseErr *csv.ParseError
+		if errors.As(err, &parseErr) {
+			result.RowErrors = append(result.RowErrors, CodeImportRowError{Row: parseErr.StartLine, Errors: []string{parseErr.Err.Error()}})
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrInvalidCodeSpec, err)
+		}
+		line, _ := reader.FieldPos(0) // Blank lines are skipped, so count lines rather than records
+
+		var code string
+		if column < len(record) {
+			code = strings.TrimSpace(record[column])
+		}
+		switch {
+		case code == "":
+			result.RowErrors = append(result.RowErrors, CodeImportRowError{Row: line, Errors: []string{"code is required"}})
+		case utf8.RuneCountInString(code) > MaxCodeLength:
+			result.RowErrors = append(result.RowErrors, CodeImportRowError{Row: line, Errors: []string{fmt.Sprintf("code must be at most %d characters", MaxCodeLength)}})
+		case rows[code] != 0:
+			result.RowErrors = append(result.RowErrors, CodeImportRowError{Row: line, Errors: []string{fmt.Sprintf("duplicate of row %d", rows[code])}})
+		default:
+			rows[code] = line
+			codes = append(codes, code)
+		}
+	}
+
+	// Existing codes are checked in the transaction that inserts the batch,
+	// so a code added in between cannot slip past the check
+	batch := &CodeBatch{Kind: kind, PrizeID: prizeID, Source: CodeSourceImported, Count: len(codes), Note: note}
+	err = db.Transaction(func(tx *gorm.DB) error {
+		existing, err := existingCodes(tx, kind, codes)
+		if err != nil {
+			return err
+		}
+		for _, code := range codes {
+			if existing[code] {
+				result.RowErrors = append(result.RowErrors, CodeImportRowError{Row: rows[code], Errors: []string{"code already exists"}})
+			}
+		}
+		if len(result.RowErrors) > 0 || dryRun {
+			return errCodesNotImported
+		}
+		if len(codes) == 0 {
+			return fmt.Errorf("%w: csv file has no codes", ErrInvalidCodeSpec)
+		}
+
+		if err := tx.Create(batch).Error; err != nil {
+			return fmt.Errorf("failed to create code batch: %w", err)
+		}
+		return insertCodes(tx, batch, codes)
+	})
+	if errors.Is(err, errCodesNotImported) {
+		return result, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	result.Imported = len(codes)
+	result.BatchID = batch.ID
+
+	if kind == CodeKindPrize {
+		if _, err := CheckCodePool(db, prizeID); err != nil {
+			return nil, err
+		}
+	}
+	return result, nil
+}
+
+// GetCodeBatches retrieves a page of code batches, newest first, and their total number.
+func GetCodeBatches(db *gorm.DB, offset, limit int) ([]CodeBatch, int64, error) {
+	var total int64
+	if err := db.Model(&CodeBatch{}).Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+
+	var batches []CodeBatch
+	if err := db.Order("id desc").Offset(offset).Limit(limit).Find(&batches).Error; err != nil {
+		return nil, 0, err
+	}
+	return batches, total, nil
+}
+
+// GetCodeBatch retrieves a code batch by ID.
+func GetCodeBatch(db *gorm.DB, id uint) (*CodeBatch, error) {
+	var batch CodeBatch
+	if err := db.First(&batch, id).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrCodeBatchNotFound
+		}
+		return nil, err
+	}
+	return &batch, nil
+}
+
+// ExportCodeBatch calls fn for every code of the batch in the order they were created.
+func ExportCodeBatch(db *gorm.DB, batch *CodeBatch, fn func(code string, used bool) error) error {
+	if batch.Kind == CodeKindRedemption {
+		var rows []RedemptionCode
+		return db.Where("batch_id = ?", batch.ID).Order("id").FindInBatches(&rows, codeInsertBatch, func(tx *gorm.DB, _ int) error {
+			for _, row := range rows {
+				if err := fn(row.Code, row.Used); err != nil {
+					return err
+				}
+			}
+			return nil
+		}).Error
+	}
+
+	var rows []Code
+	return db.Where("batch_id = ?", batch.ID).Order("id").FindInBatches(&rows, codeInsertBatch, func(tx *gorm.DB, _ int) error {
+		for _, row := range rows {
+			if err := fn(row.Code, row.IsUsed); err != nil {
+				return err
+			}
+		}
+		return nil
+	}).Error
+}
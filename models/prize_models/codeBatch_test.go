@@ -0,0 +1,139 @@
+package prize_models_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"xy.com/mysite/models/prize_models"
+)
+
+func TestLuhnCheckCharacter(t *testing.T) {
+	check, err := prize_models.LuhnCheckCharacter("ABCD", prize_models.DefaultCodeAlphabet)
+	assert.NoError(t, err)
+	code := "X-ABCD" + string(check)
+	assert.True(t, prize_models.ValidLuhnCode(code, "X-", prize_models.DefaultCodeAlphabet))
+
+	// A single mistyped character is detected
+	assert.False(t, prize_models.ValidLuhnCode("X-ABCE"+string(check), "X-", prize_models.DefaultCodeAlphabet))
+	assert.False(t, prize_models.ValidLuhnCode("Y-ABCD"+string(check), "X-", prize_models.DefaultCodeAlphabet))
+
+	_, err = prize_models.LuhnCheckCharacter("AB0", prize_models.DefaultCodeAlphabet)
+	assert.ErrorIs(t, err, prize_models.ErrInvalidCodeSpec)
+}
+
+func TestGenerateCodeBatch(t *testing.T) {
+	db := setupPointsDB(t)
+	assert.NoError(t, prize_models.AddPrize(db, "Mug", 100))
+	prize, _ := prize_models.GetPrizeByName(db, "Mug")
+
+	spec := prize_models.CodeSpec{Count: 50, Length: 8, Prefix: "MUG-", CheckDigit: true}
+	batch, err := prize_models.GenerateCodeBatch(db, prize_models.CodeKindPrize, prize.ID, spec, "printed cards")
+	assert.NoError(t, err)
+	assert.Equal(t, prize_models.CodeSourceGenerated, batch.Source)
+	assert.Equal(t, prize_models.DefaultCodeAlphabet, batch.Alphabet)
+
+	var codes []string
+	err = prize_models.ExportCodeBatch(db, batch, func(code string, used bool) error {
+		assert.False(t, used)
+		codes = append(codes, code)
+		return nil
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, 50, len(codes))
+
+	unique := make(map[string]bool)
+	for _, code := range codes {
+		unique[code] = true
+		assert.Equal(t, len("MUG-")+8+1, len(code))
+		assert.True(t, prize_models.ValidLuhnCode(code, "MUG-", prize_models.DefaultCodeAlphabet))
+	}
+	assert.Equal(t, 50, len(unique))
+
+	status, err := prize_models.GetCodePoolStatus(db, prize.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(50), status.Remaining)
+
+	// Redemption codes are not tied to a prize
+	_, err = prize_models.GenerateCodeBatch(db, prize_models.CodeKindRedemption, 0, prize_models.CodeSpec{Count: 5}, "")
+	assert.NoError(t, err)
+	var redemptionCodes int64
+	db.Model(&prize_models.RedemptionCode{}).Count(&redemptionCodes)
+	assert.Equal(t, int64(5), redemptionCodes)
+
+	_, err = prize_models.GenerateCodeBatch(db, prize_models.CodeKindPrize, 999, prize_models.CodeSpec{Count: 1}, "")
+	assert.ErrorIs(t, err, prize_models.ErrPrizeNotFound)
+	_, err = prize_models.GenerateCodeBatch(db, "voucher", 0, prize_models.CodeSpec{Count: 1}, "")
+	assert.ErrorIs(t, err, prize_models.ErrInvalidCodeKind)
+	_, err = prize_models.GenerateCodeBatch(db, prize_models.CodeKindRedemption, 0, prize_models.CodeSpec{Count: 0}, "")
+	assert.ErrorIs(t, err, prize_models.ErrInvalidCodeSpec)
+	_, err = prize_models.GenerateCodeBatch(db, prize_models.CodeKindRedemption, 0, prize_models.CodeSpec{Count: 1, Alphabet: "AAB"}, "")
+	assert.ErrorIs(t, err, prize_models.ErrInvalidCodeSpec)
+
+	// Codes must fit in 64 characters with their prefix and check character
+	_, err = prize_models.GenerateCodeBatch(db, prize_models.CodeKindRedemption, 0, prize_models.CodeSpec{Count: 1, Length: 60, Prefix: "SPRING-", CheckDigit: true}, "")
+	assert.ErrorIs(t, err, prize_models.ErrInvalidCodeSpec)
+	_, err = prize_models.GenerateCodeBatch(db, prize_models.CodeKindRedemption, 0, prize_models.CodeSpec{Count: 1, Length: 64, CheckDigit: true}, "")
+	assert.ErrorIs(t, err, prize_models.ErrInvalidCodeSpec)
+
+	// Prefixes are limited in characters, not bytes
+	_, err = prize_models.GenerateCodeBatch(db, prize_models.CodeKindRedemption, 0, prize_models.CodeSpec{Count: 1, Length: 8, Prefix: "春节快乐春节快乐春节快乐春节-"}, "")
+	assert.NoError(t, err)
+	_, err = prize_models.GenerateCodeBatch(db, prize_models.CodeKindRedemption, 0, prize_models.CodeSpec{Count: 1, Length: 8, Prefix: "春节快乐春节快乐春节快乐春节快乐-"}, "")
+	assert.ErrorIs(t, err, prize_models.ErrInvalidCodeSpec)
+
+	// Two characters of a two letter alphabet only make four codes
+	_, err = prize_models.GenerateCodeBatch(db, prize_models.CodeKindRedemption, 0, prize_models.CodeSpec{Count: 17, Length: 4, Alphabet: "AB"}, "")
+	assert.ErrorIs(t, err, prize_models.ErrInvalidCodeSpec)
+
+	batches, total, err := prize_models.GetCodeBatches(db, 0, 10)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(3), total)
+	assert.Equal(t, prize_models.CodeKindRedemption, batches[0].Kind)
+}
+
+func TestImportCodeBatch(t *testing.T) {
+	db := setupPointsDB(t)
+	assert.NoError(t, prize_models.AddRedemptionCode(db, "EXISTING"))
+
+	// Duplicates in the file and in the database are reported and nothing is imported
+	file := "code\nA1\nA2\nA1\n\n\"\"\nEXISTING\n"
+	result, err := prize_models.ImportCodeBatch(db, prize_models.CodeKindRedemption, 0, strings.NewReader(file), "", false)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, result.Imported)
+	assert.Equal(t, []prize_models.CodeImportRowError{
+		{Row: 4, Errors: []string{"duplicate of row 2"}},
+		{Row: 6, Errors: []string{"code is required"}},
+		{Row: 7, Errors: []string{"code already exists"}},
+	}, result.RowErrors)
+
+	var count int64
+	db.Model(&prize_models.RedemptionCode{}).Count(&count)
+	assert.Equal(t, int64(1), count)
+
+	// A dry run validates without writing
+	result, err = prize_models.ImportCodeBatch(db, prize_models.CodeKindRedemption, 0, strings.NewReader("code\nA1\nA2\n"), "", true)
+	assert.NoError(t, err)
+	assert.Empty(t, result.RowErrors)
+	assert.Equal(t, 2, result.Rows)
+	db.Model(&prize_models.RedemptionCode{}).Count(&count)
+	assert.Equal(t, int64(1), count)
+
+	result, err = prize_models.ImportCodeBatch(db, prize_models.CodeKindRedemption, 0, strings.NewReader("id,code\n1,A1\n2,A2\n"), "partner", false)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, result.Imported)
+
+	batch, err := prize_models.GetCodeBatch(db, result.BatchID)
+	assert.NoError(t, err)
+	assert.Equal(t, prize_models.CodeSourceImported, batch.Source)
+	assert.Equal(t, 2, batch.Count)
+
+	var traced int64
+	db.Model(&prize_models.RedemptionCode{}).Where("batch_id = ?", batch.ID).Count(&traced)
+	assert.Equal(t, int64(2), traced)
+
+	_, err = prize_models.ImportCodeBatch(db, prize_models.CodeKindRedemption, 0, strings.NewReader("serial\nA3\n"), "", false)
+	assert.ErrorIs(t, err, prize_models.ErrInvalidCodeSpec)
+	_, err = prize_models.GetCodeBatch(db, 999)
+	assert.ErrorIs(t, err, prize_models.ErrCodeBatchNotFound)
+}
@@ -3,13 +3,92 @@ package prize_models
 import (
 	"errors"
 	"fmt"
+	"log"
+	"strings"
+	"time"
+
 	"gorm.io/gorm"
+	"xy.com/mysite/models"
+)
+
+var (
+	ErrNoCodesLeft = models.NewError(models.KindConflict, "no_codes_left", "no codes left")
+	ErrCodeExists  = models.NewError(models.KindConflict, "code_exists", "code already exists")
 )
 
 type Code struct {
 	gorm.Model
-	Code   string `gorm:"unique"`
-	IsUsed bool   // 是否已被使用
+	Code    string `gorm:"unique"`
+	PrizeID uint   `gorm:"index"` // 所属奖品的兑换码池，0表示通用兑换码
+	BatchID uint   `gorm:"index"` // 批量生成或导入的批次，0表示单独添加
+	IsUsed  bool   // 是否已被使用
+}
+
+// CodePoolAlert is raised when the unused codes of a prize drop below its
+// CodeAlertThreshold, and resolved once the pool is topped up again.
+type CodePoolAlert struct {
+	gorm.Model
+	PrizeID    uint       `json:"prize_id" gorm:"index"`
+	Remaining  int64      `json:"remaining"`
+	Threshold  int        `json:"threshold"`
+	ResolvedAt *time.Time `json:"resolved_at"`
+}
+
+// CodePoolStatus summarises the code pool of a prize.
+type CodePoolStatus struct {
+	PrizeID   uint   `json:"prize_id"`
+	PrizeName string `json:"prize_name"`
+	Remaining int64  `json:"remaining"`
+	Threshold int    `json:"threshold"`
+	Low       bool   `json:"low"`
+}
+
+// NotifyCodePoolAlert is called when a new code pool alert is raised.
+var NotifyCodePoolAlert = func(alert *CodePoolAlert, prize *Prize) {
+	log.Printf("code pool for prize %q is low: %d codes left", prize.PrizeName, alert.Remaining)
+}
+
+// MigrateCodes puts codes that predate code pools, whose prize and batch are
+// null, in the shared pool. If legacyPrizes is set, the prizes predate code
+// pools too and those without codes of their own keep using the shared pool.
+func MigrateCodes(db *gorm.DB, legacyPrizes bool) error {
+	for _, column := range []string{"prize_id", "batch_id"} {
+		err := db.Unscoped().Model(&Code{}).Where(column+" IS NULL").UpdateColumn(column, 0).Error
+		if err != nil {
+			return fmt.Errorf("failed to migrate codes: %w", err)
+		}
+	}
+	if !legacyPrizes {
+		return nil
+	}
+	err := db.Unscoped().Model(&Prize{}).
+		Where("id NOT IN (?)", db.Unscoped().Model(&Code{}).Select("prize_id")).
+		UpdateColumn("shared_codes", true).Error
+	if err != nil {
+		return fmt.Errorf("failed to migrate prizes to the shared code pool: %w", err)
+	}
+	return nil
+}
+
+// checkCodesUnique returns ErrCodeExists, naming the codes, if any code is
+// given twice or already exists.
+func checkCodesUnique(db *gorm.DB, codes []string) error {
+	existing, err := existingCodes(db, CodeKindPrize, codes)
+	if err != nil {
+		return err
+	}
+	var duplicates []string
+	seen := make(map[string]int)
+	for _, code := range codes {
+		seen[code]++
+		if existing[code] && seen[code] == 1 || !existing[code] && seen[code] == 2 {
+			duplicates = append(duplicates, code)
+		}
+	}
+	if len(duplicates) > 0 {
+		return fmt.Errorf("%w: %s", ErrCodeExists, strings.Join(duplicates, ", "))
+	}
+	return nil
 }
 
 // 添加新的兑换码到数据库中
@@ -18,28 +97,203 @@ func AddCode(db *gorm.DB, code string) error {
 		Code:   code,
 		IsUsed: false,
 	}
-	if err := db.Create(&c).Error; err != nil {
-		return fmt.Errorf("failed to add code: %w", err)
+	return db.Transaction(func(tx *gorm.DB) error {
+		if err := checkCodesUnique(tx, []string{code}); err != nil {
+			return err
+		}
+		if err := tx.Create(&c).Error; err != nil {
+			return fmt.Errorf("failed to add code: %w", err)
+		}
+		return nil
+	})
+}
+
+// AddPrizeCodes adds codes to the pool of a prize and returns the pool's status.
+func AddPrizeCodes(db *gorm.DB, prizeID uint, codes []string) (*CodePoolStatus, error) {
+	if _, err := GetPrizeByID(db, prizeID); err != nil {
+		return nil, err
 	}
-	return nil
+
+	err := db.Transaction(func(tx *gorm.DB) error {
+		if err := checkCodesUnique(tx, codes); err != nil {
+			return err
+		}
+		for _, code := range codes {
+			c := Code{Code: code, PrizeID: prizeID}
+			if err := tx.Create(&c).Error; err != nil {
+				return fmt.Errorf("failed to add code %q: %w", code, err)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := CheckCodePool(db, prizeID); err != nil {
+		return nil, err
+	}
+	return GetCodePoolStatus(db, prizeID)
 }
 
-// 从数据库中获取未使用的兑换码
+// 从数据库中获取未使用的通用兑换码
 func GetCode(db *gorm.DB) (string, error) {
-	var code Code
-	// 获取第一个未使用的兑换码
-	if err := db.Where("is_used = ?", false).First(&code).Error; err != nil {
-		if errors.Is(err, gorm.ErrRecordNotFound) {
-			return "", errors.New("no codes left")
-		}
+	return claimCode(db, 0)
+}
+
+// codePool returns the pool a prize hands out codes from: its own, or the
+// shared pool if the prize is set to use it, like prizes from before code pools.
+func codePool(db *gorm.DB, prizeID uint) (uint, error) {
+	if prizeID == 0 {
+		return 0, nil
+	}
+	var shared bool
+	if err := db.Unscoped().Model(&Prize{}).Where("id = ?", prizeID).Select("shared_codes").Scan(&shared).Error; err != nil {
+		return 0, err
+	}
+	if shared {
+		return 0, nil
+	}
+	return prizeID, nil
+}
+
+// claimCode claims an unused code from the pool of a prize, see codePool.
+// The code is claimed with a conditional update, so concurrent callers never receive the same code.
+func claimCode(db *gorm.DB, prizeID uint) (string, error) {
+	prizeID, err := codePool(db, prizeID)
+	if err != nil {
 		return "", err
 	}
+	for {
+		var code Code
+		// 获取第一个未使用的兑换码
+		if err := db.Where("prize_id = ? AND is_used = ?", prizeID, false).Order("id").First(&code).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return "", ErrNoCodesLeft
+			}
+			return "", err
+		}
+
+		// 将获取到的兑换码标记为已使用
+		result := db.Model(&Code{}).Where("id = ? AND is_used = ?", code.ID, false).Update("is_used", true)
+		if result.Error != nil {
+			return "", fmt.Errorf("failed to mark code as used: %w", result.Error)
+		}
+		if result.RowsAffected == 1 {
+			return code.Code, nil
+		}
+		// Another request claimed this code first, try the next one
+	}
+}
+
+// countUnusedCodes counts the unused codes in the pool of a prize, see codePool.
+func countUnusedCodes(db *gorm.DB, prizeID uint) (int64, error) {
+	prizeID, err := codePool(db, prizeID)
+	if err != nil {
+		return 0, err
+	}
+	var remaining int64
+	err = db.Model(&Code{}).Where("prize_id = ? AND is_used = ?", prizeID, false).Count(&remaining).Error
+	return remaining, err
+}
+
+// GetCodePoolStatus retrieves the status of the code pool of a prize.
+func GetCodePoolStatus(db *gorm.DB, prizeID uint) (*CodePoolStatus, error) {
+	prize, err := GetPrizeByID(db, prizeID)
+	if err != nil {
+		return nil, err
+	}
+	remaining, err := countUnusedCodes(db, prizeID)
+	if err != nil {
+		return nil, err
+	}
+	return &CodePoolStatus{
+		PrizeID:   prize.ID,
+		PrizeName: prize.PrizeName,
+		Remaining: remaining,
+		Threshold: prize.CodeAlertThreshold,
+		Low:       remaining < int64(prize.CodeAlertThreshold),
+	}, nil
+}
+
+// GetCodePoolStatuses retrieves the status of the code pools of all prizes that have not been retired.
+func GetCodePoolStatuses(db *gorm.DB) ([]CodePoolStatus, error) {
+	var prizeIDs []uint
+	if err := db.Model(&Prize{}).Where("retired_at IS NULL").Order("id").Pluck("id", &prizeIDs).Error; err != nil {
+		return nil, err
+	}
 
-	// 将获取到的兑换码标记为已使用
-	code.IsUsed = true
-	if err := db.Save(&code).Error; err != nil {
-		return "", fmt.Errorf("failed to mark code as used: %w", err)
+	statuses := make([]CodePoolStatus, 0, len(prizeIDs))
+	for _, prizeID := range prizeIDs {
+		status, err := GetCodePoolStatus(db, prizeID)
+		if err != nil {
+			return nil, err
+		}
+		statuses = append(statuses, *status)
+	}
+	return statuses, nil
+}
+
+// CheckCodePool raises an alert if the prize's pool has dropped below its
+// threshold and there is no open alert yet, or resolves the open alert if the
+// pool has been topped up. It returns the newly raised alert, if any.
+func CheckCodePool(db *gorm.DB, prizeID uint) (*CodePoolAlert, error) {
+	var (
+		prize *Prize
+		alert *CodePoolAlert
+	)
+	err := db.Transaction(func(tx *gorm.DB) error {
+		var err error
+		prize, err = GetPrizeByID(tx, prizeID)
+		if err != nil || prize.CodeAlertThreshold == 0 {
+			return err
+		}
+		remaining, err := countUnusedCodes(tx, prizeID)
+		if err != nil {
+			return err
+		}
+
+		open := tx.Model(&CodePoolAlert{}).Where("prize_id = ? AND resolved_at IS NULL", prizeID)
+		if remaining >= int64(prize.CodeAlertThreshold) {
+			return open.Update("resolved_at", time.Now()).Error
+		}
+
+		var count int64
+		if err := open.Count(&count).Error; err != nil || count > 0 {
+			return err
+		}
+		alert = &CodePoolAlert{PrizeID: prizeID, Remaining: remaining, Threshold: prize.CodeAlertThreshold}
+		return tx.Create(alert).Error
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to check code pool: %w", err)
 	}
 
-	return code.Code, nil
+	if alert != nil {
+		NotifyCodePoolAlert(alert, prize)
+	}
+	return alert, nil
+}
+
+// checkCodePoolAfterUse checks the prize's pool after a code was handed out.
+// The code has already been given to the user, so errors are only logged.
+func checkCodePoolAfterUse(db *gorm.DB, prizeID uint) {
+	if _, err := CheckCodePool(db, prizeID); err != nil {
+		log.Printf("prize %d: %v", prizeID, err)
+	}
+}
+
+// GetCodePoolAlerts retrieves code pool alerts, newest first. If openOnly is set
+// resolved alerts are left out.
+func GetCodePoolAlerts(db *gorm.DB, openOnly bool) ([]CodePoolAlert, error) {
+	query := db.Order("id desc")
+	if openOnly {
+		query = query.Where("resolved_at IS NULL")
+	}
+
+	var alerts []CodePoolAlert
+	if err := query.Find(&alerts).Error; err != nil {
+		return nil, err
+	}
+	return alerts, nil
 }
@@ -0,0 +1,103 @@
+package prize_models_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"xy.com/mysite/models/prize_models"
+)
+
+func TestPrizeCodePools(t *testing.T) {
+	db := setupPointsDB(t)
+	userID := uint(1)
+
+	var notified []uint
+	notify := prize_models.NotifyCodePoolAlert
+	prize_models.NotifyCodePoolAlert = func(alert *prize_models.CodePoolAlert, prize *prize_models.Prize) {
+		notified = append(notified, prize.ID)
+	}
+	defer func() { prize_models.NotifyCodePoolAlert = notify }()
+
+	db.Create(&prize_models.PointsSystem{UserID: userID, Points: 1000})
+	giftCard := &prize_models.Prize{PrizeName: "Gift card", Cost: 10, PerUserLimit: 5, CodeAlertThreshold: 2}
+	assert.NoError(t, prize_models.CreatePrize(db, giftCard))
+	mug := &prize_models.Prize{PrizeName: "Mug", Cost: 10, PerUserLimit: 5}
+	assert.NoError(t, prize_models.CreatePrize(db, mug))
+
+	// Prizes without codes of their own do not get general codes
+	assert.NoError(t, prize_models.AddCode(db, "general"))
+	_, err := prize_models.ExchangePrize(db, userID, "Mug")
+	assert.ErrorIs(t, err, prize_models.ErrNoCodesLeft)
+
+	// Unless they use the shared pool
+	mug.SharedCodes = true
+	assert.NoError(t, prize_models.UpdatePrize(db, mug))
+	code, err := prize_models.ExchangePrize(db, userID, "Mug")
+	assert.NoError(t, err)
+	assert.Equal(t, "general", code)
+	_, err = prize_models.ExchangePrize(db, userID, "Mug")
+	assert.ErrorIs(t, err, prize_models.ErrNoCodesLeft)
+
+	// Codes of other prizes and general codes are never handed out
+	mug.SharedCodes = false
+	assert.NoError(t, prize_models.UpdatePrize(db, mug))
+	assert.NoError(t, prize_models.AddCode(db, "general-2"))
+	_, err = prize_models.AddPrizeCodes(db, mug.ID, []string{"mug-1"})
+	assert.NoError(t, err)
+	_, err = prize_models.AddPrizeCodes(db, giftCard.ID, []string{"card-0"})
+	assert.NoError(t, err)
+	_, err = prize_models.ExchangePrize(db, userID, "Gift card")
+	assert.NoError(t, err)
+	_, err = prize_models.ExchangePrize(db, userID, "Gift card")
+	assert.ErrorIs(t, err, prize_models.ErrNoCodesLeft)
+	assert.Equal(t, []uint{giftCard.ID}, notified)
+	notified = nil
+
+	// Duplicate codes are refused, and none of the batch is added
+	_, err = prize_models.AddPrizeCodes(db, giftCard.ID, []string{"card-1", "mug-1"})
+	assert.ErrorIs(t, err, prize_models.ErrCodeExists)
+	_, err = prize_models.AddPrizeCodes(db, giftCard.ID, []string{"card-1", "card-1"})
+	assert.ErrorIs(t, err, prize_models.ErrCodeExists)
+	assert.ErrorIs(t, prize_models.AddCode(db, "general"), prize_models.ErrCodeExists)
+
+	status, err := prize_models.AddPrizeCodes(db, giftCard.ID, []string{"card-1", "card-2", "card-3"})
+	assert.NoError(t, err)
+	assert.Equal(t, int64(3), status.Remaining)
+	assert.False(t, status.Low)
+
+	code, err = prize_models.ExchangePrize(db, userID, "Gift card")
+	assert.NoError(t, err)
+	assert.Equal(t, "card-1", code)
+	assert.Empty(t, notified)
+
+	// Dropping below the threshold raises one alert
+	for _, want := range []string{"card-2", "card-3"} {
+		code, err = prize_models.ExchangePrize(db, userID, "Gift card")
+		assert.NoError(t, err)
+		assert.Equal(t, want, code)
+	}
+	assert.Equal(t, []uint{giftCard.ID}, notified)
+
+	alerts, err := prize_models.GetCodePoolAlerts(db, true)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(alerts))
+	assert.Equal(t, int64(1), alerts[0].Remaining)
+
+	statuses, err := prize_models.GetCodePoolStatuses(db)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, len(statuses))
+	assert.Equal(t, int64(0), statuses[0].Remaining)
+	assert.True(t, statuses[0].Low)
+	assert.Equal(t, int64(1), statuses[1].Remaining)
+	assert.False(t, statuses[1].Low)
+
+	// Topping up resolves the alert
+	_, err = prize_models.AddPrizeCodes(db, giftCard.ID, []string{"card-4", "card-5"})
+	assert.NoError(t, err)
+	alerts, err = prize_models.GetCodePoolAlerts(db, true)
+	assert.NoError(t, err)
+	assert.Empty(t, alerts)
+
+	_, err = prize_models.AddPrizeCodes(db, 999, []string{"x"})
+	assert.ErrorIs(t, err, prize_models.ErrPrizeNotFound)
+}
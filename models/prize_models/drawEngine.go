@@ -0,0 +1,355 @@
+package prize_models
+
+import (
+	crand "crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"math/rand"
+	"sync"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// DrawRecord is the result of a single draw.
+type DrawRecord struct {
+	gorm.Model
+	UserID         uint   `json:"user_id" gorm:"index"`
+	RewardTableID  uint   `json:"reward_table_id" gorm:"index"`
+	CampaignID     uint   `json:"campaign_id,omitempty" gorm:"index"`
+	OutcomeID      uint   `json:"outcome_id"`
+	Kind           string `json:"kind" gorm:"size:16"`
+	Amount         int    `json:"amount"`
+	PrizeName      string `json:"prize_name,omitempty"`
+	RedemptionCode string `json:"redemption_code,omitempty"`
+	Guaranteed     bool   `json:"guaranteed"`  // Awarded by the pity counter rather than by chance
+	OverBudget     bool   `json:"over_budget"` // The campaign could not pay for the outcome, so nothing was awarded
+
+	// Provably fair inputs, see VerifyDraw
+	DrawSeedID     uint    `json:"draw_seed_id" gorm:"index"`
+	ServerSeedHash string  `json:"server_seed_hash" gorm:"size:64"`
+	ClientSeed     string  `json:"client_seed" gorm:"size:64"`
+	Nonce          int     `json:"nonce"`
+	Roll           float64 `json:"roll"`
+
+	Achievements []UserAchievement `json:"achievements,omitempty" gorm:"-"` // Unlocked by this draw
+}
+
+// DrawPity counts a user's consecutive draws from a table without a rare outcome.
+type DrawPity struct {
+	UserID        uint `gorm:"primaryKey"`
+	RewardTableID uint `gorm:"primaryKey"`
+	Misses        int
+}
+
+// DrawEngine draws weighted outcomes from reward tables. Outcomes are derived
+// from the user's committed seed pair (see FairRoll); the engine only generates
+// new seeds. It is safe for concurrent use.
+type DrawEngine struct {
+	mu    sync.Mutex
+	seeds io.Reader
+}
+
+// NewDrawEngine creates a draw engine whose seeds come from a pseudo-random
+// generator. Engines created with the same seed produce the same sequence of
+// outcomes, which makes draws reproducible in tests.
+func NewDrawEngine(seed int64) *DrawEngine {
+	return &DrawEngine{seeds: rand.New(rand.NewSource(seed))}
+}
+
+// NewSecureDrawEngine creates a draw engine whose server seeds cannot be predicted.
+func NewSecureDrawEngine() *DrawEngine {
+	return &DrawEngine{seeds: crand.Reader}
+}
+
+// randomHex returns n random bytes from the engine's seed source, hex encoded.
+func (e *DrawEngine) randomHex(n int) (string, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	b := make([]byte, n)
+	if _, err := io.ReadFull(e.seeds, b); err != nil {
+		return "", fmt.Errorf("failed to generate seed: %w", err)
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// pickOutcome chooses an outcome with probability proportional to its weight.
+// Only outcomes accepted by filter are considered.
+func pickOutcome(outcomes []RewardOutcome, n func(int) int, filter func(RewardOutcome) bool) (*RewardOutcome, error) {
+	total := 0
+	for _, outcome := range outcomes {
+		if filter(outcome) {
+			total += outcome.Weight
+		}
+	}
+	if total <= 0 {
+		return nil, fmt.Errorf("%w: no outcomes with positive weight", ErrInvalidRewardTable)
+	}
+
+	r := n(total)
+	for i := range outcomes {
+		if !filter(outcomes[i]) {
+			continue
+		}
+		if r < outcomes[i].Weight {
+			return &outcomes[i], nil
+		}
+		r -= outcomes[i].Weight
+	}
+	return nil, fmt.Errorf("%w: outcome selection failed", ErrInvalidRewardTable)
+}
+
+// Draw draws an outcome for the user from an active reward table (the oldest
+// active one if tableID is zero), awards it and records the result. The pity
+// counter, the reward and the record are committed in a single transaction.
+func (e *DrawEngine) Draw(db *gorm.DB, userID uint, tableID uint) (*DrawRecord, error) {
+	var record *DrawRecord
+	err := db.Transaction(func(tx *gorm.DB) error {
+		table, err := GetActiveRewardTable(tx, tableID)
+		if err != nil {
+			return err
+		}
+		record, err = e.draw(tx, userID, table, nil, time.Now())
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	afterDraw(db, record)
+	return record, nil
+}
+
+// DrawInCampaign draws an outcome for the user from the campaign's reward table.
+// The draw counts against the user's daily limit and its reward is charged to
+// the campaign's budget in the same transaction as the draw itself.
+func (e *DrawEngine) DrawInCampaign(db *gorm.DB, userID uint, campaignID uint) (*DrawRecord, error) {
+	return e.drawInCampaign(db, userID, campaignID, "")
+}
+
+// DrawWithRedemptionCode draws in the campaign like DrawInCampaign, paying for
+// the draw with a use of the redemption code. The code is only consumed if
+// the draw succeeds, as both are committed in one transaction. A draw whose
+// outcome the budget cannot pay for succeeds too, awarding nothing (see
+// DrawRecord.OverBudget): rolling it back would leave the nonce, and so the
+// outcome, the same for the next draw.
+func (e *DrawEngine) DrawWithRedemptionCode(db *gorm.DB, userID uint, campaignID uint, code string) (*DrawRecord, error) {
+	if code == "" {
+		return nil, ErrRedemptionCodeNotFound
+	}
+	return e.drawInCampaign(db, userID, campaignID, code)
+}
+
+// drawInCampaign draws in the campaign, consuming the redemption code if one is given.
+func (e *DrawEngine) drawInCampaign(db *gorm.DB, userID uint, campaignID uint, code string) (*DrawRecord, error) {
+	now := time.Now()
+
+	var record *DrawRecord
+	err := db.Transaction(func(tx *gorm.DB) error {
+		campaign, err := GetCampaign(tx, campaignID)
+		if err != nil {
+			return err
+		}
+		if campaign.ExhaustedAt != nil {
+			return ErrCampaignBudgetExhausted
+		}
+		if !campaign.IsRunning(now) {
+			return ErrCampaignNotRunning
+		}
+
+		eligible, err := isEligible(tx, campaign, userID)
+		if err != nil {
+			return err
+		}
+		if !eligible {
+			return ErrNotEligible
+		}
+
+		var use *RedemptionUse
+		if code != "" {
+			if use, err = useRedemptionCode(tx, code, userID, campaignID, now); err != nil {
+				return err
+			}
+		}
+
+		if err := countDailyDraw(tx, campaign, userID, now); err != nil {
+			return err
+		}
+
+		table, err := GetRewardTable(tx, campaign.RewardTableID)
+		if err != nil {
+			return err
+		}
+		record, err = e.draw(tx, userID, table, campaign, now)
+		if err != nil {
+			return err
+		}
+
+		if use != nil {
+			if err := tx.Model(use).Update("draw_id", record.ID).Error; err != nil {
+				return fmt.Errorf("failed to record code use: %w", err)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	afterDraw(db, record)
+	return record, nil
+}
+
+// afterDraw runs the checks that follow a committed draw.
+func afterDraw(db *gorm.DB, record *DrawRecord) {
+	if record.Kind != OutcomePrize {
+		return
+	}
+	if prize, err := GetPrizeByName(db, record.PrizeName); err == nil {
+		checkCodePoolAfterUse(db, prize.ID)
+	}
+}
+
+// draw performs a draw from the table inside an existing transaction.
+// If campaign is set the reward is charged to its budget, or withheld if the
+// budget cannot pay for it.
+func (e *DrawEngine) draw(tx *gorm.DB, userID uint, table *RewardTable, campaign *DrawCampaign, now time.Time) (*DrawRecord, error) {
+	pity := DrawPity{UserID: userID, RewardTableID: table.ID}
+	if err := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&pity).Error; err != nil {
+		return nil, fmt.Errorf("failed to create pity counter: %w", err)
+	}
+	if err := tx.Where("user_id = ? AND reward_table_id = ?", userID, table.ID).First(&pity).Error; err != nil {
+		return nil, err
+	}
+
+	seed, err := activeDrawSeed(tx, userID)
+	if err != nil {
+		return nil, err
+	}
+	// Take the nonce in the update itself, so that concurrent draws on the seed
+	// never share one
+	err = tx.Model(seed).Clauses(clause.Returning{Columns: []clause.Column{{Name: "nonce"}}}).
+		Update("nonce", gorm.Expr("nonce + 1")).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to update nonce: %w", err)
+	}
+	nonce := seed.Nonce - 1
+
+	// On the last draw before the threshold only rare outcomes are eligible
+	guaranteed := table.PityThreshold > 0 && pity.Misses+1 >= table.PityThreshold
+	roll := FairRoll(seed.ServerSeed, seed.ClientSeed, nonce)
+	outcome, err := ReplayDraw(table.Outcomes, roll, guaranteed)
+	if err != nil {
+		return nil, err
+	}
+
+	// An outcome the campaign can no longer pay for is recorded, so the draw
+	// still verifies, but nothing is awarded
+	overBudget := false
+	if campaign != nil {
+		affordable, err := spendBudget(tx, campaign, table, outcome, now)
+		if err != nil {
+			return nil, err
+		}
+		overBudget = !affordable
+	}
+
+	var misses interface{} = gorm.Expr("misses + 1")
+	if outcome.Rare && !overBudget {
+		misses = 0
+	}
+	err = tx.Model(&DrawPity{}).
+		Where("user_id = ? AND reward_table_id = ?", userID, table.ID).
+		Update("misses", misses).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to update pity counter: %w", err)
+	}
+
+	record := &DrawRecord{
+		UserID:         userID,
+		RewardTableID:  table.ID,
+		OutcomeID:      outcome.ID,
+		Kind:           outcome.Kind,
+		Amount:         outcome.Amount,
+		PrizeName:      outcome.PrizeName,
+		Guaranteed:     guaranteed,
+		OverBudget:     overBudget,
+		CampaignID:     campaignID(campaign),
+		DrawSeedID:     seed.ID,
+		ServerSeedHash: seed.ServerSeedHash,
+		ClientSeed:     seed.ClientSeed,
+		Nonce:          nonce,
+		Roll:           roll,
+	}
+	if overBudget {
+		record.Kind, record.Amount, record.PrizeName = OutcomeNothing, 0, ""
+	}
+	if err := tx.Create(record).Error; err != nil {
+		return nil, fmt.Errorf("failed to save draw record: %w", err)
+	}
+
+	if err := awardOutcome(tx, record); err != nil {
+		return nil, err
+	}
+	if record.Achievements, err = RecordEvent(tx, userID, EventDraw); err != nil {
+		return nil, err
+	}
+	return record, nil
+}
+
+func campaignID(campaign *DrawCampaign) uint {
+	if campaign == nil {
+		return 0
+	}
+	return campaign.ID
+}
+
+// awardOutcome credits the reward of a draw to the user.
+func awardOutcome(tx *gorm.DB, record *DrawRecord) error {
+	referenceID := fmt.Sprintf("draw:%d", record.ID)
+
+	switch record.Kind {
+	case OutcomePoints:
+		return CreditPoints(tx, record.UserID, record.Amount, LedgerReasonDraw, referenceID)
+	case OutcomeCoins:
+		_, err := applyBalanceChange(tx, balanceChange{
+			UserID:      record.UserID,
+			Currency:    CurrencyCoins,
+			Amount:      record.Amount,
+			Reason:      LedgerReasonDraw,
+			ReferenceID: referenceID,
+		})
+		return err
+	case OutcomePrize:
+		prize, err := GetPrizeByName(tx, record.PrizeName)
+		if err != nil {
+			return err
+		}
+		if err := takeStock(tx, prize); err != nil {
+			return err
+		}
+		code, err := claimCode(tx, prize.ID)
+		if err != nil {
+			return fmt.Errorf("failed to get redemption code: %w", err)
+		}
+		record.RedemptionCode = code
+		return tx.Model(record).Update("redemption_code", code).Error
+	}
+	return nil
+}
+
+// GetDrawRecords retrieves a page of a user's draws, newest first, and the total number of draws.
+func GetDrawRecords(db *gorm.DB, userID uint, offset, limit int) ([]DrawRecord, int64, error) {
+	var total int64
+	if err := db.Model(&DrawRecord{}).Where("user_id = ?", userID).Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+
+	var records []DrawRecord
+	err := db.Where("user_id = ?", userID).Order("id desc").Offset(offset).Limit(limit).Find(&records).Error
+	if err != nil {
+		return nil, 0, err
+	}
+	return records, total, nil
+}
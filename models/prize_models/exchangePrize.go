@@ -8,59 +8,141 @@ import (
 
 type ExchangedPrize struct {
 	gorm.Model
-	PrizeName      string `json:"prize_name"`
-	UserID         uint   `json:"user_id"`
+	PrizeName      string `json:"prize_name" gorm:"uniqueIndex:idx_exchanged_user_prize_seq"`
+	UserID         uint   `json:"user_id" gorm:"uniqueIndex:idx_exchanged_user_prize_seq"`
+	Seq            int    `json:"seq" gorm:"uniqueIndex:idx_exchanged_user_prize_seq;default:1"` // 该用户第几次兑换该奖品
 	RedemptionCode string `json:"redemption_code"`
+	PrizeID        uint   `json:"prize_id" gorm:"index"`
+	Cost           int    `json:"cost"` // 兑换时支付的积分，驳回时退还
+
+	// Fulfillment of physical prizes, see UpdateFulfillment. Other exchanges are delivered immediately.
+	Status          string `json:"status" gorm:"size:16;not null;default:delivered;index"`
+	RecipientName   string `json:"recipient_name,omitempty"`
+	ShippingAddress string `json:"shipping_address,omitempty" gorm:"size:1024"`
+	Phone           string `json:"phone,omitempty" gorm:"size:32"`
+	Carrier         string `json:"carrier,omitempty"`
+	TrackingNumber  string `json:"tracking_number,omitempty"`
+	RejectReason    string `json:"reject_reason,omitempty"`
+
+	Achievements []UserAchievement `json:"achievements,omitempty" gorm:"-"` // Unlocked by this exchange
 }
 
-// ExchangePrize exchanges a prize
-func ExchangePrize(db *gorm.DB, userID uint, prizeName string, pointsSystem *PointsSystem) (string, error) {
-	// Check if the prize exists
-	prize, err := GetPrizeByName(db, prizeName)
-	if err != nil {
-		return "", err
-	}
-
-	// Check if the user has already exchanged this prize
-	hasExchanged, err := CheckIfUserExchangedPrize(db, userID, prizeName)
+// ExchangePrize exchanges a prize for the user's points and returns the redemption code.
+// Physical prizes need shipping details, use ExchangePrizeWithShipping for them.
+func ExchangePrize(db *gorm.DB, userID uint, prizeName string) (string, error) {
+	exchange, err := ExchangePrizeWithShipping(db, userID, prizeName, nil)
 	if err != nil {
 		return "", err
 	}
+	return exchange.RedemptionCode, nil
+}
 
-	// If the user has already exchanged this prize, return the redemption code
-	if hasExchanged {
-		redemptionCode, err := GetRedemptionCode(db, userID, prizeName)
+// ExchangePrizeWithShipping exchanges a prize for the user's points. The points debit, the
+// stock, the code allocation and the exchange record are committed in a single transaction,
+// so a failed exchange never costs points or stock.
+//
+// Codes are taken from the prize's own pool, see AddPrizeCodes. Physical prizes get no code;
+// they are shipped to the given address once an admin processes the request.
+// A user may exchange a prize up to its per-user limit; rejected requests do not count.
+// For prizes limited to one per user, exchanging again returns the earlier exchange
+// without charging again.
+func ExchangePrizeWithShipping(db *gorm.DB, userID uint, prizeName string, shipping *ShippingDetails) (*ExchangedPrize, error) {
+	var (
+		exchange *ExchangedPrize
+		prize    *Prize
+		claimed  bool
+	)
+	err := db.Transaction(func(tx *gorm.DB) error {
+		// Check if the prize exists
+		var err error
+		prize, err = GetPrizeByName(tx, prizeName)
 		if err != nil {
-			return "", fmt.Errorf("failed to get redemption code: %w", err)
+			return err
+		}
+		if prize.Physical {
+			if shipping == nil {
+				return ErrShippingRequired
+			}
+			if err := shipping.Validate(); err != nil {
+				return err
+			}
 		}
-		return redemptionCode, nil
-	}
 
-	// The user has not exchanged this prize yet and can exchange it
-	if pointsSystem.Points < prize.Cost {
-		// The user does not have enough points to exchange the prize
-		return "", errors.New(fmt.Sprintf("insufficient points %d", prize.Cost))
-	}
+		// Count the user's earlier exchanges of this prize
+		var exchanged, active int64
+		query := tx.Model(&ExchangedPrize{}).Where("user_id = ? AND prize_name = ?", userID, prizeName)
+		if err := query.Count(&exchanged).Error; err != nil {
+			return fmt.Errorf("failed to query exchanged prizes: %w", err)
+		}
+		if err := query.Where("status <> ?", FulfillmentRejected).Count(&active).Error; err != nil {
+			return fmt.Errorf("failed to query exchanged prizes: %w", err)
+		}
 
-	// Generate a redemption code
-	redemptionCode, err := GetCode(db)
-	if err != nil {
-		return "", fmt.Errorf("failed to get redemption code: %w", err)
-	}
+		if int(active) >= prize.PerUserLimit {
+			if prize.PerUserLimit > 1 {
+				return ErrPrizeLimitReached
+			}
+			// The user has already exchanged this prize, return the earlier exchange
+			exchange = &ExchangedPrize{}
+			err := tx.Where("user_id = ? AND prize_name = ? AND status <> ?", userID, prizeName, FulfillmentRejected).
+				Order("id desc").First(exchange).Error
+			if err != nil {
+				return fmt.Errorf("failed to get exchanged prize: %w", err)
+			}
+			return nil
+		}
 
-	// Create an ExchangedPrize record
-	exchangedPrize := ExchangedPrize{
-		PrizeName:      prizeName,
-		UserID:         userID,
-		RedemptionCode: redemptionCode,
-	}
+		// Debit the cost
+		if err := DebitPoints(tx, userID, prize.Cost, LedgerReasonPrizeExchange, fmt.Sprintf("prize:%d", prize.ID)); err != nil {
+			if errors.Is(err, ErrInsufficientPoints) {
+				return fmt.Errorf("%w %d", ErrInsufficientPoints, prize.Cost)
+			}
+			return err
+		}
+
+		if err := takeStock(tx, prize); err != nil {
+			return err
+		}
+
+		// Create an ExchangedPrize record
+		exchange = &ExchangedPrize{
+			PrizeName: prizeName,
+			PrizeID:   prize.ID,
+			UserID:    userID,
+			Seq:       int(exchanged) + 1,
+			Cost:      prize.Cost,
+			Status:    FulfillmentDelivered,
+		}
+		if prize.Physical {
+			exchange.Status = FulfillmentRequested
+			exchange.RecipientName = shipping.RecipientName
+			exchange.ShippingAddress = shipping.Address
+			exchange.Phone = shipping.Phone
+		} else {
+			// Take a redemption code from the prize's pool
+			exchange.RedemptionCode, err = claimCode(tx, prize.ID)
+			if err != nil {
+				return fmt.Errorf("failed to get redemption code: %w", err)
+			}
+			claimed = true
+		}
+
+		// Save the ExchangedPrize to the database
+		if err := tx.Create(exchange).Error; err != nil {
+			return fmt.Errorf("failed to save exchanged prize: %w", err)
+		}
 
-	// Save the ExchangedPrize to the database
-	if err := db.Create(&exchangedPrize).Error; err != nil {
-		return "", fmt.Errorf("failed to save exchanged prize: %w", err)
+		exchange.Achievements, err = RecordEvent(tx, userID, EventPrizeExchange)
+		return err
+	})
+	if err != nil {
+		return nil, err
 	}
 
-	return redemptionCode, nil
+	if claimed {
+		checkCodePoolAfterUse(db, prize.ID)
+	}
+	return exchange, nil
 }
 
 // CheckIfUserExchangedPrize checks if a user has already exchanged a prize
@@ -89,7 +171,7 @@ func GetPrizeByName(db *gorm.DB, prizeName string) (*Prize, error) {
 	if err != nil {
 		// We didn't find a record with the given prize name
 		if errors.Is(err, gorm.ErrRecordNotFound) {
-			return nil, fmt.Errorf("prize_handlers not found: %s", prizeName)
+			return nil, fmt.Errorf("%w: %s", ErrPrizeNotFound, prizeName)
 		}
 
 		// Some other error occurred
@@ -100,15 +182,15 @@ func GetPrizeByName(db *gorm.DB, prizeName string) (*Prize, error) {
 	return &prize, nil
 }
 
-// GetRedemptionCode fetches the redemption code for a given user and prize
+// GetRedemptionCode fetches the redemption code of the user's latest exchange of a prize
 func GetRedemptionCode(db *gorm.DB, userID uint, prizeName string) (string, error) {
 	var exchangedPrize ExchangedPrize
-	err := db.Where("user_id = ? AND prize_name = ?", userID, prizeName).First(&exchangedPrize).Error
+	err := db.Where("user_id = ? AND prize_name = ?", userID, prizeName).Order("id desc").First(&exchangedPrize).Error
 
 	if err != nil {
 		// We didn't find a record with the given user ID and prize name
 		if errors.Is(err, gorm.ErrRecordNotFound) {
-			return "", fmt.Errorf("exchanged prize not found for user %s and prize %s", userID, prizeName)
+			return "", fmt.Errorf("%w for user %d and prize %s", ErrExchangeNotFound, userID, prizeName)
 		}
 
 		// Some other error occurred
@@ -0,0 +1,245 @@
+package prize_models
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"xy.com/mysite/models"
+)
+
+var (
+	ErrInvalidExchangeRate     = models.NewError(models.KindInvalid, "invalid_exchange_rate", "invalid exchange rate")
+	ErrInvalidExchangeAmount   = models.NewError(models.KindInvalid, "invalid_exchange_amount", "invalid exchange amount")
+	ErrDailyExchangeCapReached = models.NewError(models.KindRateLimited, "daily_exchange_cap_reached", "daily coin exchange cap reached")
+)
+
+// DefaultExchangeRate applies until an admin configures exchange rates.
+// 每100金币可以兑换1积分
+var DefaultExchangeRate = ExchangeRate{CoinsPerPoint: 100}
+
+// ExchangeRate is the coin to point exchange rate from EffectiveFrom until the
+// next rate takes effect. Rates are never changed once created, so past
+// exchanges can always be explained.
+type ExchangeRate struct {
+	ID            uint      `json:"id" gorm:"primaryKey"`
+	CreatedAt     time.Time `json:"created_at"`
+	CoinsPerPoint int       `json:"coins_per_point" gorm:"not null"`
+	MinCoins      int       `json:"min_coins"`       // Smallest exchange, at least one point's worth
+	DailyCapCoins int       `json:"daily_cap_coins"` // Most coins a user may exchange a day, 0 for no cap
+	EffectiveFrom time.Time `json:"effective_from" gorm:"not null;index"`
+	Note          string    `json:"note" gorm:"size:255"`
+}
+
+// CoinExchangeDaily counts the coins a user exchanged on one day.
+type CoinExchangeDaily struct {
+	UserID uint   `gorm:"primaryKey"`
+	Day    string `gorm:"primaryKey;size:10"` // YYYY-MM-DD in server time
+	Coins  int
+}
+
+// ExchangeQuote previews a coin exchange at the rate effective at the time.
+type ExchangeQuote struct {
+	Coins          int       `json:"coins"` // Coins that will be exchanged
+	Points         int       `json:"points"`
+	RateID         uint      `json:"rate_id,omitempty"` // Zero for the default rate
+	CoinsPerPoint  int       `json:"coins_per_point"`
+	MinCoins       int       `json:"min_coins"`
+	DailyCapCoins  int       `json:"daily_cap_coins"`
+	ExchangedToday int       `json:"exchanged_today"`
+	QuotedAt       time.Time `json:"quoted_at"`
+}
+
+// Validate checks the exchange rate settings.
+func (r *ExchangeRate) Validate() error {
+	if r.CoinsPerPoint < 1 {
+		return fmt.Errorf("%w: coins per point must be at least 1", ErrInvalidExchangeRate)
+	}
+	if r.MinCoins < 0 || r.DailyCapCoins < 0 {
+		return fmt.Errorf("%w: minimum and daily cap must not be negative", ErrInvalidExchangeRate)
+	}
+	if r.DailyCapCoins > 0 && r.DailyCapCoins < r.minCoins() {
+		return fmt.Errorf("%w: daily cap is below the minimum exchange", ErrInvalidExchangeRate)
+	}
+	return nil
+}
+
+// minCoins returns the smallest number of coins that can be exchanged.
+func (r *ExchangeRate) minCoins() int {
+	if r.MinCoins > r.CoinsPerPoint {
+		return r.MinCoins
+	}
+	return r.CoinsPerPoint
+}
+
+// CreateExchangeRate schedules a new exchange rate. Rates without an effective
+// date take effect immediately; rates cannot take effect in the past.
+func CreateExchangeRate(db *gorm.DB, rate *ExchangeRate) error {
+	if err := rate.Validate(); err != nil {
+		return err
+	}
+	now := time.Now()
+	if rate.EffectiveFrom.IsZero() {
+		rate.EffectiveFrom = now
+	} else if rate.EffectiveFrom.Before(now) {
+		return fmt.Errorf("%w: effective date must not be in the past", ErrInvalidExchangeRate)
+	}
+
+	rate.ID = 0
+	if err := db.Create(rate).Error; err != nil {
+		return fmt.Errorf("failed to create exchange rate: %w", err)
+	}
+	return nil
+}
+
+// GetExchangeRates retrieves all exchange rates, the latest effective date first.
+func GetExchangeRates(db *gorm.DB) ([]ExchangeRate, error) {
+	var rates []ExchangeRate
+	if err := db.Order("effective_from desc, id desc").Find(&rates).Error; err != nil {
+		return nil, err
+	}
+	return rates, nil
+}
+
+// GetExchangeRate retrieves the exchange rate effective at the given time.
+func GetExchangeRate(db *gorm.DB, at time.Time) (*ExchangeRate, error) {
+	var rate ExchangeRate
+	err := db.Where("effective_from <= ?", at).Order("effective_from desc, id desc").First(&rate).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		rate = DefaultExchangeRate
+		return &rate, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &rate, nil
+}
+
+// QuoteCoinExchange previews exchanging coins for points without committing it.
+// A zero amount quotes exchanging as many coins as the balance and the daily cap allow.
+func QuoteCoinExchange(db *gorm.DB, userID uint, coins int, now time.Time) (*ExchangeQuote, error) {
+	if coins < 0 {
+		return nil, fmt.Errorf("%w: coins must not be negative", ErrInvalidExchangeAmount)
+	}
+
+	rate, err := GetExchangeRate(db, now)
+	if err != nil {
+		return nil, err
+	}
+	// A user without a balance yet has no coins, there is no need to create one
+	var pointsSystem PointsSystem
+	if err := db.Where("user_id = ?", userID).Limit(1).Find(&pointsSystem).Error; err != nil {
+		return nil, err
+	}
+	var counter CoinExchangeDaily
+	err = db.Where("user_id = ? AND day = ?", userID, now.Format("2006-01-02")).Limit(1).Find(&counter).Error
+	if err != nil {
+		return nil, err
+	}
+
+	quote := &ExchangeQuote{
+		RateID:         rate.ID,
+		CoinsPerPoint:  rate.CoinsPerPoint,
+		MinCoins:       rate.minCoins(),
+		DailyCapCoins:  rate.DailyCapCoins,
+		ExchangedToday: counter.Coins,
+		QuotedAt:       now,
+	}
+	capLeft := -1
+	if rate.DailyCapCoins > 0 {
+		capLeft = rate.DailyCapCoins - counter.Coins
+	}
+
+	if coins == 0 {
+		// Exchange everything the balance and the cap allow, in whole points
+		coins = pointsSystem.Coins
+		if capLeft >= 0 && capLeft < coins {
+			coins = capLeft
+		}
+		coins -= coins % rate.CoinsPerPoint
+		if coins < quote.MinCoins {
+			if capLeft >= 0 && capLeft < quote.MinCoins {
+				return nil, ErrDailyExchangeCapReached
+			}
+			return nil, ErrInsufficientCoins
+		}
+	} else {
+		if coins%rate.CoinsPerPoint != 0 || coins < quote.MinCoins {
+			return nil, fmt.Errorf("%w: exchange a multiple of %d coins, at least %d", ErrInvalidExchangeAmount, rate.CoinsPerPoint, quote.MinCoins)
+		}
+		if coins > pointsSystem.Coins {
+			return nil, ErrInsufficientCoins
+		}
+		if capLeft >= 0 && coins > capLeft {
+			return nil, fmt.Errorf("%w: %d of %d coins left today", ErrDailyExchangeCapReached, capLeft, rate.DailyCapCoins)
+		}
+	}
+
+	quote.Coins = coins
+	quote.Points = coins / rate.CoinsPerPoint
+	return quote, nil
+}
+
+// ExchangeCoinsAmount exchanges the given number of coins for points at the rate
+// effective now, exactly as QuoteCoinExchange quotes it. A zero amount exchanges
+// as many coins as the balance and the daily cap allow.
+func ExchangeCoinsAmount(db *gorm.DB, userID uint, coins int, now time.Time) (*PointsSystem, *ExchangeQuote, error) {
+	var (
+		pointsSystem *PointsSystem
+		quote        *ExchangeQuote
+	)
+	err := db.Transaction(func(tx *gorm.DB) error {
+		var err error
+		quote, err = QuoteCoinExchange(tx, userID, coins, now)
+		if err != nil {
+			return err
+		}
+
+		if quote.DailyCapCoins > 0 {
+			counter := CoinExchangeDaily{UserID: userID, Day: now.Format("2006-01-02")}
+			if err := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&counter).Error; err != nil {
+				return fmt.Errorf("failed to create daily exchange counter: %w", err)
+			}
+			result := tx.Model(&CoinExchangeDaily{}).
+				Where("user_id = ? AND day = ? AND coins + ? <= ?", userID, counter.Day, quote.Coins, quote.DailyCapCoins).
+				Update("coins", gorm.Expr("coins + ?", quote.Coins))
+			if result.Error != nil {
+				return fmt.Errorf("failed to update daily exchange counter: %w", result.Error)
+			}
+			if result.RowsAffected == 0 {
+				return ErrDailyExchangeCapReached
+			}
+		}
+
+		referenceID := ""
+		if quote.RateID != 0 {
+			referenceID = fmt.Sprintf("rate:%d", quote.RateID)
+		}
+		applied, err := applyBalanceChange(tx, balanceChange{
+			UserID:      userID,
+			Currency:    CurrencyCoins,
+			Amount:      -quote.Coins,
+			Reason:      LedgerReasonCoinExchange,
+			ReferenceID: referenceID,
+		})
+		if err != nil {
+			return err
+		}
+		if !applied {
+			return ErrInsufficientCoins
+		}
+
+		if err := CreditPoints(tx, userID, quote.Points, LedgerReasonCoinExchange, referenceID); err != nil {
+			return err
+		}
+
+		pointsSystem, err = GetPointsSystem(tx, userID)
+		return err
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	return pointsSystem, quote, nil
+}
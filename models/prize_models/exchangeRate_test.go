@@ -0,0 +1,138 @@
+package prize_models_test
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"xy.com/mysite/models/prize_models"
+)
+
+func TestExchangeRates(t *testing.T) {
+	db := setupPointsDB(t)
+	now := time.Now()
+
+	rate, err := prize_models.GetExchangeRate(db, now)
+	assert.NoError(t, err)
+	assert.Equal(t, 100, rate.CoinsPerPoint)
+
+	err = prize_models.CreateExchangeRate(db, &prize_models.ExchangeRate{CoinsPerPoint: 0})
+	assert.ErrorIs(t, err, prize_models.ErrInvalidExchangeRate)
+	err = prize_models.CreateExchangeRate(db, &prize_models.ExchangeRate{CoinsPerPoint: 10, EffectiveFrom: now.Add(-time.Hour)})
+	assert.ErrorIs(t, err, prize_models.ErrInvalidExchangeRate)
+	err = prize_models.CreateExchangeRate(db, &prize_models.ExchangeRate{CoinsPerPoint: 10, MinCoins: 50, DailyCapCoins: 20})
+	assert.ErrorIs(t, err, prize_models.ErrInvalidExchangeRate)
+
+	// Scheduled rates take effect at their effective date
+	tomorrow := now.AddDate(0, 0, 1)
+	nextWeek := now.AddDate(0, 0, 7)
+	assert.NoError(t, prize_models.CreateExchangeRate(db, &prize_models.ExchangeRate{CoinsPerPoint: 50, EffectiveFrom: tomorrow}))
+	assert.NoError(t, prize_models.CreateExchangeRate(db, &prize_models.ExchangeRate{CoinsPerPoint: 20, EffectiveFrom: nextWeek}))
+
+	for at, want := range map[time.Time]int{now: 100, tomorrow.Add(time.Minute): 50, nextWeek.Add(time.Minute): 20} {
+		rate, err := prize_models.GetExchangeRate(db, at)
+		assert.NoError(t, err)
+		assert.Equal(t, want, rate.CoinsPerPoint)
+	}
+
+	rates, err := prize_models.GetExchangeRates(db)
+	assert.NoError(t, err)
+	assert.Len(t, rates, 2)
+	assert.Equal(t, 20, rates[0].CoinsPerPoint)
+}
+
+func TestPartialCoinExchange(t *testing.T) {
+	db := setupPointsDB(t)
+	userID := uint(1)
+	_, err := prize_models.AdjustBalance(db, userID, prize_models.CurrencyCoins, 1000, prize_models.LedgerReasonAdminAdjustment, "")
+	assert.NoError(t, err)
+
+	at := time.Now().Add(time.Hour)
+	assert.NoError(t, prize_models.CreateExchangeRate(db, &prize_models.ExchangeRate{
+		CoinsPerPoint: 50, MinCoins: 200, DailyCapCoins: 600, EffectiveFrom: at,
+	}))
+	now := at.Add(time.Minute)
+
+	for _, coins := range []int{-50, 75, 150} {
+		_, err := prize_models.QuoteCoinExchange(db, userID, coins, now)
+		assert.ErrorIs(t, err, prize_models.ErrInvalidExchangeAmount, coins)
+	}
+	_, err = prize_models.QuoteCoinExchange(db, userID, 2000, now)
+	assert.ErrorIs(t, err, prize_models.ErrInsufficientCoins)
+	_, err = prize_models.QuoteCoinExchange(db, userID, 650, now)
+	assert.ErrorIs(t, err, prize_models.ErrDailyExchangeCapReached)
+
+	// The quote matches the exchange
+	quote, err := prize_models.QuoteCoinExchange(db, userID, 250, now)
+	assert.NoError(t, err)
+	assert.Equal(t, 5, quote.Points)
+	assert.Equal(t, 200, quote.MinCoins)
+	ps, exchanged, err := prize_models.ExchangeCoinsAmount(db, userID, 250, now)
+	assert.NoError(t, err)
+	assert.Equal(t, quote.Points, exchanged.Points)
+	assert.Equal(t, 750, ps.Coins)
+	assert.Equal(t, 5, ps.Points)
+
+	// Without an amount, as much as the daily cap allows
+	quote, err = prize_models.QuoteCoinExchange(db, userID, 0, now)
+	assert.NoError(t, err)
+	assert.Equal(t, 350, quote.Coins)
+	assert.Equal(t, 250, quote.ExchangedToday)
+	ps, _, err = prize_models.ExchangeCoinsAmount(db, userID, 0, now)
+	assert.NoError(t, err)
+	assert.Equal(t, 400, ps.Coins)
+	assert.Equal(t, 12, ps.Points)
+
+	_, _, err = prize_models.ExchangeCoinsAmount(db, userID, 0, now)
+	assert.ErrorIs(t, err, prize_models.ErrDailyExchangeCapReached)
+
+	// The cap resets the next day
+	ps, _, err = prize_models.ExchangeCoinsAmount(db, userID, 200, now.AddDate(0, 0, 1))
+	assert.NoError(t, err)
+	assert.Equal(t, 200, ps.Coins)
+
+	check, err := prize_models.VerifyBalance(db, userID)
+	assert.NoError(t, err)
+	assert.True(t, check.Consistent)
+
+	// Quoting for a user without a balance does not create one
+	_, err = prize_models.QuoteCoinExchange(db, userID+1, 0, now)
+	assert.ErrorIs(t, err, prize_models.ErrInsufficientCoins)
+	var count int64
+	assert.NoError(t, db.Model(&prize_models.PointsSystem{}).Where("user_id = ?", userID+1).Count(&count).Error)
+	assert.Zero(t, count)
+}
+
+func TestDailyExchangeCapUnderConcurrency(t *testing.T) {
+	db := setupPointsDB(t)
+	userID := uint(1)
+	db.Create(&prize_models.PointsSystem{UserID: userID, Coins: 10000})
+
+	at := time.Now().Add(time.Hour)
+	assert.NoError(t, prize_models.CreateExchangeRate(db, &prize_models.ExchangeRate{CoinsPerPoint: 100, DailyCapCoins: 500, EffectiveFrom: at}))
+	now := at.Add(time.Minute)
+
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	succeeded := 0
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, _, err := prize_models.ExchangeCoinsAmount(db, userID, 100, now); err == nil {
+				mu.Lock()
+				succeeded++
+				mu.Unlock()
+			} else {
+				assert.ErrorIs(t, err, prize_models.ErrDailyExchangeCapReached)
+			}
+		}()
+	}
+	wg.Wait()
+
+	assert.Equal(t, 5, succeeded)
+	ps, _ := prize_models.GetPointsSystem(db, userID)
+	assert.Equal(t, 9500, ps.Coins)
+	assert.Equal(t, 5, ps.Points)
+}
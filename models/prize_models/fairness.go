@@ -0,0 +1,252 @@
+package prize_models
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"time"
+
+	"gorm.io/gorm"
+	"xy.com/mysite/models"
+)
+
+var (
+	ErrSeedNotRevealed   = models.NewError(models.KindConflict, "seed_not_revealed", "server seed has not been revealed yet")
+	ErrInvalidClientSeed = models.NewError(models.KindInvalid, "invalid_client_seed", "client seed must be at most 64 characters")
+	ErrDrawNotFound      = models.NewError(models.KindNotFound, "draw_not_found", "draw not found")
+	ErrDrawSeedRequired  = models.NewError(models.KindConflict, "draw_seed_required", "fetch your draw seed before drawing")
+)
+
+// DrawSeed is a user's provably fair seed pair. The hash of the server seed is
+// shown to the user before any draw uses it; the seed itself is only revealed
+// when the user rotates to a new pair. Each draw uses the next nonce.
+type DrawSeed struct {
+	gorm.Model
+	UserID         uint       `json:"user_id" gorm:"index"` // One unrevealed pair per user, see MigrateDrawSeeds
+	ServerSeed     string     `json:"-" gorm:"size:64;not null"`
+	ServerSeedHash string     `json:"server_seed_hash" gorm:"size:64;not null"`
+	ClientSeed     string     `json:"client_seed" gorm:"size:64;not null"`
+	Nonce          int        `json:"nonce"` // Nonce of the next draw
+	RevealedAt     *time.Time `json:"revealed_at"`
+}
+
+// DrawVerification is the result of replaying a past draw.
+type DrawVerification struct {
+	DrawID         uint            `json:"draw_id"`
+	ServerSeed     string          `json:"server_seed"`
+	ServerSeedHash string          `json:"server_seed_hash"`
+	ClientSeed     string          `json:"client_seed"`
+	Nonce          int             `json:"nonce"`
+	Guaranteed     bool            `json:"guaranteed"`
+	Outcomes       []RewardOutcome `json:"outcomes"` // The table's outcomes at the time of the draw, in draw order
+	Roll           float64         `json:"roll"`
+	OutcomeID      uint            `json:"outcome_id"`
+	Valid          bool            `json:"valid"` // The seed matches its hash and the replay gives the recorded outcome
+}
+
+// HashServerSeed returns the commitment published for a server seed.
+func HashServerSeed(serverSeed string) string {
+	sum := sha256.Sum256([]byte(serverSeed))
+	return hex.EncodeToString(sum[:])
+}
+
+// FairRoll derives a number in [0, 1) from HMAC-SHA256 of the client seed and
+// nonce keyed by the server seed.
+func FairRoll(serverSeed, clientSeed string, nonce int) float64 {
+	mac := hmac.New(sha256.New, []byte(serverSeed))
+	fmt.Fprintf(mac, "%s:%d", clientSeed, nonce)
+	sum := mac.Sum(nil)
+
+	// Use the top 53 bits, the precision of a float64
+	return float64(binary.BigEndian.Uint64(sum[:8])>>11) / (1 << 53)
+}
+
+// ReplayDraw picks the outcome a roll selects from outcomes ordered by ID. If
+// guaranteed is set only rare outcomes are eligible, as for a draw awarded by
+// the pity counter.
+func ReplayDraw(outcomes []RewardOutcome, roll float64, guaranteed bool) (*RewardOutcome, error) {
+	filter := func(RewardOutcome) bool { return true }
+	if guaranteed {
+		filter = func(outcome RewardOutcome) bool { return outcome.Rare }
+	}
+	return pickOutcome(outcomes, func(total int) int {
+		r := int(roll * float64(total))
+		if r >= total {
+			r = total - 1
+		}
+		return r
+	}, filter)
+}
+
+// newDrawSeed creates a seed pair with a fresh server seed. An empty client
+// seed is replaced by a random one.
+func (e *DrawEngine) newDrawSeed(db *gorm.DB, userID uint, clientSeed string) (*DrawSeed, error) {
+	serverSeed, err := e.randomHex(32)
+	if err != nil {
+		return nil, err
+	}
+	if clientSeed == "" {
+		if clientSeed, err = e.randomHex(8); err != nil {
+			return nil, err
+		}
+	}
+	if len(clientSeed) > 64 {
+		return nil, ErrInvalidClientSeed
+	}
+
+	seed := &DrawSeed{
+		UserID:         userID,
+		ServerSeed:     serverSeed,
+		ServerSeedHash: HashServerSeed(serverSeed),
+		ClientSeed:     clientSeed,
+	}
+	if err := db.Create(seed).Error; err != nil {
+		return nil, fmt.Errorf("failed to create draw seed: %w", err)
+	}
+	return seed, nil
+}
+
+// MigrateDrawSeeds reveals all but the latest unrevealed seed pair of each
+// user, which concurrent requests could create, and makes sure there is only
+// ever one from then on.
+func MigrateDrawSeeds(db *gorm.DB) error {
+	if db.Migrator().HasIndex(&DrawSeed{}, "idx_draw_seeds_active") {
+		return nil
+	}
+	latest := db.Model(&DrawSeed{}).Where("revealed_at IS NULL").Select("MAX(id)").Group("user_id")
+	err := db.Model(&DrawSeed{}).Where("revealed_at IS NULL AND id NOT IN (?)", latest).
+		Update("revealed_at", time.Now()).Error
+	if err != nil {
+		return fmt.Errorf("failed to reveal extra draw seeds: %w", err)
+	}
+	return db.Exec("CREATE UNIQUE INDEX idx_draw_seeds_active ON draw_seeds(user_id) WHERE revealed_at IS NULL AND deleted_at IS NULL").Error
+}
+
+// activeDrawSeed retrieves the user's unrevealed seed pair, or
+// ErrDrawSeedRequired if they have not fetched one yet.
+func activeDrawSeed(db *gorm.DB, userID uint) (*DrawSeed, error) {
+	var seed DrawSeed
+	err := db.Where("user_id = ? AND revealed_at IS NULL", userID).First(&seed).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrDrawSeedRequired
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &seed, nil
+}
+
+// GetActiveDrawSeed retrieves the user's current seed pair, creating one if
+// there is none. Its server seed stays hidden. Users fetch it before their first
+// draw, so that they know the hash of every server seed before it is used.
+func (e *DrawEngine) GetActiveDrawSeed(db *gorm.DB, userID uint) (*DrawSeed, error) {
+	var seed *DrawSeed
+	err := db.Transaction(func(tx *gorm.DB) error {
+		var err error
+		seed, err = activeDrawSeed(tx, userID)
+		if errors.Is(err, ErrDrawSeedRequired) {
+			seed, err = e.newDrawSeed(tx, userID, "")
+		}
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return seed, nil
+}
+
+// RotateDrawSeed reveals the user's current server seed and starts a new seed
+// pair with the given client seed. It returns the revealed pair and the new one.
+func (e *DrawEngine) RotateDrawSeed(db *gorm.DB, userID uint, clientSeed string) (revealed *DrawSeed, next *DrawSeed, err error) {
+	err = db.Transaction(func(tx *gorm.DB) error {
+		revealed, err = e.GetActiveDrawSeed(tx, userID)
+		if err != nil {
+			return err
+		}
+
+		now := time.Now()
+		if err := tx.Model(revealed).Update("revealed_at", now).Error; err != nil {
+			return fmt.Errorf("failed to reveal draw seed: %w", err)
+		}
+
+		next, err = e.newDrawSeed(tx, userID, clientSeed)
+		return err
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	return revealed, next, nil
+}
+
+// GetRevealedDrawSeeds retrieves the user's revealed seed pairs, newest first.
+func GetRevealedDrawSeeds(db *gorm.DB, userID uint) ([]DrawSeed, error) {
+	var seeds []DrawSeed
+	err := db.Where("user_id = ? AND revealed_at IS NOT NULL", userID).Order("id desc").Find(&seeds).Error
+	if err != nil {
+		return nil, err
+	}
+	return seeds, nil
+}
+
+// outcomesAt retrieves the outcomes a reward table had at the given time.
+// Outcomes replaced by UpdateRewardTable are soft deleted, so they can still be found.
+func outcomesAt(db *gorm.DB, tableID uint, at time.Time) ([]RewardOutcome, error) {
+	var outcomes []RewardOutcome
+	err := db.Unscoped().
+		Where("reward_table_id = ? AND created_at <= ?", tableID, at).
+		Where("deleted_at IS NULL OR deleted_at > ?", at).
+		Order("id").
+		Find(&outcomes).Error
+	if err != nil {
+		return nil, err
+	}
+	return outcomes, nil
+}
+
+// VerifyDraw replays one of the user's draws from its revealed seeds.
+func VerifyDraw(db *gorm.DB, userID uint, drawID uint) (*DrawVerification, error) {
+	var record DrawRecord
+	err := db.Where("id = ? AND user_id = ?", drawID, userID).First(&record).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrDrawNotFound
+		}
+		return nil, err
+	}
+
+	var seed DrawSeed
+	if err := db.First(&seed, record.DrawSeedID).Error; err != nil {
+		return nil, fmt.Errorf("failed to get draw seed: %w", err)
+	}
+	if seed.RevealedAt == nil {
+		return nil, ErrSeedNotRevealed
+	}
+
+	outcomes, err := outcomesAt(db, record.RewardTableID, record.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+
+	verification := &DrawVerification{
+		DrawID:         record.ID,
+		ServerSeed:     seed.ServerSeed,
+		ServerSeedHash: record.ServerSeedHash,
+		ClientSeed:     record.ClientSeed,
+		Nonce:          record.Nonce,
+		Guaranteed:     record.Guaranteed,
+		Outcomes:       outcomes,
+		Roll:           FairRoll(seed.ServerSeed, record.ClientSeed, record.Nonce),
+	}
+	outcome, err := ReplayDraw(outcomes, verification.Roll, record.Guaranteed)
+	if err == nil {
+		verification.OutcomeID = outcome.ID
+	}
+	verification.Valid = err == nil &&
+		HashServerSeed(seed.ServerSeed) == record.ServerSeedHash &&
+		verification.Roll == record.Roll &&
+		outcome.ID == record.OutcomeID
+	return verification, nil
+}
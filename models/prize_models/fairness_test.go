@@ -0,0 +1,187 @@
+package prize_models_test
+
+import (
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"xy.com/mysite/models/prize_models"
+)
+
+func TestFairRoll(t *testing.T) {
+	roll := prize_models.FairRoll("server", "client", 0)
+	assert.Equal(t, roll, prize_models.FairRoll("server", "client", 0))
+	assert.NotEqual(t, roll, prize_models.FairRoll("server", "client", 1))
+	assert.NotEqual(t, roll, prize_models.FairRoll("server", "other", 0))
+	assert.GreaterOrEqual(t, roll, 0.0)
+	assert.Less(t, roll, 1.0)
+
+	outcomes := []prize_models.RewardOutcome{{Weight: 1}, {Weight: 3, Rare: true}}
+	outcomes[0].ID, outcomes[1].ID = 1, 2
+	outcome, err := prize_models.ReplayDraw(outcomes, 0.2, false)
+	assert.NoError(t, err)
+	assert.Equal(t, uint(1), outcome.ID)
+	outcome, err = prize_models.ReplayDraw(outcomes, 0.2, true)
+	assert.NoError(t, err)
+	assert.Equal(t, uint(2), outcome.ID)
+}
+
+func TestProvablyFairDraws(t *testing.T) {
+	db := setupPointsDB(t)
+	userID := uint(1)
+	engine := prize_models.NewDrawEngine(1)
+
+	table := &prize_models.RewardTable{
+		Name:   "fair",
+		Active: true,
+		Outcomes: []prize_models.RewardOutcome{
+			{Kind: prize_models.OutcomeNothing, Weight: 1},
+			{Kind: prize_models.OutcomePoints, Amount: 10, Weight: 1},
+		},
+	}
+	assert.NoError(t, prize_models.CreateRewardTable(db, table))
+
+	// The server seed is committed before the draws, which wait for it
+	_, err := engine.Draw(db, userID, table.ID)
+	assert.ErrorIs(t, err, prize_models.ErrDrawSeedRequired)
+	committed, err := engine.GetActiveDrawSeed(db, userID)
+	assert.NoError(t, err)
+
+	var records []*prize_models.DrawRecord
+	for i := 0; i < 3; i++ {
+		record, err := engine.Draw(db, userID, table.ID)
+		assert.NoError(t, err)
+		assert.Equal(t, i, record.Nonce)
+		assert.Equal(t, committed.ServerSeedHash, record.ServerSeedHash)
+		records = append(records, record)
+	}
+
+	_, err = prize_models.VerifyDraw(db, userID, records[0].ID)
+	assert.ErrorIs(t, err, prize_models.ErrSeedNotRevealed)
+
+	revealed, next, err := engine.RotateDrawSeed(db, userID, "my seed")
+	assert.NoError(t, err)
+	assert.Equal(t, committed.ID, revealed.ID)
+	assert.Equal(t, committed.ServerSeedHash, prize_models.HashServerSeed(revealed.ServerSeed))
+	assert.Equal(t, "my seed", next.ClientSeed)
+	assert.NotEqual(t, committed.ServerSeedHash, next.ServerSeedHash)
+
+	// Changing the table afterwards does not affect verification of earlier draws
+	table.Outcomes = []prize_models.RewardOutcome{{Kind: prize_models.OutcomeNothing, Weight: 1}}
+	assert.NoError(t, prize_models.UpdateRewardTable(db, table))
+
+	for _, record := range records {
+		verification, err := prize_models.VerifyDraw(db, userID, record.ID)
+		assert.NoError(t, err)
+		assert.True(t, verification.Valid)
+		assert.Equal(t, record.OutcomeID, verification.OutcomeID)
+		assert.Equal(t, revealed.ServerSeed, verification.ServerSeed)
+		assert.Equal(t, 2, len(verification.Outcomes))
+	}
+
+	// Draws after the rotation use the new pair from nonce zero
+	record, err := engine.Draw(db, userID, table.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, next.ID, record.DrawSeedID)
+	assert.Equal(t, 0, record.Nonce)
+
+	// Other users' draws cannot be looked up
+	_, err = prize_models.VerifyDraw(db, userID+1, records[0].ID)
+	assert.ErrorIs(t, err, prize_models.ErrDrawNotFound)
+
+	// A tampered record no longer verifies
+	db.Model(records[1]).Update("roll", 0.5)
+	verification, err := prize_models.VerifyDraw(db, userID, records[1].ID)
+	assert.NoError(t, err)
+	assert.False(t, verification.Valid)
+}
+
+func TestConcurrentDrawsTakeDistinctNonces(t *testing.T) {
+	db := setupPointsDB(t)
+	userID := uint(1)
+	engine := prize_models.NewDrawEngine(1)
+	const draws = 10
+	sqlDB, err := db.DB()
+	assert.NoError(t, err)
+
+	table := &prize_models.RewardTable{
+		Name:     "nonces",
+		Active:   true,
+		Outcomes: []prize_models.RewardOutcome{{Kind: prize_models.OutcomeNothing, Weight: 1}},
+	}
+	assert.NoError(t, prize_models.CreateRewardTable(db, table))
+	seed, err := engine.GetActiveDrawSeed(db, userID)
+	assert.NoError(t, err)
+
+	var (
+		wg     sync.WaitGroup
+		mu     sync.Mutex
+		nonces = make(map[int]bool)
+		start  = make(chan struct{})
+	)
+	for i := 0; i < draws; i++ {
+		session, conn := connSession(t, sqlDB)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer conn.Close()
+			<-start
+			record, err := engine.Draw(session, userID, table.ID)
+			if !assert.NoError(t, err) {
+				return
+			}
+			mu.Lock()
+			defer mu.Unlock()
+			assert.False(t, nonces[record.Nonce], "nonce %d drawn twice", record.Nonce)
+			nonces[record.Nonce] = true
+		}()
+	}
+	close(start)
+	wg.Wait()
+
+	for nonce := 0; nonce < draws; nonce++ {
+		assert.True(t, nonces[nonce], "nonce %d not drawn", nonce)
+	}
+	next, err := engine.GetActiveDrawSeed(db, userID)
+	assert.NoError(t, err)
+	assert.Equal(t, seed.ID, next.ID)
+	assert.Equal(t, draws, next.Nonce)
+}
+
+func TestConcurrentSeedFetchesShareOneSeed(t *testing.T) {
+	db := setupPointsDB(t)
+	userID := uint(1)
+	engine := prize_models.NewDrawEngine(1)
+	sqlDB, err := db.DB()
+	assert.NoError(t, err)
+
+	var (
+		wg     sync.WaitGroup
+		mu     sync.Mutex
+		hashes = make(map[string]bool)
+		start  = make(chan struct{})
+	)
+	for i := 0; i < 5; i++ {
+		session, conn := connSession(t, sqlDB)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer conn.Close()
+			<-start
+			seed, err := engine.GetActiveDrawSeed(session, userID)
+			if !assert.NoError(t, err) {
+				return
+			}
+			mu.Lock()
+			defer mu.Unlock()
+			hashes[seed.ServerSeedHash] = true
+		}()
+	}
+	close(start)
+	wg.Wait()
+	assert.Equal(t, 1, len(hashes))
+
+	// The database refuses a second unrevealed seed
+	err = db.Create(&prize_models.DrawSeed{UserID: userID, ServerSeed: "x", ServerSeedHash: "x", ClientSeed: "x"}).Error
+	assert.Error(t, err)
+}
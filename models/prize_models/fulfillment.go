@@ -0,0 +1,206 @@
+package prize_models
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"gorm.io/gorm"
+	"xy.com/mysite/models"
+)
+
+// Fulfillment states of an exchanged prize. Physical prizes start as requested;
+// everything else is delivered as soon as it is exchanged.
+const (
+	FulfillmentRequested = "requested"
+	FulfillmentApproved  = "approved"
+	FulfillmentShipped   = "shipped"
+	FulfillmentDelivered = "delivered"
+	FulfillmentRejected  = "rejected"
+)
+
+var (
+	ErrShippingRequired          = models.NewError(models.KindInvalid, "shipping_required", "shipping details are required for physical prizes")
+	ErrInvalidShipping           = models.NewError(models.KindInvalid, "invalid_shipping", "invalid shipping details")
+	ErrInvalidFulfillmentStatus  = models.NewError(models.KindInvalid, "invalid_fulfillment_status", "invalid fulfillment status")
+	ErrInvalidFulfillmentChange  = models.NewError(models.KindConflict, "invalid_fulfillment_change", "fulfillment status cannot change this way")
+	ErrTrackingNumberRequired    = models.NewError(models.KindInvalid, "tracking_number_required", "a tracking number is required to ship a prize")
+	ErrRejectReasonRequired      = models.NewError(models.KindInvalid, "reject_reason_required", "a reason is required to reject a request")
+	errFulfillmentChangedByOther = errors.New("exchange was updated concurrently")
+)
+
+// fulfillmentTransitions lists the states each state may move to.
+var fulfillmentTransitions = map[string][]string{
+	FulfillmentRequested: {FulfillmentApproved, FulfillmentRejected},
+	FulfillmentApproved:  {FulfillmentShipped, FulfillmentRejected},
+	FulfillmentShipped:   {FulfillmentDelivered},
+}
+
+// ShippingDetails is where a physical prize is sent, captured at exchange.
+type ShippingDetails struct {
+	RecipientName string `json:"recipient_name"`
+	Address       string `json:"address"`
+	Phone         string `json:"phone"`
+}
+
+// Validate trims and checks the shipping details.
+func (s *ShippingDetails) Validate() error {
+	s.RecipientName = strings.TrimSpace(s.RecipientName)
+	s.Address = strings.TrimSpace(s.Address)
+	s.Phone = strings.TrimSpace(s.Phone)
+
+	switch {
+	case s.RecipientName == "":
+		return fmt.Errorf("%w: recipient name is required", ErrInvalidShipping)
+	case s.Address == "" || len(s.Address) > 1024:
+		return fmt.Errorf("%w: address must be between 1 and 1024 characters", ErrInvalidShipping)
+	case s.Phone == "" || len(s.Phone) > 32:
+		return fmt.Errorf("%w: phone must be between 1 and 32 characters", ErrInvalidShipping)
+	}
+	return nil
+}
+
+// FulfillmentUpdate is an admin's change to the fulfillment of an exchange.
+type FulfillmentUpdate struct {
+	Status         string `json:"status"`
+	Carrier        string `json:"carrier"`
+	TrackingNumber string `json:"tracking_number"` // Required when shipping
+	Reason         string `json:"reason"`          // Required when rejecting
+}
+
+// ValidFulfillmentStatus reports whether status is a known fulfillment status.
+func ValidFulfillmentStatus(status string) bool {
+	switch status {
+	case FulfillmentRequested, FulfillmentApproved, FulfillmentShipped, FulfillmentDelivered, FulfillmentRejected:
+		return true
+	}
+	return false
+}
+
+func canChangeFulfillment(from, to string) bool {
+	for _, next := range fulfillmentTransitions[from] {
+		if next == to {
+			return true
+		}
+	}
+	return false
+}
+
+// UpdateFulfillment moves an exchange to the next fulfillment state. Rejecting a
+// request refunds its points and returns the prize to stock in the same transaction.
+func UpdateFulfillment(db *gorm.DB, exchangeID uint, update FulfillmentUpdate) (*ExchangedPrize, error) {
+	if !ValidFulfillmentStatus(update.Status) {
+		return nil, fmt.Errorf("%w %q", ErrInvalidFulfillmentStatus, update.Status)
+	}
+	if update.Status == FulfillmentShipped && strings.TrimSpace(update.TrackingNumber) == "" {
+		return nil, ErrTrackingNumberRequired
+	}
+	if update.Status == FulfillmentRejected && strings.TrimSpace(update.Reason) == "" {
+		return nil, ErrRejectReasonRequired
+	}
+
+	var exchange ExchangedPrize
+	err := db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.First(&exchange, exchangeID).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return ErrExchangeNotFound
+			}
+			return err
+		}
+		if !canChangeFulfillment(exchange.Status, update.Status) {
+			return fmt.Errorf("%w: from %s to %s", ErrInvalidFulfillmentChange, exchange.Status, update.Status)
+		}
+
+		changes := map[string]interface{}{"status": update.Status}
+		switch update.Status {
+		case FulfillmentShipped:
+			changes["carrier"] = strings.TrimSpace(update.Carrier)
+			changes["tracking_number"] = strings.TrimSpace(update.TrackingNumber)
+		case FulfillmentRejected:
+			changes["reject_reason"] = strings.TrimSpace(update.Reason)
+		}
+
+		// Only move on from the state that was checked, in case another admin got there first
+		result := tx.Model(&exchange).Where("status = ?", exchange.Status).Updates(changes)
+		if result.Error != nil {
+			return fmt.Errorf("failed to update fulfillment: %w", result.Error)
+		}
+		if result.RowsAffected == 0 {
+			return fmt.Errorf("%w: %v", ErrInvalidFulfillmentChange, errFulfillmentChangedByOther)
+		}
+
+		if update.Status == FulfillmentRejected {
+			return refundExchange(tx, &exchange)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &exchange, nil
+}
+
+// refundExchange returns the points paid for an exchange and puts the prize back in stock.
+func refundExchange(tx *gorm.DB, exchange *ExchangedPrize) error {
+	if exchange.Cost > 0 {
+		err := CreditPoints(tx, exchange.UserID, exchange.Cost, LedgerReasonRefund, fmt.Sprintf("exchange:%d", exchange.ID))
+		if err != nil {
+			return err
+		}
+	}
+
+	err := tx.Model(&Prize{}).
+		Where("id = ? AND stock IS NOT NULL", exchange.PrizeID).
+		Update("stock", gorm.Expr("stock + 1")).Error
+	if err != nil {
+		return fmt.Errorf("failed to restock prize: %w", err)
+	}
+	return nil
+}
+
+// GetFulfillmentQueue retrieves a page of physical prize exchanges in the given
+// state, oldest first, and their total number.
+func GetFulfillmentQueue(db *gorm.DB, status string, offset, limit int) ([]ExchangedPrize, int64, error) {
+	if !ValidFulfillmentStatus(status) {
+		return nil, 0, fmt.Errorf("%w %q", ErrInvalidFulfillmentStatus, status)
+	}
+
+	query := db.Model(&ExchangedPrize{}).Where("status = ? AND shipping_address <> ''", status)
+	var total int64
+	if err := query.Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+
+	var exchanges []ExchangedPrize
+	if err := query.Order("id").Offset(offset).Limit(limit).Find(&exchanges).Error; err != nil {
+		return nil, 0, err
+	}
+	return exchanges, total, nil
+}
+
+// GetUserExchanges retrieves a page of the user's exchanges, newest first, and their total number.
+func GetUserExchanges(db *gorm.DB, userID uint, offset, limit int) ([]ExchangedPrize, int64, error) {
+	var total int64
+	if err := db.Model(&ExchangedPrize{}).Where("user_id = ?", userID).Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+
+	var exchanges []ExchangedPrize
+	err := db.Where("user_id = ?", userID).Order("id desc").Offset(offset).Limit(limit).Find(&exchanges).Error
+	if err != nil {
+		return nil, 0, err
+	}
+	return exchanges, total, nil
+}
+
+// GetUserExchange retrieves one of the user's exchanges.
+func GetUserExchange(db *gorm.DB, userID uint, exchangeID uint) (*ExchangedPrize, error) {
+	var exchange ExchangedPrize
+	if err := db.Where("id = ? AND user_id = ?", exchangeID, userID).First(&exchange).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrExchangeNotFound
+		}
+		return nil, err
+	}
+	return &exchange, nil
+}
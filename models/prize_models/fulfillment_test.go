@@ -0,0 +1,162 @@
+package prize_models_test
+
+import (
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"gorm.io/gorm"
+	"xy.com/mysite/models"
+	"xy.com/mysite/models/prize_models"
+)
+
+var testShipping = &prize_models.ShippingDetails{RecipientName: "Alice", Address: "1 Main St", Phone: "555-0100"}
+
+// createPhysicalPrize creates a physical prize with the given stock and gives the user enough points for two.
+func createPhysicalPrize(t *testing.T, db *gorm.DB, userID uint, stock int) *prize_models.Prize {
+	prize := &prize_models.Prize{PrizeName: "Mug", Cost: 100, Stock: &stock, PerUserLimit: 2, Physical: true}
+	if err := prize_models.CreatePrize(db, prize); err != nil {
+		t.Fatal(err)
+	}
+	if err := prize_models.CreditPoints(db, userID, 200, prize_models.LedgerReasonAdminAdjustment, ""); err != nil {
+		t.Fatal(err)
+	}
+	return prize
+}
+
+func TestExchangePhysicalPrize(t *testing.T) {
+	db := setupPointsDB(t)
+	userID := uint(1)
+	createPhysicalPrize(t, db, userID, 5)
+
+	_, err := prize_models.ExchangePrizeWithShipping(db, userID, "Mug", nil)
+	assert.ErrorIs(t, err, prize_models.ErrShippingRequired)
+	_, err = prize_models.ExchangePrizeWithShipping(db, userID, "Mug", &prize_models.ShippingDetails{RecipientName: "Alice"})
+	assert.ErrorIs(t, err, prize_models.ErrInvalidShipping)
+	assert.ErrorIs(t, err, models.ErrInvalid)
+
+	shipping := *testShipping
+	exchange, err := prize_models.ExchangePrizeWithShipping(db, userID, "Mug", &shipping)
+	assert.NoError(t, err)
+	assert.Equal(t, prize_models.FulfillmentRequested, exchange.Status)
+	assert.Equal(t, "1 Main St", exchange.ShippingAddress)
+	assert.Equal(t, 100, exchange.Cost)
+	assert.Empty(t, exchange.RedemptionCode)
+
+	ps, _ := prize_models.GetPointsSystem(db, userID)
+	assert.Equal(t, 100, ps.Points)
+
+	queue, total, err := prize_models.GetFulfillmentQueue(db, prize_models.FulfillmentRequested, 0, 10)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1), total)
+	assert.Equal(t, exchange.ID, queue[0].ID)
+
+	// Digital prizes are delivered immediately and never queued
+	assert.NoError(t, prize_models.AddPrize(db, "sticker", 0))
+	addPrizeCodes(t, db, "sticker", "code-1")
+	digital, err := prize_models.ExchangePrizeWithShipping(db, userID, "sticker", nil)
+	assert.NoError(t, err)
+	assert.Equal(t, prize_models.FulfillmentDelivered, digital.Status)
+	_, total, _ = prize_models.GetFulfillmentQueue(db, prize_models.FulfillmentDelivered, 0, 10)
+	assert.Equal(t, int64(0), total)
+
+	exchanges, total, err := prize_models.GetUserExchanges(db, userID, 0, 10)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(2), total)
+	assert.Equal(t, digital.ID, exchanges[0].ID)
+
+	_, err = prize_models.GetUserExchange(db, userID+1, exchange.ID)
+	assert.ErrorIs(t, err, prize_models.ErrExchangeNotFound)
+}
+
+func TestUpdateFulfillment(t *testing.T) {
+	db := setupPointsDB(t)
+	userID := uint(1)
+	createPhysicalPrize(t, db, userID, 5)
+
+	exchange, err := prize_models.ExchangePrizeWithShipping(db, userID, "Mug", testShipping)
+	assert.NoError(t, err)
+
+	_, err = prize_models.UpdateFulfillment(db, exchange.ID, prize_models.FulfillmentUpdate{Status: "lost"})
+	assert.ErrorIs(t, err, prize_models.ErrInvalidFulfillmentStatus)
+	_, err = prize_models.UpdateFulfillment(db, exchange.ID, prize_models.FulfillmentUpdate{Status: prize_models.FulfillmentDelivered})
+	assert.ErrorIs(t, err, prize_models.ErrInvalidFulfillmentChange)
+	_, err = prize_models.UpdateFulfillment(db, exchange.ID+100, prize_models.FulfillmentUpdate{Status: prize_models.FulfillmentApproved})
+	assert.ErrorIs(t, err, prize_models.ErrExchangeNotFound)
+
+	updated, err := prize_models.UpdateFulfillment(db, exchange.ID, prize_models.FulfillmentUpdate{Status: prize_models.FulfillmentApproved})
+	assert.NoError(t, err)
+	assert.Equal(t, prize_models.FulfillmentApproved, updated.Status)
+
+	_, err = prize_models.UpdateFulfillment(db, exchange.ID, prize_models.FulfillmentUpdate{Status: prize_models.FulfillmentShipped})
+	assert.ErrorIs(t, err, prize_models.ErrTrackingNumberRequired)
+	updated, err = prize_models.UpdateFulfillment(db, exchange.ID, prize_models.FulfillmentUpdate{
+		Status: prize_models.FulfillmentShipped, Carrier: "UPS", TrackingNumber: "1Z999",
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, "1Z999", updated.TrackingNumber)
+
+	// Shipped prizes can no longer be rejected
+	_, err = prize_models.UpdateFulfillment(db, exchange.ID, prize_models.FulfillmentUpdate{Status: prize_models.FulfillmentRejected, Reason: "oops"})
+	assert.ErrorIs(t, err, prize_models.ErrInvalidFulfillmentChange)
+
+	updated, err = prize_models.UpdateFulfillment(db, exchange.ID, prize_models.FulfillmentUpdate{Status: prize_models.FulfillmentDelivered})
+	assert.NoError(t, err)
+
+	got, err := prize_models.GetUserExchange(db, userID, exchange.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, prize_models.FulfillmentDelivered, got.Status)
+	assert.Equal(t, "UPS", got.Carrier)
+}
+
+func TestRejectFulfillmentRefunds(t *testing.T) {
+	db := setupPointsDB(t)
+	userID := uint(1)
+	prize := createPhysicalPrize(t, db, userID, 1)
+
+	exchange, err := prize_models.ExchangePrizeWithShipping(db, userID, "Mug", testShipping)
+	assert.NoError(t, err)
+	_, err = prize_models.ExchangePrizeWithShipping(db, userID, "Mug", testShipping)
+	assert.ErrorIs(t, err, prize_models.ErrOutOfStock)
+
+	_, err = prize_models.UpdateFulfillment(db, exchange.ID, prize_models.FulfillmentUpdate{Status: prize_models.FulfillmentRejected})
+	assert.ErrorIs(t, err, prize_models.ErrRejectReasonRequired)
+
+	// Two admins rejecting at once refund only once
+	var wg sync.WaitGroup
+	errs := make([]error, 5)
+	for i := range errs {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			_, errs[i] = prize_models.UpdateFulfillment(db, exchange.ID, prize_models.FulfillmentUpdate{
+				Status: prize_models.FulfillmentRejected, Reason: "address not deliverable",
+			})
+		}(i)
+	}
+	wg.Wait()
+
+	succeeded := 0
+	for _, err := range errs {
+		if err == nil {
+			succeeded++
+		} else {
+			assert.ErrorIs(t, err, prize_models.ErrInvalidFulfillmentChange)
+		}
+	}
+	assert.Equal(t, 1, succeeded)
+
+	ps, _ := prize_models.GetPointsSystem(db, userID)
+	assert.Equal(t, 200, ps.Points)
+	check, err := prize_models.VerifyBalance(db, userID)
+	assert.NoError(t, err)
+	assert.True(t, check.Consistent)
+
+	restocked, _ := prize_models.GetPrizeByID(db, prize.ID)
+	assert.Equal(t, 1, *restocked.Stock)
+
+	// Rejected requests do not count towards the per-user limit
+	again, err := prize_models.ExchangePrizeWithShipping(db, userID, "Mug", testShipping)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, again.Seq)
+}
@@ -0,0 +1,357 @@
+package prize_models
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"xy.com/mysite/models"
+	"xy.com/mysite/models/user_models"
+)
+
+// Leaderboards
+const (
+	LeaderboardAllTime  = "all_time" // Ranked by points balance
+	LeaderboardWeekly   = "weekly"   // Ranked by points won in draws during a week
+	LeaderboardCampaign = "campaign" // Ranked by points won in draws of a campaign
+)
+
+// LeaderboardSnapshotSize is how many entries of a weekly board are kept once the week is over.
+const LeaderboardSnapshotSize = 100
+
+var (
+	ErrInvalidLeaderboard = models.NewError(models.KindInvalid, "invalid_leaderboard", "invalid leaderboard")
+	ErrWeekNotOver        = models.NewError(models.KindConflict, "week_not_over", "the week is not over yet")
+)
+
+// LeaderboardExcludedSegments lists the user segments that are left off every leaderboard.
+var LeaderboardExcludedSegments = []string{user_models.SegmentAdmin, user_models.SegmentBanned}
+
+// LeaderboardEntry is a user's position on a leaderboard. Users with the same
+// score share a rank. A zero rank means the user is not on the board.
+type LeaderboardEntry struct {
+	Rank   int  `json:"rank"`
+	UserID uint `json:"user_id"`
+	Score  int  `json:"score"`
+	Draws  int  `json:"draws"`
+}
+
+// Leaderboard is the top of a leaderboard for a period.
+type Leaderboard struct {
+	Board      string             `json:"board"`
+	Period     string             `json:"period,omitempty"` // ISO week of weekly boards, e.g. 2024-W07
+	CampaignID uint               `json:"campaign_id,omitempty"`
+	Snapshot   bool               `json:"snapshot"` // Read from a snapshot taken after the week was over
+	Entries    []LeaderboardEntry `json:"entries"`
+}
+
+// LeaderboardQuery selects a leaderboard. Week may be any time in the week of a
+// weekly board; CampaignID is required for campaign boards.
+type LeaderboardQuery struct {
+	Board      string
+	Week       time.Time
+	CampaignID uint
+}
+
+// LeaderboardSnapshot is an entry of a weekly leaderboard kept after the week
+// is over, so later changes to draws or segments do not rewrite history.
+type LeaderboardSnapshot struct {
+	ID        uint      `json:"-" gorm:"primaryKey"`
+	CreatedAt time.Time `json:"created_at"`
+	Board     string    `json:"board" gorm:"size:16;not null;uniqueIndex:idx_leaderboard_snapshot_user"`
+	Period    string    `json:"period" gorm:"size:16;not null;uniqueIndex:idx_leaderboard_snapshot_user"`
+	UserID    uint      `json:"user_id" gorm:"not null;uniqueIndex:idx_leaderboard_snapshot_user"`
+	Rank      int       `json:"rank"`
+	Score     int       `json:"score"`
+	Draws     int       `json:"draws"`
+}
+
+// LeaderboardSnapshotPeriod marks a period of a board as snapshotted, including
+// weeks without any entries, so they are not snapshotted again.
+type LeaderboardSnapshotPeriod struct {
+	ID        uint      `json:"-" gorm:"primaryKey"`
+	CreatedAt time.Time `json:"created_at"`
+	Board     string    `json:"board" gorm:"size:16;not null;uniqueIndex:idx_leaderboard_snapshot_period"`
+	Period    string    `json:"period" gorm:"size:16;not null;uniqueIndex:idx_leaderboard_snapshot_period"`
+	Entries   int       `json:"entries"`
+}
+
+// WeekStart returns the start of the week containing t, Monday 00:00 UTC.
+func WeekStart(t time.Time) time.Time {
+	t = t.UTC()
+	offset := (int(t.Weekday()) + 6) % 7
+	return time.Date(t.Year(), t.Month(), t.Day()-offset, 0, 0, 0, 0, time.UTC)
+}
+
+// WeekPeriod returns the ISO week containing t, e.g. 2024-W07.
+func WeekPeriod(t time.Time) string {
+	year, week := WeekStart(t).ISOWeek()
+	return fmt.Sprintf("%d-W%02d", year, week)
+}
+
+// MigrateLeaderboards adds the indexes leaderboard queries rely on and marks the
+// periods snapshotted before snapshots were marked.
+func MigrateLeaderboards(db *gorm.DB) error {
+	if !db.Migrator().HasIndex(&DrawRecord{}, "idx_draw_records_created_at") {
+		if err := db.Exec("CREATE INDEX idx_draw_records_created_at ON draw_records(created_at)").Error; err != nil {
+			return err
+		}
+	}
+
+	var periods []LeaderboardSnapshotPeriod
+	err := db.Model(&LeaderboardSnapshot{}).
+		Select("board, period, COUNT(*) AS entries").
+		Where("NOT EXISTS (?)", db.Model(&LeaderboardSnapshotPeriod{}).Select("1").
+			Where("leaderboard_snapshot_periods.board = leaderboard_snapshots.board AND leaderboard_snapshot_periods.period = leaderboard_snapshots.period")).
+		Group("board, period").Scan(&periods).Error
+	if err != nil || len(periods) == 0 {
+		return err
+	}
+	return db.Create(&periods).Error
+}
+
+// isSnapshotted reports whether the period of the board has been snapshotted.
+func isSnapshotted(db *gorm.DB, board, period string) (bool, error) {
+	var count int64
+	err := db.Model(&LeaderboardSnapshotPeriod{}).Where("board = ? AND period = ?", board, period).Count(&count).Error
+	return count > 0, err
+}
+
+func (q *LeaderboardQuery) validate() error {
+	switch q.Board {
+	case LeaderboardAllTime:
+	case LeaderboardWeekly:
+		if q.Week.IsZero() {
+			q.Week = time.Now()
+		}
+	case LeaderboardCampaign:
+		if q.CampaignID == 0 {
+			return fmt.Errorf("%w: campaign is required", ErrInvalidLeaderboard)
+		}
+	default:
+		return fmt.Errorf("%w %q", ErrInvalidLeaderboard, q.Board)
+	}
+	return nil
+}
+
+// excludedUsers selects the users that are left off the leaderboards.
+func excludedUsers(db *gorm.DB) *gorm.DB {
+	return db.Model(&user_models.UserSegment{}).Select("user_id").Where("segment IN ?", LeaderboardExcludedSegments)
+}
+
+// scores selects the user_id, score and draws of every user on the board.
+func (q *LeaderboardQuery) scores(db *gorm.DB) *gorm.DB {
+	if q.Board == LeaderboardAllTime {
+		draws := db.Model(&DrawRecord{}).Select("user_id, COUNT(*) AS draws").Group("user_id")
+		return db.Table("points_systems").
+			Select("points_systems.user_id AS user_id, points_systems.points AS score, COALESCE(d.draws, 0) AS draws").
+			Joins("LEFT JOIN (?) AS d ON d.user_id = points_systems.user_id", draws).
+			Where("points_systems.user_id NOT IN (?)", excludedUsers(db))
+	}
+
+	query := db.Model(&DrawRecord{}).
+		Select("user_id, COALESCE(SUM(CASE WHEN kind = ? THEN amount ELSE 0 END), 0) AS score, COUNT(*) AS draws", OutcomePoints).
+		Where("user_id NOT IN (?)", excludedUsers(db)).
+		Group("user_id")
+	if q.Board == LeaderboardWeekly {
+		start := WeekStart(q.Week)
+		query = query.Where("created_at >= ? AND created_at < ?", start, start.AddDate(0, 0, 7))
+	} else {
+		query = query.Where("campaign_id = ?", q.CampaignID)
+	}
+	return query
+}
+
+// GetLeaderboard retrieves the top entries of a leaderboard. Weekly boards of weeks
+// that have been snapshotted are read from the snapshot.
+func GetLeaderboard(db *gorm.DB, q LeaderboardQuery, limit int) (*Leaderboard, error) {
+	if err := q.validate(); err != nil {
+		return nil, err
+	}
+
+	board := &Leaderboard{Board: q.Board, CampaignID: q.CampaignID, Entries: []LeaderboardEntry{}}
+	if q.Board == LeaderboardWeekly {
+		board.Period = WeekPeriod(q.Week)
+		snapshotted, err := isSnapshotted(db, q.Board, board.Period)
+		if err != nil {
+			return nil, err
+		}
+		if snapshotted {
+			var snapshots []LeaderboardSnapshot
+			err := db.Where("board = ? AND period = ?", q.Board, board.Period).Order("rank, user_id").Limit(limit).Find(&snapshots).Error
+			if err != nil {
+				return nil, err
+			}
+			board.Snapshot = true
+			for _, s := range snapshots {
+				board.Entries = append(board.Entries, LeaderboardEntry{Rank: s.Rank, UserID: s.UserID, Score: s.Score, Draws: s.Draws})
+			}
+			return board, nil
+		}
+	}
+
+	err := db.Table("(?) AS scores", q.scores(db)).Order("score desc, user_id").Limit(limit).Scan(&board.Entries).Error
+	if err != nil {
+		return nil, err
+	}
+	// Users with the same score share a rank
+	for i := range board.Entries {
+		if i > 0 && board.Entries[i].Score == board.Entries[i-1].Score {
+			board.Entries[i].Rank = board.Entries[i-1].Rank
+		} else {
+			board.Entries[i].Rank = i + 1
+		}
+	}
+	return board, nil
+}
+
+// GetLeaderboardRank retrieves the user's position on a leaderboard. Users who are
+// not on the board, or not in the snapshot of a past week, get a zero rank.
+func GetLeaderboardRank(db *gorm.DB, q LeaderboardQuery, userID uint) (*LeaderboardEntry, error) {
+	if err := q.validate(); err != nil {
+		return nil, err
+	}
+	entry := &LeaderboardEntry{UserID: userID}
+
+	if q.Board == LeaderboardWeekly {
+		period := WeekPeriod(q.Week)
+		snapshotted, err := isSnapshotted(db, q.Board, period)
+		if err != nil {
+			return nil, err
+		}
+		if snapshotted {
+			var snapshot LeaderboardSnapshot
+			err := db.Where("board = ? AND period = ? AND user_id = ?", q.Board, period, userID).First(&snapshot).Error
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return entry, nil
+			}
+			if err != nil {
+				return nil, err
+			}
+			entry.Rank, entry.Score, entry.Draws = snapshot.Rank, snapshot.Score, snapshot.Draws
+			return entry, nil
+		}
+	}
+
+	var found []LeaderboardEntry
+	err := db.Table("(?) AS scores", q.scores(db)).Where("user_id = ?", userID).Limit(1).Scan(&found).Error
+	if err != nil {
+		return nil, err
+	}
+	if len(found) == 0 {
+		return entry, nil
+	}
+	entry.Score, entry.Draws = found[0].Score, found[0].Draws
+
+	var ahead int64
+	if err := db.Table("(?) AS scores", q.scores(db)).Where("score > ?", entry.Score).Count(&ahead).Error; err != nil {
+		return nil, err
+	}
+	entry.Rank = int(ahead) + 1
+	return entry, nil
+}
+
+// SnapshotWeeklyLeaderboard stores the top of the weekly board of the week containing
+// week, once the week is over. Snapshotting a week again has no effect.
+func SnapshotWeeklyLeaderboard(db *gorm.DB, week time.Time) (int, error) {
+	start := WeekStart(week)
+	if time.Now().Before(start.AddDate(0, 0, 7)) {
+		return 0, fmt.Errorf("%w: %s", ErrWeekNotOver, WeekPeriod(start))
+	}
+
+	period := WeekPeriod(start)
+	var created int
+	err := db.Transaction(func(tx *gorm.DB) error {
+		board, err := GetLeaderboard(tx, LeaderboardQuery{Board: LeaderboardWeekly, Week: start}, LeaderboardSnapshotSize)
+		if err != nil {
+			return err
+		}
+		if board.Snapshot {
+			return nil
+		}
+
+		// Weeks without entries are marked too, so they are not looked at again
+		marker := &LeaderboardSnapshotPeriod{Board: LeaderboardWeekly, Period: period, Entries: len(board.Entries)}
+		result := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(marker)
+		if result.Error != nil {
+			return fmt.Errorf("failed to save leaderboard snapshot: %w", result.Error)
+		}
+		if result.RowsAffected == 0 || len(board.Entries) == 0 {
+			return nil
+		}
+
+		snapshots := make([]LeaderboardSnapshot, len(board.Entries))
+		for i, e := range board.Entries {
+			snapshots[i] = LeaderboardSnapshot{Board: LeaderboardWeekly, Period: period, UserID: e.UserID, Rank: e.Rank, Score: e.Score, Draws: e.Draws}
+		}
+		result = tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&snapshots)
+		if result.Error != nil {
+			return fmt.Errorf("failed to save leaderboard snapshot: %w", result.Error)
+		}
+		created = int(result.RowsAffected)
+		return nil
+	})
+	return created, err
+}
+
+// SnapshotMissedLeaderboards snapshots the weekly boards of the weeks since the
+// last snapshotted one up to the week before now, oldest first, so that weeks
+// missed while the server was down are caught up. It returns the number of
+// entries saved.
+func SnapshotMissedLeaderboards(db *gorm.DB, now time.Time) (int, error) {
+	var first DrawRecord
+	err := db.Select("created_at").Order("created_at").First(&first).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	// Every snapshotted week is marked, even without entries, so this stops at
+	// the last snapshotted week, or at the first draw when there is none
+	var missed []time.Time
+	for week := WeekStart(now).AddDate(0, 0, -7); !week.Before(WeekStart(first.CreatedAt)); week = week.AddDate(0, 0, -7) {
+		snapshotted, err := isSnapshotted(db, LeaderboardWeekly, WeekPeriod(week))
+		if err != nil {
+			return 0, err
+		}
+		if snapshotted {
+			break
+		}
+		missed = append(missed, week)
+	}
+
+	created := 0
+	for i := len(missed) - 1; i >= 0; i-- {
+		n, err := SnapshotWeeklyLeaderboard(db, missed[i])
+		if err != nil {
+			return created, fmt.Errorf("failed to snapshot leaderboard of %s: %w", WeekPeriod(missed[i]), err)
+		}
+		created += n
+	}
+	return created, nil
+}
+
+// RunLeaderboardSnapshots snapshots the weekly boards of past weeks that have
+// not been snapshotted yet every interval until stop is closed.
+func RunLeaderboardSnapshots(db *gorm.DB, interval time.Duration, stop <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		if n, err := SnapshotMissedLeaderboards(db, time.Now()); err != nil {
+			log.Print(err)
+		} else if n > 0 {
+			log.Printf("Snapshotted %d leaderboard entries", n)
+		}
+
+		select {
+		case <-ticker.C:
+		case <-stop:
+			return
+		}
+	}
+}
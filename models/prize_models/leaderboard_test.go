@@ -0,0 +1,199 @@
+package prize_models_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"gorm.io/gorm"
+	"xy.com/mysite/models/prize_models"
+	"xy.com/mysite/models/user_models"
+)
+
+// addDrawRecord records a draw that won the given points at the given time.
+func addDrawRecord(t *testing.T, db *gorm.DB, userID, campaignID uint, points int, at time.Time) {
+	record := &prize_models.DrawRecord{UserID: userID, CampaignID: campaignID, Kind: prize_models.OutcomePoints, Amount: points}
+	record.CreatedAt = at
+	if err := db.Create(record).Error; err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestAllTimeLeaderboard(t *testing.T) {
+	db := setupPointsDB(t)
+	for userID, points := range map[uint]int{1: 300, 2: 500, 3: 300, 4: 100, 5: 900} {
+		db.Create(&prize_models.PointsSystem{UserID: userID, Points: points})
+	}
+	addDrawRecord(t, db, 1, 0, 10, time.Now())
+	addDrawRecord(t, db, 1, 0, 10, time.Now())
+	assert.NoError(t, user_models.AddUserToSegment(db, 5, user_models.SegmentAdmin))
+
+	board, err := prize_models.GetLeaderboard(db, prize_models.LeaderboardQuery{Board: prize_models.LeaderboardAllTime}, 3)
+	assert.NoError(t, err)
+	assert.Equal(t, []prize_models.LeaderboardEntry{
+		{Rank: 1, UserID: 2, Score: 500},
+		{Rank: 2, UserID: 1, Score: 300, Draws: 2},
+		{Rank: 2, UserID: 3, Score: 300},
+	}, board.Entries)
+
+	rank, err := prize_models.GetLeaderboardRank(db, prize_models.LeaderboardQuery{Board: prize_models.LeaderboardAllTime}, 4)
+	assert.NoError(t, err)
+	assert.Equal(t, 4, rank.Rank)
+	assert.Equal(t, 100, rank.Score)
+
+	// Admins and banned users are left off
+	rank, err = prize_models.GetLeaderboardRank(db, prize_models.LeaderboardQuery{Board: prize_models.LeaderboardAllTime}, 5)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, rank.Rank)
+	assert.NoError(t, user_models.AddUserToSegment(db, 2, user_models.SegmentBanned))
+	rank, _ = prize_models.GetLeaderboardRank(db, prize_models.LeaderboardQuery{Board: prize_models.LeaderboardAllTime}, 4)
+	assert.Equal(t, 3, rank.Rank)
+
+	_, err = prize_models.GetLeaderboard(db, prize_models.LeaderboardQuery{Board: "monthly"}, 3)
+	assert.ErrorIs(t, err, prize_models.ErrInvalidLeaderboard)
+	_, err = prize_models.GetLeaderboard(db, prize_models.LeaderboardQuery{Board: prize_models.LeaderboardCampaign}, 3)
+	assert.ErrorIs(t, err, prize_models.ErrInvalidLeaderboard)
+}
+
+func TestWeeklyAndCampaignLeaderboards(t *testing.T) {
+	db := setupPointsDB(t)
+	week := prize_models.WeekStart(time.Date(2024, 2, 14, 12, 0, 0, 0, time.UTC))
+	assert.Equal(t, time.Date(2024, 2, 12, 0, 0, 0, 0, time.UTC), week)
+	assert.Equal(t, "2024-W07", prize_models.WeekPeriod(week))
+
+	addDrawRecord(t, db, 1, 7, 50, week.Add(time.Hour))
+	addDrawRecord(t, db, 1, 0, 50, week.Add(2*time.Hour))
+	addDrawRecord(t, db, 2, 7, 80, week.AddDate(0, 0, 6))
+	addDrawRecord(t, db, 2, 7, 80, week.AddDate(0, 0, 7))  // Next week
+	addDrawRecord(t, db, 3, 0, 10, week.Add(-time.Minute)) // Previous week
+
+	q := prize_models.LeaderboardQuery{Board: prize_models.LeaderboardWeekly, Week: week.AddDate(0, 0, 3)}
+	board, err := prize_models.GetLeaderboard(db, q, 10)
+	assert.NoError(t, err)
+	assert.Equal(t, "2024-W07", board.Period)
+	assert.False(t, board.Snapshot)
+	assert.Equal(t, []prize_models.LeaderboardEntry{
+		{Rank: 1, UserID: 1, Score: 100, Draws: 2},
+		{Rank: 2, UserID: 2, Score: 80, Draws: 1},
+	}, board.Entries)
+
+	rank, err := prize_models.GetLeaderboardRank(db, q, 3)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, rank.Rank)
+
+	campaign := prize_models.LeaderboardQuery{Board: prize_models.LeaderboardCampaign, CampaignID: 7}
+	board, err = prize_models.GetLeaderboard(db, campaign, 10)
+	assert.NoError(t, err)
+	assert.Equal(t, uint(2), board.Entries[0].UserID)
+	assert.Equal(t, 160, board.Entries[0].Score)
+	rank, err = prize_models.GetLeaderboardRank(db, campaign, 1)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, rank.Rank)
+}
+
+func TestSnapshotWeeklyLeaderboard(t *testing.T) {
+	db := setupPointsDB(t)
+	week := prize_models.WeekStart(time.Date(2024, 2, 14, 0, 0, 0, 0, time.UTC))
+	addDrawRecord(t, db, 1, 0, 50, week.Add(time.Hour))
+	addDrawRecord(t, db, 2, 0, 80, week.Add(time.Hour))
+
+	_, err := prize_models.SnapshotWeeklyLeaderboard(db, time.Now())
+	assert.ErrorIs(t, err, prize_models.ErrWeekNotOver)
+
+	n, err := prize_models.SnapshotWeeklyLeaderboard(db, week)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, n)
+	n, err = prize_models.SnapshotWeeklyLeaderboard(db, week)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, n)
+
+	// Later changes do not rewrite a snapshotted week
+	addDrawRecord(t, db, 3, 0, 500, week.Add(2*time.Hour))
+	assert.NoError(t, user_models.AddUserToSegment(db, 2, user_models.SegmentBanned))
+
+	q := prize_models.LeaderboardQuery{Board: prize_models.LeaderboardWeekly, Week: week}
+	board, err := prize_models.GetLeaderboard(db, q, 10)
+	assert.NoError(t, err)
+	assert.True(t, board.Snapshot)
+	assert.Equal(t, []prize_models.LeaderboardEntry{
+		{Rank: 1, UserID: 2, Score: 80, Draws: 1},
+		{Rank: 2, UserID: 1, Score: 50, Draws: 1},
+	}, board.Entries)
+
+	rank, err := prize_models.GetLeaderboardRank(db, q, 1)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, rank.Rank)
+	rank, err = prize_models.GetLeaderboardRank(db, q, 3)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, rank.Rank)
+}
+
+func TestSnapshotMissedLeaderboards(t *testing.T) {
+	db := setupPointsDB(t)
+	now := time.Now()
+	n, err := prize_models.SnapshotMissedLeaderboards(db, now)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, n)
+
+	weeksAgo := func(weeks int) time.Time {
+		return prize_models.WeekStart(now).AddDate(0, 0, -7*weeks).Add(time.Hour)
+	}
+	for weeks := 1; weeks <= 5; weeks++ {
+		if weeks != 2 { // Nobody drew two weeks ago
+			addDrawRecord(t, db, uint(weeks), 0, 10, weeksAgo(weeks))
+		}
+	}
+	addDrawRecord(t, db, 9, 0, 10, now) // This week is not over
+	_, err = prize_models.SnapshotWeeklyLeaderboard(db, weeksAgo(4))
+	assert.NoError(t, err)
+
+	// The server was down for the last three weeks; weeks before the last
+	// snapshot are left alone
+	n, err = prize_models.SnapshotMissedLeaderboards(db, now)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, n)
+	var periods []string
+	db.Model(&prize_models.LeaderboardSnapshot{}).Order("period").Pluck("period", &periods)
+	assert.Equal(t, []string{
+		prize_models.WeekPeriod(weeksAgo(4)),
+		prize_models.WeekPeriod(weeksAgo(3)),
+		prize_models.WeekPeriod(weeksAgo(1)),
+	}, periods)
+
+	// The week without draws is marked as snapshotted all the same
+	periods = nil
+	db.Model(&prize_models.LeaderboardSnapshotPeriod{}).Order("period").Pluck("period", &periods)
+	assert.Equal(t, []string{
+		prize_models.WeekPeriod(weeksAgo(4)),
+		prize_models.WeekPeriod(weeksAgo(3)),
+		prize_models.WeekPeriod(weeksAgo(2)),
+		prize_models.WeekPeriod(weeksAgo(1)),
+	}, periods)
+	board, err := prize_models.GetLeaderboard(db, prize_models.LeaderboardQuery{Board: prize_models.LeaderboardWeekly, Week: weeksAgo(2)}, 10)
+	assert.NoError(t, err)
+	assert.True(t, board.Snapshot)
+	assert.Empty(t, board.Entries)
+
+	n, err = prize_models.SnapshotMissedLeaderboards(db, now)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, n)
+}
+
+func TestMigrateLeaderboardsMarksSnapshots(t *testing.T) {
+	db := setupPointsDB(t)
+	week := prize_models.WeekStart(time.Date(2024, 2, 14, 0, 0, 0, 0, time.UTC))
+	snapshot := &prize_models.LeaderboardSnapshot{Board: prize_models.LeaderboardWeekly, Period: prize_models.WeekPeriod(week), UserID: 1, Rank: 1, Score: 50, Draws: 1}
+	assert.NoError(t, db.Create(snapshot).Error)
+
+	// Snapshots taken before periods were marked are marked on migration
+	assert.NoError(t, prize_models.MigrateLeaderboards(db))
+	assert.NoError(t, prize_models.MigrateLeaderboards(db))
+	var marker prize_models.LeaderboardSnapshotPeriod
+	assert.NoError(t, db.Where("board = ? AND period = ?", prize_models.LeaderboardWeekly, snapshot.Period).First(&marker).Error)
+	assert.Equal(t, 1, marker.Entries)
+
+	addDrawRecord(t, db, 2, 0, 80, week.Add(time.Hour))
+	n, err := prize_models.SnapshotWeeklyLeaderboard(db, week)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, n)
+}
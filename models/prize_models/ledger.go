@@ -0,0 +1,242 @@
+package prize_models
+
+import (
+	"fmt"
+	"time"
+
+	"gorm.io/gorm"
+	"xy.com/mysite/models"
+)
+
+// Ledger currencies
+const (
+	CurrencyPoints = "points"
+	CurrencyCoins  = "coins"
+)
+
+// Ledger reasons
+const (
+	LedgerReasonDraw            = "draw"
+	LedgerReasonCoinExchange    = "coin_exchange"
+	LedgerReasonPrizeExchange   = "prize_exchange"
+	LedgerReasonAdminAdjustment = "admin_adjustment"
+	LedgerReasonRefund          = "refund"
+	LedgerReasonOpeningBalance  = "opening_balance"
+	LedgerReasonCheckIn         = "check_in"
+	LedgerReasonAchievement     = "achievement"
+	LedgerReasonExpiry          = "expiry"
+	LedgerReasonReferral        = "referral"
+)
+
+var (
+	ErrLedgerImmutable = models.NewError(models.KindConflict, "ledger_immutable", "ledger entries are append-only")
+	ErrInvalidCurrency = models.NewError(models.KindInvalid, "invalid_currency", "invalid currency")
+)
+
+// LedgerEntry records a single credit or debit of a user's points or coins.
+// Entries are append-only: every balance change in PointsSystem writes one,
+// so the sum of a user's entries always equals their balance.
+type LedgerEntry struct {
+	ID          uint      `gorm:"primaryKey" json:"id"`
+	CreatedAt   time.Time `json:"created_at"`
+	UserID      uint      `gorm:"not null;index" json:"user_id"`
+	Currency    string    `gorm:"size:16;not null" json:"currency"`
+	Amount      int       `gorm:"not null" json:"amount"`  // Signed change, negative for debits
+	Balance     int       `gorm:"not null" json:"balance"` // Balance of Currency after this entry
+	Reason      string    `gorm:"size:32;not null;index" json:"reason"`
+	ReferenceID string    `gorm:"size:64;index" json:"reference_id,omitempty"`
+	Note        string    `gorm:"size:255" json:"note,omitempty"`
+}
+
+// BeforeUpdate prevents ledger entries from being modified.
+func (LedgerEntry) BeforeUpdate(*gorm.DB) error {
+	return ErrLedgerImmutable
+}
+
+// BeforeDelete prevents ledger entries from being removed.
+func (LedgerEntry) BeforeDelete(*gorm.DB) error {
+	return ErrLedgerImmutable
+}
+
+// balanceChange describes a credit (positive Amount) or debit (negative Amount).
+type balanceChange struct {
+	UserID      uint
+	Currency    string
+	Amount      int
+	Reason      string
+	ReferenceID string
+	Note        string
+	skipBuckets bool // The caller settles the points buckets itself
+}
+
+// applyBalanceChange atomically applies the change to the user's balance and
+// appends the matching ledger entry. It must run inside a transaction.
+// Debits only apply if the balance covers them. Optional conditions further
+// restrict the balances the change applies to, e.g. "points < ?", 90000.
+// It returns false, without error, if the balance did not match.
+func applyBalanceChange(tx *gorm.DB, change balanceChange, conditions ...interface{}) (bool, error) {
+	column := change.Currency
+	if column != CurrencyPoints && column != CurrencyCoins {
+		return false, ErrInvalidCurrency
+	}
+
+	if err := ensurePointsSystem(tx, change.UserID); err != nil {
+		return false, err
+	}
+
+	query := tx.Model(&PointsSystem{}).Where("user_id = ?", change.UserID)
+	if change.Amount < 0 {
+		query = query.Where(column+" >= ?", -change.Amount)
+	}
+	if len(conditions) > 0 {
+		query = query.Where(conditions[0], conditions[1:]...)
+	}
+
+	result := query.Update(column, gorm.Expr(column+" + ?", change.Amount))
+	if result.Error != nil {
+		return false, fmt.Errorf("failed to update %s: %w", column, result.Error)
+	}
+	if result.RowsAffected == 0 {
+		return false, nil
+	}
+
+	var balance int
+	err := tx.Model(&PointsSystem{}).Where("user_id = ?", change.UserID).Select(column).Scan(&balance).Error
+	if err != nil {
+		return false, err
+	}
+
+	entry := LedgerEntry{
+		UserID:      change.UserID,
+		Currency:    change.Currency,
+		Amount:      change.Amount,
+		Balance:     balance,
+		Reason:      change.Reason,
+		ReferenceID: change.ReferenceID,
+		Note:        change.Note,
+	}
+	if err := tx.Create(&entry).Error; err != nil {
+		return false, fmt.Errorf("failed to write ledger entry: %w", err)
+	}
+
+	if change.Currency == CurrencyPoints && !change.skipBuckets {
+		if change.Amount > 0 {
+			err = addPointsBucket(tx, change, entry.CreatedAt)
+		} else {
+			err = spendPointsBuckets(tx, change.UserID, -change.Amount)
+		}
+		if err != nil {
+			return false, err
+		}
+	}
+
+	return true, nil
+}
+
+// AdjustBalance applies a manual credit or debit, e.g. by an admin or as a refund.
+func AdjustBalance(db *gorm.DB, userID uint, currency string, amount int, reason string, note string) (*PointsSystem, error) {
+	var pointsSystem *PointsSystem
+	err := db.Transaction(func(tx *gorm.DB) error {
+		applied, err := applyBalanceChange(tx, balanceChange{
+			UserID:   userID,
+			Currency: currency,
+			Amount:   amount,
+			Reason:   reason,
+			Note:     note,
+		})
+		if err != nil {
+			return err
+		}
+		if !applied {
+			if currency == CurrencyCoins {
+				return ErrInsufficientCoins
+			}
+			return ErrInsufficientPoints
+		}
+
+		pointsSystem, err = GetPointsSystem(tx, userID)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return pointsSystem, nil
+}
+
+// GetLedgerEntries retrieves a page of a user's ledger, newest first, and the total number of entries.
+func GetLedgerEntries(db *gorm.DB, userID uint, offset, limit int) ([]LedgerEntry, int64, error) {
+	var total int64
+	if err := db.Model(&LedgerEntry{}).Where("user_id = ?", userID).Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+
+	var entries []LedgerEntry
+	err := db.Where("user_id = ?", userID).Order("id desc").Offset(offset).Limit(limit).Find(&entries).Error
+	if err != nil {
+		return nil, 0, err
+	}
+	return entries, total, nil
+}
+
+// BalanceCheck compares a user's stored balances with the balances derived from the ledger.
+type BalanceCheck struct {
+	UserID       uint `json:"user_id"`
+	Points       int  `json:"points"`
+	Coins        int  `json:"coins"`
+	LedgerPoints int  `json:"ledger_points"`
+	LedgerCoins  int  `json:"ledger_coins"`
+	Consistent   bool `json:"consistent"`
+}
+
+// LedgerBalance sums a user's ledger entries for a currency.
+func LedgerBalance(db *gorm.DB, userID uint, currency string) (int, error) {
+	var sum int
+	err := db.Model(&LedgerEntry{}).
+		Where("user_id = ? AND currency = ?", userID, currency).
+		Select("COALESCE(SUM(amount), 0)").Scan(&sum).Error
+	return sum, err
+}
+
+// VerifyBalance checks that the user's balances match their ledger.
+func VerifyBalance(db *gorm.DB, userID uint) (*BalanceCheck, error) {
+	pointsSystem, err := GetPointsSystem(db, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	check := &BalanceCheck{UserID: userID, Points: pointsSystem.Points, Coins: pointsSystem.Coins}
+	if check.LedgerPoints, err = LedgerBalance(db, userID, CurrencyPoints); err != nil {
+		return nil, err
+	}
+	if check.LedgerCoins, err = LedgerBalance(db, userID, CurrencyCoins); err != nil {
+		return nil, err
+	}
+	check.Consistent = check.Points == check.LedgerPoints && check.Coins == check.LedgerCoins
+	return check, nil
+}
+
+// BackfillOpeningBalances writes an opening balance entry for every user who
+// has a non-zero balance but no ledger entries yet, i.e. balances that predate
+// the ledger. Users that already have entries are left alone so drift stays detectable.
+func BackfillOpeningBalances(db *gorm.DB) error {
+	var pointsSystems []PointsSystem
+	err := db.Where("(points <> 0 OR coins <> 0) AND user_id NOT IN (?)",
+		db.Model(&LedgerEntry{}).Distinct("user_id")).Find(&pointsSystems).Error
+	if err != nil {
+		return err
+	}
+
+	for _, ps := range pointsSystems {
+		var entries []LedgerEntry
+		if ps.Points != 0 {
+			entries = append(entries, LedgerEntry{UserID: ps.UserID, Currency: CurrencyPoints, Amount: ps.Points, Balance: ps.Points, Reason: LedgerReasonOpeningBalance})
+		}
+		if ps.Coins != 0 {
+			entries = append(entries, LedgerEntry{UserID: ps.UserID, Currency: CurrencyCoins, Amount: ps.Coins, Balance: ps.Coins, Reason: LedgerReasonOpeningBalance})
+		}
+		if err := db.Create(&entries).Error; err != nil {
+			return fmt.Errorf("failed to backfill ledger for user %d: %w", ps.UserID, err)
+		}
+	}
+	return nil
+}
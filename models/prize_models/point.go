@@ -1,75 +1,94 @@
 package prize_models
 
 import (
-	"errors"
 	"fmt"
+	"time"
+
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"xy.com/mysite/models"
+)
+
+var (
+	ErrInsufficientPoints = models.NewError(models.KindInsufficientFunds, "insufficient_points", "insufficient points")
+	ErrInsufficientCoins  = models.NewError(models.KindInsufficientFunds, "insufficient_coins", "金币不足，不能兑换")
 )
 
 type PointsSystem struct {
-	UserID uint `gorm:"primaryKey"` // Set UserID as primary key
-	Points int  `json:"points"`
+	UserID uint `gorm:"primaryKey"`          // Set UserID as primary key
+	Points int  `json:"points" gorm:"index"` // Indexed for the all-time leaderboard
 	Coins  int  `json:"coins"`
 }
 
+// ensurePointsSystem creates an empty points system for the user if there is none yet.
+// It is safe to call concurrently.
+func ensurePointsSystem(db *gorm.DB, userID uint) error {
+	pointsSystem := PointsSystem{UserID: userID}
+	if err := db.Clauses(clause.OnConflict{DoNothing: true}).Create(&pointsSystem).Error; err != nil {
+		return fmt.Errorf("failed to create points system: %w", err)
+	}
+	return nil
+}
+
 // 通过用户ID获取积分系统
 func GetPointsSystem(db *gorm.DB, userID uint) (*PointsSystem, error) {
+	if err := ensurePointsSystem(db, userID); err != nil {
+		return nil, err
+	}
+
 	var pointsSystem PointsSystem
 	if err := db.Where("user_id = ?", userID).First(&pointsSystem).Error; err != nil {
-		if err == gorm.ErrRecordNotFound {
-			// User does not exist, create a new points system with zero points
-			pointsSystem = PointsSystem{UserID: userID, Points: 0, Coins: 0}
-			if err := db.Create(&pointsSystem).Error; err != nil {
-				return nil, fmt.Errorf("failed to create points system: %w", err)
-			}
-		} else {
-			return nil, err
-		}
+		return nil, err
 	}
 	return &pointsSystem, nil
 }
 
-// 更新积分系统
-func UpdatePointsSystem(db *gorm.DB, pointsSystem *PointsSystem) error {
-	if err := db.Save(pointsSystem).Error; err != nil {
-		return fmt.Errorf("failed to update points system: %w", err)
-	}
-	return nil
-}
-
-func (ps *PointsSystem) Draw(db *gorm.DB) error {
-	if ps.Points < 90000 {
-		// 积分低于90000时，100%概率获得1000积分
-		ps.Points += 1000
-	} else if ps.Points >= 90000 && ps.Points < 100000 {
-		// 积分高于40000时，每次抽奖获得金币
-		ps.Coins += 10
-	} else {
-		return errors.New("积分已经超过100000，不能再抽奖")
+// CreditPoints atomically adds points to the user's balance and records it in the ledger.
+// Called inside a transaction, it commits or rolls back with it.
+func CreditPoints(db *gorm.DB, userID uint, amount int, reason string, referenceID string) error {
+	if amount < 0 {
+		return fmt.Errorf("invalid credit amount %d", amount)
 	}
-
-	// 更新积分系统到数据库
-	if err := UpdatePointsSystem(db, ps); err != nil {
+	return db.Transaction(func(tx *gorm.DB) error {
+		_, err := applyBalanceChange(tx, balanceChange{
+			UserID:      userID,
+			Currency:    CurrencyPoints,
+			Amount:      amount,
+			Reason:      reason,
+			ReferenceID: referenceID,
+		})
 		return err
-	}
-
-	return nil
+	})
 }
 
-func (ps *PointsSystem) ExchangeCoins(db *gorm.DB) error {
-	if ps.Coins < 100 {
-		// 金币不足100，不能兑换
-		return errors.New("金币不足，不能兑换")
-	}
-
-	// 每100金币可以兑换1积分
-	ps.Points += ps.Coins / 100
-	ps.Coins %= 100
-
-	// 更新积分系统到数据库
-	if err := UpdatePointsSystem(db, ps); err != nil {
-		return err
+// DebitPoints atomically removes points from the user's balance and records it in the ledger.
+// It returns ErrInsufficientPoints, leaving the balance untouched, if the user has fewer than amount points.
+// Called inside a transaction, it commits or rolls back with it.
+func DebitPoints(db *gorm.DB, userID uint, amount int, reason string, referenceID string) error {
+	if amount < 0 {
+		return fmt.Errorf("invalid debit amount %d", amount)
 	}
+	return db.Transaction(func(tx *gorm.DB) error {
+		applied, err := applyBalanceChange(tx, balanceChange{
+			UserID:      userID,
+			Currency:    CurrencyPoints,
+			Amount:      -amount,
+			Reason:      reason,
+			ReferenceID: referenceID,
+		})
+		if err != nil {
+			return err
+		}
+		if !applied {
+			return ErrInsufficientPoints
+		}
+		return nil
+	})
+}
 
-	return nil
+// ExchangeCoins converts as many of the user's coins to points as the current
+// exchange rate allows and returns the updated points system.
+func ExchangeCoins(db *gorm.DB, userID uint) (*PointsSystem, error) {
+	pointsSystem, _, err := ExchangeCoinsAmount(db, userID, 0, time.Now())
+	return pointsSystem, err
 }
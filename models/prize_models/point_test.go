@@ -0,0 +1,493 @@
+package prize_models_test
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+	"xy.com/mysite/models/prize_models"
+	"xy.com/mysite/models/user_models"
+)
+
+func setupPointsDB(t *testing.T) *gorm.DB {
+	// Same SQLite options as database.InitDB
+	dsn := filepath.Join(t.TempDir(), "points.db") + "?_journal_mode=WAL&_busy_timeout=5000&_txlock=immediate"
+	db, err := gorm.Open(sqlite.Open(dsn), &gorm.Config{
+		Logger: logger.Default.LogMode(logger.Silent),
+	})
+	if err != nil {
+		t.Fatalf("failed to connect to test database: %v", err)
+	}
+
+	err = db.AutoMigrate(
+		&prize_models.PointsSystem{},
+		&prize_models.Prize{},
+		&prize_models.ExchangedPrize{},
+		&prize_models.Code{},
+		&prize_models.CodePoolAlert{},
+		&prize_models.CodeBatch{},
+		&prize_models.RedemptionCode{},
+		&prize_models.RedemptionUse{},
+		&prize_models.LedgerEntry{},
+		&prize_models.RewardTable{},
+		&prize_models.RewardOutcome{},
+		&prize_models.DrawRecord{},
+		&prize_models.DrawPity{},
+		&prize_models.DrawSeed{},
+		&prize_models.DrawCampaign{},
+		&prize_models.CampaignDailyDraws{},
+		&prize_models.LeaderboardSnapshot{},
+		&prize_models.LeaderboardSnapshotPeriod{},
+		&prize_models.DailyCheckIn{},
+		&prize_models.StreakReward{},
+		&prize_models.AchievementProgress{},
+		&prize_models.UserAchievement{},
+		&prize_models.ExchangeRate{},
+		&prize_models.CoinExchangeDaily{},
+		&prize_models.PointsBucket{},
+		&user_models.UserSegment{},
+		&user_models.Referral{},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := prize_models.MigratePrizes(db); err != nil {
+		t.Fatal(err)
+	}
+	if err := prize_models.MigrateDrawSeeds(db); err != nil {
+		t.Fatal(err)
+	}
+	if err := prize_models.MigrateLeaderboards(db); err != nil {
+		t.Fatal(err)
+	}
+	withoutAchievements(t)
+	return db
+}
+
+// withoutAchievements disables achievements for the test so their rewards do not
+// skew the balances it checks. Achievement tests set their own.
+func withoutAchievements(t *testing.T) {
+	achievements := prize_models.Achievements
+	prize_models.Achievements = nil
+	t.Cleanup(func() { prize_models.Achievements = achievements })
+}
+
+// addPrizeCodes adds codes to the pool of the named prize.
+func addPrizeCodes(t *testing.T, db *gorm.DB, prizeName string, codes ...string) {
+	prize, err := prize_models.GetPrizeByName(db, prizeName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := prize_models.AddPrizeCodes(db, prize.ID, codes); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// createPointsTable creates an active reward table that always awards 1000 points.
+func createPointsTable(t *testing.T, db *gorm.DB) *prize_models.RewardTable {
+	table := &prize_models.RewardTable{
+		Name:     "points",
+		Active:   true,
+		Outcomes: []prize_models.RewardOutcome{{Kind: prize_models.OutcomePoints, Amount: 1000, Weight: 1}},
+	}
+	if err := prize_models.CreateRewardTable(db, table); err != nil {
+		t.Fatal(err)
+	}
+	return table
+}
+
+func TestDraw(t *testing.T) {
+	db := setupPointsDB(t)
+	userID := uint(1)
+	engine := prize_models.NewDrawEngine(1)
+	fetchDrawSeeds(t, db, engine, userID)
+
+	_, err := engine.Draw(db, userID, 0)
+	assert.ErrorIs(t, err, prize_models.ErrNoRewardTable)
+
+	table := createPointsTable(t, db)
+	record, err := engine.Draw(db, userID, 0)
+	assert.NoError(t, err)
+	assert.Equal(t, table.ID, record.RewardTableID)
+	assert.Equal(t, prize_models.OutcomePoints, record.Kind)
+	assert.Equal(t, 1000, record.Amount)
+
+	ps, _ := prize_models.GetPointsSystem(db, userID)
+	assert.Equal(t, 1000, ps.Points)
+
+	// Inactive tables cannot be drawn from
+	table.Active = false
+	assert.NoError(t, prize_models.UpdateRewardTable(db, table))
+	_, err = engine.Draw(db, userID, table.ID)
+	assert.ErrorIs(t, err, prize_models.ErrNoRewardTable)
+
+	records, total, err := prize_models.GetDrawRecords(db, userID, 0, 10)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1), total)
+	assert.Equal(t, record.ID, records[0].ID)
+}
+
+func TestDrawOutcomes(t *testing.T) {
+	db := setupPointsDB(t)
+	assert.NoError(t, prize_models.AddPrize(db, "sticker", 100))
+	addPrizeCodes(t, db, "sticker", "code-1")
+
+	table := &prize_models.RewardTable{
+		Name:   "mixed",
+		Active: true,
+		Outcomes: []prize_models.RewardOutcome{
+			{Kind: prize_models.OutcomeNothing, Weight: 6},
+			{Kind: prize_models.OutcomePoints, Amount: 10, Weight: 3},
+			{Kind: prize_models.OutcomeCoins, Amount: 5, Weight: 1},
+		},
+	}
+	assert.NoError(t, prize_models.CreateRewardTable(db, table))
+
+	// Engines with the same seed draw the same outcomes
+	kinds := func(userID uint, seed int64) []string {
+		engine := prize_models.NewDrawEngine(seed)
+		fetchDrawSeeds(t, db, engine, userID)
+		var kinds []string
+		for i := 0; i < 50; i++ {
+			record, err := engine.Draw(db, userID, table.ID)
+			if !assert.NoError(t, err) {
+				return nil
+			}
+			kinds = append(kinds, record.Kind)
+		}
+		return kinds
+	}
+	first := kinds(1, 42)
+	assert.Equal(t, first, kinds(2, 42))
+
+	counts := make(map[string]int)
+	for _, kind := range first {
+		counts[kind]++
+	}
+	assert.Equal(t, 3, len(counts))
+
+	ps, _ := prize_models.GetPointsSystem(db, 1)
+	assert.Equal(t, 10*counts[prize_models.OutcomePoints], ps.Points)
+	assert.Equal(t, 5*counts[prize_models.OutcomeCoins], ps.Coins)
+
+	check, err := prize_models.VerifyBalance(db, 1)
+	assert.NoError(t, err)
+	assert.True(t, check.Consistent)
+
+	// Prize outcomes hand out a redemption code
+	prizeTable := &prize_models.RewardTable{
+		Name:     "prize",
+		Active:   true,
+		Outcomes: []prize_models.RewardOutcome{{Kind: prize_models.OutcomePrize, PrizeName: "sticker", Weight: 1}},
+	}
+	assert.NoError(t, prize_models.CreateRewardTable(db, prizeTable))
+	fetchDrawSeeds(t, db, prize_models.NewDrawEngine(1), 3)
+	record, err := prize_models.NewDrawEngine(1).Draw(db, 3, prizeTable.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, "code-1", record.RedemptionCode)
+
+	// Without codes left the draw is rolled back
+	_, err = prize_models.NewDrawEngine(1).Draw(db, 3, prizeTable.ID)
+	assert.Error(t, err)
+	_, total, _ := prize_models.GetDrawRecords(db, 3, 0, 10)
+	assert.Equal(t, int64(1), total)
+}
+
+func TestDrawPity(t *testing.T) {
+	db := setupPointsDB(t)
+	userID := uint(1)
+
+	table := &prize_models.RewardTable{
+		Name:          "pity",
+		Active:        true,
+		PityThreshold: 5,
+		Outcomes: []prize_models.RewardOutcome{
+			{Kind: prize_models.OutcomeNothing, Weight: 1000000},
+			{Kind: prize_models.OutcomeCoins, Amount: 100, Weight: 1, Rare: true},
+		},
+	}
+	assert.NoError(t, prize_models.CreateRewardTable(db, table))
+
+	engine := prize_models.NewDrawEngine(7)
+	fetchDrawSeeds(t, db, engine, userID)
+	for round := 0; round < 2; round++ {
+		for i := 0; i < 4; i++ {
+			record, err := engine.Draw(db, userID, table.ID)
+			assert.NoError(t, err)
+			assert.Equal(t, prize_models.OutcomeNothing, record.Kind)
+		}
+		record, err := engine.Draw(db, userID, table.ID)
+		assert.NoError(t, err)
+		assert.Equal(t, prize_models.OutcomeCoins, record.Kind)
+		assert.True(t, record.Guaranteed)
+	}
+
+	ps, _ := prize_models.GetPointsSystem(db, userID)
+	assert.Equal(t, 200, ps.Coins)
+
+	// A pity threshold needs a rare outcome to guarantee
+	invalid := &prize_models.RewardTable{
+		Name:          "invalid",
+		PityThreshold: 3,
+		Outcomes:      []prize_models.RewardOutcome{{Kind: prize_models.OutcomeNothing, Weight: 1}},
+	}
+	assert.ErrorIs(t, prize_models.CreateRewardTable(db, invalid), prize_models.ErrInvalidRewardTable)
+}
+
+func TestExchangeCoins(t *testing.T) {
+	db := setupPointsDB(t)
+	userID := uint(1)
+
+	db.Create(&prize_models.PointsSystem{UserID: userID, Points: 5, Coins: 250})
+	ps, err := prize_models.ExchangeCoins(db, userID)
+	assert.NoError(t, err)
+	assert.Equal(t, 7, ps.Points)
+	assert.Equal(t, 50, ps.Coins)
+
+	_, err = prize_models.ExchangeCoins(db, userID)
+	assert.ErrorIs(t, err, prize_models.ErrInsufficientCoins)
+}
+
+func TestExchangePrizeDebitsPoints(t *testing.T) {
+	db := setupPointsDB(t)
+	userID := uint(1)
+
+	db.Create(&prize_models.PointsSystem{UserID: userID, Points: 150})
+	assert.NoError(t, prize_models.AddPrize(db, "prize", 100))
+	addPrizeCodes(t, db, "prize", "code-1")
+	assert.NoError(t, prize_models.AddPrize(db, "expensive", 100))
+	addPrizeCodes(t, db, "expensive", "code-2")
+
+	code, err := prize_models.ExchangePrize(db, userID, "prize")
+	assert.NoError(t, err)
+	assert.Equal(t, "code-1", code)
+
+	ps, _ := prize_models.GetPointsSystem(db, userID)
+	assert.Equal(t, 50, ps.Points)
+
+	// Exchanging again returns the same code without charging again
+	code, err = prize_models.ExchangePrize(db, userID, "prize")
+	assert.NoError(t, err)
+	assert.Equal(t, "code-1", code)
+	ps, _ = prize_models.GetPointsSystem(db, userID)
+	assert.Equal(t, 50, ps.Points)
+
+	// Not enough points for another prize: nothing is debited and no code is used
+	_, err = prize_models.ExchangePrize(db, userID, "expensive")
+	assert.ErrorIs(t, err, prize_models.ErrInsufficientPoints)
+	ps, _ = prize_models.GetPointsSystem(db, userID)
+	assert.Equal(t, 50, ps.Points)
+
+	var unused int64
+	db.Model(&prize_models.Code{}).Where("is_used = ?", false).Count(&unused)
+	assert.Equal(t, int64(1), unused)
+}
+
+// connSession opens a session on a connection of its own, so that concurrent
+// sessions really run side by side.
+func connSession(t *testing.T, sqlDB *sql.DB) (*gorm.DB, *sql.Conn) {
+	conn, err := sqlDB.Conn(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	session, err := gorm.Open(sqlite.Dialector{Conn: conn}, &gorm.Config{Logger: logger.Default.LogMode(logger.Silent)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return session, conn
+}
+
+// fetchDrawSeeds fetches the users' draw seeds from the engine, as users do
+// before their first draw.
+func fetchDrawSeeds(t *testing.T, db *gorm.DB, engine *prize_models.DrawEngine, userIDs ...uint) {
+	for _, userID := range userIDs {
+		if _, err := engine.GetActiveDrawSeed(db, userID); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestConcurrentDebitsNeverOverdraw(t *testing.T) {
+	db := setupPointsDB(t)
+	userID := uint(1)
+	const (
+		balance = 1050
+		cost    = 100
+		debits  = 20
+	)
+	sqlDB, err := db.DB()
+	assert.NoError(t, err)
+
+	db.Create(&prize_models.PointsSystem{UserID: userID, Points: balance})
+	assert.NoError(t, prize_models.BackfillOpeningBalances(db))
+
+	// Every debit runs in a transaction of its own, on a connection of its own
+	var (
+		wg        sync.WaitGroup
+		succeeded int32
+		start     = make(chan struct{})
+	)
+	for i := 0; i < debits; i++ {
+		session, conn := connSession(t, sqlDB)
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			defer conn.Close()
+			<-start
+			err := prize_models.DebitPoints(session, userID, cost, prize_models.LedgerReasonPrizeExchange, fmt.Sprintf("debit:%d", i))
+			if err == nil {
+				atomic.AddInt32(&succeeded, 1)
+				return
+			}
+			assert.ErrorIs(t, err, prize_models.ErrInsufficientPoints)
+		}(i)
+	}
+	assert.Equal(t, debits, sqlDB.Stats().OpenConnections)
+	close(start)
+	wg.Wait()
+
+	assert.Equal(t, int32(balance/cost), succeeded)
+	ps, err := prize_models.GetPointsSystem(db, userID)
+	assert.NoError(t, err)
+	assert.Equal(t, balance%cost, ps.Points)
+	check, err := prize_models.VerifyBalance(db, userID)
+	assert.NoError(t, err)
+	assert.True(t, check.Consistent)
+}
+
+func TestConcurrentDrawsAndExchangesConservePoints(t *testing.T) {
+	db := setupPointsDB(t)
+	userID := uint(1)
+
+	const (
+		initialPoints = 5000
+		draws         = 40
+		prizes        = 20
+		prizeCost     = 700
+	)
+
+	db.Create(&prize_models.PointsSystem{UserID: userID, Points: initialPoints})
+	assert.NoError(t, prize_models.BackfillOpeningBalances(db))
+	createPointsTable(t, db)
+	engine := prize_models.NewDrawEngine(1)
+	fetchDrawSeeds(t, db, engine, userID)
+	for i := 0; i < prizes; i++ {
+		assert.NoError(t, prize_models.AddPrize(db, fmt.Sprintf("prize-%d", i), prizeCost))
+		addPrizeCodes(t, db, fmt.Sprintf("prize-%d", i), fmt.Sprintf("code-%d", i))
+	}
+
+	var (
+		wg                 sync.WaitGroup
+		mu                 sync.Mutex
+		successfulDraws    int
+		successfulExchange int
+		codes              = make(map[string]bool)
+	)
+
+	for i := 0; i < draws; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := engine.Draw(db, userID, 0)
+			mu.Lock()
+			defer mu.Unlock()
+			if assert.NoError(t, err) {
+				successfulDraws++
+			}
+		}()
+	}
+
+	// Each prize is exchanged by two concurrent requests; only one may charge
+	for i := 0; i < prizes*2; i++ {
+		wg.Add(1)
+		go func(prizeName string) {
+			defer wg.Done()
+			code, err := prize_models.ExchangePrize(db, userID, prizeName)
+			mu.Lock()
+			defer mu.Unlock()
+			if err != nil {
+				assert.ErrorIs(t, err, prize_models.ErrInsufficientPoints)
+				return
+			}
+			// The second request for a prize gets the first request's code without being charged
+			if !codes[code] {
+				codes[code] = true
+				successfulExchange++
+			}
+		}(fmt.Sprintf("prize-%d", i%prizes))
+	}
+
+	wg.Wait()
+
+	ps, err := prize_models.GetPointsSystem(db, userID)
+	assert.NoError(t, err)
+	assert.Equal(t, draws, successfulDraws)
+	assert.Equal(t, initialPoints+1000*successfulDraws-prizeCost*successfulExchange, ps.Points)
+	assert.GreaterOrEqual(t, ps.Points, 0)
+
+	var exchanged, usedCodes int64
+	db.Model(&prize_models.ExchangedPrize{}).Where("user_id = ?", userID).Count(&exchanged)
+	db.Model(&prize_models.Code{}).Where("is_used = ?", true).Count(&usedCodes)
+	assert.Equal(t, int64(successfulExchange), exchanged)
+	assert.Equal(t, int64(successfulExchange), usedCodes)
+
+	// Every change is in the ledger
+	check, err := prize_models.VerifyBalance(db, userID)
+	assert.NoError(t, err)
+	assert.True(t, check.Consistent)
+}
+
+func TestLedger(t *testing.T) {
+	db := setupPointsDB(t)
+	userID := uint(1)
+	createPointsTable(t, db)
+
+	engine := prize_models.NewDrawEngine(1)
+	fetchDrawSeeds(t, db, engine, userID)
+	_, err := engine.Draw(db, userID, 0)
+	assert.NoError(t, err)
+	_, err = prize_models.AdjustBalance(db, userID, prize_models.CurrencyCoins, 250, prize_models.LedgerReasonAdminAdjustment, "bonus")
+	assert.NoError(t, err)
+	_, err = prize_models.ExchangeCoins(db, userID)
+	assert.NoError(t, err)
+
+	// Debits beyond the balance are refused
+	_, err = prize_models.AdjustBalance(db, userID, prize_models.CurrencyPoints, -5000, prize_models.LedgerReasonAdminAdjustment, "")
+	assert.ErrorIs(t, err, prize_models.ErrInsufficientPoints)
+
+	entries, total, err := prize_models.GetLedgerEntries(db, userID, 0, 10)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(4), total)
+
+	// Newest first: coin exchange credit, coin exchange debit, adjustment, draw
+	assert.Equal(t, prize_models.LedgerReasonCoinExchange, entries[0].Reason)
+	assert.Equal(t, prize_models.CurrencyPoints, entries[0].Currency)
+	assert.Equal(t, 2, entries[0].Amount)
+	assert.Equal(t, 1002, entries[0].Balance)
+	assert.Equal(t, -200, entries[1].Amount)
+	assert.Equal(t, 50, entries[1].Balance)
+	assert.Equal(t, "bonus", entries[2].Note)
+	assert.Equal(t, prize_models.LedgerReasonDraw, entries[3].Reason)
+
+	check, err := prize_models.VerifyBalance(db, userID)
+	assert.NoError(t, err)
+	assert.True(t, check.Consistent)
+
+	// Entries cannot be changed or removed
+	assert.ErrorIs(t, db.Model(&entries[0]).Update("amount", 1).Error, prize_models.ErrLedgerImmutable)
+	assert.ErrorIs(t, db.Delete(&entries[0]).Error, prize_models.ErrLedgerImmutable)
+
+	// Balances changed behind the ledger's back are detected
+	db.Model(&prize_models.PointsSystem{}).Where("user_id = ?", userID).Update("points", 1)
+	check, err = prize_models.VerifyBalance(db, userID)
+	assert.NoError(t, err)
+	assert.False(t, check.Consistent)
+}
@@ -0,0 +1,272 @@
+package prize_models
+
+import (
+	"fmt"
+	"log"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// PointsExpiry is how long credited points stay valid, zero for points that never expire.
+// It applies to points credited after it is set.
+var PointsExpiry time.Duration
+
+// PointsBucket holds points credited together, which expire together.
+// The remaining points of a user's buckets add up to their points balance.
+// Spending takes points from the buckets that expire first.
+type PointsBucket struct {
+	ID          uint       `json:"id" gorm:"primaryKey"`
+	CreatedAt   time.Time  `json:"created_at"`
+	UserID      uint       `json:"user_id" gorm:"not null;index"`
+	Amount      int        `json:"amount"`                            // Points credited
+	Remaining   int        `json:"remaining"`                         // Points not spent or expired yet
+	ExpiresAt   *time.Time `json:"expires_at,omitempty" gorm:"index"` // Nil never expires
+	ExpiredAt   *time.Time `json:"expired_at,omitempty"`
+	Reason      string     `json:"reason" gorm:"size:32"` // Ledger reason of the credit
+	ReferenceID string     `json:"reference_id,omitempty" gorm:"size:64"`
+}
+
+// ExpiringPoints is a user's points that expire within a period.
+type ExpiringPoints struct {
+	UserID        uint      `json:"user_id"`
+	Points        int       `json:"points"`
+	FirstExpiring time.Time `json:"first_expiring"`
+}
+
+// pointsExpiresAt returns when points credited at now expire, nil if they do not.
+func pointsExpiresAt(now time.Time) *time.Time {
+	if PointsExpiry <= 0 {
+		return nil
+	}
+	expiresAt := now.Add(PointsExpiry)
+	return &expiresAt
+}
+
+// addPointsBucket puts credited points in a new bucket.
+func addPointsBucket(tx *gorm.DB, change balanceChange, now time.Time) error {
+	bucket := PointsBucket{
+		UserID:      change.UserID,
+		Amount:      change.Amount,
+		Remaining:   change.Amount,
+		ExpiresAt:   pointsExpiresAt(now),
+		Reason:      change.Reason,
+		ReferenceID: change.ReferenceID,
+	}
+	if err := tx.Create(&bucket).Error; err != nil {
+		return fmt.Errorf("failed to create points bucket: %w", err)
+	}
+	return nil
+}
+
+// spendPointsBuckets takes spent points from the user's buckets, those expiring first
+// first. Points held outside any bucket, e.g. balances set directly, cover the rest.
+func spendPointsBuckets(tx *gorm.DB, userID uint, amount int) error {
+	for amount > 0 {
+		var buckets []PointsBucket
+		err := tx.Where("user_id = ? AND remaining > 0", userID).
+			Order("expires_at IS NULL, expires_at, id").Limit(50).Find(&buckets).Error
+		if err != nil {
+			return err
+		}
+		if len(buckets) == 0 {
+			return nil
+		}
+
+		for _, bucket := range buckets {
+			take := bucket.Remaining
+			if take > amount {
+				take = amount
+			}
+			err := tx.Model(&PointsBucket{}).Where("id = ?", bucket.ID).
+				Update("remaining", gorm.Expr("remaining - ?", take)).Error
+			if err != nil {
+				return fmt.Errorf("failed to spend points bucket: %w", err)
+			}
+			amount -= take
+			if amount == 0 {
+				return nil
+			}
+		}
+	}
+	return nil
+}
+
+// BackfillPointsBuckets puts points that are not in any bucket, i.e. balances that
+// predate buckets, in a bucket expiring one PointsExpiry from now.
+func BackfillPointsBuckets(db *gorm.DB, now time.Time) error {
+	bucketed := db.Model(&PointsBucket{}).Select("user_id, SUM(remaining) AS remaining").Group("user_id")
+	var gaps []struct {
+		UserID uint
+		Gap    int
+	}
+	err := db.Table("points_systems").
+		Select("points_systems.user_id AS user_id, points_systems.points - COALESCE(b.remaining, 0) AS gap").
+		Joins("LEFT JOIN (?) AS b ON b.user_id = points_systems.user_id", bucketed).
+		Where("points_systems.points - COALESCE(b.remaining, 0) > 0").
+		Scan(&gaps).Error
+	if err != nil {
+		return err
+	}
+
+	for _, gap := range gaps {
+		bucket := PointsBucket{
+			UserID:    gap.UserID,
+			Amount:    gap.Gap,
+			Remaining: gap.Gap,
+			ExpiresAt: pointsExpiresAt(now),
+			Reason:    LedgerReasonOpeningBalance,
+		}
+		if err := db.Create(&bucket).Error; err != nil {
+			return fmt.Errorf("failed to backfill points bucket for user %d: %w", gap.UserID, err)
+		}
+	}
+	return nil
+}
+
+// expirePointsBucket expires what is left of a bucket and debits it from the balance.
+func expirePointsBucket(db *gorm.DB, bucket PointsBucket, now time.Time) (int, error) {
+	var expired int
+	err := db.Transaction(func(tx *gorm.DB) error {
+		// Only expire what is still there, the user may just have spent it
+		if err := tx.First(&bucket, bucket.ID).Error; err != nil {
+			return err
+		}
+		if bucket.Remaining == 0 {
+			return nil
+		}
+		result := tx.Model(&PointsBucket{}).Where("id = ? AND remaining = ?", bucket.ID, bucket.Remaining).
+			Updates(map[string]interface{}{"remaining": 0, "expired_at": now})
+		if result.Error != nil {
+			return fmt.Errorf("failed to expire points bucket: %w", result.Error)
+		}
+		if result.RowsAffected == 0 {
+			return nil
+		}
+
+		// Never take the balance below zero, should it have drifted from the buckets
+		pointsSystem, err := GetPointsSystem(tx, bucket.UserID)
+		if err != nil {
+			return err
+		}
+		expired = bucket.Remaining
+		if expired > pointsSystem.Points {
+			expired = pointsSystem.Points
+		}
+		if expired == 0 {
+			return nil
+		}
+
+		applied, err := applyBalanceChange(tx, balanceChange{
+			UserID:      bucket.UserID,
+			Currency:    CurrencyPoints,
+			Amount:      -expired,
+			Reason:      LedgerReasonExpiry,
+			ReferenceID: fmt.Sprintf("bucket:%d", bucket.ID),
+			skipBuckets: true,
+		})
+		if err != nil {
+			return err
+		}
+		if !applied {
+			return ErrInsufficientPoints
+		}
+		return nil
+	})
+	return expired, err
+}
+
+// ExpirePoints expires every bucket whose expiry has passed at now and returns
+// how many points expired. Each bucket expires in its own transaction.
+func ExpirePoints(db *gorm.DB, now time.Time) (int, error) {
+	total := 0
+	for {
+		var buckets []PointsBucket
+		err := db.Where("remaining > 0 AND expires_at <= ?", now).Order("expires_at, id").Limit(100).Find(&buckets).Error
+		if err != nil {
+			return total, err
+		}
+		if len(buckets) == 0 {
+			return total, nil
+		}
+
+		for _, bucket := range buckets {
+			expired, err := expirePointsBucket(db, bucket, now)
+			if err != nil {
+				return total, fmt.Errorf("failed to expire points bucket %d: %w", bucket.ID, err)
+			}
+			total += expired
+		}
+	}
+}
+
+// RunPointsExpiry expires points every interval until stop is closed.
+func RunPointsExpiry(db *gorm.DB, interval time.Duration, stop <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		if n, err := ExpirePoints(db, time.Now()); err != nil {
+			log.Printf("Failed to expire points: %v", err)
+		} else if n > 0 {
+			log.Printf("Expired %d points", n)
+		}
+
+		select {
+		case <-ticker.C:
+		case <-stop:
+			return
+		}
+	}
+}
+
+// GetExpiringPointsBuckets retrieves the user's buckets with points that expire
+// between now and now plus within, the first to expire first.
+func GetExpiringPointsBuckets(db *gorm.DB, userID uint, within time.Duration, now time.Time) ([]PointsBucket, error) {
+	var buckets []PointsBucket
+	err := db.Where("user_id = ? AND remaining > 0 AND expires_at > ? AND expires_at <= ?", userID, now, now.Add(within)).
+		Order("expires_at, id").Find(&buckets).Error
+	if err != nil {
+		return nil, err
+	}
+	return buckets, nil
+}
+
+// GetUsersWithExpiringPoints retrieves a page of the users who have points that
+// expire between now and now plus within, so they can be notified, and their total number.
+func GetUsersWithExpiringPoints(db *gorm.DB, within time.Duration, now time.Time, offset, limit int) ([]ExpiringPoints, int64, error) {
+	expiring := db.Model(&PointsBucket{}).Where("remaining > 0 AND expires_at > ? AND expires_at <= ?", now, now.Add(within))
+
+	var total int64
+	if err := expiring.Session(&gorm.Session{}).Distinct("user_id").Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+
+	var users []ExpiringPoints
+	err := expiring.Session(&gorm.Session{}).Select("user_id, SUM(remaining) AS points").
+		Group("user_id").Order("user_id").Offset(offset).Limit(limit).Scan(&users).Error
+	if err != nil {
+		return nil, 0, err
+	}
+	if len(users) == 0 {
+		return users, total, nil
+	}
+
+	// Find each user's first expiring bucket
+	userIDs := make([]uint, len(users))
+	for i, user := range users {
+		userIDs[i] = user.UserID
+	}
+	var buckets []PointsBucket
+	err = expiring.Session(&gorm.Session{}).Where("user_id IN ?", userIDs).Order("expires_at desc").Find(&buckets).Error
+	if err != nil {
+		return nil, 0, err
+	}
+	first := make(map[uint]time.Time)
+	for _, bucket := range buckets {
+		first[bucket.UserID] = *bucket.ExpiresAt
+	}
+	for i := range users {
+		users[i].FirstExpiring = first[users[i].UserID]
+	}
+	return users, total, nil
+}
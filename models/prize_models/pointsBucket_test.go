@@ -0,0 +1,119 @@
+package prize_models_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"gorm.io/gorm"
+	"xy.com/mysite/models/prize_models"
+)
+
+// withPointsExpiry makes points credited during the test expire after d.
+func withPointsExpiry(t *testing.T, d time.Duration) {
+	expiry := prize_models.PointsExpiry
+	prize_models.PointsExpiry = d
+	t.Cleanup(func() { prize_models.PointsExpiry = expiry })
+}
+
+func creditPoints(t *testing.T, db *gorm.DB, userID uint, amount int) {
+	if err := prize_models.CreditPoints(db, userID, amount, prize_models.LedgerReasonAdminAdjustment, ""); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestPointsBucketsSpendFirstExpiringFirst(t *testing.T) {
+	db := setupPointsDB(t)
+	userID := uint(1)
+
+	withPointsExpiry(t, 0)
+	creditPoints(t, db, userID, 100) // Never expires
+	withPointsExpiry(t, 48*time.Hour)
+	creditPoints(t, db, userID, 50)
+	withPointsExpiry(t, 24*time.Hour)
+	creditPoints(t, db, userID, 30)
+
+	assert.NoError(t, prize_models.DebitPoints(db, userID, 60, prize_models.LedgerReasonPrizeExchange, ""))
+
+	var buckets []prize_models.PointsBucket
+	db.Where("user_id = ?", userID).Order("id").Find(&buckets)
+	assert.Equal(t, []int{100, 20, 0}, []int{buckets[0].Remaining, buckets[1].Remaining, buckets[2].Remaining})
+
+	// Only the 48 hour bucket has points left to expire
+	now := time.Now()
+	expiring, err := prize_models.GetExpiringPointsBuckets(db, userID, 72*time.Hour, now)
+	assert.NoError(t, err)
+	if assert.Len(t, expiring, 1) {
+		assert.Equal(t, 20, expiring[0].Remaining)
+	}
+
+	expired, err := prize_models.ExpirePoints(db, now.Add(72*time.Hour))
+	assert.NoError(t, err)
+	assert.Equal(t, 20, expired)
+	expired, err = prize_models.ExpirePoints(db, now.Add(72*time.Hour))
+	assert.NoError(t, err)
+	assert.Equal(t, 0, expired)
+
+	ps, _ := prize_models.GetPointsSystem(db, userID)
+	assert.Equal(t, 100, ps.Points)
+
+	entries, _, err := prize_models.GetLedgerEntries(db, userID, 0, 1)
+	assert.NoError(t, err)
+	assert.Equal(t, prize_models.LedgerReasonExpiry, entries[0].Reason)
+	assert.Equal(t, -20, entries[0].Amount)
+	check, err := prize_models.VerifyBalance(db, userID)
+	assert.NoError(t, err)
+	assert.True(t, check.Consistent)
+}
+
+func TestUsersWithExpiringPoints(t *testing.T) {
+	db := setupPointsDB(t)
+	withPointsExpiry(t, 10*24*time.Hour)
+	creditPoints(t, db, 1, 10)
+	creditPoints(t, db, 1, 15)
+	creditPoints(t, db, 2, 40)
+	withPointsExpiry(t, 60*24*time.Hour)
+	creditPoints(t, db, 3, 99)
+
+	now := time.Now()
+	users, total, err := prize_models.GetUsersWithExpiringPoints(db, 30*24*time.Hour, now, 0, 10)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(2), total)
+	if assert.Len(t, users, 2) {
+		assert.Equal(t, uint(1), users[0].UserID)
+		assert.Equal(t, 25, users[0].Points)
+		assert.WithinDuration(t, now.Add(10*24*time.Hour), users[0].FirstExpiring, time.Minute)
+		assert.Equal(t, 40, users[1].Points)
+	}
+
+	users, _, err = prize_models.GetUsersWithExpiringPoints(db, 30*24*time.Hour, now, 1, 10)
+	assert.NoError(t, err)
+	assert.Len(t, users, 1)
+}
+
+func TestBackfillPointsBuckets(t *testing.T) {
+	db := setupPointsDB(t)
+	withPointsExpiry(t, 24*time.Hour)
+	userID := uint(1)
+
+	// Balances from before buckets have no bucket
+	db.Create(&prize_models.PointsSystem{UserID: userID, Points: 300})
+	assert.NoError(t, prize_models.BackfillOpeningBalances(db))
+	creditPoints(t, db, userID, 20)
+
+	now := time.Now()
+	assert.NoError(t, prize_models.BackfillPointsBuckets(db, now))
+	assert.NoError(t, prize_models.BackfillPointsBuckets(db, now))
+
+	var remaining int
+	db.Model(&prize_models.PointsBucket{}).Where("user_id = ?", userID).Select("SUM(remaining)").Scan(&remaining)
+	assert.Equal(t, 320, remaining)
+
+	expired, err := prize_models.ExpirePoints(db, now.Add(25*time.Hour))
+	assert.NoError(t, err)
+	assert.Equal(t, 320, expired)
+	ps, _ := prize_models.GetPointsSystem(db, userID)
+	assert.Equal(t, 0, ps.Points)
+	check, _ := prize_models.VerifyBalance(db, userID)
+	assert.True(t, check.Consistent)
+}
@@ -1,24 +1,285 @@
 package prize_models
 
 import (
+	"errors"
 	"fmt"
+	"strings"
+	"time"
+	"unicode"
+
 	"gorm.io/gorm"
+	"xy.com/mysite/models"
+)
+
+var (
+	ErrPrizeNotFound     = models.NewError(models.KindNotFound, "prize_not_found", "prize not found")
+	ErrPrizeRetired      = models.NewError(models.KindConflict, "prize_retired", "prize has been retired")
+	ErrOutOfStock        = models.NewError(models.KindConflict, "out_of_stock", "prize is out of stock")
+	ErrPrizeLimitReached = models.NewError(models.KindConflict, "prize_limit_reached", "prize exchange limit reached")
+	ErrInvalidPrize      = models.NewError(models.KindInvalid, "invalid_prize", "invalid prize")
+	ErrExchangeNotFound  = models.NewError(models.KindNotFound, "exchange_not_found", "exchanged prize not found")
+	ErrPrizeExists       = models.NewError(models.KindConflict, "prize_exists", "a prize with this name or slug already exists")
 )
 
 type Prize struct {
 	gorm.Model
-	PrizeName string `json:"prize_name"`
-	Cost      int    `json:"cost"` // 积分兑换的价格
+	Slug               string     `json:"slug" gorm:"size:128"`       // Unique, see MigratePrizes
+	PrizeName          string     `json:"prize_name" gorm:"size:128"` // Unique, see MigratePrizes
+	Description        string     `json:"description"`
+	ImageURL           string     `json:"image_url"`
+	Cost               int        `json:"cost"`                            // 积分兑换的价格
+	Stock              *int       `json:"stock"`                           // 剩余库存，nil表示不限量
+	PerUserLimit       int        `json:"per_user_limit" gorm:"default:1"` // 每个用户最多兑换的次数
+	CodeAlertThreshold int        `json:"code_alert_threshold"`            // 兑换码池少于该数量时报警，0表示不报警
+	Physical           bool       `json:"physical"`                        // 实物奖品需要发货，见 UpdateFulfillment
+	SharedCodes        bool       `json:"shared_codes"`                    // 使用通用兑换码池而非自己的，见 codePool
+	RetiredAt          *time.Time `json:"retired_at,omitempty" gorm:"index"`
+}
+
+// Slugify derives a URL-friendly slug from a prize name.
+func Slugify(name string) string {
+	var b strings.Builder
+	dash := false
+	for _, r := range strings.ToLower(strings.TrimSpace(name)) {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			b.WriteRune(r)
+			dash = false
+		} else if !dash && b.Len() > 0 {
+			b.WriteByte('-')
+			dash = true
+		}
+	}
+	return strings.TrimSuffix(b.String(), "-")
+}
+
+// BeforeSave fills in the slug from the name if none is set.
+func (p *Prize) BeforeSave(tx *gorm.DB) error {
+	if p.Slug == "" {
+		p.Slug = Slugify(p.PrizeName)
+	}
+	return nil
+}
+
+// Validate checks the prize settings.
+func (p *Prize) Validate() error {
+	if p.PrizeName == "" {
+		return fmt.Errorf("%w: name is required", ErrInvalidPrize)
+	}
+	if p.Cost < 0 {
+		return fmt.Errorf("%w: cost must not be negative", ErrInvalidPrize)
+	}
+	if p.Stock != nil && *p.Stock < 0 {
+		return fmt.Errorf("%w: stock must not be negative", ErrInvalidPrize)
+	}
+	if p.PerUserLimit < 1 {
+		return fmt.Errorf("%w: per user limit must be at least 1", ErrInvalidPrize)
+	}
+	if p.CodeAlertThreshold < 0 {
+		return fmt.Errorf("%w: code alert threshold must not be negative", ErrInvalidPrize)
+	}
+	return nil
+}
+
+// IsRetired reports whether the prize has been withdrawn from the catalog.
+func (p *Prize) IsRetired() bool {
+	return p.RetiredAt != nil
+}
+
+// MigratePrizes gives prizes that predate slugs a slug and adds the unique
+// indexes on slug and name. AutoMigrate cannot add them to an existing table on SQLite.
+func MigratePrizes(db *gorm.DB) error {
+	var prizes []Prize
+	if err := db.Unscoped().Where("slug IS NULL OR slug = ''").Find(&prizes).Error; err != nil {
+		return err
+	}
+	for _, prize := range prizes {
+		slug := Slugify(prize.PrizeName)
+		if slug == "" {
+			slug = fmt.Sprintf("prize-%d", prize.ID)
+		}
+		var taken int64
+		if err := db.Unscoped().Model(&Prize{}).Where("slug = ?", slug).Count(&taken).Error; err != nil {
+			return err
+		}
+		if taken > 0 {
+			slug = fmt.Sprintf("%s-%d", slug, prize.ID)
+		}
+		if err := db.Unscoped().Model(&Prize{}).Where("id = ?", prize.ID).UpdateColumn("slug", slug).Error; err != nil {
+			return err
+		}
+	}
+
+	indexes := map[string]string{
+		"idx_prizes_slug":       "slug",
+		"idx_prizes_prize_name": "prize_name",
+	}
+	for name, column := range indexes {
+		if db.Migrator().HasIndex(&Prize{}, name) {
+			continue
+		}
+		if err := db.Exec(fmt.Sprintf("CREATE UNIQUE INDEX %s ON prizes(%s)", name, column)).Error; err != nil {
+			return fmt.Errorf("failed to create unique index on prizes.%s, resolve duplicates first: %w", column, err)
+		}
+	}
+	return nil
+}
+
+// checkPrizeUnique returns ErrPrizeExists if another prize has the same name or slug.
+func checkPrizeUnique(db *gorm.DB, prize *Prize) error {
+	var count int64
+	err := db.Unscoped().Model(&Prize{}).
+		Where("(prize_name = ? OR slug = ?) AND id <> ?", prize.PrizeName, prize.Slug, prize.ID).
+		Count(&count).Error
+	if err != nil {
+		return err
+	}
+	if count > 0 {
+		return ErrPrizeExists
+	}
+	return nil
 }
 
 // 添加新的奖品到数据库中
 func AddPrize(db *gorm.DB, prizeName string, cost int) error {
 	prize := Prize{
-		PrizeName: prizeName,
-		Cost:      cost,
+		PrizeName:    prizeName,
+		Cost:         cost,
+		PerUserLimit: 1,
 	}
-	if err := db.Create(&prize).Error; err != nil {
+	return CreatePrize(db, &prize)
+}
+
+// CreatePrize validates and saves a new prize.
+func CreatePrize(db *gorm.DB, prize *Prize) error {
+	prize.RetiredAt = nil
+	if err := prize.Validate(); err != nil {
+		return err
+	}
+	if prize.Slug == "" {
+		prize.Slug = Slugify(prize.PrizeName)
+	}
+	if err := checkPrizeUnique(db, prize); err != nil {
+		return err
+	}
+	if err := db.Create(prize).Error; err != nil {
 		return fmt.Errorf("failed to add prize_handlers: %w", err)
 	}
 	return nil
 }
+
+// UpdatePrize updates a prize's catalog details, stock and limits.
+func UpdatePrize(db *gorm.DB, prize *Prize) error {
+	if err := prize.Validate(); err != nil {
+		return err
+	}
+
+	return db.Transaction(func(tx *gorm.DB) error {
+		existing, err := GetPrizeByID(tx, prize.ID)
+		if err != nil {
+			return err
+		}
+
+		if prize.Slug == "" {
+			prize.Slug = existing.Slug
+		}
+		if err := checkPrizeUnique(tx, prize); err != nil {
+			return err
+		}
+		err = tx.Model(existing).Updates(map[string]interface{}{
+			"slug":                 prize.Slug,
+			"prize_name":           prize.PrizeName,
+			"description":          prize.Description,
+			"image_url":            prize.ImageURL,
+			"cost":                 prize.Cost,
+			"stock":                prize.Stock,
+			"per_user_limit":       prize.PerUserLimit,
+			"code_alert_threshold": prize.CodeAlertThreshold,
+			"physical":             prize.Physical,
+			"shared_codes":         prize.SharedCodes,
+		}).Error
+		if err != nil {
+			return fmt.Errorf("failed to update prize: %w", err)
+		}
+
+		*prize = *existing
+		return nil
+	})
+}
+
+// RetirePrize withdraws a prize from the catalog. It can no longer be exchanged
+// or drawn, but past exchanges are kept.
+func RetirePrize(db *gorm.DB, id uint) error {
+	result := db.Model(&Prize{}).Where("id = ? AND retired_at IS NULL", id).Update("retired_at", time.Now())
+	if result.Error != nil {
+		return fmt.Errorf("failed to retire prize: %w", result.Error)
+	}
+	if result.RowsAffected == 0 {
+		if _, err := GetPrizeByID(db, id); err != nil {
+			return err
+		}
+		return ErrPrizeRetired
+	}
+	return nil
+}
+
+// GetPrizeByID retrieves a prize by ID.
+func GetPrizeByID(db *gorm.DB, id uint) (*Prize, error) {
+	var prize Prize
+	if err := db.First(&prize, id).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrPrizeNotFound
+		}
+		return nil, err
+	}
+	return &prize, nil
+}
+
+// GetPrizeBySlug retrieves a prize by slug.
+func GetPrizeBySlug(db *gorm.DB, slug string) (*Prize, error) {
+	var prize Prize
+	if err := db.Where("slug = ?", slug).First(&prize).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, fmt.Errorf("%w: %s", ErrPrizeNotFound, slug)
+		}
+		return nil, err
+	}
+	return &prize, nil
+}
+
+// GetPrizes retrieves a page of the prizes that have not been retired and their total number.
+func GetPrizes(db *gorm.DB, offset, limit int) ([]Prize, int64, error) {
+	query := db.Model(&Prize{}).Where("retired_at IS NULL")
+
+	var total int64
+	if err := query.Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+
+	var prizes []Prize
+	if err := query.Order("id").Offset(offset).Limit(limit).Find(&prizes).Error; err != nil {
+		return nil, 0, err
+	}
+	return prizes, total, nil
+}
+
+// takeStock atomically removes one item from the prize's stock.
+// Prizes without a stock are unlimited.
+func takeStock(tx *gorm.DB, prize *Prize) error {
+	if prize.IsRetired() {
+		return ErrPrizeRetired
+	}
+	if prize.Stock == nil {
+		return nil
+	}
+
+	result := tx.Model(&Prize{}).
+		Where("id = ? AND stock > 0", prize.ID).
+		Update("stock", gorm.Expr("stock - 1"))
+	if result.Error != nil {
+		return fmt.Errorf("failed to update stock: %w", result.Error)
+	}
+	if result.RowsAffected == 0 {
+		return ErrOutOfStock
+	}
+	return nil
+}
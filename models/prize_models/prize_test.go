@@ -0,0 +1,128 @@
+package prize_models_test
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"xy.com/mysite/models"
+	"xy.com/mysite/models/prize_models"
+)
+
+func TestSlugify(t *testing.T) {
+	assert.Equal(t, "gift-card-50", prize_models.Slugify("  Gift Card (50$) "))
+	assert.Equal(t, "积分礼包", prize_models.Slugify("积分礼包"))
+}
+
+func TestPrizeCatalog(t *testing.T) {
+	db := setupPointsDB(t)
+
+	assert.NoError(t, prize_models.AddPrize(db, "Mug", 100))
+	assert.ErrorIs(t, prize_models.AddPrize(db, "Mug", 200), prize_models.ErrPrizeExists)
+	assert.ErrorIs(t, prize_models.CreatePrize(db, &prize_models.Prize{PrizeName: "Bad", PerUserLimit: 0}), prize_models.ErrInvalidPrize)
+
+	prize, err := prize_models.GetPrizeBySlug(db, "mug")
+	assert.NoError(t, err)
+	assert.Equal(t, 1, prize.PerUserLimit)
+	assert.Nil(t, prize.Stock)
+
+	stock := 5
+	prize.Description = "A mug"
+	prize.Stock = &stock
+	assert.NoError(t, prize_models.UpdatePrize(db, prize))
+	assert.Equal(t, "mug", prize.Slug)
+	assert.Equal(t, 5, *prize.Stock)
+
+	assert.NoError(t, prize_models.AddPrize(db, "Pen", 10))
+	prizes, total, err := prize_models.GetPrizes(db, 0, 10)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(2), total)
+	assert.Equal(t, 2, len(prizes))
+
+	// Retired prizes leave the catalog and cannot be exchanged
+	assert.NoError(t, prize_models.RetirePrize(db, prize.ID))
+	assert.ErrorIs(t, prize_models.RetirePrize(db, prize.ID), prize_models.ErrPrizeRetired)
+	assert.ErrorIs(t, prize_models.RetirePrize(db, 999), prize_models.ErrPrizeNotFound)
+	assert.ErrorIs(t, prize_models.RetirePrize(db, 999), models.ErrNotFound)
+	assert.NotErrorIs(t, prize_models.RetirePrize(db, 999), models.ErrConflict)
+	_, total, _ = prize_models.GetPrizes(db, 0, 10)
+	assert.Equal(t, int64(1), total)
+
+	db.Create(&prize_models.PointsSystem{UserID: 1, Points: 1000})
+	addPrizeCodes(t, db, "Mug", "code-1")
+	_, err = prize_models.ExchangePrize(db, 1, "Mug")
+	assert.ErrorIs(t, err, prize_models.ErrPrizeRetired)
+	_, err = prize_models.ExchangePrize(db, 1, "Nothing")
+	assert.ErrorIs(t, err, prize_models.ErrPrizeNotFound)
+
+	ps, _ := prize_models.GetPointsSystem(db, 1)
+	assert.Equal(t, 1000, ps.Points)
+}
+
+func TestPrizePerUserLimit(t *testing.T) {
+	db := setupPointsDB(t)
+	userID := uint(1)
+
+	db.Create(&prize_models.PointsSystem{UserID: userID, Points: 1000})
+	assert.NoError(t, prize_models.CreatePrize(db, &prize_models.Prize{PrizeName: "Ticket", Cost: 100, PerUserLimit: 2}))
+	addPrizeCodes(t, db, "Ticket", "code-0", "code-1", "code-2")
+
+	for i := 0; i < 2; i++ {
+		code, err := prize_models.ExchangePrize(db, userID, "Ticket")
+		assert.NoError(t, err)
+		assert.Equal(t, fmt.Sprintf("code-%d", i), code)
+	}
+	_, err := prize_models.ExchangePrize(db, userID, "Ticket")
+	assert.ErrorIs(t, err, prize_models.ErrPrizeLimitReached)
+
+	ps, _ := prize_models.GetPointsSystem(db, userID)
+	assert.Equal(t, 800, ps.Points)
+}
+
+func TestPrizeStockUnderConcurrency(t *testing.T) {
+	db := setupPointsDB(t)
+
+	const (
+		stock = 3
+		users = 10
+	)
+	available := stock
+	assert.NoError(t, prize_models.CreatePrize(db, &prize_models.Prize{PrizeName: "Limited", Cost: 50, Stock: &available, PerUserLimit: 1}))
+	for i := 0; i < users; i++ {
+		db.Create(&prize_models.PointsSystem{UserID: uint(i + 1), Points: 100})
+		addPrizeCodes(t, db, "Limited", fmt.Sprintf("code-%d", i))
+	}
+
+	var (
+		wg        sync.WaitGroup
+		mu        sync.Mutex
+		exchanged int
+	)
+	for i := 0; i < users; i++ {
+		wg.Add(1)
+		go func(userID uint) {
+			defer wg.Done()
+			_, err := prize_models.ExchangePrize(db, userID, "Limited")
+			mu.Lock()
+			defer mu.Unlock()
+			if err != nil {
+				assert.ErrorIs(t, err, prize_models.ErrOutOfStock)
+				return
+			}
+			exchanged++
+		}(uint(i + 1))
+	}
+	wg.Wait()
+
+	assert.Equal(t, stock, exchanged)
+
+	prize, err := prize_models.GetPrizeByName(db, "Limited")
+	assert.NoError(t, err)
+	assert.Equal(t, 0, *prize.Stock)
+
+	// Users who missed out were not charged
+	var points int64
+	db.Model(&prize_models.PointsSystem{}).Select("SUM(points)").Scan(&points)
+	assert.Equal(t, int64(users*100-stock*50), points)
+}
@@ -3,48 +3,170 @@ package prize_models
 import (
 	"errors"
 	"fmt"
+	"time"
+
 	"gorm.io/gorm"
+	"xy.com/mysite/models"
+)
+
+var (
+	ErrRedemptionCodeNotFound      = models.NewError(models.KindNotFound, "code_not_found", "code not found")
+	ErrRedemptionCodeUsed          = models.NewError(models.KindConflict, "code_used", "code already used")
+	ErrRedemptionCodeExpired       = models.NewError(models.KindConflict, "code_expired", "code has expired")
+	ErrRedemptionCodeRevoked       = models.NewError(models.KindConflict, "code_revoked", "code has been revoked")
+	ErrRedemptionCodeWrongUser     = models.NewError(models.KindForbidden, "code_wrong_user", "code belongs to another user")
+	ErrRedemptionCodeWrongCampaign = models.NewError(models.KindForbidden, "code_wrong_campaign", "code is not valid for this campaign")
+	ErrInvalidRedemptionCode       = models.NewError(models.KindInvalid, "invalid_code", "invalid redemption code")
 )
 
 type RedemptionCode struct {
 	gorm.Model
-	Code string `gorm:"unique"`
-	Used bool   `gorm:"default:false"` // Add 'Used' field
+	Code       string     `json:"code" gorm:"unique"`
+	BatchID    uint       `json:"batch_id,omitempty" gorm:"index"` // Batch the code was generated or imported in, 0 if added on its own
+	Used       bool       `json:"used" gorm:"default:false"`       // Set once every use has been taken
+	MaxUses    int        `json:"max_uses" gorm:"default:1"`
+	Uses       int        `json:"uses" gorm:"default:0"`
+	ExpiresAt  *time.Time `json:"expires_at"`                         // Nil never expires
+	UserID     uint       `json:"user_id,omitempty" gorm:"index"`     // Only this user may use the code, 0 for anyone
+	CampaignID uint       `json:"campaign_id,omitempty" gorm:"index"` // Only valid for draws in this campaign, 0 for any
+	RevokedAt  *time.Time `json:"revoked_at"`
+}
+
+// RedemptionUse records one use of a redemption code.
+type RedemptionUse struct {
+	gorm.Model
+	RedemptionCodeID uint `json:"redemption_code_id" gorm:"index"`
+	UserID           uint `json:"user_id" gorm:"index"`
+	CampaignID       uint `json:"campaign_id,omitempty"`
+	DrawID           uint `json:"draw_id,omitempty"` // The draw the code was used for
+}
+
+// Validate checks the code and its limits.
+func (c *RedemptionCode) Validate() error {
+	if c.Code == "" || len(c.Code) > 64 {
+		return fmt.Errorf("%w: code must be between 1 and 64 characters", ErrInvalidRedemptionCode)
+	}
+	if c.MaxUses < 1 {
+		return fmt.Errorf("%w: max_uses must be at least 1", ErrInvalidRedemptionCode)
+	}
+	return nil
+}
+
+// MigrateRedemptionCodes marks codes used before use counts existed as fully used.
+func MigrateRedemptionCodes(db *gorm.DB) error {
+	return db.Model(&RedemptionCode{}).
+		Where("used = ? AND uses = 0", true).
+		Update("uses", gorm.Expr("max_uses")).Error
 }
 
 func AddRedemptionCode(db *gorm.DB, code string) error {
-	c := RedemptionCode{
-		Code: code,
-		Used: false,
+	return CreateRedemptionCode(db, &RedemptionCode{Code: code, MaxUses: 1})
+}
+
+// CreateRedemptionCode adds a redemption code with its expiry, use limit and bindings.
+func CreateRedemptionCode(db *gorm.DB, code *RedemptionCode) error {
+	if err := code.Validate(); err != nil {
+		return err
 	}
-	if err := db.Create(&c).Error; err != nil {
+	code.Used = false
+	code.Uses = 0
+	if err := db.Create(code).Error; err != nil {
 		return fmt.Errorf("failed to add code: %w", err)
 	}
 	return nil
 }
 
-func UseRedemptionCode(db *gorm.DB, code string) error {
+// GetRedemptionCodeByCode retrieves a redemption code and its uses.
+func GetRedemptionCodeByCode(db *gorm.DB, code string) (*RedemptionCode, []RedemptionUse, error) {
 	var c RedemptionCode
-
 	if err := db.Where("code = ?", code).First(&c).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, nil, ErrRedemptionCodeNotFound
+		}
+		return nil, nil, err
+	}
+
+	var uses []RedemptionUse
+	if err := db.Where("redemption_code_id = ?", c.ID).Order("id").Find(&uses).Error; err != nil {
+		return nil, nil, err
+	}
+	return &c, uses, nil
+}
+
+// RevokeRedemptionCode stops a code from being used again.
+func RevokeRedemptionCode(db *gorm.DB, code string) error {
+	result := db.Model(&RedemptionCode{}).
+		Where("code = ? AND revoked_at IS NULL", code).
+		Update("revoked_at", time.Now())
+	if result.Error != nil {
+		return fmt.Errorf("failed to revoke code: %w", result.Error)
+	}
+	if result.RowsAffected == 0 {
+		if _, _, err := GetRedemptionCodeByCode(db, code); err != nil {
+			return err
+		}
+		return ErrRedemptionCodeRevoked
+	}
+	return nil
+}
+
+// UseRedemptionCode takes one use of the code for the user's draw in the
+// campaign. The checks and the use count are applied atomically, so a code
+// is never used more often than it allows.
+func UseRedemptionCode(db *gorm.DB, code string, userID uint, campaignID uint) (*RedemptionUse, error) {
+	var use *RedemptionUse
+	err := db.Transaction(func(tx *gorm.DB) error {
+		var err error
+		use, err = useRedemptionCode(tx, code, userID, campaignID, time.Now())
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return use, nil
+}
+
+// useRedemptionCode takes one use of the code inside an existing transaction,
+// so that the use is rolled back if the rest of the transaction fails.
+func useRedemptionCode(tx *gorm.DB, code string, userID uint, campaignID uint, now time.Time) (*RedemptionUse, error) {
+	var c RedemptionCode
+	if err := tx.Where("code = ?", code).First(&c).Error; err != nil {
 		// 如果在数据库中找不到这个兑换码，返回错误
 		if errors.Is(err, gorm.ErrRecordNotFound) {
-			return fmt.Errorf("code not found")
+			return nil, ErrRedemptionCodeNotFound
 		}
 		// 如果在查找过程中出现其他错误，返回错误
-		return fmt.Errorf("failed to find code: %w", err)
+		return nil, fmt.Errorf("failed to find code: %w", err)
 	}
 
-	// 如果这个兑换码已经被使用，返回一个错误
-	if c.Used {
-		return fmt.Errorf("code already used")
+	switch {
+	case c.RevokedAt != nil:
+		return nil, ErrRedemptionCodeRevoked
+	case c.ExpiresAt != nil && !now.Before(*c.ExpiresAt):
+		return nil, ErrRedemptionCodeExpired
+	case c.UserID != 0 && c.UserID != userID:
+		return nil, ErrRedemptionCodeWrongUser
+	case c.CampaignID != 0 && c.CampaignID != campaignID:
+		return nil, ErrRedemptionCodeWrongCampaign
 	}
 
-	// 如果找到了这个兑换码，并且还没有被使用，将其标记为已使用
-	c.Used = true
-	if err := db.Save(&c).Error; err != nil {
-		return fmt.Errorf("failed to mark code as used: %w", err)
+	// 只有在还有剩余次数且未被撤销时才计入一次使用，用完后标记为已使用
+	result := tx.Model(&RedemptionCode{}).
+		Where("id = ? AND uses < max_uses AND revoked_at IS NULL", c.ID).
+		Updates(map[string]interface{}{
+			"uses": gorm.Expr("uses + 1"),
+			"used": gorm.Expr("uses + 1 >= max_uses"),
+		})
+	if result.Error != nil {
+		return nil, fmt.Errorf("failed to mark code as used: %w", result.Error)
+	}
+	if result.RowsAffected == 0 {
+		return nil, ErrRedemptionCodeUsed
 	}
 
-	return nil
+	use := &RedemptionUse{RedemptionCodeID: c.ID, UserID: userID, CampaignID: campaignID}
+	if err := tx.Create(use).Error; err != nil {
+		return nil, fmt.Errorf("failed to record code use: %w", err)
+	}
+	return use, nil
 }
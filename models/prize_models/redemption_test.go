@@ -0,0 +1,176 @@
+package prize_models_test
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"xy.com/mysite/models/prize_models"
+)
+
+func TestUseRedemptionCode(t *testing.T) {
+	db := setupPointsDB(t)
+
+	assert.NoError(t, prize_models.AddRedemptionCode(db, "single"))
+	_, err := prize_models.UseRedemptionCode(db, "single", 1, 0)
+	assert.NoError(t, err)
+	_, err = prize_models.UseRedemptionCode(db, "single", 1, 0)
+	assert.ErrorIs(t, err, prize_models.ErrRedemptionCodeUsed)
+	_, err = prize_models.UseRedemptionCode(db, "missing", 1, 0)
+	assert.ErrorIs(t, err, prize_models.ErrRedemptionCodeNotFound)
+
+	past := time.Now().Add(-time.Minute)
+	assert.NoError(t, prize_models.CreateRedemptionCode(db, &prize_models.RedemptionCode{Code: "expired", MaxUses: 1, ExpiresAt: &past}))
+	_, err = prize_models.UseRedemptionCode(db, "expired", 1, 0)
+	assert.ErrorIs(t, err, prize_models.ErrRedemptionCodeExpired)
+
+	assert.NoError(t, prize_models.CreateRedemptionCode(db, &prize_models.RedemptionCode{Code: "bound", MaxUses: 1, UserID: 2, CampaignID: 7}))
+	_, err = prize_models.UseRedemptionCode(db, "bound", 1, 7)
+	assert.ErrorIs(t, err, prize_models.ErrRedemptionCodeWrongUser)
+	_, err = prize_models.UseRedemptionCode(db, "bound", 2, 8)
+	assert.ErrorIs(t, err, prize_models.ErrRedemptionCodeWrongCampaign)
+	use, err := prize_models.UseRedemptionCode(db, "bound", 2, 7)
+	assert.NoError(t, err)
+	assert.Equal(t, uint(7), use.CampaignID)
+
+	assert.NoError(t, prize_models.CreateRedemptionCode(db, &prize_models.RedemptionCode{Code: "multi", MaxUses: 3}))
+	for userID := uint(1); userID <= 2; userID++ {
+		_, err = prize_models.UseRedemptionCode(db, "multi", userID, 0)
+		assert.NoError(t, err)
+	}
+	assert.NoError(t, prize_models.RevokeRedemptionCode(db, "multi"))
+	assert.ErrorIs(t, prize_models.RevokeRedemptionCode(db, "multi"), prize_models.ErrRedemptionCodeRevoked)
+	assert.ErrorIs(t, prize_models.RevokeRedemptionCode(db, "missing"), prize_models.ErrRedemptionCodeNotFound)
+	_, err = prize_models.UseRedemptionCode(db, "multi", 3, 0)
+	assert.ErrorIs(t, err, prize_models.ErrRedemptionCodeRevoked)
+
+	code, uses, err := prize_models.GetRedemptionCodeByCode(db, "multi")
+	assert.NoError(t, err)
+	assert.Equal(t, 2, code.Uses)
+	assert.False(t, code.Used)
+	assert.Equal(t, 2, len(uses))
+
+	assert.ErrorIs(t, prize_models.CreateRedemptionCode(db, &prize_models.RedemptionCode{Code: "none", MaxUses: 0}), prize_models.ErrInvalidRedemptionCode)
+}
+
+func TestUseRedemptionCodeUnderConcurrency(t *testing.T) {
+	db := setupPointsDB(t)
+
+	const (
+		maxUses = 3
+		users   = 10
+	)
+	assert.NoError(t, prize_models.CreateRedemptionCode(db, &prize_models.RedemptionCode{Code: "shared", MaxUses: maxUses}))
+
+	var (
+		wg   sync.WaitGroup
+		mu   sync.Mutex
+		used int
+	)
+	for i := 0; i < users; i++ {
+		wg.Add(1)
+		go func(userID uint) {
+			defer wg.Done()
+			_, err := prize_models.UseRedemptionCode(db, "shared", userID, 0)
+			mu.Lock()
+			defer mu.Unlock()
+			if err != nil {
+				assert.ErrorIs(t, err, prize_models.ErrRedemptionCodeUsed, fmt.Sprint(userID))
+				return
+			}
+			used++
+		}(uint(i + 1))
+	}
+	wg.Wait()
+
+	assert.Equal(t, maxUses, used)
+	code, uses, err := prize_models.GetRedemptionCodeByCode(db, "shared")
+	assert.NoError(t, err)
+	assert.True(t, code.Used)
+	assert.Equal(t, maxUses, code.Uses)
+	assert.Equal(t, maxUses, len(uses))
+}
+
+func TestDrawWithRedemptionCode(t *testing.T) {
+	db := setupPointsDB(t)
+	engine := prize_models.NewDrawEngine(1)
+	table := createPointsTable(t, db)
+	fetchDrawSeeds(t, db, engine, 1, 2)
+	campaign := createCampaign(t, db, &prize_models.DrawCampaign{
+		Name:          "codes",
+		RewardTableID: table.ID,
+		Budget:        10000,
+		DailyLimit:    1,
+	})
+
+	assert.NoError(t, prize_models.AddRedemptionCode(db, "first"))
+	assert.NoError(t, prize_models.AddRedemptionCode(db, "second"))
+
+	record, err := engine.DrawWithRedemptionCode(db, 1, campaign.ID, "first")
+	assert.NoError(t, err)
+	_, uses, _ := prize_models.GetRedemptionCodeByCode(db, "first")
+	assert.Equal(t, record.ID, uses[0].DrawID)
+
+	// The draw fails on the daily limit, so the code is not consumed
+	_, err = engine.DrawWithRedemptionCode(db, 1, campaign.ID, "second")
+	assert.ErrorIs(t, err, prize_models.ErrDailyDrawLimitReached)
+	code, uses, err := prize_models.GetRedemptionCodeByCode(db, "second")
+	assert.NoError(t, err)
+	assert.False(t, code.Used)
+	assert.Equal(t, 0, code.Uses)
+	assert.Empty(t, uses)
+
+	_, err = engine.DrawWithRedemptionCode(db, 2, campaign.ID, "first")
+	assert.ErrorIs(t, err, prize_models.ErrRedemptionCodeUsed)
+	_, err = engine.DrawWithRedemptionCode(db, 2, campaign.ID, "")
+	assert.ErrorIs(t, err, prize_models.ErrRedemptionCodeNotFound)
+
+	// A failed code leaves no trace of the draw
+	var draws int64
+	db.Model(&prize_models.DrawRecord{}).Count(&draws)
+	assert.Equal(t, int64(1), draws)
+}
+
+func TestDrawWithRedemptionCodeUnderConcurrency(t *testing.T) {
+	db := setupPointsDB(t)
+	engine := prize_models.NewDrawEngine(1)
+	table := createPointsTable(t, db)
+	campaign := createCampaign(t, db, &prize_models.DrawCampaign{
+		Name:          "race",
+		RewardTableID: table.ID,
+		Budget:        100000,
+	})
+	assert.NoError(t, prize_models.AddRedemptionCode(db, "once"))
+
+	const users = 10
+	for i := 0; i < users; i++ {
+		fetchDrawSeeds(t, db, engine, uint(i+1))
+	}
+	var (
+		wg    sync.WaitGroup
+		mu    sync.Mutex
+		drawn int
+	)
+	for i := 0; i < users; i++ {
+		wg.Add(1)
+		go func(userID uint) {
+			defer wg.Done()
+			_, err := engine.DrawWithRedemptionCode(db, userID, campaign.ID, "once")
+			mu.Lock()
+			defer mu.Unlock()
+			if err != nil {
+				assert.ErrorIs(t, err, prize_models.ErrRedemptionCodeUsed)
+				return
+			}
+			drawn++
+		}(uint(i + 1))
+	}
+	wg.Wait()
+
+	assert.Equal(t, 1, drawn)
+	var draws int64
+	db.Model(&prize_models.DrawRecord{}).Count(&draws)
+	assert.Equal(t, int64(1), draws)
+}
@@ -0,0 +1,72 @@
+package prize_models
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"gorm.io/gorm"
+	"xy.com/mysite/models/user_models"
+)
+
+// Referral rewards, credited to both parties once the referee completes the
+// qualifying event for the first time.
+var (
+	ReferrerReward          = 200
+	RefereeReward           = 100
+	ReferralQualifyingEvent = EventOrderPaid
+)
+
+// qualifyReferral rewards the pending referral of the referee, if there is one.
+// Referrals where either party has been banned since signing up are rejected
+// instead. Each referral is rewarded once.
+func qualifyReferral(db *gorm.DB, refereeID uint, now time.Time) error {
+	var referral user_models.Referral
+	err := db.Where("referee_id = ? AND status = ?", refereeID, user_models.ReferralPending).First(&referral).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	banned, err := user_models.IsUserInAnySegment(db, referral.ReferrerID, []string{user_models.SegmentBanned})
+	if err != nil {
+		return err
+	}
+	if !banned {
+		banned, err = user_models.IsUserInAnySegment(db, referral.RefereeID, []string{user_models.SegmentBanned})
+		if err != nil {
+			return err
+		}
+	}
+
+	updates := map[string]interface{}{"status": user_models.ReferralRewarded, "qualified_at": now}
+	if banned {
+		updates = map[string]interface{}{"status": user_models.ReferralRejected, "reject_reason": user_models.ReferralRejectBanned}
+	}
+
+	// Callers outside a transaction still get the status change and both rewards together
+	return db.Transaction(func(tx *gorm.DB) error {
+		result := tx.Model(&user_models.Referral{}).Where("id = ? AND status = ?", referral.ID, user_models.ReferralPending).Updates(updates)
+		if result.Error != nil {
+			return fmt.Errorf("failed to update referral: %w", result.Error)
+		}
+		if result.RowsAffected == 0 || banned {
+			return nil
+		}
+
+		referenceID := fmt.Sprintf("referral:%d", referral.ID)
+		if ReferrerReward > 0 {
+			if err := CreditPoints(tx, referral.ReferrerID, ReferrerReward, LedgerReasonReferral, referenceID); err != nil {
+				return err
+			}
+		}
+		if RefereeReward > 0 {
+			if err := CreditPoints(tx, referral.RefereeID, RefereeReward, LedgerReasonReferral, referenceID); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
@@ -0,0 +1,76 @@
+package prize_models_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"xy.com/mysite/models/prize_models"
+	"xy.com/mysite/models/user_models"
+)
+
+func TestReferralRewards(t *testing.T) {
+	db := setupPointsDB(t)
+	assert.NoError(t, db.AutoMigrate(&user_models.User{}))
+
+	signUp := func(name string, ip string, code string) *user_models.User {
+		user := &user_models.User{Username: name, Email: name + "@example.com", Password: "password"}
+		assert.NoError(t, user_models.SignUp(db, user, user_models.SignupReferral{Code: code, IP: ip}))
+		return user
+	}
+	points := func(userID uint) int {
+		ps, err := prize_models.GetPointsSystem(db, userID)
+		assert.NoError(t, err)
+		return ps.Points
+	}
+
+	referrer := signUp("referrer", "10.0.0.1", "")
+	referee := signUp("referee", "10.0.0.2", referrer.ReferralCode)
+	sameIP := signUp("sameip", "10.0.0.1", referrer.ReferralCode)
+	banned := signUp("banned", "10.0.0.3", referrer.ReferralCode)
+	assert.NoError(t, user_models.AddUserToSegment(db, banned.ID, user_models.SegmentBanned))
+
+	// Other events, including placing an unpaid order, do not qualify
+	_, err := prize_models.RecordEvent(db, referee.ID, prize_models.EventPrizeExchange)
+	assert.NoError(t, err)
+	_, err = prize_models.RecordEvent(db, referee.ID, prize_models.EventOrderPlaced)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, points(referrer.ID))
+
+	// The qualifying event rewards both parties once
+	for i := 0; i < 2; i++ {
+		_, err = prize_models.RecordEvent(db, referee.ID, prize_models.ReferralQualifyingEvent)
+		assert.NoError(t, err)
+	}
+	assert.Equal(t, prize_models.ReferrerReward, points(referrer.ID))
+	assert.Equal(t, prize_models.RefereeReward, points(referee.ID))
+
+	// Rejected and banned referees earn nothing for either party
+	_, err = prize_models.RecordEvent(db, sameIP.ID, prize_models.ReferralQualifyingEvent)
+	assert.NoError(t, err)
+	_, err = prize_models.RecordEvent(db, banned.ID, prize_models.ReferralQualifyingEvent)
+	assert.NoError(t, err)
+	assert.Equal(t, prize_models.ReferrerReward, points(referrer.ID))
+	assert.Equal(t, 0, points(sameIP.ID))
+	assert.Equal(t, 0, points(banned.ID))
+
+	referrals, err := user_models.GetReferralsByReferrer(db, referrer.ID)
+	assert.NoError(t, err)
+	status := make(map[uint]string)
+	for _, referral := range referrals {
+		status[referral.RefereeID] = referral.Status + ":" + referral.RejectReason
+		if referral.RefereeID == referee.ID {
+			assert.NotNil(t, referral.QualifiedAt)
+		}
+	}
+	assert.Equal(t, map[uint]string{
+		referee.ID: "rewarded:",
+		sameIP.ID:  "rejected:same_ip",
+		banned.ID:  "rejected:banned",
+	}, status)
+
+	for _, userID := range []uint{referrer.ID, referee.ID} {
+		check, err := prize_models.VerifyBalance(db, userID)
+		assert.NoError(t, err)
+		assert.True(t, check.Consistent)
+	}
+}
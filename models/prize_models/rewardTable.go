@@ -0,0 +1,179 @@
+package prize_models
+
+import (
+	"errors"
+	"fmt"
+	"gorm.io/gorm"
+	"xy.com/mysite/models"
+)
+
+// Reward outcome kinds
+const (
+	OutcomePoints  = "points"
+	OutcomeCoins   = "coins"
+	OutcomePrize   = "prize"
+	OutcomeNothing = "nothing"
+)
+
+var (
+	ErrNoRewardTable       = models.NewError(models.KindNotFound, "no_reward_table", "no active reward table")
+	ErrRewardTableNotFound = models.NewError(models.KindNotFound, "reward_table_not_found", "reward table not found")
+	ErrInvalidRewardTable  = models.NewError(models.KindInvalid, "invalid_reward_table", "invalid reward table")
+)
+
+// RewardTable is an admin-defined set of weighted draw outcomes.
+// If PityThreshold is set, a user is guaranteed a rare outcome on their
+// PityThreshold-th consecutive draw without one.
+type RewardTable struct {
+	gorm.Model
+	Name          string          `json:"name" gorm:"uniqueIndex;size:128;not null"`
+	Active        bool            `json:"active"`
+	PityThreshold int             `json:"pity_threshold"`
+	Outcomes      []RewardOutcome `json:"outcomes" gorm:"foreignKey:RewardTableID"`
+}
+
+// RewardOutcome is one possible result of a draw. Its chance is Weight divided
+// by the sum of the weights of all outcomes in the table.
+type RewardOutcome struct {
+	gorm.Model
+	RewardTableID uint   `json:"-" gorm:"index"`
+	Kind          string `json:"kind" gorm:"size:16;not null"`
+	Amount        int    `json:"amount"`     // Points or coins awarded
+	PrizeName     string `json:"prize_name"` // Prize awarded for prize outcomes
+	Weight        int    `json:"weight"`
+	Rare          bool   `json:"rare"` // Rare outcomes reset the pity counter
+}
+
+// Validate checks that the table can be drawn from.
+func (t *RewardTable) Validate() error {
+	if t.Name == "" {
+		return fmt.Errorf("%w: name is required", ErrInvalidRewardTable)
+	}
+	if len(t.Outcomes) == 0 {
+		return fmt.Errorf("%w: at least one outcome is required", ErrInvalidRewardTable)
+	}
+	if t.PityThreshold < 0 {
+		return fmt.Errorf("%w: pity threshold must not be negative", ErrInvalidRewardTable)
+	}
+
+	hasRare := false
+	for i, outcome := range t.Outcomes {
+		if outcome.Weight <= 0 {
+			return fmt.Errorf("%w: outcome %d must have a positive weight", ErrInvalidRewardTable, i)
+		}
+		switch outcome.Kind {
+		case OutcomePoints, OutcomeCoins:
+			if outcome.Amount <= 0 {
+				return fmt.Errorf("%w: outcome %d must have a positive amount", ErrInvalidRewardTable, i)
+			}
+		case OutcomePrize:
+			if outcome.PrizeName == "" {
+				return fmt.Errorf("%w: outcome %d must name a prize", ErrInvalidRewardTable, i)
+			}
+		case OutcomeNothing:
+		default:
+			return fmt.Errorf("%w: outcome %d has unknown kind %q", ErrInvalidRewardTable, i, outcome.Kind)
+		}
+		hasRare = hasRare || outcome.Rare
+	}
+
+	if t.PityThreshold > 0 && !hasRare {
+		return fmt.Errorf("%w: a pity threshold requires at least one rare outcome", ErrInvalidRewardTable)
+	}
+	return nil
+}
+
+// CreateRewardTable validates and saves a new reward table with its outcomes.
+func CreateRewardTable(db *gorm.DB, table *RewardTable) error {
+	if err := table.Validate(); err != nil {
+		return err
+	}
+	if err := db.Create(table).Error; err != nil {
+		return fmt.Errorf("failed to create reward table: %w", err)
+	}
+	return nil
+}
+
+// UpdateRewardTable replaces the settings and outcomes of an existing reward table.
+func UpdateRewardTable(db *gorm.DB, table *RewardTable) error {
+	if err := table.Validate(); err != nil {
+		return err
+	}
+
+	return db.Transaction(func(tx *gorm.DB) error {
+		var existing RewardTable
+		if err := tx.First(&existing, table.ID).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return ErrRewardTableNotFound
+			}
+			return err
+		}
+
+		err := tx.Model(&existing).Updates(map[string]interface{}{
+			"name":           table.Name,
+			"active":         table.Active,
+			"pity_threshold": table.PityThreshold,
+		}).Error
+		if err != nil {
+			return fmt.Errorf("failed to update reward table: %w", err)
+		}
+
+		if err := tx.Where("reward_table_id = ?", table.ID).Delete(&RewardOutcome{}).Error; err != nil {
+			return fmt.Errorf("failed to replace outcomes: %w", err)
+		}
+		for i := range table.Outcomes {
+			table.Outcomes[i].ID = 0
+			table.Outcomes[i].RewardTableID = table.ID
+		}
+		if err := tx.Create(&table.Outcomes).Error; err != nil {
+			return fmt.Errorf("failed to replace outcomes: %w", err)
+		}
+		return nil
+	})
+}
+
+// GetRewardTables retrieves all reward tables with their outcomes.
+func GetRewardTables(db *gorm.DB) ([]RewardTable, error) {
+	var tables []RewardTable
+	if err := db.Preload("Outcomes").Order("id").Find(&tables).Error; err != nil {
+		return nil, err
+	}
+	return tables, nil
+}
+
+// preloadOutcomes loads a table's outcomes in the order draws depend on, see ReplayDraw.
+func preloadOutcomes(db *gorm.DB) *gorm.DB {
+	return db.Preload("Outcomes", func(db *gorm.DB) *gorm.DB {
+		return db.Order("id")
+	})
+}
+
+// GetRewardTable retrieves a reward table with its outcomes, whether it is active or not.
+func GetRewardTable(db *gorm.DB, tableID uint) (*RewardTable, error) {
+	var table RewardTable
+	if err := preloadOutcomes(db).First(&table, tableID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrNoRewardTable
+		}
+		return nil, err
+	}
+	return &table, nil
+}
+
+// GetActiveRewardTable retrieves an active reward table with its outcomes.
+// If tableID is zero the oldest active table is used.
+func GetActiveRewardTable(db *gorm.DB, tableID uint) (*RewardTable, error) {
+	query := preloadOutcomes(db).Where("active = ?", true)
+	if tableID != 0 {
+		query = query.Where("id = ?", tableID)
+	}
+
+	var table RewardTable
+	if err := query.Order("id").First(&table).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrNoRewardTable
+		}
+		return nil, err
+	}
+	return &table, nil
+}
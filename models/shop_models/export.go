@@ -0,0 +1,55 @@
+package shop_models
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// exportBatchSize is the number of rows loaded per query while exporting.
+const exportBatchSize = 100
+
+// ExportFilter restricts an export to records created in [From, To).
+// Zero values leave that side of the range open.
+type ExportFilter struct {
+	From time.Time
+	To   time.Time
+}
+
+func (f ExportFilter) apply(db *gorm.DB) *gorm.DB {
+	if !f.From.IsZero() {
+		db = db.Where("created_at >= ?", f.From)
+	}
+	if !f.To.IsZero() {
+		db = db.Where("created_at < ?", f.To)
+	}
+	return db
+}
+
+// ExportProducts calls fn for every product matching the filter, ordered by ID.
+// Products are loaded in batches so the whole catalog is never held in memory.
+func ExportProducts(db *gorm.DB, filter ExportFilter, fn func(*Product) error) error {
+	var batch []Product
+	return filter.apply(db).FindInBatches(&batch, exportBatchSize, func(tx *gorm.DB, _ int) error {
+		for i := range batch {
+			if err := fn(&batch[i]); err != nil {
+				return err
+			}
+		}
+		return nil
+	}).Error
+}
+
+// ExportOrders calls fn for every order matching the filter, ordered by ID,
+// with its items loaded. Orders are loaded in batches.
+func ExportOrders(db *gorm.DB, filter ExportFilter, fn func(*Order) error) error {
+	var batch []Order
+	return filter.apply(db.Preload("OrderItems")).FindInBatches(&batch, exportBatchSize, func(tx *gorm.DB, _ int) error {
+		for i := range batch {
+			if err := fn(&batch[i]); err != nil {
+				return err
+			}
+		}
+		return nil
+	}).Error
+}
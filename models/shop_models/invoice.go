@@ -0,0 +1,81 @@
+package shop_models
+
+import (
+	"errors"
+	"fmt"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// invoiceSequenceName is the name of the counter row used for invoice numbers.
+const invoiceSequenceName = "invoice"
+
+// Invoice represents the invoice issued for an order.
+type Invoice struct {
+	gorm.Model
+	OrderID uint   `gorm:"uniqueIndex;not null" json:"order_id"`
+	Number  string `gorm:"uniqueIndex;size:32;not null" json:"number"`
+}
+
+// InvoiceSequence stores a monotonically increasing counter. Values are never
+// decremented, so a number handed out once is never handed out again, even if
+// the invoice it was used for is later deleted.
+type InvoiceSequence struct {
+	Name  string `gorm:"primaryKey;size:64"`
+	Value uint64 `gorm:"not null"`
+}
+
+// FormatInvoiceNumber renders a sequence value as an invoice number.
+func FormatInvoiceNumber(value uint64) string {
+	return fmt.Sprintf("INV-%08d", value)
+}
+
+// NextInvoiceNumber allocates the next invoice number. It should be called
+// inside a transaction so the increment and the read are atomic.
+func NextInvoiceNumber(tx *gorm.DB) (string, error) {
+	seq := InvoiceSequence{Name: invoiceSequenceName}
+	if err := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&seq).Error; err != nil {
+		return "", fmt.Errorf("failed to create invoice sequence: %w", err)
+	}
+
+	err := tx.Model(&InvoiceSequence{}).
+		Where("name = ?", invoiceSequenceName).
+		Update("value", gorm.Expr("value + 1")).Error
+	if err != nil {
+		return "", fmt.Errorf("failed to increment invoice sequence: %w", err)
+	}
+
+	if err := tx.Where("name = ?", invoiceSequenceName).First(&seq).Error; err != nil {
+		return "", fmt.Errorf("failed to read invoice sequence: %w", err)
+	}
+
+	return FormatInvoiceNumber(seq.Value), nil
+}
+
+// GetOrCreateInvoice returns the invoice for an order, issuing a new invoice
+// number the first time it is requested.
+func GetOrCreateInvoice(db *gorm.DB, orderID uint) (*Invoice, error) {
+	var invoice Invoice
+	err := db.Transaction(func(tx *gorm.DB) error {
+		err := tx.Unscoped().Where("order_id = ?", orderID).First(&invoice).Error
+		if err == nil {
+			return nil
+		}
+		if !errors.Is(err, gorm.ErrRecordNotFound) {
+			return err
+		}
+
+		number, err := NextInvoiceNumber(tx)
+		if err != nil {
+			return err
+		}
+
+		invoice = Invoice{OrderID: orderID, Number: number}
+		return tx.Create(&invoice).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &invoice, nil
+}
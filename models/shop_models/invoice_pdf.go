@@ -0,0 +1,130 @@
+package shop_models
+
+import (
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/go-pdf/fpdf"
+)
+
+// InvoiceIssuer holds the seller details printed at the top of an invoice.
+type InvoiceIssuer struct {
+	Name    string
+	Address string
+	Email   string
+}
+
+// RenderInvoicePDF writes the invoice for an order as a PDF document.
+// The order is expected to have its OrderItems.Product association loaded.
+func RenderInvoicePDF(w io.Writer, issuer InvoiceIssuer, invoice *Invoice, order *Order) error {
+	pdf := fpdf.New("P", "mm", "A4", "")
+	pdf.SetTitle("Invoice "+invoice.Number, true)
+	pdf.SetAuthor(issuer.Name, true)
+	pdf.AddPage()
+
+	// Header with the seller's branding
+	pdf.SetFont("Helvetica", "B", 20)
+	pdf.CellFormat(0, 10, issuer.Name, "", 1, "L", false, 0, "")
+	pdf.SetFont("Helvetica", "", 10)
+	for _, line := range splitLines(issuer.Address) {
+		pdf.CellFormat(0, 5, line, "", 1, "L", false, 0, "")
+	}
+	if issuer.Email != "" {
+		pdf.CellFormat(0, 5, issuer.Email, "", 1, "L", false, 0, "")
+	}
+	pdf.Ln(6)
+
+	// Invoice details
+	pdf.SetFont("Helvetica", "B", 14)
+	pdf.CellFormat(0, 8, "INVOICE", "", 1, "L", false, 0, "")
+	pdf.SetFont("Helvetica", "", 10)
+	pdf.CellFormat(0, 5, "Invoice number: "+invoice.Number, "", 1, "L", false, 0, "")
+	pdf.CellFormat(0, 5, fmt.Sprintf("Order number: %d", order.ID), "", 1, "L", false, 0, "")
+	pdf.CellFormat(0, 5, "Date: "+invoice.CreatedAt.Format("2006-01-02"), "", 1, "L", false, 0, "")
+	pdf.Ln(4)
+
+	// Addresses
+	y := pdf.GetY()
+	billEnd := writeAddressBlock(pdf, 10, y, "Bill to", order.BillingAddress)
+	shipEnd := writeAddressBlock(pdf, 110, y, "Ship to", order.ShippingAddress)
+	if shipEnd > billEnd {
+		billEnd = shipEnd
+	}
+	pdf.SetXY(10, billEnd+6)
+
+	// Items table
+	widths := []float64{90, 25, 35, 40}
+	pdf.SetFont("Helvetica", "B", 10)
+	pdf.SetFillColor(230, 230, 230)
+	for i, header := range []string{"Item", "Qty", "Unit price", "Amount"} {
+		align := "R"
+		if i == 0 {
+			align = "L"
+		}
+		pdf.CellFormat(widths[i], 7, header, "1", 0, align, true, 0, "")
+	}
+	pdf.Ln(-1)
+
+	pdf.SetFont("Helvetica", "", 10)
+	subtotal := 0.0
+	for _, item := range order.OrderItems {
+		amount := item.Price * float64(item.Quantity)
+		subtotal += amount
+
+		name := item.Product.Name
+		if name == "" {
+			name = fmt.Sprintf("Product #%d", item.ProductID)
+		}
+		pdf.CellFormat(widths[0], 7, name, "1", 0, "L", false, 0, "")
+		pdf.CellFormat(widths[1], 7, fmt.Sprintf("%d", item.Quantity), "1", 0, "R", false, 0, "")
+		pdf.CellFormat(widths[2], 7, formatAmount(item.Price), "1", 0, "R", false, 0, "")
+		pdf.CellFormat(widths[3], 7, formatAmount(amount), "1", 0, "R", false, 0, "")
+		pdf.Ln(-1)
+	}
+
+	// Totals
+	labelWidth := widths[0] + widths[1] + widths[2]
+	pdf.CellFormat(labelWidth, 7, "Subtotal", "", 0, "R", false, 0, "")
+	pdf.CellFormat(widths[3], 7, formatAmount(subtotal), "", 1, "R", false, 0, "")
+	pdf.SetFont("Helvetica", "B", 11)
+	pdf.CellFormat(labelWidth, 8, "Total", "", 0, "R", false, 0, "")
+	pdf.CellFormat(widths[3], 8, formatAmount(order.TotalCost), "T", 1, "R", false, 0, "")
+
+	pdf.Ln(10)
+	pdf.SetFont("Helvetica", "I", 9)
+	pdf.CellFormat(0, 5, "Thank you for your order.", "", 1, "C", false, 0, "")
+
+	return pdf.Output(w)
+}
+
+// writeAddressBlock prints a titled, multi-line address at the given position
+// and returns the vertical position just below it.
+func writeAddressBlock(pdf *fpdf.Fpdf, x, y float64, title, address string) float64 {
+	pdf.SetXY(x, y)
+	pdf.SetFont("Helvetica", "B", 10)
+	pdf.CellFormat(90, 5, title, "", 2, "L", false, 0, "")
+	pdf.SetFont("Helvetica", "", 10)
+	lines := splitLines(address)
+	if len(lines) == 0 {
+		lines = []string{"-"}
+	}
+	for _, line := range lines {
+		pdf.CellFormat(90, 5, line, "", 2, "L", false, 0, "")
+	}
+	return pdf.GetY()
+}
+
+func splitLines(s string) []string {
+	var lines []string
+	for _, line := range strings.Split(s, "\n") {
+		if line = strings.TrimSpace(line); line != "" {
+			lines = append(lines, line)
+		}
+	}
+	return lines
+}
+
+func formatAmount(amount float64) string {
+	return fmt.Sprintf("%.2f", amount)
+}
@@ -1,15 +1,56 @@
 package shop_models
 
 import (
+	"errors"
+	"fmt"
+
 	"gorm.io/gorm"
+	"xy.com/mysite/models"
+)
+
+// Order statuses.
+const (
+	OrderStatusPending   = "pending"
+	OrderStatusPaid      = "paid"
+	OrderStatusShipped   = "shipped"
+	OrderStatusDelivered = "delivered"
+	OrderStatusCancelled = "cancelled"
 )
 
+var (
+	ErrOrderNotFound      = models.NewError(models.KindNotFound, "order_not_found", "order not found")
+	ErrInvalidOrderStatus = models.NewError(models.KindInvalid, "invalid_order_status", "invalid order status")
+)
+
+// ValidOrderStatus reports whether status is a known order status.
+func ValidOrderStatus(status string) bool {
+	switch status {
+	case OrderStatusPending, OrderStatusPaid, OrderStatusShipped, OrderStatusDelivered, OrderStatusCancelled:
+		return true
+	}
+	return false
+}
+
+// IsPaidOrderStatus reports whether an order with the status has been paid for,
+// including orders that have since been shipped or delivered.
+func IsPaidOrderStatus(status string) bool {
+	switch status {
+	case OrderStatusPaid, OrderStatusShipped, OrderStatusDelivered:
+		return true
+	}
+	return false
+}
+
 // Order represents an order entity in the system.
 type Order struct {
 	gorm.Model
 	UserID     uint        `json:"user_id" gorm:"index:idx_user_OrderItems"`
 	OrderItems []OrderItem `json:"order_items" gorm:"foreignKey:OrderID"`
 	TotalCost  float64     `json:"total_cost"`
+	Status     string      `gorm:"size:32;not null;default:pending;index" json:"status"`
+
+	ShippingAddress string `gorm:"size:1024" json:"shipping_address"`
+	BillingAddress  string `gorm:"size:1024" json:"billing_address"`
 }
 
 type OrderItem struct {
@@ -21,8 +62,9 @@ type OrderItem struct {
 	Price     float64 `json:"price"`
 }
 
-// CreateOrder creates a new order in the database.
+// CreateOrder creates a new order in the database. New orders always start as pending.
 func CreateOrder(db *gorm.DB, order *Order) error {
+	order.Status = OrderStatusPending
 	return db.Create(order).Error
 }
 
@@ -42,6 +84,9 @@ func GetOrderByID(db *gorm.DB, id uint) (*Order, error) {
 	var order Order
 	err := db.Preload("OrderItems.Product").Where("id = ?", id).First(&order).Error
 	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrOrderNotFound
+		}
 		return nil, err
 	}
 	return &order, nil
@@ -58,8 +103,37 @@ func GetOrdersByUserID(db *gorm.DB, userID uint) ([]Order, error) {
 }
 
 // UpdateOrder updates the order data in the database.
+// The status and customer are left untouched; use UpdateOrderStatus to change the status.
 func UpdateOrder(db *gorm.DB, order *Order) error {
-	return db.Save(order).Error
+	return db.Omit("status", "user_id").Save(order).Error
+}
+
+// UpdateOrderStatus sets the status of an order.
+func UpdateOrderStatus(db *gorm.DB, id uint, status string) error {
+	if !ValidOrderStatus(status) {
+		return fmt.Errorf("%w %q", ErrInvalidOrderStatus, status)
+	}
+	result := db.Model(&Order{}).Where("id = ?", id).Update("status", status)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrOrderNotFound
+	}
+	return nil
+}
+
+// HasDeliveredOrderWithProduct reports whether the user has a delivered order containing the product.
+func HasDeliveredOrderWithProduct(db *gorm.DB, userID, productID uint) (bool, error) {
+	var count int64
+	err := db.Model(&OrderItem{}).
+		Joins("JOIN orders ON orders.id = order_items.order_id AND orders.deleted_at IS NULL").
+		Where("orders.user_id = ? AND orders.status = ? AND order_items.product_id = ?", userID, OrderStatusDelivered, productID).
+		Count(&count).Error
+	if err != nil {
+		return false, err
+	}
+	return count > 0, nil
 }
 
 // DeleteOrder deletes an order from the database.
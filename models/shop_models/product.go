@@ -1,9 +1,14 @@
 package shop_models
 
 import (
+	"errors"
+
 	"gorm.io/gorm"
+	"xy.com/mysite/models"
 )
 
+var ErrProductNotFound = models.NewError(models.KindNotFound, "product_not_found", "product not found")
+
 // Product represents a product entity in the system.
 type Product struct {
 	gorm.Model
@@ -23,6 +28,9 @@ func GetProductByID(db *gorm.DB, id uint) (*Product, error) {
 	var product Product
 	err := db.Where("id = ?", id).First(&product).Error
 	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrProductNotFound
+		}
 		return nil, err
 	}
 	return &product, nil
@@ -0,0 +1,224 @@
+package shop_models
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"xy.com/mysite/models"
+)
+
+// ErrInvalidImportFile is returned when the import file itself cannot be read,
+// as opposed to individual rows failing validation.
+var ErrInvalidImportFile = models.NewError(models.KindInvalid, "invalid_import_file", "invalid import file")
+
+// errDryRun is returned from the import transaction to roll it back in dry-run mode.
+var errDryRun = errors.New("dry run")
+
+// errRowsRejected is returned from the import transaction to roll it back when
+// rows turn out to be invalid against the existing products.
+var errRowsRejected = errors.New("rows rejected")
+
+// ImportRowError describes the validation errors for a single CSV row.
+// Row is the 1-based line number in the file, counting the header.
+type ImportRowError struct {
+	Row    int      `json:"row"`
+	Errors []string `json:"errors"`
+}
+
+// ImportResult summarises a product import.
+type ImportResult struct {
+	DryRun    bool             `json:"dry_run"`
+	Rows      int              `json:"rows"`
+	Created   int              `json:"created"`
+	Updated   int              `json:"updated"`
+	RowErrors []ImportRowError `json:"row_errors,omitempty"`
+}
+
+// ImportProducts parses a product CSV with the columns id, name, description,
+// price and image_url, validates every row and upserts all products in a
+// single transaction. Rows with an id update that product, rows without one
+// create a new product. Rows cannot update deleted products. If any row is
+// invalid nothing is written and the per-row errors are returned in the
+// result. In dry-run mode the transaction is always rolled back.
+func ImportProducts(db *gorm.DB, r io.Reader, dryRun bool) (*ImportResult, error) {
+	products, lines, rowErrors, err := parseProductCSV(r)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &ImportResult{DryRun: dryRun, Rows: len(products) + len(rowErrors), RowErrors: rowErrors}
+	if len(rowErrors) > 0 {
+		return result, nil
+	}
+
+	err = db.Transaction(func(tx *gorm.DB) error {
+		for i := range products {
+			product := &products[i]
+			if product.ID == 0 {
+				if err := tx.Create(product).Error; err != nil {
+					return fmt.Errorf("failed to create product %q: %w", product.Name, err)
+				}
+				result.Created++
+				continue
+			}
+
+			var existing []Product
+			if err := tx.Unscoped().Where("id = ?", product.ID).Limit(1).Find(&existing).Error; err != nil {
+				return err
+			}
+			if len(existing) > 0 && existing[0].DeletedAt.Valid {
+				result.RowErrors = append(result.RowErrors, ImportRowError{
+					Row:    lines[product.ID],
+					Errors: []string{fmt.Sprintf("product %d has been deleted", product.ID)},
+				})
+				continue
+			}
+			err := tx.Clauses(clause.OnConflict{
+				Columns:   []clause.Column{{Name: "id"}},
+				DoUpdates: clause.AssignmentColumns([]string{"name", "description", "price", "image_url", "updated_at"}),
+			}).Create(product).Error
+			if err != nil {
+				return fmt.Errorf("failed to upsert product %d: %w", product.ID, err)
+			}
+			if len(existing) > 0 {
+				result.Updated++
+			} else {
+				result.Created++
+			}
+		}
+
+		if len(result.RowErrors) > 0 {
+			return errRowsRejected
+		}
+		if dryRun {
+			return errDryRun
+		}
+		return nil
+	})
+	if errors.Is(err, errRowsRejected) {
+		result.Created, result.Updated = 0, 0
+		return result, nil
+	}
+	if err != nil && !errors.Is(err, errDryRun) {
+		return nil, err
+	}
+
+	return result, nil
+}
+
+// parseProductCSV reads every row of the CSV, returning the valid products, the
+// lines of the rows by product ID and the errors for the invalid rows.
+func parseProductCSV(r io.Reader) ([]Product, map[uint]int, []ImportRowError, error) {
+	reader := csv.NewReader(r)
+	reader.TrimLeadingSpace = true
+	reader.FieldsPerRecord = -1
+
+	header, err := reader.Read()
+	if err != nil {
+		if errors.Is(err, io.EOF) {
+			return nil, nil, nil, fmt.Errorf("%w: csv file is empty", ErrInvalidImportFile)
+		}
+		return nil, nil, nil, fmt.Errorf("%w: failed to read csv header: %v", ErrInvalidImportFile, err)
+	}
+
+	columns := make(map[string]int)
+	for i, name := range header {
+		columns[strings.ToLower(strings.TrimSpace(name))] = i
+	}
+	for _, required := range []string{"name", "price"} {
+		if _, ok := columns[required]; !ok {
+			return nil, nil, nil, fmt.Errorf("%w: csv header is missing required column %q", ErrInvalidImportFile, required)
+		}
+	}
+
+	var products []Product
+	var rowErrors []ImportRowError
+	seenIDs := make(map[uint]int)
+	line := 1
+	for {
+		record, err := reader.Read()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		line++
+		if err != nil {
+			rowErrors = append(rowErrors, ImportRowError{Row: line, Errors: []string{err.Error()}})
+			continue
+		}
+
+		product, errs := parseProductRecord(record, columns)
+		if product.ID != 0 {
+			if first, ok := seenIDs[product.ID]; ok {
+				errs = append(errs, fmt.Sprintf("id %d already used on row %d", product.ID, first))
+			} else {
+				seenIDs[product.ID] = line
+			}
+		}
+		if len(errs) > 0 {
+			rowErrors = append(rowErrors, ImportRowError{Row: line, Errors: errs})
+			continue
+		}
+		products = append(products, product)
+	}
+
+	return products, seenIDs, rowErrors, nil
+}
+
+// parseProductRecord converts a CSV record to a Product and validates it.
+func parseProductRecord(record []string, columns map[string]int) (Product, []string) {
+	field := func(name string) string {
+		i, ok := columns[name]
+		if !ok || i >= len(record) {
+			return ""
+		}
+		return strings.TrimSpace(record[i])
+	}
+
+	var product Product
+	var errs []string
+
+	if raw := field("id"); raw != "" {
+		id, err := strconv.ParseUint(raw, 10, 64)
+		if err != nil || id == 0 {
+			errs = append(errs, fmt.Sprintf("invalid id %q", raw))
+		} else {
+			product.ID = uint(id)
+		}
+	}
+
+	product.Name = field("name")
+	if product.Name == "" {
+		errs = append(errs, "name is required")
+	} else if len(product.Name) > 255 {
+		errs = append(errs, "name must be at most 255 characters")
+	}
+
+	product.Description = field("description")
+	if len(product.Description) > 1024 {
+		errs = append(errs, "description must be at most 1024 characters")
+	}
+
+	if raw := field("price"); raw == "" {
+		errs = append(errs, "price is required")
+	} else if price, err := strconv.ParseFloat(raw, 64); err != nil || math.IsNaN(price) || math.IsInf(price, 0) {
+		errs = append(errs, fmt.Sprintf("invalid price %q", raw))
+	} else if price < 0 {
+		errs = append(errs, "price must not be negative")
+	} else {
+		product.Price = price
+	}
+
+	product.ImageURL = field("image_url")
+	if len(product.ImageURL) > 512 {
+		errs = append(errs, "image_url must be at most 512 characters")
+	}
+
+	return product, errs
+}
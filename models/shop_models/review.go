@@ -0,0 +1,164 @@
+package shop_models
+
+import (
+	"gorm.io/gorm"
+	"xy.com/mysite/models"
+)
+
+// Review moderation states.
+const (
+	ReviewStatusPending  = "pending"
+	ReviewStatusApproved = "approved"
+	ReviewStatusRejected = "rejected"
+)
+
+var (
+	ErrInvalidRating       = models.NewError(models.KindInvalid, "invalid_rating", "rating must be between 1 and 5")
+	ErrReviewNotAllowed    = models.NewError(models.KindForbidden, "review_not_allowed", "only customers with a delivered order containing this product can review it")
+	ErrReviewExists        = models.NewError(models.KindConflict, "review_exists", "you have already reviewed this product")
+	ErrReviewNotFound      = models.NewError(models.KindNotFound, "review_not_found", "review not found")
+	ErrInvalidReviewStatus = models.NewError(models.KindInvalid, "invalid_review_status", "invalid review status")
+)
+
+// Review represents a customer's rating of a product.
+// New reviews are pending and only become public once approved.
+type Review struct {
+	gorm.Model
+	ProductID uint   `gorm:"not null;uniqueIndex:idx_review_user_product;index" json:"product_id"`
+	UserID    uint   `gorm:"not null;uniqueIndex:idx_review_user_product" json:"user_id"`
+	Rating    int    `gorm:"not null" json:"rating"`
+	Text      string `gorm:"size:2000" json:"text"`
+	Status    string `gorm:"size:16;not null;default:pending;index" json:"status"`
+}
+
+// RatingSummary is the aggregate of the approved reviews of a product.
+type RatingSummary struct {
+	ProductID     uint    `json:"-"`
+	AverageRating float64 `json:"average_rating"`
+	ReviewCount   int64   `json:"review_count"`
+}
+
+// ProductWithRating is a product together with its rating summary, used in listings.
+type ProductWithRating struct {
+	Product
+	AverageRating float64 `json:"average_rating"`
+	ReviewCount   int64   `json:"review_count"`
+}
+
+// CreateReview adds a pending review after checking the rating and that the
+// user has received the product.
+func CreateReview(db *gorm.DB, review *Review) error {
+	if review.Rating < 1 || review.Rating > 5 {
+		return ErrInvalidRating
+	}
+
+	allowed, err := HasDeliveredOrderWithProduct(db, review.UserID, review.ProductID)
+	if err != nil {
+		return err
+	}
+	if !allowed {
+		return ErrReviewNotAllowed
+	}
+
+	var count int64
+	err = db.Unscoped().Model(&Review{}).
+		Where("user_id = ? AND product_id = ?", review.UserID, review.ProductID).
+		Count(&count).Error
+	if err != nil {
+		return err
+	}
+	if count > 0 {
+		return ErrReviewExists
+	}
+
+	review.Status = ReviewStatusPending
+	return db.Create(review).Error
+}
+
+// GetApprovedReviewsByProductID retrieves the public reviews of a product, newest first.
+func GetApprovedReviewsByProductID(db *gorm.DB, productID uint) ([]Review, error) {
+	var reviews []Review
+	err := db.Where("product_id = ? AND status = ?", productID, ReviewStatusApproved).
+		Order("created_at desc").Find(&reviews).Error
+	if err != nil {
+		return nil, err
+	}
+	return reviews, nil
+}
+
+// GetReviewsByStatus retrieves all reviews in a moderation state, oldest first.
+func GetReviewsByStatus(db *gorm.DB, status string) ([]Review, error) {
+	var reviews []Review
+	err := db.Where("status = ?", status).Order("created_at").Find(&reviews).Error
+	if err != nil {
+		return nil, err
+	}
+	return reviews, nil
+}
+
+// ModerateReview sets the moderation state of a review.
+func ModerateReview(db *gorm.DB, id uint, status string) error {
+	if status != ReviewStatusPending && status != ReviewStatusApproved && status != ReviewStatusRejected {
+		return ErrInvalidReviewStatus
+	}
+	result := db.Model(&Review{}).Where("id = ?", id).Update("status", status)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrReviewNotFound
+	}
+	return nil
+}
+
+// GetRatingSummaries aggregates the approved reviews of the given products.
+// Products without approved reviews are absent from the result.
+func GetRatingSummaries(db *gorm.DB, productIDs []uint) (map[uint]RatingSummary, error) {
+	summaries := make(map[uint]RatingSummary)
+	if len(productIDs) == 0 {
+		return summaries, nil
+	}
+
+	var rows []RatingSummary
+	err := db.Model(&Review{}).
+		Select("product_id, AVG(rating) AS average_rating, COUNT(*) AS review_count").
+		Where("status = ? AND product_id IN ?", ReviewStatusApproved, productIDs).
+		Group("product_id").
+		Scan(&rows).Error
+	if err != nil {
+		return nil, err
+	}
+
+	for _, row := range rows {
+		summaries[row.ProductID] = row
+	}
+	return summaries, nil
+}
+
+// GetAllProductsWithRatings retrieves all products with their rating summaries.
+func GetAllProductsWithRatings(db *gorm.DB) ([]ProductWithRating, error) {
+	products, err := GetAllProducts(db)
+	if err != nil {
+		return nil, err
+	}
+
+	ids := make([]uint, len(products))
+	for i, product := range products {
+		ids[i] = product.ID
+	}
+	summaries, err := GetRatingSummaries(db, ids)
+	if err != nil {
+		return nil, err
+	}
+
+	listing := make([]ProductWithRating, len(products))
+	for i, product := range products {
+		summary := summaries[product.ID]
+		listing[i] = ProductWithRating{
+			Product:       product,
+			AverageRating: summary.AverageRating,
+			ReviewCount:   summary.ReviewCount,
+		}
+	}
+	return listing, nil
+}
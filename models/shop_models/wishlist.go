@@ -0,0 +1,38 @@
+package shop_models
+
+import (
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// WishlistItem represents a product saved to a user's wishlist.
+type WishlistItem struct {
+	gorm.Model
+	UserID    uint    `gorm:"not null;uniqueIndex:idx_wishlist_user_product" json:"user_id"`
+	ProductID uint    `gorm:"not null;uniqueIndex:idx_wishlist_user_product" json:"product_id"`
+	Product   Product `json:"product"`
+}
+
+// AddToWishlist saves a product to the user's wishlist. Adding a product twice is a no-op.
+func AddToWishlist(db *gorm.DB, userID, productID uint) error {
+	if _, err := GetProductByID(db, productID); err != nil {
+		return err
+	}
+	item := WishlistItem{UserID: userID, ProductID: productID}
+	return db.Clauses(clause.OnConflict{DoNothing: true}).Create(&item).Error
+}
+
+// RemoveFromWishlist removes a product from the user's wishlist.
+func RemoveFromWishlist(db *gorm.DB, userID, productID uint) error {
+	return db.Unscoped().Where("user_id = ? AND product_id = ?", userID, productID).Delete(&WishlistItem{}).Error
+}
+
+// GetWishlistByUserID retrieves the wishlist of a user with the products loaded.
+func GetWishlistByUserID(db *gorm.DB, userID uint) ([]WishlistItem, error) {
+	var items []WishlistItem
+	err := db.Preload("Product").Where("user_id = ?", userID).Order("created_at desc").Find(&items).Error
+	if err != nil {
+		return nil, err
+	}
+	return items, nil
+}
@@ -0,0 +1,227 @@
+package user_models
+
+import (
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+	"xy.com/mysite/models"
+)
+
+// Referral statuses
+const (
+	ReferralPending  = "pending"  // Waiting for the referee's qualifying action
+	ReferralRewarded = "rewarded" // Both parties have been rewarded
+	ReferralRejected = "rejected" // Failed a fraud check, never rewarded
+)
+
+// Reasons a referral is rejected
+const (
+	ReferralRejectSelf       = "self_referral"
+	ReferralRejectSameIP     = "same_ip"
+	ReferralRejectSameDevice = "same_device"
+	ReferralRejectBanned     = "banned"
+)
+
+const (
+	referralCodeLength   = 8
+	referralCodeAlphabet = "23456789ABCDEFGHJKLMNPQRSTUVWXYZ" // No 0/O or 1/I/L to misread
+)
+
+var ErrInvalidReferralCode = models.NewError(models.KindInvalid, "invalid_referral_code", "invalid referral code")
+
+// Referral records that a user signed up with another user's referral code.
+type Referral struct {
+	ID           uint       `json:"id" gorm:"primaryKey"`
+	CreatedAt    time.Time  `json:"created_at"`
+	ReferrerID   uint       `json:"referrer_id" gorm:"not null;index"`
+	RefereeID    uint       `json:"referee_id" gorm:"not null;uniqueIndex"` // Users are referred at most once
+	Code         string     `json:"code" gorm:"size:16"`
+	SignupIP     string     `json:"-" gorm:"size:64"`
+	DeviceID     string     `json:"-" gorm:"size:128"`
+	Status       string     `json:"status" gorm:"size:16;not null;index"`
+	RejectReason string     `json:"reject_reason,omitempty" gorm:"size:32"`
+	QualifiedAt  *time.Time `json:"qualified_at,omitempty"`
+}
+
+// SignupReferral is the referral code a user signs up with and where they sign up from.
+type SignupReferral struct {
+	Code     string
+	IP       string
+	DeviceID string
+}
+
+// newReferralCode generates a referral code no user has yet.
+func newReferralCode(db *gorm.DB) (string, error) {
+	for attempt := 0; attempt < 5; attempt++ {
+		code := make([]byte, referralCodeLength)
+		for i := range code {
+			n, err := rand.Int(rand.Reader, big.NewInt(int64(len(referralCodeAlphabet))))
+			if err != nil {
+				return "", err
+			}
+			code[i] = referralCodeAlphabet[n.Int64()]
+		}
+
+		var count int64
+		if err := db.Model(&User{}).Where("referral_code = ?", string(code)).Count(&count).Error; err != nil {
+			return "", err
+		}
+		if count == 0 {
+			return string(code), nil
+		}
+	}
+	return "", errors.New("failed to generate a unique referral code")
+}
+
+// MigrateReferralCodes gives users that predate referrals a referral code and
+// makes the codes unique.
+func MigrateReferralCodes(db *gorm.DB) error {
+	var users []User
+	if err := db.Where("referral_code IS NULL OR referral_code = ''").Find(&users).Error; err != nil {
+		return err
+	}
+	for _, user := range users {
+		code, err := newReferralCode(db)
+		if err != nil {
+			return err
+		}
+		if err := db.Model(&User{}).Where("id = ?", user.ID).Update("referral_code", code).Error; err != nil {
+			return fmt.Errorf("failed to set referral code of user %d: %w", user.ID, err)
+		}
+	}
+
+	if db.Migrator().HasIndex(&User{}, "idx_users_referral_code") {
+		return nil
+	}
+	return db.Exec("CREATE UNIQUE INDEX idx_users_referral_code ON users(referral_code)").Error
+}
+
+// GetUserByReferralCode retrieves the user a referral code belongs to. Codes are
+// case-insensitive.
+func GetUserByReferralCode(db *gorm.DB, code string) (*User, error) {
+	var user User
+	err := db.Where("referral_code = ?", strings.ToUpper(strings.TrimSpace(code))).First(&user).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrUserNotFound
+		}
+		return nil, err
+	}
+	return &user, nil
+}
+
+// normalizeEmail reduces an email address to its mailbox, ignoring case and
+// +tags, so aliases of one mailbox compare equal.
+func normalizeEmail(email string) string {
+	email = strings.ToLower(strings.TrimSpace(email))
+	at := strings.LastIndex(email, "@")
+	if at < 0 {
+		return email
+	}
+	local, domain := email[:at], email[at:]
+	if plus := strings.Index(local, "+"); plus >= 0 {
+		local = local[:plus]
+	}
+	return local + domain
+}
+
+// referralRejectReason runs the fraud checks on a signup referred by referrer and
+// returns why the referral must not be rewarded, empty if it passes. Referrals are
+// rejected when the referee is the referrer under another address, or signs up from
+// the IP or device the referrer or another of their referees signed up from.
+func referralRejectReason(db *gorm.DB, referrer *User, user *User, referral SignupReferral) (string, error) {
+	if normalizeEmail(referrer.Email) == normalizeEmail(user.Email) {
+		return ReferralRejectSelf, nil
+	}
+
+	checks := []struct {
+		column, value, reason string
+	}{
+		{"signup_ip", referral.IP, ReferralRejectSameIP},
+		{"device_id", referral.DeviceID, ReferralRejectSameDevice},
+	}
+	for _, check := range checks {
+		if check.value == "" {
+			continue
+		}
+		var count int64
+		err := db.Model(&User{}).Where("id = ? AND "+check.column+" = ?", referrer.ID, check.value).Count(&count).Error
+		if err != nil {
+			return "", err
+		}
+		if count == 0 {
+			err = db.Model(&Referral{}).Where("referrer_id = ? AND "+check.column+" = ?", referrer.ID, check.value).Count(&count).Error
+			if err != nil {
+				return "", err
+			}
+		}
+		if count > 0 {
+			return check.reason, nil
+		}
+	}
+	return "", nil
+}
+
+// SignUp creates a user like CreateUser, recording where they signed up from and the
+// referral if they signed up with a referral code. Referrals that fail the fraud
+// checks are recorded as rejected without failing the signup.
+func SignUp(db *gorm.DB, user *User, referral SignupReferral) error {
+	user.SignupIP = referral.IP
+	user.DeviceID = referral.DeviceID
+
+	return db.Transaction(func(tx *gorm.DB) error {
+		var referrer *User
+		if referral.Code != "" {
+			var err error
+			referrer, err = GetUserByReferralCode(tx, referral.Code)
+			if errors.Is(err, ErrUserNotFound) {
+				return fmt.Errorf("%w %q", ErrInvalidReferralCode, referral.Code)
+			}
+			if err != nil {
+				return err
+			}
+		}
+
+		if err := CreateUser(tx, user); err != nil {
+			return err
+		}
+		if referrer == nil {
+			return nil
+		}
+
+		reason, err := referralRejectReason(tx, referrer, user, referral)
+		if err != nil {
+			return err
+		}
+		record := Referral{
+			ReferrerID:   referrer.ID,
+			RefereeID:    user.ID,
+			Code:         referrer.ReferralCode,
+			SignupIP:     referral.IP,
+			DeviceID:     referral.DeviceID,
+			Status:       ReferralPending,
+			RejectReason: reason,
+		}
+		if reason != "" {
+			record.Status = ReferralRejected
+		}
+		if err := tx.Create(&record).Error; err != nil {
+			return fmt.Errorf("failed to create referral: %w", err)
+		}
+		return nil
+	})
+}
+
+// GetReferralsByReferrer retrieves the users the referrer referred, newest first.
+func GetReferralsByReferrer(db *gorm.DB, referrerID uint) ([]Referral, error) {
+	var referrals []Referral
+	if err := db.Where("referrer_id = ?", referrerID).Order("created_at desc, id desc").Find(&referrals).Error; err != nil {
+		return nil, err
+	}
+	return referrals, nil
+}
@@ -0,0 +1,103 @@
+package user_models_test
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+	"xy.com/mysite/models"
+	"xy.com/mysite/models/user_models"
+)
+
+func setupReferralDB(t *testing.T) *gorm.DB {
+	db, err := gorm.Open(sqlite.Open(filepath.Join(t.TempDir(), "referrals.db")), &gorm.Config{
+		Logger: logger.Default.LogMode(logger.Silent),
+	})
+	if err != nil {
+		t.Fatalf("failed to connect to test database: %v", err)
+	}
+	if err := db.AutoMigrate(&user_models.User{}, &user_models.Referral{}); err != nil {
+		t.Fatal(err)
+	}
+	if err := user_models.MigrateReferralCodes(db); err != nil {
+		t.Fatal(err)
+	}
+	return db
+}
+
+func TestReferralCodes(t *testing.T) {
+	db := setupReferralDB(t)
+
+	// Users that predate referrals get a code when migrating
+	legacy := user_models.User{Username: "legacy", Email: "legacy@example.com", Password: "x"}
+	assert.NoError(t, db.Create(&legacy).Error)
+	assert.NoError(t, user_models.MigrateReferralCodes(db))
+	found, err := user_models.GetUserByID(db, legacy.ID)
+	assert.NoError(t, err)
+	assert.Len(t, found.ReferralCode, 8)
+
+	// New users get one, whatever they asked for, and keep it on updates
+	user := &user_models.User{Username: "alice", Email: "alice@example.com", Password: "password", ReferralCode: "MINE"}
+	assert.NoError(t, user_models.CreateUser(db, user))
+	assert.Len(t, user.ReferralCode, 8)
+	assert.NotEqual(t, found.ReferralCode, user.ReferralCode)
+	code := user.ReferralCode
+
+	user.ReferralCode = ""
+	user.Email = "alice2@example.com"
+	assert.NoError(t, user_models.UpdateUser(db, user))
+	found, err = user_models.GetUserByReferralCode(db, " "+code+" ")
+	assert.NoError(t, err)
+	assert.Equal(t, user.ID, found.ID)
+	assert.Equal(t, "alice2@example.com", found.Email)
+
+	_, err = user_models.GetUserByReferralCode(db, "NOPE")
+	assert.ErrorIs(t, err, user_models.ErrUserNotFound)
+}
+
+func TestSignUpWithReferral(t *testing.T) {
+	db := setupReferralDB(t)
+	referrer := &user_models.User{Username: "referrer", Email: "Referrer@example.com", Password: "password"}
+	assert.NoError(t, user_models.SignUp(db, referrer, user_models.SignupReferral{IP: "10.0.0.1", DeviceID: "device-1"}))
+
+	signUp := func(name, email, ip, device string) (*user_models.User, error) {
+		user := &user_models.User{Username: name, Email: email, Password: "password"}
+		err := user_models.SignUp(db, user, user_models.SignupReferral{Code: referrer.ReferralCode, IP: ip, DeviceID: device})
+		return user, err
+	}
+
+	// Unknown codes fail the signup, so typos can be fixed
+	user := &user_models.User{Username: "typo", Email: "typo@example.com", Password: "password"}
+	err := user_models.SignUp(db, user, user_models.SignupReferral{Code: "NOPE"})
+	assert.ErrorIs(t, err, user_models.ErrInvalidReferralCode)
+	assert.True(t, errors.Is(err, models.ErrInvalid))
+	_, err = user_models.GetUserByUsername(db, "typo")
+	assert.ErrorIs(t, err, user_models.ErrUserNotFound)
+
+	bob, err := signUp("bob", "bob@example.com", "10.0.0.2", "device-2")
+	assert.NoError(t, err)
+	self, err := signUp("self", "referrer+alt@EXAMPLE.com", "10.0.0.3", "device-3")
+	assert.NoError(t, err)
+	sameIP, err := signUp("sameip", "carol@example.com", "10.0.0.1", "device-4")
+	assert.NoError(t, err)
+	sameDevice, err := signUp("samedevice", "dave@example.com", "10.0.0.5", "device-2")
+	assert.NoError(t, err)
+
+	referrals, err := user_models.GetReferralsByReferrer(db, referrer.ID)
+	assert.NoError(t, err)
+	status := make(map[uint]string)
+	for _, referral := range referrals {
+		assert.Equal(t, referrer.ReferralCode, referral.Code)
+		status[referral.RefereeID] = referral.Status + ":" + referral.RejectReason
+	}
+	assert.Equal(t, map[uint]string{
+		bob.ID:        "pending:",
+		self.ID:       "rejected:self_referral",
+		sameIP.ID:     "rejected:same_ip",
+		sameDevice.ID: "rejected:same_device",
+	}, status)
+}
@@ -0,0 +1,66 @@
+package user_models
+
+import (
+	"fmt"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"xy.com/mysite/models"
+)
+
+// Segments with a special meaning to the site. Admins and banned users are,
+// for example, left off the leaderboards.
+const (
+	SegmentAdmin  = "admin"
+	SegmentBanned = "banned"
+)
+
+var ErrInvalidSegment = models.NewError(models.KindInvalid, "invalid_segment", "invalid segment")
+
+// UserSegment assigns a user to a named segment, such as "vip" or "beta".
+type UserSegment struct {
+	UserID  uint   `json:"user_id" gorm:"primaryKey"`
+	Segment string `json:"segment" gorm:"primaryKey;size:64"`
+}
+
+// AddUserToSegment adds the user to the segment. Adding a user twice has no effect.
+func AddUserToSegment(db *gorm.DB, userID uint, segment string) error {
+	if segment == "" {
+		return fmt.Errorf("%w: segment is required", ErrInvalidSegment)
+	}
+	userSegment := UserSegment{UserID: userID, Segment: segment}
+	return db.Clauses(clause.OnConflict{DoNothing: true}).Create(&userSegment).Error
+}
+
+// RemoveUserFromSegment removes the user from the segment.
+func RemoveUserFromSegment(db *gorm.DB, userID uint, segment string) error {
+	return db.Where("user_id = ? AND segment = ?", userID, segment).Delete(&UserSegment{}).Error
+}
+
+// GetUserSegments retrieves the names of the segments the user is in.
+func GetUserSegments(db *gorm.DB, userID uint) ([]string, error) {
+	var segments []string
+	err := db.Model(&UserSegment{}).Where("user_id = ?", userID).Order("segment").Pluck("segment", &segments).Error
+	if err != nil {
+		return nil, err
+	}
+	return segments, nil
+}
+
+// IsUserInAnySegment reports whether the user is in at least one of the segments.
+func IsUserInAnySegment(db *gorm.DB, userID uint, segments []string) (bool, error) {
+	if len(segments) == 0 {
+		return false, nil
+	}
+	var count int64
+	err := db.Model(&UserSegment{}).Where("user_id = ? AND segment IN ?", userID, segments).Count(&count).Error
+	if err != nil {
+		return false, err
+	}
+	return count > 0, nil
+}
+
+// IsAdmin reports whether the user is in the admin segment.
+func IsAdmin(db *gorm.DB, userID uint) (bool, error) {
+	return IsUserInAnySegment(db, userID, []string{SegmentAdmin})
+}
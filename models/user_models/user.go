@@ -4,6 +4,12 @@ import (
 	"errors"
 	"golang.org/x/crypto/bcrypt"
 	"gorm.io/gorm"
+	"xy.com/mysite/models"
+)
+
+var (
+	ErrUserNotFound       = models.NewError(models.KindNotFound, "user_not_found", "user not found")
+	ErrInvalidCredentials = models.NewError(models.KindUnauthorized, "invalid_credentials", "invalid email or password")
 )
 
 // User represents a user entity in the system.
@@ -12,6 +18,10 @@ type User struct {
 	Username string `gorm:"unique;not null" json:"username"`
 	Email    string `gorm:"unique;not null" json:"email"`
 	Password string `gorm:"not null" json:"password"`
+
+	ReferralCode string `gorm:"size:16" json:"referral_code"` // Code other users sign up with to be referred by this user
+	SignupIP     string `gorm:"size:64" json:"-"`
+	DeviceID     string `gorm:"size:128" json:"-"`
 }
 
 // CreateUser creates a new user in the database.
@@ -21,6 +31,10 @@ func CreateUser(db *gorm.DB, user *User) error {
 		return err
 	}
 	user.Password = string(hashedPassword)
+	user.ReferralCode, err = newReferralCode(db)
+	if err != nil {
+		return err
+	}
 	return db.Create(user).Error
 }
 
@@ -29,6 +43,9 @@ func GetUserByID(db *gorm.DB, id uint) (*User, error) {
 	var user User
 	err := db.Where("id = ?", id).First(&user).Error
 	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrUserNotFound
+		}
 		return nil, err
 	}
 	return &user, nil
@@ -39,6 +56,9 @@ func GetUserByUsername(db *gorm.DB, username string) (*User, error) {
 	var user User
 	err := db.Where("username = ?", username).First(&user).Error
 	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrUserNotFound
+		}
 		return nil, err
 	}
 	return &user, nil
@@ -49,14 +69,18 @@ func GetUserByEmail(db *gorm.DB, email string) (*User, error) {
 	var user User
 	err := db.Where("Email = ?", email).First(&user).Error
 	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrUserNotFound
+		}
 		return nil, err
 	}
 	return &user, nil
 }
 
 // UpdateUser updates the user data in the database.
+// The referral code and signup details never change.
 func UpdateUser(db *gorm.DB, user *User) error {
-	return db.Save(user).Error
+	return db.Omit("ReferralCode", "SignupIP", "DeviceID").Save(user).Error
 }
 
 // DeleteUser deletes a user from the database.
@@ -68,11 +92,14 @@ func DeleteUser(db *gorm.DB, id uint) error {
 func AuthenticateUser(db *gorm.DB, email, password string) (*User, error) {
 	user, err := GetUserByEmail(db, email)
 	if err != nil {
-		return nil, errors.New("user not found")
+		if errors.Is(err, ErrUserNotFound) {
+			return nil, ErrInvalidCredentials
+		}
+		return nil, err
 	}
 	err = bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(password))
 	if err != nil {
-		return nil, err
+		return nil, ErrInvalidCredentials
 	}
 
 	return user, nil
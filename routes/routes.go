@@ -12,7 +12,11 @@ import (
 )
 
 func SetupRouter() *gin.Engine {
-	router := gin.Default()
+	router := gin.New()
+	router.Use(gin.Logger(), middleware.Recovery())
+	// Client IPs come from the connection unless proxies are trusted, see ServerConfig
+	router.SetTrustedProxies(nil)
+	router.Use(middleware.ErrorHandler())
 	SetupStaticRoutes(router)
 
 	// Auth routes
@@ -32,12 +36,18 @@ func SetupRouter() *gin.Engine {
 		userGroup.DELETE("/:id", user_handlers.DeleteUserHandler)
 	}
 
+	referralGroup := router.Group("/referrals", middleware.AuthMiddleware())
+	{
+		referralGroup.GET("/", user_handlers.GetReferralsHandler)
+	}
+
 	// Order routes
 	orderGroup := router.Group("/orders", middleware.AuthMiddleware())
 	{
 		orderGroup.GET("/getAllOrders", shop_handlers.GetAllOrdersHandler)
 		orderGroup.POST("/", shop_handlers.CreateOrderHandler)
 		orderGroup.GET("/:id", shop_handlers.GetOrderByIDHandler)
+		orderGroup.GET("/:id/invoice.pdf", shop_handlers.GetOrderInvoiceHandler)
 		orderGroup.GET("/user/:userID", shop_handlers.GetOrdersByUserIDHandler)
 		orderGroup.PUT("/:id", shop_handlers.UpdateOrderHandler)
 		orderGroup.DELETE("/:id", shop_handlers.DeleteOrderHandler)
@@ -52,37 +62,115 @@ func SetupRouter() *gin.Engine {
 		productGroup.GET("/all", shop_handlers.GetAllProductsHandler)
 		productGroup.PUT("/:id", shop_handlers.UpdateProductHandler)
 		productGroup.DELETE("/:id", shop_handlers.DeleteProductHandler)
+		productGroup.GET("/:id/reviews", shop_handlers.GetProductReviewsHandler)
+		productGroup.POST("/:id/reviews", shop_handlers.CreateReviewHandler)
+	}
+
+	// Wishlist routes
+	wishlistGroup := router.Group("/wishlist", middleware.AuthMiddleware())
+	{
+		wishlistGroup.GET("/", shop_handlers.GetWishlistHandler)
+		wishlistGroup.POST("/:productID", shop_handlers.AddToWishlistHandler)
+		wishlistGroup.DELETE("/:productID", shop_handlers.RemoveFromWishlistHandler)
 	}
 
 	// Chat routes
-	router.GET("/ws", func(c *gin.Context) {
-		handlers.HandleConnections(c.Writer, c.Request)
-	})
+	router.GET("/ws", middleware.AuthMiddleware(), handlers.HandleConnections)
+	chatGroup := router.Group("/chat", middleware.AuthMiddleware())
+	{
+		chatGroup.GET("/unread", handlers.GetUnreadCountsHandler)
+	}
 
 	pointGroup := router.Group("/point", middleware.AuthMiddleware())
 	{
 		pointGroup.GET("/points", prize_handlers.GetPointsSystemHandler)
-		pointGroup.POST("/draw", middleware.CheckRedemptionCode(), prize_handlers.DrawHandler)
+		pointGroup.GET("/campaigns", prize_handlers.GetRunningCampaignsHandler)
+		pointGroup.POST("/campaigns/:campaignID/draw", middleware.CheckRedemptionCode(), prize_handlers.DrawHandler)
 		pointGroup.POST("/exchange", prize_handlers.ExchangeCoinsHandler)
+		pointGroup.GET("/exchange/quote", prize_handlers.QuoteCoinExchangeHandler)
+		pointGroup.GET("/history", prize_handlers.PointHistoryHandler)
+		pointGroup.GET("/expiring", prize_handlers.GetExpiringPointsHandler)
+		pointGroup.GET("/draws", prize_handlers.DrawHistoryHandler)
+		pointGroup.GET("/draws/:id/verify", prize_handlers.VerifyDrawHandler)
+		pointGroup.GET("/seeds", prize_handlers.GetDrawSeedHandler)
+		pointGroup.GET("/seeds/revealed", prize_handlers.GetRevealedDrawSeedsHandler)
+		pointGroup.POST("/seeds/rotate", prize_handlers.RotateDrawSeedHandler)
+		pointGroup.POST("/checkin", prize_handlers.CheckInHandler)
+		pointGroup.GET("/checkin", prize_handlers.GetCheckInStatusHandler)
+		pointGroup.GET("/achievements", prize_handlers.GetAchievementsHandler)
+	}
+
+	prizesGroup := router.Group("/prizes", middleware.AuthMiddleware())
+	{
+		prizesGroup.GET("/", prize_handlers.GetPrizesHandler)
+		prizesGroup.GET("/:slug", prize_handlers.GetPrizeBySlugHandler)
 	}
 
-	prizeGroup := router.Group("/prize_handlers", middleware.AuthMiddleware())
+	prizeGroup := router.Group("/prize_handlers", middleware.AuthMiddleware(), middleware.CheckAdmin())
 	{
 		prizeGroup.POST("/addPrize", prize_handlers.AddPrizeHandler)
 	}
 
+	leaderboardGroup := router.Group("/leaderboards", middleware.AuthMiddleware())
+	{
+		leaderboardGroup.GET("/:board", prize_handlers.GetLeaderboardHandler)
+		leaderboardGroup.GET("/:board/me", prize_handlers.GetMyLeaderboardRankHandler)
+	}
+
 	exchangeGroup := router.Group("/exchange", middleware.AuthMiddleware())
 	{
 		exchangeGroup.GET("/checkExchanged/:prizeName", prize_handlers.CheckIfUserExchangedPrizeHandler)
 		exchangeGroup.POST("/exchanged", prize_handlers.ExchangePrizeHandler)
 		exchangeGroup.GET("/prize_handlers/:prizeName", prize_handlers.GetPrizeByNameHandler)
+		exchangeGroup.GET("/requests", prize_handlers.GetExchangeRequestsHandler)
+		exchangeGroup.GET("/requests/:id", prize_handlers.GetExchangeRequestHandler)
 	}
 
-	adminGroup := router.Group("/admin", middleware.AuthMiddleware())
+	adminGroup := router.Group("/admin", middleware.AuthMiddleware(), middleware.CheckAdmin())
 	{
 		adminGroup.POST("/addCode", prize_handlers.AddCodeHandler)
 		adminGroup.POST("/addRedemptionCode", prize_handlers.AddRedemptionCodeHandler)
+		adminGroup.GET("/redemptionCodes/:code", prize_handlers.GetRedemptionCodeUsesHandler)
+		adminGroup.DELETE("/redemptionCodes/:code", prize_handlers.RevokeRedemptionCodeHandler)
 		adminGroup.POST("/addPrize", prize_handlers.AddPrizeHandler)
+		adminGroup.PUT("/prizes/:id", prize_handlers.UpdatePrizeHandler)
+		adminGroup.DELETE("/prizes/:id", prize_handlers.RetirePrizeHandler)
+		adminGroup.POST("/prizes/:id/codes", prize_handlers.AddPrizeCodesHandler)
+		adminGroup.GET("/codePools", prize_handlers.GetCodePoolsHandler)
+		adminGroup.GET("/codePools/alerts", prize_handlers.GetCodePoolAlertsHandler)
+		adminGroup.POST("/codeBatches/generate", prize_handlers.GenerateCodeBatchHandler)
+		adminGroup.POST("/codeBatches/import", prize_handlers.ImportCodeBatchHandler)
+		adminGroup.GET("/codeBatches", prize_handlers.GetCodeBatchesHandler)
+		adminGroup.GET("/codeBatches/:id/export", prize_handlers.ExportCodeBatchHandler)
+		adminGroup.GET("/exchangeRates", prize_handlers.GetExchangeRatesHandler)
+		adminGroup.POST("/exchangeRates", prize_handlers.CreateExchangeRateHandler)
+		adminGroup.GET("/streakRewards", prize_handlers.GetStreakRewardsHandler)
+		adminGroup.PUT("/streakRewards", prize_handlers.SetStreakRewardsHandler)
+		adminGroup.POST("/leaderboards/snapshot", prize_handlers.SnapshotLeaderboardHandler)
+		adminGroup.GET("/fulfillment", prize_handlers.GetFulfillmentQueueHandler)
+		adminGroup.PUT("/fulfillment/:id", prize_handlers.UpdateFulfillmentHandler)
+		adminGroup.POST("/points/adjust", prize_handlers.AdjustBalanceHandler)
+		adminGroup.GET("/points/verify/:userID", prize_handlers.VerifyBalanceHandler)
+		adminGroup.GET("/points/expiring", prize_handlers.GetUsersWithExpiringPointsHandler)
+		adminGroup.POST("/rewardTables", prize_handlers.CreateRewardTableHandler)
+		adminGroup.GET("/rewardTables", prize_handlers.GetRewardTablesHandler)
+		adminGroup.PUT("/rewardTables/:id", prize_handlers.UpdateRewardTableHandler)
+		adminGroup.POST("/campaigns", prize_handlers.CreateCampaignHandler)
+		adminGroup.GET("/campaigns", prize_handlers.GetCampaignsHandler)
+		adminGroup.PUT("/campaigns/:id", prize_handlers.UpdateCampaignHandler)
+		adminGroup.GET("/users/:userID/segments", user_handlers.GetUserSegmentsHandler)
+		adminGroup.POST("/users/:userID/segments/:segment", user_handlers.AddUserToSegmentHandler)
+		adminGroup.DELETE("/users/:userID/segments/:segment", user_handlers.RemoveUserFromSegmentHandler)
+		adminGroup.GET("/products/export", shop_handlers.ExportProductsHandler)
+		adminGroup.POST("/products/import", shop_handlers.ImportProductsHandler)
+		adminGroup.GET("/orders/export", shop_handlers.ExportOrdersHandler)
+		adminGroup.PUT("/orders/:id/status", shop_handlers.UpdateOrderStatusHandler)
+		adminGroup.GET("/reviews", shop_handlers.GetReviewsForModerationHandler)
+		adminGroup.PUT("/reviews/:id", shop_handlers.ModerateReviewHandler)
+		adminGroup.POST("/chat/users/:userID/:sanction", handlers.SanctionChatUserHandler)
+		adminGroup.DELETE("/chat/users/:userID/:sanction", handlers.LiftChatSanctionHandler)
+		adminGroup.DELETE("/chat/messages/:id", handlers.DeleteChatMessageHandler)
+		adminGroup.GET("/chat/moderation", handlers.GetChatModerationLogHandler)
 	}
 	return router
 }
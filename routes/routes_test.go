@@ -0,0 +1,117 @@
+package routes_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/stretchr/testify/assert"
+	"xy.com/mysite/database"
+	"xy.com/mysite/models/chat_models"
+	"xy.com/mysite/models/user_models"
+	"xy.com/mysite/routes"
+)
+
+// token signs a token for the user like the login handler does.
+func token(t *testing.T, userID uint) string {
+	signed, err := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
+		"id":  userID,
+		"exp": time.Now().Add(time.Hour).Unix(),
+	}).SignedString([]byte("your_jwt_secret"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signed
+}
+
+func TestAdminRoutesNeedAdmin(t *testing.T) {
+	assert.NoError(t, database.InitDB())
+	router := routes.SetupRouter()
+	const userID, adminID = 1, 2
+	assert.NoError(t, user_models.AddUserToSegment(database.DB, adminID, user_models.SegmentAdmin))
+
+	request := func(method, path, body string, userID uint) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		if userID != 0 {
+			req.Header.Set("Authorization", "Bearer "+token(t, userID))
+		}
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	adjust := `{"user_id":1,"currency":"points","amount":1000000,"note":"free points"}`
+	assert.Equal(t, http.StatusUnauthorized, request("POST", "/admin/points/adjust", adjust, 0).Code)
+	w := request("POST", "/admin/points/adjust", adjust, userID)
+	assert.Equal(t, http.StatusForbidden, w.Code)
+	assert.Contains(t, w.Body.String(), "admin_only")
+
+	for _, route := range []struct{ method, path string }{
+		{"POST", "/admin/addPrize"},
+		{"POST", "/prize_handlers/addPrize"},
+		{"GET", "/admin/points/verify/1"},
+		{"GET", "/admin/points/expiring"},
+		{"POST", "/admin/rewardTables"},
+		{"PUT", "/admin/streakRewards"},
+		{"GET", "/admin/products/export"},
+		{"GET", "/admin/orders/export"},
+		{"POST", "/admin/codeBatches/generate"},
+		{"POST", "/admin/codeBatches/import"},
+		{"GET", "/admin/codeBatches"},
+		{"GET", "/admin/codeBatches/1/export"},
+		{"GET", "/admin/fulfillment"},
+		{"PUT", "/admin/fulfillment/1"},
+		{"POST", "/admin/chat/users/2/ban"},
+		{"DELETE", "/admin/chat/users/2/mute"},
+		{"DELETE", "/admin/chat/messages/1"},
+		{"GET", "/admin/chat/moderation"},
+	} {
+		assert.Equal(t, http.StatusForbidden, request(route.method, route.path, `{}`, userID).Code, route.path)
+	}
+
+	// Nothing was credited and nobody was banned, admins get through
+	assert.NoError(t, chat_models.CheckCanJoin(database.DB, adminID, ""))
+	w = request("GET", "/point/points", "", userID)
+	assert.Contains(t, w.Body.String(), `"points":0`)
+	assert.Equal(t, http.StatusOK, request("POST", "/admin/points/adjust", adjust, adminID).Code)
+	assert.Equal(t, http.StatusOK, request("GET", "/admin/points/verify/1", "", adminID).Code)
+
+	// Users cannot leave the banned segment, or join the admin one
+	assert.NoError(t, user_models.AddUserToSegment(database.DB, userID, user_models.SegmentBanned))
+	assert.Equal(t, http.StatusForbidden, request("DELETE", "/admin/users/1/segments/banned", "", userID).Code)
+	assert.Equal(t, http.StatusForbidden, request("POST", "/admin/users/1/segments/admin", "", userID).Code)
+	assert.Equal(t, http.StatusForbidden, request("GET", "/admin/users/1/segments", "", userID).Code)
+	banned, err := user_models.IsUserInAnySegment(database.DB, userID, []string{user_models.SegmentBanned})
+	assert.NoError(t, err)
+	assert.True(t, banned)
+	admin, err := user_models.IsAdmin(database.DB, userID)
+	assert.NoError(t, err)
+	assert.False(t, admin)
+	assert.Equal(t, http.StatusOK, request("DELETE", "/admin/users/1/segments/banned", "", adminID).Code)
+}
+
+func TestSignupIPIgnoresForwardedFor(t *testing.T) {
+	assert.NoError(t, database.InitDB())
+	router := routes.SetupRouter()
+
+	signUp := func(name string, forwardedFor string) *httptest.ResponseRecorder {
+		body := `{"username":"` + name + `","email":"` + name + `@example.com","password":"password"}`
+		req, _ := http.NewRequest("POST", "/auth/signup", strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("X-Forwarded-For", forwardedFor)
+		req.RemoteAddr = "203.0.113.7:4321"
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	// Without trusted proxies the client cannot pick its IP
+	assert.Equal(t, http.StatusCreated, signUp("spoofer", "198.51.100.1").Code)
+	var user user_models.User
+	assert.NoError(t, database.DB.Where("username = ?", "spoofer").First(&user).Error)
+	assert.Equal(t, "203.0.113.7", user.SignupIP)
+}